@@ -0,0 +1,221 @@
+package alert
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/webconfig"
+)
+
+// 告警级别
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Event 告警事件
+type Event struct {
+	Type     string         `json:"type"`               // 告警类型，见 webconfig.AlertEvent*
+	Severity string         `json:"severity"`           // 告警级别
+	Title    string         `json:"title"`              // 标题
+	Message  string         `json:"message"`            // 详细描述
+	TokenKey string         `json:"tokenKey,omitempty"` // 相关token（用于去重）
+	Fields   map[string]any `json:"fields,omitempty"`   // 附加信息
+	Time     time.Time      `json:"time"`               // 发生时间
+}
+
+// dedupeKey 返回事件的去重键：同类型、同token的告警在去重窗口内只发送一次
+func (e Event) dedupeKey() string {
+	return e.Type + "|" + e.TokenKey
+}
+
+var (
+	mutex          sync.Mutex
+	configProvider func() webconfig.AlertConfig
+	lastSent       = make(map[string]time.Time)
+)
+
+// SetConfigProvider 设置告警配置的获取回调（配置热更新后立即生效）
+func SetConfigProvider(provider func() webconfig.AlertConfig) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	configProvider = provider
+}
+
+// currentConfig 获取当前告警配置，未设置时返回禁用配置
+func currentConfig() (webconfig.AlertConfig, bool) {
+	mutex.Lock()
+	provider := configProvider
+	mutex.Unlock()
+
+	if provider == nil {
+		return webconfig.AlertConfig{}, false
+	}
+	return provider(), true
+}
+
+// Fire 异步发送告警（不阻塞调用者），相同告警在去重窗口内只发送一次
+func Fire(ev Event) {
+	cfg, ok := currentConfig()
+	if !ok || !cfg.Enabled || len(cfg.Webhooks) == 0 {
+		return
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	if !shouldSend(ev, time.Duration(cfg.DedupeMinutes)*time.Minute) {
+		logger.Debug("告警在去重窗口内，跳过发送",
+			logger.String("type", ev.Type),
+			logger.String("token_key", ev.TokenKey))
+		return
+	}
+
+	go dispatch(cfg, ev)
+}
+
+// shouldSend 检查去重窗口并记录发送时间
+func shouldSend(ev Event, window time.Duration) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	key := ev.dedupeKey()
+	if last, exists := lastSent[key]; exists && window > 0 && ev.Time.Sub(last) < window {
+		return false
+	}
+	lastSent[key] = ev.Time
+
+	// 顺便清理过期记录，避免map无限增长
+	for k, t := range lastSent {
+		if ev.Time.Sub(t) >= window && k != key {
+			delete(lastSent, k)
+		}
+	}
+	return true
+}
+
+// resetDedupe 清空去重记录（仅用于测试）
+func resetDedupe() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	lastSent = make(map[string]time.Time)
+}
+
+// dispatch 将告警发送到所有启用且订阅了该类型的Webhook
+func dispatch(cfg webconfig.AlertConfig, ev Event) {
+	for _, webhook := range cfg.Webhooks {
+		if !webhook.Enabled || !subscribes(webhook, ev.Type) {
+			continue
+		}
+
+		result := deliver(webhook, ev, cfg.MaxRetries)
+		if !result.Success {
+			logger.Warn("告警发送失败",
+				logger.String("webhook", webhook.Name),
+				logger.String("type", ev.Type),
+				logger.String("error", result.Error))
+		}
+	}
+}
+
+// subscribes 检查Webhook是否订阅了指定告警类型（未配置订阅表示全部）
+func subscribes(webhook webconfig.AlertWebhook, eventType string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, event := range webhook.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyLowCredit 剩余额度低于阈值时发送低额度告警
+func NotifyLowCredit(tokenKey string, available float64, fields map[string]any) {
+	cfg, ok := currentConfig()
+	if !ok || cfg.LowCreditThreshold <= 0 || available >= cfg.LowCreditThreshold {
+		return
+	}
+
+	if fields == nil {
+		fields = make(map[string]any)
+	}
+	fields["available"] = available
+	fields["threshold"] = cfg.LowCreditThreshold
+
+	Fire(Event{
+		Type:     webconfig.AlertEventLowCredit,
+		Severity: SeverityWarning,
+		Title:    "Token剩余额度过低",
+		Message:  fmt.Sprintf("token %s 剩余额度 %.2f，低于阈值 %.2f", tokenKey, available, cfg.LowCreditThreshold),
+		TokenKey: tokenKey,
+		Fields:   fields,
+	})
+}
+
+// NotifyRefreshFailed 发送token刷新失败告警
+func NotifyRefreshFailed(tokenKey string, err error, fields map[string]any) {
+	Fire(Event{
+		Type:     webconfig.AlertEventRefreshFailed,
+		Severity: SeverityCritical,
+		Title:    "Token刷新失败",
+		Message:  fmt.Sprintf("token %s 刷新失败: %v", tokenKey, err),
+		TokenKey: tokenKey,
+		Fields:   fields,
+	})
+}
+
+// NotifyTokenDisabled 发送token被移出轮换的告警
+func NotifyTokenDisabled(tokenKey string, reason string, fields map[string]any) {
+	Fire(Event{
+		Type:     webconfig.AlertEventTokenDisabled,
+		Severity: SeverityWarning,
+		Title:    "Token已移出轮换",
+		Message:  fmt.Sprintf("token %s 已移出轮换: %s", tokenKey, reason),
+		TokenKey: tokenKey,
+		Fields:   fields,
+	})
+}
+
+// NotifyPoolEmpty 发送token池无可用token的告警
+func NotifyPoolEmpty(total int) {
+	Fire(Event{
+		Type:     webconfig.AlertEventPoolEmpty,
+		Severity: SeverityCritical,
+		Title:    "Token池没有可用Token",
+		Message:  fmt.Sprintf("全部 %d 个token均不可用，请求将失败", total),
+		Fields:   map[string]any{"total": total},
+	})
+}
+
+// TestFire 同步发送测试告警（忽略去重、订阅过滤和全局开关）
+// webhookName 为空时发送到所有启用的Webhook
+func TestFire(webhookName string) []webconfig.AlertTestResult {
+	cfg, _ := currentConfig()
+
+	ev := Event{
+		Type:     webconfig.AlertEventTest,
+		Severity: SeverityInfo,
+		Title:    "Kiro2API 测试告警",
+		Message:  "这是一条测试告警，收到说明Webhook配置正确",
+		Time:     time.Now(),
+	}
+
+	results := make([]webconfig.AlertTestResult, 0)
+	for _, webhook := range cfg.Webhooks {
+		if webhookName != "" {
+			if webhook.Name != webhookName {
+				continue
+			}
+		} else if !webhook.Enabled {
+			continue
+		}
+		results = append(results, deliver(webhook, ev, 0))
+	}
+	return results
+}
@@ -0,0 +1,195 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kiro2api/webconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookRecorder 记录收到的Webhook请求
+type webhookRecorder struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	headers  []http.Header
+	failures int // 前N次请求返回500
+	received chan struct{}
+}
+
+func newWebhookServer(t *testing.T, failures int) (*httptest.Server, *webhookRecorder) {
+	rec := &webhookRecorder{failures: failures, received: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if rec.failures > 0 {
+			rec.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rec.bodies = append(rec.bodies, body)
+		rec.headers = append(rec.headers, r.Header.Clone())
+		w.WriteHeader(http.StatusOK)
+		rec.received <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, rec
+}
+
+func (r *webhookRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.bodies)
+}
+
+func (r *webhookRecorder) wait(t *testing.T) {
+	select {
+	case <-r.received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("等待Webhook请求超时")
+	}
+}
+
+func setupAlertConfig(t *testing.T, cfg webconfig.AlertConfig) {
+	SetConfigProvider(func() webconfig.AlertConfig { return cfg })
+	resetDedupe()
+	oldDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() {
+		SetConfigProvider(nil)
+		resetDedupe()
+		retryBaseDelay = oldDelay
+	})
+}
+
+func TestFire_GenericWebhookSigned(t *testing.T) {
+	srv, rec := newWebhookServer(t, 0)
+	setupAlertConfig(t, webconfig.AlertConfig{
+		Enabled:  true,
+		Webhooks: []webconfig.AlertWebhook{{Name: "ops", URL: srv.URL, Format: webconfig.AlertFormatGeneric, Secret: "s3cret", Enabled: true}},
+	})
+
+	NotifyRefreshFailed("token_0", errors.New("invalid grant"), nil)
+	rec.wait(t)
+
+	var ev Event
+	assert.NoError(t, json.Unmarshal(rec.bodies[0], &ev))
+	assert.Equal(t, webconfig.AlertEventRefreshFailed, ev.Type)
+	assert.Equal(t, "token_0", ev.TokenKey)
+
+	timestamp := rec.headers[0].Get(HeaderTimestamp)
+	assert.NotEmpty(t, timestamp)
+	assert.Equal(t, Sign("s3cret", timestamp, rec.bodies[0]), rec.headers[0].Get(HeaderSignature))
+}
+
+func TestFire_SlackFormat(t *testing.T) {
+	srv, rec := newWebhookServer(t, 0)
+	setupAlertConfig(t, webconfig.AlertConfig{
+		Enabled:  true,
+		Webhooks: []webconfig.AlertWebhook{{Name: "slack", URL: srv.URL, Format: webconfig.AlertFormatSlack, Secret: "ignored", Enabled: true}},
+	})
+
+	NotifyPoolEmpty(3)
+	rec.wait(t)
+
+	var payload map[string]string
+	assert.NoError(t, json.Unmarshal(rec.bodies[0], &payload))
+	assert.Contains(t, payload["text"], "Token池没有可用Token")
+	assert.Empty(t, rec.headers[0].Get(HeaderSignature))
+}
+
+func TestFire_Dedupe(t *testing.T) {
+	srv, rec := newWebhookServer(t, 0)
+	setupAlertConfig(t, webconfig.AlertConfig{
+		Enabled:       true,
+		DedupeMinutes: 30,
+		Webhooks:      []webconfig.AlertWebhook{{Name: "ops", URL: srv.URL, Format: webconfig.AlertFormatGeneric, Enabled: true}},
+	})
+
+	NotifyTokenDisabled("token_0", "额度已耗尽", nil)
+	NotifyTokenDisabled("token_0", "额度已耗尽", nil)
+	NotifyTokenDisabled("token_1", "额度已耗尽", nil)
+	rec.wait(t)
+	rec.wait(t)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, rec.count())
+}
+
+func TestFire_DisabledOrUnsubscribed(t *testing.T) {
+	srv, rec := newWebhookServer(t, 0)
+	setupAlertConfig(t, webconfig.AlertConfig{
+		Enabled: true,
+		Webhooks: []webconfig.AlertWebhook{
+			{Name: "off", URL: srv.URL, Format: webconfig.AlertFormatGeneric, Enabled: false},
+			{Name: "pool", URL: srv.URL, Format: webconfig.AlertFormatGeneric, Enabled: true, Events: []string{webconfig.AlertEventPoolEmpty}},
+		},
+	})
+
+	NotifyRefreshFailed("token_0", errors.New("boom"), nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, rec.count())
+}
+
+func TestFire_RetriesOnFailure(t *testing.T) {
+	srv, rec := newWebhookServer(t, 2)
+	setupAlertConfig(t, webconfig.AlertConfig{
+		Enabled:    true,
+		MaxRetries: 3,
+		Webhooks:   []webconfig.AlertWebhook{{Name: "ops", URL: srv.URL, Format: webconfig.AlertFormatGeneric, Enabled: true}},
+	})
+
+	NotifyPoolEmpty(1)
+	rec.wait(t)
+	assert.Equal(t, 1, rec.count())
+}
+
+func TestNotifyLowCredit_Threshold(t *testing.T) {
+	srv, rec := newWebhookServer(t, 0)
+	setupAlertConfig(t, webconfig.AlertConfig{
+		Enabled:            true,
+		LowCreditThreshold: 10,
+		Webhooks:           []webconfig.AlertWebhook{{Name: "ops", URL: srv.URL, Format: webconfig.AlertFormatGeneric, Enabled: true}},
+	})
+
+	NotifyLowCredit("token_0", 50, nil)
+	NotifyLowCredit("token_1", 4.5, nil)
+	rec.wait(t)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, rec.count())
+
+	var ev Event
+	assert.NoError(t, json.Unmarshal(rec.bodies[0], &ev))
+	assert.Equal(t, "token_1", ev.TokenKey)
+	assert.Equal(t, 4.5, ev.Fields["available"])
+}
+
+func TestTestFire_IgnoresGlobalSwitch(t *testing.T) {
+	srv, rec := newWebhookServer(t, 0)
+	setupAlertConfig(t, webconfig.AlertConfig{
+		Enabled: false,
+		Webhooks: []webconfig.AlertWebhook{
+			{Name: "a", URL: srv.URL, Format: webconfig.AlertFormatGeneric, Enabled: true},
+			{Name: "b", URL: srv.URL + "/missing", Format: webconfig.AlertFormatSlack, Enabled: false},
+		},
+	})
+
+	results := TestFire("")
+	assert.Len(t, results, 1)
+	assert.True(t, results[0].Success)
+	assert.Equal(t, 1, rec.count())
+
+	// 指定名称时即使Webhook未启用也发送
+	results = TestFire("b")
+	assert.Len(t, results, 1)
+	assert.Equal(t, "b", results[0].Webhook)
+}
@@ -0,0 +1,129 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/utils"
+	"kiro2api/webconfig"
+)
+
+// 签名相关请求头（仅generic格式）
+const (
+	HeaderTimestamp = "X-Kiro2API-Timestamp"
+	HeaderSignature = "X-Kiro2API-Signature"
+)
+
+// retryBaseDelay 重试基础延迟（测试中可调小）
+var retryBaseDelay = config.AlertRetryBaseDelay
+
+// deliver 发送告警到单个Webhook，失败时按指数退避重试
+func deliver(webhook webconfig.AlertWebhook, ev Event, maxRetries int) webconfig.AlertTestResult {
+	result := webconfig.AlertTestResult{Webhook: webhook.Name}
+
+	body, err := buildPayload(webhook.Format, ev)
+	if err != nil {
+		result.Error = fmt.Sprintf("构建告警消息失败: %v", err)
+		return result
+	}
+
+	delay := retryBaseDelay
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		statusCode, sendErr := send(webhook, body)
+		result.StatusCode = statusCode
+		if sendErr == nil {
+			result.Success = true
+			result.Error = ""
+			return result
+		}
+		result.Error = sendErr.Error()
+	}
+
+	return result
+}
+
+// send 执行一次Webhook请求
+func send(webhook webconfig.AlertWebhook, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.AlertRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if webhook.Format != webconfig.AlertFormatSlack && webhook.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+	}
+
+	resp, err := utils.DoRequest(req)
+	if err != nil {
+		return 0, fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("状态码 %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign 计算告警签名：sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// buildPayload 按Webhook格式构建请求体
+func buildPayload(format string, ev Event) ([]byte, error) {
+	if format == webconfig.AlertFormatSlack {
+		return utils.SafeMarshal(map[string]string{"text": slackText(ev)})
+	}
+	return utils.SafeMarshal(ev)
+}
+
+// slackText 生成Slack消息文本
+func slackText(ev Event) string {
+	icon := map[string]string{
+		SeverityInfo:     ":information_source:",
+		SeverityWarning:  ":warning:",
+		SeverityCritical: ":rotating_light:",
+	}[ev.Severity]
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s *[kiro2api] %s*\n%s", icon, ev.Title, ev.Message)
+	if len(ev.Fields) > 0 {
+		keys := make([]string, 0, len(ev.Fields))
+		for k := range ev.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&sb, "\n• %s: %v", k, ev.Fields[k])
+		}
+	}
+	return sb.String()
+}
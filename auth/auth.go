@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"kiro2api/logger"
+	"kiro2api/metrics"
 	"kiro2api/types"
 	"kiro2api/webconfig"
+	"sync"
 )
 
 // AuthService 认证服务（推荐使用依赖注入方式）
@@ -12,6 +16,36 @@ type AuthService struct {
 	tokenManager  *TokenManager
 	configs       []AuthConfig
 	configManager *webconfig.Manager // 用于动态重载配置
+
+	mutex       sync.RWMutex // 保护 tokenManager 和 configs 的替换
+	reloadMutex sync.Mutex   // 串行化热重载
+}
+
+// pool 获取当前的token管理器和配置（线程安全）
+func (as *AuthService) pool() (*TokenManager, []AuthConfig) {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+	return as.tokenManager, as.configs
+}
+
+// manager 获取当前的token管理器（线程安全）
+func (as *AuthService) manager() *TokenManager {
+	tm, _ := as.pool()
+	return tm
+}
+
+// swapPool 原子性地替换token池，并让旧token池排空
+// 在途请求在旧token池上完成，排队请求转到新token池
+func (as *AuthService) swapPool(tokenManager *TokenManager, configs []AuthConfig) {
+	as.mutex.Lock()
+	oldTokenManager := as.tokenManager
+	as.tokenManager = tokenManager
+	as.configs = configs
+	as.mutex.Unlock()
+
+	if oldTokenManager != nil {
+		oldTokenManager.retire()
+	}
 }
 
 // NewAuthService 创建新的认证服务（推荐使用此方法而不是全局函数）
@@ -47,29 +81,69 @@ func NewAuthService() (*AuthService, error) {
 
 // GetToken 获取可用的token
 func (as *AuthService) GetToken() (types.TokenInfo, error) {
-	if as.tokenManager == nil {
+	tokenManager := as.manager()
+	if tokenManager == nil {
 		return types.TokenInfo{}, fmt.Errorf("token管理器未初始化")
 	}
-	return as.tokenManager.getBestToken()
+	return tokenManager.getBestToken()
+}
+
+// AcquireToken 获取可用的token并占用并发槽位，请求结束后必须调用release
+// affinityKey 为会话标识，非空时同一会话优先使用同一个token
+// 获取期间发生热重载时，自动在新的token池上重试
+func (as *AuthService) AcquireToken(ctx context.Context, affinityKey string) (types.TokenInfo, func(), error) {
+	for {
+		tokenManager := as.manager()
+		if tokenManager == nil {
+			return types.TokenInfo{}, nil, fmt.Errorf("token管理器未初始化")
+		}
+
+		token, release, err := tokenManager.AcquireToken(ctx, affinityKey)
+		if errors.Is(err, errPoolRetired) {
+			logger.Debug("token池已热重载，在新token池上重试")
+			continue
+		}
+		return token, release, err
+	}
+}
+
+// GetConcurrencyStats 获取token并发与排队统计
+func (as *AuthService) GetConcurrencyStats() types.TokenConcurrencyStats {
+	tokenManager := as.manager()
+	if tokenManager == nil {
+		return types.TokenConcurrencyStats{}
+	}
+	return tokenManager.ConcurrencyStats()
+}
+
+// TokenStates 获取各token的状态，供指标采集
+func (as *AuthService) TokenStates() []metrics.TokenState {
+	tokenManager := as.manager()
+	if tokenManager == nil {
+		return nil
+	}
+	return tokenManager.TokenStates()
 }
 
 // GetTokenManager 获取底层的TokenManager（用于高级操作）
 func (as *AuthService) GetTokenManager() *TokenManager {
-	return as.tokenManager
+	return as.manager()
 }
 
 // GetConfigs 获取认证配置
 func (as *AuthService) GetConfigs() []AuthConfig {
-	return as.configs
+	_, configs := as.pool()
+	return configs
 }
 
 // GetCurrentTokenIndex 获取当前正在使用的token索引
 func (as *AuthService) GetCurrentTokenIndex() int {
-	if as.tokenManager == nil {
+	tokenManager := as.manager()
+	if tokenManager == nil {
 		return -1
 	}
 	
-	currentKey := as.tokenManager.GetCurrentTokenKey()
+	currentKey := tokenManager.GetCurrentTokenKey()
 	if currentKey == "" {
 		return -1
 	}
@@ -86,19 +160,24 @@ func (as *AuthService) GetCurrentTokenIndex() int {
 
 // SwitchToToken 手动切换到指定索引的token
 func (as *AuthService) SwitchToToken(configIndex int) error {
-	if as.tokenManager == nil {
+	tokenManager := as.manager()
+	if tokenManager == nil {
 		return fmt.Errorf("token管理器未初始化")
 	}
 	
-	return as.tokenManager.SwitchToToken(configIndex)
+	return tokenManager.SwitchToToken(configIndex)
 }
 
 // ReloadConfigs 重新加载配置
+// 仍然存在的token（按Token ID匹配）继承原有状态，只刷新新增或凭据变更的token
 func (as *AuthService) ReloadConfigs() error {
 	if as.configManager == nil {
 		return fmt.Errorf("configManager未初始化，无法重载配置")
 	}
 
+	as.reloadMutex.Lock()
+	defer as.reloadMutex.Unlock()
+
 	logger.Info("开始重新加载认证配置")
 
 	// 从Web配置重新加载认证配置
@@ -108,22 +187,27 @@ func (as *AuthService) ReloadConfigs() error {
 	}
 
 	// 创建新的token管理器
+	webConfig := as.configManager.GetConfig()
 	newTokenManager := NewTokenManager(newConfigs)
-
-	// 预热第一个可用token
-	_, warmupErr := newTokenManager.getBestToken()
-	if warmupErr != nil {
+	newTokenManager.SetQueueOptions(QueueOptionsFromWebConfig(webConfig))
+	newTokenManager.SetAffinityTTL(AffinityTTLFromWebConfig(webConfig))
+
+	oldTokenManager, oldConfigs := as.pool()
+	inherited := 0
+	if oldTokenManager != nil {
+		inherited = newTokenManager.inheritFrom(oldTokenManager)
+	} else if _, warmupErr := newTokenManager.getBestToken(); warmupErr != nil {
+		// 预热第一个可用token
 		logger.Warn("新配置token预热失败", logger.Err(warmupErr))
 	}
 
-	// 原子性地替换配置
-	oldConfigCount := len(as.configs)
-	as.configs = newConfigs
-	as.tokenManager = newTokenManager
+	// 原子性地替换token池
+	as.swapPool(newTokenManager, newConfigs)
 
 	logger.Info("认证配置重新加载完成",
-		logger.Int("旧配置数量", oldConfigCount),
-		logger.Int("新配置数量", len(newConfigs)))
+		logger.Int("旧配置数量", len(oldConfigs)),
+		logger.Int("新配置数量", len(newConfigs)),
+		logger.Int("继承状态数量", inherited))
 
 	return nil
 }
@@ -144,6 +228,8 @@ func NewAuthServiceWithConfig(configManager *webconfig.Manager) (*AuthService, e
 
 	// 创建token管理器
 	tokenManager := NewTokenManager(configs)
+	tokenManager.SetQueueOptions(QueueOptionsFromWebConfig(configManager.GetConfig()))
+	tokenManager.SetAffinityTTL(AffinityTTLFromWebConfig(configManager.GetConfig()))
 
 	// 预热第一个可用token
 	_, warmupErr := tokenManager.getBestToken()
@@ -4,18 +4,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
+	"kiro2api/config"
 	"kiro2api/logger"
+	"kiro2api/types"
 	"kiro2api/webconfig"
 )
 
 // AuthConfig 简化的认证配置
 type AuthConfig struct {
+	ID           string `json:"id,omitempty"` // Web配置中的Token ID，热重载时用于匹配同一个token
 	AuthType     string `json:"auth"`
 	RefreshToken string `json:"refreshToken"`
 	ClientID     string `json:"clientId,omitempty"`
 	ClientSecret string `json:"clientSecret,omitempty"`
 	Disabled     bool   `json:"disabled,omitempty"`
+
+	// 最大并发请求数，0表示不限制
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+
+	// token分组，绑定到分组的API密钥只使用该分组的token
+	Group string `json:"group,omitempty"`
+
+	// 上游配置（区域、profileArn、端点、客户端指纹），未设置的字段使用默认值
+	Upstream types.UpstreamProfile `json:"upstream,omitempty"`
 }
 
 // 认证方法常量
@@ -146,6 +159,9 @@ func processConfigs(configs []AuthConfig) []AuthConfig {
 			continue
 		}
 
+		// 补全上游配置默认值
+		config.Upstream = config.Upstream.Resolve()
+
 		validConfigs = append(validConfigs, config)
 		_ = i // 避免未使用变量警告
 	}
@@ -161,15 +177,11 @@ func loadConfigsFromWebConfig(configManager *webconfig.Manager) ([]AuthConfig, e
 		return nil, fmt.Errorf("没有配置任何认证Token，请先在Web界面中添加Token")
 	}
 
+	webConfig := configManager.GetConfig()
+
 	var configs []AuthConfig
 	for _, token := range tokens {
-		config := AuthConfig{
-			AuthType:     token.Auth,
-			RefreshToken: token.RefreshToken,
-			ClientID:     token.ClientID,
-			ClientSecret: token.ClientSecret,
-		}
-		configs = append(configs, config)
+		configs = append(configs, AuthConfigFromWebToken(webConfig, token))
 	}
 
 	logger.Info("从Web配置加载认证配置",
@@ -178,6 +190,41 @@ func loadConfigsFromWebConfig(configManager *webconfig.Manager) ([]AuthConfig, e
 	return configs, nil
 }
 
+// AuthConfigFromWebToken 将Web配置中的Token转换为认证配置，并解析其上游配置
+func AuthConfigFromWebToken(webConfig *webconfig.WebConfig, token webconfig.AuthToken) AuthConfig {
+	return AuthConfig{
+		ID:           token.ID,
+		AuthType:     token.Auth,
+		RefreshToken: token.RefreshToken,
+		ClientID:     token.ClientID,
+		ClientSecret: token.ClientSecret,
+		Disabled:     !token.Enabled,
+		Upstream:     webConfig.ResolveUpstream(token),
+
+		MaxConcurrency: webConfig.ResolveMaxConcurrency(token),
+		Group:          token.Group,
+	}
+}
+
+// AffinityTTLFromWebConfig 从Web配置解析会话亲和有效期，0表示禁用
+func AffinityTTLFromWebConfig(webConfig *webconfig.WebConfig) time.Duration {
+	if !webConfig.AffinityConfig.Enabled {
+		return 0
+	}
+	if webConfig.AffinityConfig.TTLMinutes <= 0 {
+		return config.DefaultAffinityTTL
+	}
+	return time.Duration(webConfig.AffinityConfig.TTLMinutes) * time.Minute
+}
+
+// QueueOptionsFromWebConfig 从Web配置解析token排队参数
+func QueueOptionsFromWebConfig(webConfig *webconfig.WebConfig) QueueOptions {
+	return QueueOptions{
+		Size:    webConfig.ConcurrencyConfig.QueueSize,
+		Timeout: time.Duration(webConfig.ConcurrencyConfig.QueueTimeoutSeconds) * time.Second,
+	}
+}
+
 // GetConfigsFromWebConfig 从Web配置获取认证配置的公开函数
 func GetConfigsFromWebConfig(configManager *webconfig.Manager) ([]AuthConfig, error) {
 	return loadConfigsFromWebConfig(configManager)
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"kiro2api/config"
 	"kiro2api/types"
 	"kiro2api/utils"
 	"net/http"
@@ -15,7 +14,7 @@ import (
 func (tm *TokenManager) refreshSingleToken(authConfig AuthConfig) (types.TokenInfo, error) {
 	switch authConfig.AuthType {
 	case AuthMethodSocial:
-		return refreshSocialToken(authConfig.RefreshToken)
+		return refreshSocialToken(authConfig)
 	case AuthMethodIdC:
 		return refreshIdCToken(authConfig)
 	default:
@@ -24,9 +23,10 @@ func (tm *TokenManager) refreshSingleToken(authConfig AuthConfig) (types.TokenIn
 }
 
 // refreshSocialToken 刷新Social认证token
-func refreshSocialToken(refreshToken string) (types.TokenInfo, error) {
+func refreshSocialToken(authConfig AuthConfig) (types.TokenInfo, error) {
+	upstream := authConfig.Upstream.Resolve()
 	refreshReq := types.RefreshRequest{
-		RefreshToken: refreshToken,
+		RefreshToken: authConfig.RefreshToken,
 	}
 
 	reqBody, err := utils.FastMarshal(refreshReq)
@@ -34,7 +34,7 @@ func refreshSocialToken(refreshToken string) (types.TokenInfo, error) {
 		return types.TokenInfo{}, fmt.Errorf("序列化请求失败: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", config.RefreshTokenURL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest("POST", upstream.Endpoints.SocialRefresh, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return types.TokenInfo{}, fmt.Errorf("创建请求失败: %v", err)
 	}
@@ -64,13 +64,18 @@ func refreshSocialToken(refreshToken string) (types.TokenInfo, error) {
 	}
 
 	var token types.Token
-	token.FromRefreshResponse(refreshResp, refreshToken)
+	token.FromRefreshResponse(refreshResp, authConfig.RefreshToken)
+	token.Upstream = upstream
+	if token.Upstream.ProfileArn == "" {
+		token.Upstream.ProfileArn = refreshResp.ProfileArn
+	}
 
 	return token, nil
 }
 
 // refreshIdCToken 刷新IdC认证token
 func refreshIdCToken(authConfig AuthConfig) (types.TokenInfo, error) {
+	upstream := authConfig.Upstream.Resolve()
 	refreshReq := types.IdcRefreshRequest{
 		ClientId:     authConfig.ClientID,
 		ClientSecret: authConfig.ClientSecret,
@@ -83,14 +88,13 @@ func refreshIdCToken(authConfig AuthConfig) (types.TokenInfo, error) {
 		return types.TokenInfo{}, fmt.Errorf("序列化IdC请求失败: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", config.IdcRefreshTokenURL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest("POST", upstream.Endpoints.IdcRefresh, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return types.TokenInfo{}, fmt.Errorf("创建IdC请求失败: %v", err)
 	}
 
 	// 设置IdC特殊headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Host", "oidc.us-east-1.amazonaws.com")
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("x-amz-user-agent", "aws-sdk-js/3.738.0 ua/2.1 os/other lang/js md/browser#unknown_unknown api/sso-oidc#3.738.0 m/E KiroIDE")
 	req.Header.Set("Accept", "*/*")
@@ -126,13 +130,18 @@ func refreshIdCToken(authConfig AuthConfig) (types.TokenInfo, error) {
 	token.RefreshToken = authConfig.RefreshToken
 	token.ExpiresIn = refreshResp.ExpiresIn
 	token.ExpiresAt = time.Now().Add(time.Duration(refreshResp.ExpiresIn) * time.Second)
+	token.ProfileArn = refreshResp.ProfileArn
+	token.Upstream = upstream
+	if token.Upstream.ProfileArn == "" {
+		token.Upstream.ProfileArn = refreshResp.ProfileArn
+	}
 
 	return token, nil
 }
 
 // RefreshSocialToken 公开的Social token刷新函数
-func RefreshSocialToken(refreshToken string) (types.TokenInfo, error) {
-	return refreshSocialToken(refreshToken)
+func RefreshSocialToken(authConfig AuthConfig) (types.TokenInfo, error) {
+	return refreshSocialToken(authConfig)
 }
 
 // RefreshIdCToken 公开的IdC token刷新函数
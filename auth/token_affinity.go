@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"time"
+
+	"kiro2api/logger"
+)
+
+// affinityEntry 会话到token的绑定
+type affinityEntry struct {
+	tokenKey  string
+	expiresAt time.Time
+}
+
+// SetAffinityTTL 设置会话亲和绑定的有效期，0表示禁用会话亲和
+func (tm *TokenManager) SetAffinityTTL(ttl time.Duration) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.affinityTTL = ttl
+	if ttl <= 0 {
+		tm.affinity = make(map[string]affinityEntry)
+	}
+}
+
+// selectAffinityTokenUnlocked 返回会话绑定的token，绑定不存在、已过期或token不可用时返回nil
+// 绑定的token并发已满时同样返回nil（本次回退到常规选择，但保留绑定）
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) selectAffinityTokenUnlocked(affinityKey string) (string, *CachedToken) {
+	if affinityKey == "" || tm.affinityTTL <= 0 {
+		return "", nil
+	}
+
+	entry, exists := tm.affinity[affinityKey]
+	if !exists {
+		return "", nil
+	}
+
+	now := time.Now()
+	if now.After(entry.expiresAt) {
+		delete(tm.affinity, affinityKey)
+		return "", nil
+	}
+
+	cached, exists := tm.cache.tokens[entry.tokenKey]
+	if !exists || time.Since(cached.CachedAt) > tm.cache.ttl || !cached.IsUsable() {
+		// 绑定的token已不可用，解除绑定，回退到常规选择后重新绑定
+		delete(tm.affinity, affinityKey)
+		logger.Debug("会话绑定的token不可用，回退到常规选择",
+			logger.String("token_key", entry.tokenKey))
+		return "", nil
+	}
+
+	if !tm.hasCapacityUnlocked(entry.tokenKey) {
+		return "", nil
+	}
+
+	entry.expiresAt = now.Add(tm.affinityTTL)
+	tm.affinity[affinityKey] = entry
+
+	logger.Debug("会话亲和命中",
+		logger.String("token_key", entry.tokenKey))
+	return entry.tokenKey, cached
+}
+
+// bindAffinityUnlocked 将会话绑定到token，已有有效绑定时不覆盖
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) bindAffinityUnlocked(affinityKey, tokenKey string) {
+	if affinityKey == "" || tm.affinityTTL <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if entry, exists := tm.affinity[affinityKey]; exists && now.Before(entry.expiresAt) {
+		return
+	}
+
+	tm.sweepAffinityUnlocked(now)
+	tm.affinity[affinityKey] = affinityEntry{
+		tokenKey:  tokenKey,
+		expiresAt: now.Add(tm.affinityTTL),
+	}
+}
+
+// sweepAffinityUnlocked 定期清理过期的会话绑定，避免内存增长
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) sweepAffinityUnlocked(now time.Time) {
+	if now.Sub(tm.lastAffinitySweep) < tm.affinityTTL {
+		return
+	}
+
+	for key, entry := range tm.affinity {
+		if now.After(entry.expiresAt) {
+			delete(tm.affinity, key)
+		}
+	}
+	tm.lastAffinitySweep = now
+}
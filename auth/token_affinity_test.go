@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireToken_AffinityPinsConversation(t *testing.T) {
+	tm := newQueueTestManager([]int{0, 0})
+
+	// 会话A绑定到token_0后，手动切换不影响已绑定的会话
+	first, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_0", first.AccessToken)
+
+	assert.NoError(t, tm.SwitchToToken(1))
+
+	again, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_0", again.AccessToken)
+
+	// 新会话按常规顺序选择
+	other, release, err := tm.AcquireToken(context.Background(), "conv-b")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_1", other.AccessToken)
+
+	assert.Equal(t, 2, tm.ConcurrencyStats().AffinityCount)
+}
+
+func TestAcquireToken_AffinityFallbackWhenUnusable(t *testing.T) {
+	tm := newQueueTestManager([]int{0, 0})
+
+	_, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+
+	// 绑定的token额度耗尽后回退到下一个token，并重新绑定
+	tm.mutex.Lock()
+	tm.cache.tokens["token_0"].Available = 0
+	tm.mutex.Unlock()
+
+	token, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_1", token.AccessToken)
+
+	tm.mutex.Lock()
+	assert.Equal(t, "token_1", tm.affinity["conv-a"].tokenKey)
+	tm.mutex.Unlock()
+}
+
+func TestAcquireToken_AffinityExpires(t *testing.T) {
+	tm := newQueueTestManager([]int{0, 0})
+	tm.SetAffinityTTL(10 * time.Millisecond)
+
+	_, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+
+	assert.NoError(t, tm.SwitchToToken(1))
+	time.Sleep(20 * time.Millisecond)
+
+	token, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_1", token.AccessToken)
+}
+
+func TestAcquireToken_AffinityDisabled(t *testing.T) {
+	tm := newQueueTestManager([]int{0, 0})
+	tm.SetAffinityTTL(0)
+
+	_, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+
+	assert.NoError(t, tm.SwitchToToken(1))
+
+	token, release, err := tm.AcquireToken(context.Background(), "conv-a")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_1", token.AccessToken)
+	assert.Equal(t, 0, tm.ConcurrencyStats().AffinityCount)
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "context"
+
+// tokenGroupKey context中token分组的键
+type tokenGroupKey struct{}
+
+// WithTokenGroup 返回限定只使用指定分组token的context，group为空表示不限制
+func WithTokenGroup(ctx context.Context, group string) context.Context {
+	if group == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tokenGroupKey{}, group)
+}
+
+// TokenGroupFromContext 从context读取token分组，未设置时返回空串
+func TokenGroupFromContext(ctx context.Context) string {
+	group, _ := ctx.Value(tokenGroupKey{}).(string)
+	return group
+}
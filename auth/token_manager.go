@@ -2,9 +2,13 @@ package auth
 
 import (
 	"fmt"
+	"kiro2api/alert"
 	"kiro2api/config"
+	"kiro2api/events"
 	"kiro2api/logger"
 	"kiro2api/types"
+	"kiro2api/usage"
+	"kiro2api/webconfig"
 	"sync"
 	"time"
 )
@@ -19,6 +23,28 @@ type TokenManager struct {
 	currentIndex int             // 当前使用的token索引
 	exhausted    map[string]bool // 已耗尽的token记录
 	lastUsedKey  string          // 最后使用的token key
+	groups       map[string]string // token key -> 分组
+	poolEmpty    map[string]bool // 已通知没有可用token的分组，重新选到token后清除
+
+	// 并发控制（同样由 mutex 保护）
+	maxInFlight map[string]int           // 每个token的最大并发数，0表示不限制
+	inFlight    map[string]*inFlightSlot // 每个token当前在途请求数，热重载时与旧token池共享
+	successor   *TokenManager            // 热重载后接替的token池，旧池释放槽位时唤醒其排队请求
+	waiters     []*tokenWaiter           // 所有token饱和时的FIFO等待队列
+	queueOpts   QueueOptions             // 排队参数
+	queueStats  queueStats               // 排队统计
+
+	// 会话亲和（同样由 mutex 保护）
+	affinity          map[string]affinityEntry // 会话ID -> token绑定
+	affinityTTL       time.Duration            // 绑定有效期，0表示禁用
+	lastAffinitySweep time.Time                // 上次清理过期绑定的时间
+
+	// 额度重置后的重新检查（同样由 mutex 保护）
+	resetTimers map[string]*resetTimer // token key -> 已安排的重新检查
+	stopped     bool                   // 已停止，不再安排新的检查
+
+	// 加锁期间产生的告警和审计记录（同样由 mutex 保护），释放锁后再发出，见 unlock
+	notices []func()
 }
 
 // SimpleTokenCache 简化的token缓存（纯数据结构，无锁）
@@ -35,6 +61,7 @@ type CachedToken struct {
 	CachedAt  time.Time
 	LastUsed  time.Time
 	Available float64
+	ResetAt   time.Time // 额度重置时间，未知时为零值
 }
 
 // NewSimpleTokenCache 创建简单的token缓存
@@ -54,108 +81,236 @@ func NewTokenManager(configs []AuthConfig) *TokenManager {
 		logger.Int("config_count", len(configs)),
 		logger.Int("config_order_count", len(configOrder)))
 
+	maxInFlight := make(map[string]int, len(configs))
+	inFlight := make(map[string]*inFlightSlot, len(configs))
+	groups := make(map[string]string, len(configs))
+	for i, cfg := range configs {
+		inFlight[configOrder[i]] = &inFlightSlot{}
+		if cfg.MaxConcurrency > 0 {
+			maxInFlight[configOrder[i]] = cfg.MaxConcurrency
+		}
+		if cfg.Group != "" {
+			groups[configOrder[i]] = cfg.Group
+		}
+	}
+
 	return &TokenManager{
 		cache:        NewSimpleTokenCache(config.TokenCacheTTL),
 		configs:      configs,
 		configOrder:  configOrder,
 		currentIndex: 0,
 		exhausted:    make(map[string]bool),
+		groups:       groups,
+		poolEmpty:    make(map[string]bool),
+		maxInFlight:  maxInFlight,
+		inFlight:     inFlight,
+		affinity:     make(map[string]affinityEntry),
+		affinityTTL:  config.DefaultAffinityTTL,
+		resetTimers:  make(map[string]*resetTimer),
 	}
 }
 
 // getBestToken 获取最优可用token
 // 统一锁管理：所有操作在单一锁保护下完成，避免多次加锁/解锁
+// 注意：不占用并发槽位，处理客户端请求应使用 AcquireToken
 func (tm *TokenManager) getBestToken() (types.TokenInfo, error) {
 	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
+	defer tm.unlock()
 
 	// 检查是否需要刷新缓存（在锁内）
-	if time.Since(tm.lastRefresh) > config.TokenCacheTTL {
-		if err := tm.refreshCacheUnlocked(); err != nil {
-			logger.Warn("刷新token缓存失败", logger.Err(err))
-		}
-	}
+	tm.refreshIfStaleUnlocked()
 
 	// 选择最优token（内部方法，不加锁）
-	bestToken := tm.selectBestTokenUnlocked()
+	bestKey, bestToken, _ := tm.selectBestTokenUnlocked("")
 	if bestToken == nil {
 		return types.TokenInfo{}, fmt.Errorf("没有可用的token")
 	}
 
-	// 更新最后使用时间（在锁内，安全）
-	bestToken.LastUsed = time.Now()
-	if bestToken.Available > 0 {
-		bestToken.Available--
-	}
+	tm.markUsedUnlocked(bestKey, bestToken)
+	return bestToken.Token, nil
+}
 
-	// 记录最后使用的token key
-	for key, cached := range tm.cache.tokens {
-		if cached == bestToken {
-			tm.lastUsedKey = key
-			break
+// refreshIfStaleUnlocked 缓存超过TTL时刷新
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) refreshIfStaleUnlocked() {
+	if time.Since(tm.lastRefresh) > config.TokenCacheTTL {
+		if err := tm.refreshCacheUnlocked(); err != nil {
+			logger.Warn("刷新token缓存失败", logger.Err(err))
 		}
 	}
+}
 
-	return bestToken.Token, nil
+// markUsedUnlocked 记录token被使用：更新最后使用时间、递减可用次数
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) markUsedUnlocked(key string, cached *CachedToken) {
+	cached.LastUsed = time.Now()
+	if cached.Available > 0 {
+		cached.Available--
+	}
+	tm.lastUsedKey = key
 }
 
-// selectBestTokenUnlocked 按配置顺序选择下一个可用token
+// selectBestTokenUnlocked 按配置顺序选择下一个可用且未达并发上限的token
+// group 非空时只在该分组的token中选择
 // 内部方法：调用者必须持有 tm.mutex
-// 重构说明：从selectBestToken改为Unlocked后缀，明确锁约定
-func (tm *TokenManager) selectBestTokenUnlocked() *CachedToken {
+// 返回值 saturated 表示存在可用token但其并发均已满（此时应排队而不是失败）
+func (tm *TokenManager) selectBestTokenUnlocked(group string) (string, *CachedToken, bool) {
 	// 调用者已持有 tm.mutex，无需额外加锁
+	saturated := false
+	skipped := false // 跳过了并发已满或其他分组的token，此时不移动粘性索引
 
 	// 如果没有配置顺序，降级到按map遍历顺序
 	if len(tm.configOrder) == 0 {
 		for key, cached := range tm.cache.tokens {
+			if !tm.inGroupUnlocked(key, group) {
+				continue
+			}
 			if time.Since(cached.CachedAt) <= tm.cache.ttl && cached.IsUsable() {
+				if !tm.hasCapacityUnlocked(key) {
+					saturated = true
+					continue
+				}
 				logger.Debug("顺序策略选择token（无顺序配置）",
 					logger.String("selected_key", key),
 					logger.Float64("available_count", cached.Available))
-				return cached
+				delete(tm.poolEmpty, group)
+				return key, cached, false
 			}
 		}
-		return nil
+		return "", nil, saturated
 	}
 
 	// 从当前索引开始，找到第一个可用的token
+	index := tm.currentIndex
 	for attempts := 0; attempts < len(tm.configOrder); attempts++ {
-		currentKey := tm.configOrder[tm.currentIndex]
+		currentKey := tm.configOrder[index]
+		nextIndex := (index + 1) % len(tm.configOrder)
 
-		// 检查这个token是否存在且可用
-		if cached, exists := tm.cache.tokens[currentKey]; exists {
-			// 检查token是否过期
-			if time.Since(cached.CachedAt) > tm.cache.ttl {
-				tm.exhausted[currentKey] = true
-				tm.currentIndex = (tm.currentIndex + 1) % len(tm.configOrder)
-				continue
-			}
+		if !tm.inGroupUnlocked(currentKey, group) {
+			skipped = true
+			index = nextIndex
+			continue
+		}
 
-			// 检查token是否可用
-			if cached.IsUsable() {
+		// 检查这个token是否存在、未过期且可用
+		if cached, exists := tm.cache.tokens[currentKey]; exists &&
+			time.Since(cached.CachedAt) <= tm.cache.ttl && cached.IsUsable() {
+			if tm.hasCapacityUnlocked(currentKey) {
 				logger.Debug("顺序策略选择token",
 					logger.String("selected_key", currentKey),
-					logger.Int("index", tm.currentIndex),
+					logger.Int("index", index),
 					logger.Float64("available_count", cached.Available))
-				return cached
+				delete(tm.poolEmpty, group)
+				return currentKey, cached, false
 			}
+
+			// 并发已满：临时跳过，不标记耗尽，也不移动粘性索引
+			saturated = true
+			skipped = true
+			index = nextIndex
+			continue
 		}
 
 		// 标记当前token为已耗尽，移动到下一个
+		if !tm.exhausted[currentKey] {
+			reason := tm.unusableReasonUnlocked(currentKey)
+			tm.noticeUnlocked(func() { alert.NotifyTokenDisabled(currentKey, reason, nil) })
+			tm.publishTokenEventUnlocked(events.TypeTokenExhausted, currentKey, map[string]any{"reason": reason})
+		}
 		tm.exhausted[currentKey] = true
-		tm.currentIndex = (tm.currentIndex + 1) % len(tm.configOrder)
+		if cached, exists := tm.cache.tokens[currentKey]; exists {
+			tm.scheduleResetRecheckUnlocked(currentKey, cached)
+		}
+		if !skipped {
+			tm.currentIndex = nextIndex
+		}
+		index = nextIndex
 
 		logger.Debug("token不可用，切换到下一个",
 			logger.String("exhausted_key", currentKey),
-			logger.Int("next_index", tm.currentIndex))
+			logger.Int("next_index", index))
+	}
+
+	if saturated {
+		logger.Debug("所有可用token并发已满",
+			logger.Int("total_count", len(tm.configOrder)))
+		delete(tm.poolEmpty, group)
+		return "", nil, true
 	}
 
-	// 所有token都不可用
+	// 所有token都不可用：只在从有可用token变为没有时通知，排队请求的每次重试不再重复通知
+	if tm.poolEmpty[group] {
+		return "", nil, false
+	}
+	tm.poolEmpty[group] = true
 	logger.Warn("所有token都不可用",
 		logger.Int("total_count", len(tm.configOrder)),
-		logger.Int("exhausted_count", len(tm.exhausted)))
+		logger.Int("exhausted_count", len(tm.exhausted)),
+		logger.String("group", group))
+	total, exhausted := len(tm.configOrder), len(tm.exhausted)
+	tm.noticeUnlocked(func() {
+		if group == "" {
+			alert.NotifyPoolEmpty(total)
+		}
+		events.Publish(events.TypePoolEmpty, map[string]any{
+			"total":     total,
+			"exhausted": exhausted,
+			"group":     group,
+		})
+	})
+
+	return "", nil, false
+}
 
-	return nil
+// inGroupUnlocked 检查token是否属于指定分组，group为空表示不限制
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) inGroupUnlocked(key, group string) bool {
+	return group == "" || tm.groups[key] == group
+}
+
+// unusableReasonUnlocked 描述token不可用的原因（用于告警）
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) unusableReasonUnlocked(key string) string {
+	cached, exists := tm.cache.tokens[key]
+	switch {
+	case !exists:
+		return "token刷新失败或尚未缓存"
+	case time.Now().After(cached.Token.ExpiresAt):
+		return "access token已过期"
+	case cached.Available <= 0:
+		return "额度已耗尽"
+	default:
+		return "token缓存已过期"
+	}
+}
+
+// noticeUnlocked 登记告警或审计记录，释放锁后由 unlock 发出
+// 告警发送和审计写文件都可能阻塞，不能在持有 tm.mutex 时执行
+// 内部方法：调用者必须持有 tm.mutex（写锁）
+func (tm *TokenManager) noticeUnlocked(notice func()) {
+	tm.notices = append(tm.notices, notice)
+}
+
+// unlock 释放 tm.mutex 写锁，并按顺序发出加锁期间登记的告警和审计记录
+func (tm *TokenManager) unlock() {
+	notices := tm.notices
+	tm.notices = nil
+	tm.mutex.Unlock()
+
+	for _, notice := range notices {
+		notice()
+	}
+}
+
+// publishTokenEventUnlocked 发布token事件，附带token标识和配置索引
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) publishTokenEventUnlocked(eventType, key string, data map[string]any) {
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data["token_id"] = tm.tokenIDUnlocked(key)
+	data["index"] = tm.configIndexUnlocked(key)
+	events.Publish(eventType, data)
 }
 
 // refreshCacheUnlocked 刷新token缓存
@@ -167,45 +322,86 @@ func (tm *TokenManager) refreshCacheUnlocked() error {
 		if cfg.Disabled {
 			continue
 		}
+		tm.refreshOneUnlocked(i, cfg)
+	}
 
-		// 刷新token
-		token, err := tm.refreshSingleToken(cfg)
-		if err != nil {
-			logger.Warn("刷新单个token失败",
-				logger.Int("config_index", i),
-				logger.String("auth_type", cfg.AuthType),
-				logger.Err(err))
-			continue
-		}
+	tm.lastRefresh = time.Now()
+	return nil
+}
 
-		// 检查使用限制
-		var usageInfo *types.UsageLimits
-		var available float64
+// refreshOneUnlocked 刷新单个token并更新缓存，失败时保留原缓存
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) refreshOneUnlocked(i int, cfg AuthConfig) {
+	cacheKey := tm.configOrder[i]
+
+	// 刷新token并检查使用限制
+	cached, err := fetchCachedToken(tm, cfg)
+	if err != nil {
+		logger.Warn("刷新单个token失败",
+			logger.Int("config_index", i),
+			logger.String("auth_type", cfg.AuthType),
+			logger.Err(err))
+		tokenID := tm.tokenIDUnlocked(cacheKey)
+		tm.noticeUnlocked(func() {
+			alert.NotifyRefreshFailed(cacheKey, err, map[string]any{"auth_type": cfg.AuthType})
+			webconfig.RecordSystemAudit(webconfig.AuditActionTokenRefreshFail, tokenID, err.Error())
+		})
+		tm.publishTokenEventUnlocked(events.TypeTokenRefreshFailed, cacheKey, map[string]any{"error": logger.RedactSecrets(err.Error())})
+		return
+	}
 
-		checker := NewUsageLimitsChecker()
-		if usage, checkErr := checker.CheckUsageLimits(token); checkErr == nil {
-			usageInfo = usage
-			available = CalculateAvailableCount(usage)
-		} else {
-			logger.Warn("检查使用限制失败", logger.Err(checkErr))
-		}
+	// 更新缓存（直接访问，已在tm.mutex保护下）
+	tm.storeCachedUnlocked(cacheKey, cached)
+	tm.publishTokenEventUnlocked(events.TypeTokenRefreshed, cacheKey, map[string]any{
+		"available":  cached.Available,
+		"expires_at": cached.Token.ExpiresAt,
+	})
+	if cached.UsageInfo != nil {
+		available := cached.Available
+		tm.noticeUnlocked(func() { alert.NotifyLowCredit(cacheKey, available, nil) })
+	}
 
-		// 更新缓存（直接访问，已在tm.mutex保护下）
-		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, i)
-		tm.cache.tokens[cacheKey] = &CachedToken{
-			Token:     token,
-			UsageInfo: usageInfo,
-			CachedAt:  time.Now(),
-			Available: available,
-		}
+	// 额度已耗尽时安排在重置后重新检查
+	if cached.Available <= 0 {
+		tm.scheduleResetRecheckUnlocked(cacheKey, cached)
+	}
+
+	logger.Debug("token缓存更新",
+		logger.String("cache_key", cacheKey),
+		logger.Float64("available", cached.Available))
+}
+
+// storeCachedUnlocked 更新token缓存，并把两次观测之间已用额度的变化报告给预算统计
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) storeCachedUnlocked(key string, cached *CachedToken) {
+	if previous, exists := tm.cache.tokens[key]; exists && previous.UsageInfo != nil && cached.UsageInfo != nil {
+		usage.ObserveCredits(tm.tokenIDUnlocked(key), cached.UsageInfo.CreditsUsed()-previous.UsageInfo.CreditsUsed())
+	}
+	tm.cache.tokens[key] = cached
+}
 
-		logger.Debug("token缓存更新",
-			logger.String("cache_key", cacheKey),
-			logger.Float64("available", available))
+// fetchCachedToken 刷新token并检查使用限制，生成缓存条目（测试中可替换，避免网络请求）
+var fetchCachedToken = func(tm *TokenManager, cfg AuthConfig) (*CachedToken, error) {
+	token, err := tm.refreshSingleToken(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	tm.lastRefresh = time.Now()
-	return nil
+	cached := &CachedToken{
+		Token:    token,
+		CachedAt: time.Now(),
+	}
+
+	checker := NewUsageLimitsChecker()
+	if usage, checkErr := checker.CheckUsageLimits(token); checkErr == nil {
+		cached.UsageInfo = usage
+		cached.Available = CalculateAvailableCount(usage)
+		cached.ResetAt = usage.ResetTime(time.Now())
+	} else {
+		logger.Warn("检查使用限制失败", logger.Err(checkErr))
+	}
+
+	return cached, nil
 }
 
 // IsUsable 检查缓存的token是否可用
@@ -276,7 +472,7 @@ func (tm *TokenManager) GetCurrentTokenKey() string {
 // SwitchToToken 手动切换到指定的token（通过索引）
 func (tm *TokenManager) SwitchToToken(configIndex int) error {
 	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
+	defer tm.unlock()
 
 	if configIndex < 0 || configIndex >= len(tm.configOrder) {
 		return fmt.Errorf("无效的token索引: %d", configIndex)
@@ -289,6 +485,9 @@ func (tm *TokenManager) SwitchToToken(configIndex int) error {
 	targetKey := tm.configOrder[configIndex]
 	delete(tm.exhausted, targetKey)
 
+	// 切换后可能有空闲槽位，唤醒排队请求
+	tm.dispatchWaitersUnlocked()
+
 	logger.Info("手动切换token",
 		logger.Int("target_index", configIndex),
 		logger.String("target_key", targetKey))
@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/events"
+	"kiro2api/logger"
+	"kiro2api/metrics"
+	"kiro2api/types"
+)
+
+var (
+	// ErrTokenQueueFull 所有token并发已满且排队队列已满
+	ErrTokenQueueFull = errors.New("所有token并发已满，排队队列已满")
+	// ErrTokenQueueTimeout 排队等待可用token超时
+	ErrTokenQueueTimeout = errors.New("等待可用token超时")
+
+	// errNoAvailableToken 没有可用token（非并发饱和）
+	errNoAvailableToken = errors.New("没有可用的token")
+)
+
+// QueueOptions token排队参数，零值使用默认配置
+type QueueOptions struct {
+	Size    int           // 最大排队请求数
+	Timeout time.Duration // 排队超时时间
+}
+
+func (o QueueOptions) size() int {
+	if o.Size > 0 {
+		return o.Size
+	}
+	return config.DefaultTokenQueueSize
+}
+
+func (o QueueOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return config.DefaultTokenQueueTimeout
+}
+
+// tokenWaiter 排队等待token的请求
+type tokenWaiter struct {
+	ready       chan tokenGrant // 缓冲为1，分配结果只写一次
+	enqueuedAt  time.Time
+	affinityKey string // 会话亲和键，可为空
+	group       string // 限定的token分组，可为空
+}
+
+// tokenGrant 分配给排队请求的结果
+type tokenGrant struct {
+	token   types.TokenInfo
+	release func()
+	err     error
+}
+
+// queueStats 排队统计（由 TokenManager.mutex 保护）
+type queueStats struct {
+	queued    int64
+	timeouts  int64
+	rejected  int64
+	waitCount int64
+	totalWait time.Duration
+	maxWait   time.Duration
+	lastWait  time.Duration
+}
+
+// inFlightSlot 单个token的在途请求计数
+// 热重载时新token池按 configIdentity 继承旧池的计数对象，旧池中尚未结束的请求仍占用新池的并发额度，
+// 新旧两个池各自持有自己的锁，因此计数使用原子操作
+type inFlightSlot struct {
+	count atomic.Int64
+}
+
+// tryAcquire 未达到上限时占用一个槽位，limit<=0 表示不限制
+func (s *inFlightSlot) tryAcquire(limit int) bool {
+	for {
+		n := s.count.Load()
+		if limit > 0 && n >= int64(limit) {
+			return false
+		}
+		if s.count.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release 释放一个槽位
+func (s *inFlightSlot) release() {
+	for {
+		n := s.count.Load()
+		if n <= 0 || s.count.CompareAndSwap(n, n-1) {
+			return
+		}
+	}
+}
+
+// load 返回当前在途请求数
+func (s *inFlightSlot) load() int {
+	if s == nil {
+		return 0
+	}
+	return int(s.count.Load())
+}
+
+// SetQueueOptions 设置排队参数
+func (tm *TokenManager) SetQueueOptions(opts QueueOptions) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.queueOpts = opts
+}
+
+// AcquireToken 获取token并占用一个并发槽位
+// affinityKey 非空时优先使用该会话绑定的token（见 token_affinity.go）
+// ctx 通过 WithTokenGroup 指定分组时只使用该分组的token
+// 所有token并发饱和时按FIFO排队等待，队列已满或等待超时返回错误
+// 调用方必须在请求（包括流式响应）结束后调用返回的release函数
+func (tm *TokenManager) AcquireToken(ctx context.Context, affinityKey string) (types.TokenInfo, func(), error) {
+	tm.mutex.Lock()
+	if tm.stopped {
+		// 已被热重载替换，由调用方在新的token池上重试
+		tm.unlock()
+		return types.TokenInfo{}, nil, errPoolRetired
+	}
+	tm.refreshIfStaleUnlocked()
+
+	group := TokenGroupFromContext(ctx)
+	if group != "" && affinityKey != "" {
+		// 不同分组的请求即使会话相同也不能共用绑定
+		affinityKey = group + "|" + affinityKey
+	}
+
+	// 同分组已有请求在排队时直接入队，保证先到先得
+	if !tm.hasWaitersUnlocked(group) {
+		token, release, saturated, err := tm.acquireUnlocked(affinityKey, group)
+		if err == nil || !saturated {
+			tm.unlock()
+			return token, release, err
+		}
+	}
+
+	if len(tm.waiters) >= tm.queueOpts.size() {
+		tm.queueStats.rejected++
+		depth := len(tm.waiters)
+		tm.unlock()
+		logger.Warn("token排队队列已满，拒绝请求", logger.Int("queue_depth", depth))
+		return types.TokenInfo{}, nil, ErrTokenQueueFull
+	}
+
+	waiter := &tokenWaiter{
+		ready:       make(chan tokenGrant, 1),
+		enqueuedAt:  time.Now(),
+		affinityKey: affinityKey,
+		group:       group,
+	}
+	tm.waiters = append(tm.waiters, waiter)
+	tm.queueStats.queued++
+	depth := len(tm.waiters)
+	timeout := tm.queueOpts.timeout()
+	tm.unlock()
+
+	logger.Debug("所有token并发已满，请求进入排队",
+		logger.Int("queue_depth", depth),
+		logger.Duration("timeout", timeout))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var waitErr error
+	select {
+	case grant := <-waiter.ready:
+		return grant.token, grant.release, grant.err
+	case <-timer.C:
+		waitErr = ErrTokenQueueTimeout
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+
+	tm.mutex.Lock()
+	removed := tm.removeWaiterUnlocked(waiter)
+	if removed && errors.Is(waitErr, ErrTokenQueueTimeout) {
+		tm.queueStats.timeouts++
+		tm.recordWaitUnlocked(time.Since(waiter.enqueuedAt))
+	}
+	tm.unlock()
+
+	if !removed {
+		// 超时与分配同时发生时，以分配结果为准
+		grant := <-waiter.ready
+		return grant.token, grant.release, grant.err
+	}
+
+	logger.Warn("等待可用token失败",
+		logger.Duration("waited", time.Since(waiter.enqueuedAt)),
+		logger.Err(waitErr))
+	return types.TokenInfo{}, nil, waitErr
+}
+
+// acquireUnlocked 选择token并占用一个并发槽位
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) acquireUnlocked(affinityKey, group string) (types.TokenInfo, func(), bool, error) {
+	key, cached := tm.selectAffinityTokenUnlocked(affinityKey)
+	if cached != nil && !tm.inGroupUnlocked(key, group) {
+		// 热重载后token分组发生变化，绑定不再有效
+		key, cached = "", nil
+	}
+	affinity := cached != nil
+	if cached == nil {
+		var saturated bool
+		key, cached, saturated = tm.selectBestTokenUnlocked(group)
+		if cached == nil {
+			return types.TokenInfo{}, nil, saturated, errNoAvailableToken
+		}
+	}
+
+	if !tm.inFlight[key].tryAcquire(tm.maxInFlight[key]) {
+		// 热重载期间旧token池的请求同时占用了最后一个槽位
+		return types.TokenInfo{}, nil, true, errNoAvailableToken
+	}
+	if !affinity {
+		// 占到槽位后再绑定，避免会话被绑定到没有拿到的token
+		tm.bindAffinityUnlocked(affinityKey, key)
+	}
+	tm.markUsedUnlocked(key, cached)
+
+	token := cached.Token
+	token.ID = tm.tokenIDUnlocked(key)
+	token.Index = tm.configIndexUnlocked(key)
+	tm.publishTokenEventUnlocked(events.TypeTokenSelected, key, map[string]any{
+		"available": cached.Available,
+		"in_flight": tm.inFlight[key].load(),
+		"affinity":  affinity,
+		"group":     group,
+	})
+	return token, tm.releaseFunc(key), false, nil
+}
+
+// tokenIDUnlocked 返回token的配置标识，没有配置ID时使用缓存键
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) tokenIDUnlocked(key string) string {
+	if i := tm.configIndexUnlocked(key); i >= 0 && tm.configs[i].ID != "" {
+		return tm.configs[i].ID
+	}
+	return key
+}
+
+// releaseFunc 返回释放并发槽位的函数，重复调用是安全的
+// 槽位可能已被热重载后的新token池继承，释放后同时唤醒接替的token池中的排队请求
+func (tm *TokenManager) releaseFunc(key string) func() {
+	var once sync.Once
+	slot := tm.inFlight[key]
+	return func() {
+		once.Do(func() {
+			slot.release()
+			for pool := tm; pool != nil; {
+				pool.mutex.Lock()
+				pool.dispatchWaitersUnlocked()
+				next := pool.successor
+				pool.unlock()
+				pool = next
+			}
+		})
+	}
+}
+
+// hasCapacityUnlocked 检查token是否还有空闲并发槽位
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) hasCapacityUnlocked(key string) bool {
+	limit := tm.maxInFlight[key]
+	return limit <= 0 || tm.inFlight[key].load() < limit
+}
+
+// dispatchWaitersUnlocked 按FIFO顺序为排队请求分配token
+// 同一分组内严格先到先得；某分组仍然饱和时不阻塞其他分组的排队请求
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) dispatchWaitersUnlocked() {
+	var blocked map[string]bool // 本轮已确认饱和的分组
+	remaining := tm.waiters[:0]
+
+	for i, waiter := range tm.waiters {
+		tm.waiters[i] = nil
+		if blocked[waiter.group] {
+			remaining = append(remaining, waiter)
+			continue
+		}
+
+		token, release, saturated, err := tm.acquireUnlocked(waiter.affinityKey, waiter.group)
+		if saturated {
+			if blocked == nil {
+				blocked = make(map[string]bool)
+			}
+			blocked[waiter.group] = true
+			remaining = append(remaining, waiter)
+			continue
+		}
+
+		tm.recordWaitUnlocked(time.Since(waiter.enqueuedAt))
+
+		// token已全部不可用时，排队请求无法再被满足，依次返回错误
+		waiter.ready <- tokenGrant{token: token, release: release, err: err}
+	}
+
+	tm.waiters = remaining
+}
+
+// hasWaitersUnlocked 检查指定分组是否有请求在排队
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) hasWaitersUnlocked(group string) bool {
+	for _, waiter := range tm.waiters {
+		if waiter.group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// removeWaiterUnlocked 从队列中移除等待者，返回是否仍在队列中
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) removeWaiterUnlocked(target *tokenWaiter) bool {
+	for i, waiter := range tm.waiters {
+		if waiter == target {
+			tm.waiters = append(tm.waiters[:i], tm.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// recordWaitUnlocked 记录一次排队等待时间
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) recordWaitUnlocked(wait time.Duration) {
+	tm.queueStats.waitCount++
+	tm.queueStats.totalWait += wait
+	tm.queueStats.lastWait = wait
+	if wait > tm.queueStats.maxWait {
+		tm.queueStats.maxWait = wait
+	}
+}
+
+// ConcurrencyStats 获取并发与排队统计
+func (tm *TokenManager) ConcurrencyStats() types.TokenConcurrencyStats {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	stats := types.TokenConcurrencyStats{
+		QueueDepth:     len(tm.waiters),
+		QueueCapacity:  tm.queueOpts.size(),
+		QueueTimeoutMs: tm.queueOpts.timeout().Milliseconds(),
+		TotalQueued:    tm.queueStats.queued,
+		TotalTimeouts:  tm.queueStats.timeouts,
+		TotalRejected:  tm.queueStats.rejected,
+		MaxWaitMs:      tm.queueStats.maxWait.Milliseconds(),
+		LastWaitMs:     tm.queueStats.lastWait.Milliseconds(),
+		Tokens:         make([]types.TokenInFlightStat, 0, len(tm.configOrder)),
+	}
+	if tm.queueStats.waitCount > 0 {
+		avg := tm.queueStats.totalWait / time.Duration(tm.queueStats.waitCount)
+		stats.AvgWaitMs = float64(avg.Microseconds()) / 1000
+	}
+
+	now := time.Now()
+	for _, entry := range tm.affinity {
+		if now.Before(entry.expiresAt) {
+			stats.AffinityCount++
+		}
+	}
+
+	for i, key := range tm.configOrder {
+		stats.Tokens = append(stats.Tokens, types.TokenInFlightStat{
+			Index:       i,
+			InFlight:    tm.inFlight[key].load(),
+			MaxInFlight: tm.maxInFlight[key],
+		})
+	}
+
+	return stats
+}
+
+// TokenStates 获取各token的额度、过期时间、耗尽状态和在途请求数，供指标采集
+func (tm *TokenManager) TokenStates() []metrics.TokenState {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	states := make([]metrics.TokenState, 0, len(tm.configOrder))
+	for i, key := range tm.configOrder {
+		state := metrics.TokenState{
+			ID:        tm.tokenIDUnlocked(key),
+			Index:     i,
+			Exhausted: tm.exhausted[key],
+			InFlight:  tm.inFlight[key].load(),
+		}
+		if cached, exists := tm.cache.tokens[key]; exists {
+			state.Available = cached.Available
+			state.ExpiresAt = cached.Token.ExpiresAt
+			state.Exhausted = state.Exhausted || (cached.UsageInfo != nil && cached.Available <= 0)
+		}
+		states = append(states, state)
+	}
+	return states
+}
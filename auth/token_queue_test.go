@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/events"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newQueueTestManager 创建预填充缓存的TokenManager，避免网络刷新
+func newQueueTestManager(maxConcurrency []int) *TokenManager {
+	configs := make([]AuthConfig, len(maxConcurrency))
+	for i, limit := range maxConcurrency {
+		configs[i] = AuthConfig{
+			AuthType:       AuthMethodSocial,
+			RefreshToken:   fmt.Sprintf("token%d", i),
+			MaxConcurrency: limit,
+		}
+	}
+
+	tm := NewTokenManager(configs)
+	tm.lastRefresh = time.Now()
+	for i := range configs {
+		tm.cache.tokens[fmt.Sprintf(config.TokenCacheKeyFormat, i)] = &CachedToken{
+			Token: types.TokenInfo{
+				AccessToken: fmt.Sprintf("access_%d", i),
+				ExpiresAt:   time.Now().Add(time.Hour),
+			},
+			CachedAt:  time.Now(),
+			Available: 100,
+		}
+	}
+	return tm
+}
+
+func TestAcquireToken_SkipsSaturatedToken(t *testing.T) {
+	tm := newQueueTestManager([]int{1, 1})
+
+	first, releaseFirst, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+	second, releaseSecond, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "access_0", first.AccessToken)
+	assert.Equal(t, "access_1", second.AccessToken)
+
+	// 释放后应回到粘性索引上的第一个token
+	releaseFirst()
+	third, releaseThird, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "access_0", third.AccessToken)
+
+	releaseSecond()
+	releaseThird()
+	assert.Equal(t, 0, tm.ConcurrencyStats().Tokens[0].InFlight)
+}
+
+func TestAcquireToken_QueueFIFO(t *testing.T) {
+	tm := newQueueTestManager([]int{1})
+	tm.SetQueueOptions(QueueOptions{Size: 10, Timeout: time.Second})
+
+	_, release, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+
+	order := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func(id int) {
+			_, r, err := tm.AcquireToken(context.Background(), "")
+			if err == nil {
+				order <- id
+				r()
+			}
+		}(i)
+		// 确保按顺序入队
+		assert.Eventually(t, func() bool { return tm.ConcurrencyStats().QueueDepth == i+1 }, time.Second, time.Millisecond)
+	}
+
+	release()
+
+	assert.Equal(t, 0, <-order)
+	assert.Equal(t, 1, <-order)
+
+	stats := tm.ConcurrencyStats()
+	assert.Equal(t, 0, stats.QueueDepth)
+	assert.Equal(t, int64(2), stats.TotalQueued)
+}
+
+func TestAcquireToken_QueueTimeoutAndFull(t *testing.T) {
+	tm := newQueueTestManager([]int{1})
+	tm.SetQueueOptions(QueueOptions{Size: 1, Timeout: 20 * time.Millisecond})
+
+	_, release, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := tm.AcquireToken(context.Background(), "")
+		done <- err
+	}()
+	assert.Eventually(t, func() bool { return tm.ConcurrencyStats().QueueDepth == 1 }, time.Second, time.Millisecond)
+
+	// 队列已满时直接拒绝
+	_, _, err = tm.AcquireToken(context.Background(), "")
+	assert.ErrorIs(t, err, ErrTokenQueueFull)
+
+	assert.ErrorIs(t, <-done, ErrTokenQueueTimeout)
+
+	stats := tm.ConcurrencyStats()
+	assert.Equal(t, 0, stats.QueueDepth)
+	assert.Equal(t, int64(1), stats.TotalTimeouts)
+	assert.Equal(t, int64(1), stats.TotalRejected)
+	assert.GreaterOrEqual(t, stats.MaxWaitMs, int64(20))
+}
+
+func TestAcquireToken_UnlimitedByDefault(t *testing.T) {
+	tm := newQueueTestManager([]int{0})
+
+	for i := 0; i < 5; i++ {
+		_, _, err := tm.AcquireToken(context.Background(), "")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 5, tm.ConcurrencyStats().Tokens[0].InFlight)
+	assert.Equal(t, 0, tm.ConcurrencyStats().Tokens[0].MaxInFlight)
+}
+
+func TestAcquireToken_TokenGroup(t *testing.T) {
+	tm := newQueueTestManager([]int{1, 1})
+	tm.groups["token_1"] = "team-a"
+	tm.SetQueueOptions(QueueOptions{Size: 10, Timeout: time.Second})
+	groupCtx := WithTokenGroup(context.Background(), "team-a")
+
+	// 分组请求只使用分组内的token
+	grouped, releaseGrouped, err := tm.AcquireToken(groupCtx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "access_1", grouped.AccessToken)
+	assert.Equal(t, "token_1", grouped.ID)
+
+	// 分组token已满时排队，不占用分组外的token
+	result := make(chan string, 1)
+	go func() {
+		token, release, err := tm.AcquireToken(groupCtx, "")
+		if err == nil {
+			result <- token.AccessToken
+			release()
+		}
+	}()
+	assert.Eventually(t, func() bool { return tm.ConcurrencyStats().QueueDepth == 1 }, time.Second, time.Millisecond)
+
+	// 分组请求排队时，不限分组的请求不受阻塞
+	other, releaseOther, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "access_0", other.AccessToken)
+
+	releaseGrouped()
+	assert.Equal(t, "access_1", <-result)
+
+	releaseOther()
+
+	// 不存在的分组没有可用token
+	_, _, err = tm.AcquireToken(WithTokenGroup(context.Background(), "missing"), "")
+	assert.Error(t, err)
+}
+
+func TestAcquireToken_PublishesSelectedEvent(t *testing.T) {
+	tm := newQueueTestManager([]int{0, 0})
+	sub, _ := events.Subscribe(0)
+	defer sub.Close()
+
+	_, release, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+	defer release()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.C:
+			if ev.Type != events.TypeTokenSelected {
+				continue
+			}
+			assert.Equal(t, "token_0", ev.Data["token_id"])
+			assert.Equal(t, 0, ev.Data["index"])
+			assert.Equal(t, 1, ev.Data["in_flight"])
+			assert.Equal(t, false, ev.Data["affinity"])
+			return
+		case <-timeout:
+			t.Fatal("未收到token选择事件")
+		}
+	}
+}
+
+func TestSelectBestToken_PublishesPoolEmptyOnTransition(t *testing.T) {
+	tm := newQueueTestManager([]int{0})
+	defer tm.Stop()
+	sub, _ := events.Subscribe(0)
+	defer sub.Close()
+
+	// poolEmptyEvents 取出已收到的 pool.empty 事件数
+	poolEmptyEvents := func() int {
+		count := 0
+		for {
+			select {
+			case ev := <-sub.C:
+				if ev.Type == events.TypePoolEmpty {
+					count++
+				}
+			default:
+				return count
+			}
+		}
+	}
+	// selectOnce 选择一次token，返回释放锁后发布的 pool.empty 事件数
+	selectOnce := func() int {
+		tm.mutex.Lock()
+		tm.selectBestTokenUnlocked("")
+		assert.Zero(t, poolEmptyEvents(), "持锁期间不发布事件")
+		tm.unlock()
+		return poolEmptyEvents()
+	}
+
+	tm.mutex.Lock()
+	tm.cache.tokens["token_0"].Available = 0
+	tm.cache.tokens["token_0"].ResetAt = time.Now().Add(48 * time.Hour)
+	tm.mutex.Unlock()
+
+	// 排队请求反复重试只通知一次
+	assert.Equal(t, 1, selectOnce())
+	assert.Zero(t, selectOnce())
+	assert.Zero(t, selectOnce())
+
+	// 恢复后再次变为空时重新通知
+	tm.mutex.Lock()
+	tm.cache.tokens["token_0"].Available = 100
+	tm.mutex.Unlock()
+	assert.Zero(t, selectOnce())
+	tm.mutex.Lock()
+	tm.cache.tokens["token_0"].Available = 0
+	tm.mutex.Unlock()
+	assert.Equal(t, 1, selectOnce())
+}
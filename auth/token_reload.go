@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"kiro2api/logger"
+)
+
+// errPoolRetired token池已被热重载替换，请求应在新的token池上重试
+var errPoolRetired = errors.New("token池已被替换")
+
+// configIdentity 返回token配置的稳定标识，热重载时据此匹配新旧token
+// 优先使用Web配置中的Token ID；环境变量配置没有ID，使用凭据摘要
+func configIdentity(cfg AuthConfig) string {
+	if cfg.ID != "" {
+		return "id:" + cfg.ID
+	}
+	sum := sha256.Sum256([]byte(cfg.AuthType + "\x00" + cfg.RefreshToken + "\x00" + cfg.ClientID))
+	return "rt:" + hex.EncodeToString(sum[:8])
+}
+
+// sameCredentials 凭据和上游配置均未变化时，旧token池中缓存的access token仍然有效
+func sameCredentials(a, b AuthConfig) bool {
+	return a.AuthType == b.AuthType &&
+		a.RefreshToken == b.RefreshToken &&
+		a.ClientID == b.ClientID &&
+		a.ClientSecret == b.ClientSecret &&
+		a.Upstream == b.Upstream
+}
+
+// inheritFrom 从即将被替换的旧token管理器继承仍然存在的token的状态，返回继承的token数
+// 按 configIdentity 匹配（与索引无关），继承缓存、耗尽标记、在途计数、会话亲和、当前位置和排队统计；
+// 在途计数与旧池共享，旧池中未结束的请求继续占用并发额度，MaxConcurrency 在重载前后都不会被突破；
+// 只有新增或凭据变更的token会发起刷新，避免每次保存配置都刷新全部token。
+// 必须在新管理器对外可见之前调用
+func (tm *TokenManager) inheritFrom(old *TokenManager) int {
+	// configs 创建后不再修改，无需加锁即可匹配
+	oldIndex := make(map[string]int, len(old.configs))
+	for j, cfg := range old.configs {
+		oldIndex[configIdentity(cfg)] = j
+	}
+
+	matched := make(map[int]int, len(tm.configs)) // 新索引 -> 旧索引
+	for i, cfg := range tm.configs {
+		if j, ok := oldIndex[configIdentity(cfg)]; ok && sameCredentials(old.configs[j], cfg) {
+			matched[i] = j
+		}
+	}
+
+	tm.mutex.Lock()
+	defer tm.unlock()
+
+	// 先刷新新增的token（网络请求期间不持有旧管理器的锁，旧token池照常服务）
+	for i, cfg := range tm.configs {
+		if _, ok := matched[i]; !ok && !cfg.Disabled {
+			tm.refreshOneUnlocked(i, cfg)
+		}
+	}
+
+	old.mutex.Lock()
+	defer old.mutex.Unlock()
+	old.successor = tm
+
+	keyMap := make(map[string]string, len(matched)) // 旧key -> 新key
+	for i, j := range matched {
+		oldKey, newKey := old.configOrder[j], tm.configOrder[i]
+		keyMap[oldKey] = newKey
+		tm.inFlight[newKey] = old.inFlight[oldKey]
+
+		// 复制缓存条目：旧token池在排空期间仍会修改自己的条目
+		if cached, exists := old.cache.tokens[oldKey]; exists {
+			copied := *cached
+			tm.cache.tokens[newKey] = &copied
+			if old.exhausted[oldKey] {
+				tm.exhausted[newKey] = true
+				tm.scheduleResetRecheckUnlocked(newKey, &copied)
+			}
+		}
+	}
+
+	for sessionID, entry := range old.affinity {
+		if newKey, ok := keyMap[entry.tokenKey]; ok {
+			entry.tokenKey = newKey
+			tm.affinity[sessionID] = entry
+		}
+	}
+
+	if len(old.configOrder) > 0 {
+		if newKey, ok := keyMap[old.configOrder[old.currentIndex]]; ok {
+			tm.currentIndex = tm.configIndexUnlocked(newKey)
+		}
+	}
+	tm.lastUsedKey = keyMap[old.lastUsedKey]
+	tm.lastRefresh = old.lastRefresh
+	tm.queueStats = old.queueStats
+
+	return len(matched)
+}
+
+// retire 停止被替换的token管理器
+// 取消额度重置检查，排队中的请求转到新的token池重试；
+// 在途请求仍持有旧管理器的release函数，结束时正常释放
+func (tm *TokenManager) retire() {
+	tm.Stop()
+
+	tm.mutex.Lock()
+	waiters := tm.waiters
+	tm.waiters = nil
+	tm.mutex.Unlock()
+
+	for _, waiter := range waiters {
+		waiter.ready <- tokenGrant{err: errPoolRetired}
+	}
+
+	if len(waiters) > 0 {
+		logger.Info("旧token池的排队请求已转到新token池", logger.Int("count", len(waiters)))
+	}
+}
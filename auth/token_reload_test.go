@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newReloadTestManager(configs []AuthConfig) *TokenManager {
+	tm := NewTokenManager(configs)
+	tm.lastRefresh = time.Now()
+	for i, cfg := range configs {
+		tm.cache.tokens[tm.configOrder[i]] = &CachedToken{
+			Token: types.TokenInfo{
+				AccessToken: "access_" + cfg.ID,
+				ExpiresAt:   time.Now().Add(time.Hour),
+			},
+			CachedAt:  time.Now(),
+			Available: 100,
+		}
+	}
+	return tm
+}
+
+func TestInheritFrom_MatchesByID(t *testing.T) {
+	var fetched []string
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		fetched = append(fetched, cfg.ID)
+		return &CachedToken{
+			Token:     types.TokenInfo{AccessToken: "access_" + cfg.ID, ExpiresAt: time.Now().Add(time.Hour)},
+			CachedAt:  time.Now(),
+			Available: 50,
+		}, nil
+	})
+
+	old := newReloadTestManager([]AuthConfig{
+		{ID: "a", AuthType: AuthMethodSocial, RefreshToken: "rt-a"},
+		{ID: "b", AuthType: AuthMethodSocial, RefreshToken: "rt-b"},
+	})
+	old.cache.tokens["token_1"].Available = 7
+	old.exhausted["token_0"] = true
+	old.affinity["session-1"] = affinityEntry{tokenKey: "token_1", expiresAt: time.Now().Add(time.Minute)}
+	old.affinity["session-0"] = affinityEntry{tokenKey: "token_0", expiresAt: time.Now().Add(time.Minute)}
+	old.currentIndex = 1
+	old.queueStats.queued = 3
+
+	// 删除a，b移到第一位，新增c
+	tm := NewTokenManager([]AuthConfig{
+		{ID: "b", AuthType: AuthMethodSocial, RefreshToken: "rt-b"},
+		{ID: "c", AuthType: AuthMethodSocial, RefreshToken: "rt-c"},
+	})
+	inherited := tm.inheritFrom(old)
+
+	assert.Equal(t, 1, inherited)
+	assert.Equal(t, []string{"c"}, fetched, "只刷新新增的token")
+	assert.Equal(t, "access_b", tm.cache.tokens["token_0"].Token.AccessToken)
+	assert.Equal(t, float64(7), tm.cache.tokens["token_0"].Available)
+	assert.Equal(t, "access_c", tm.cache.tokens["token_1"].Token.AccessToken)
+	assert.Empty(t, tm.exhausted)
+	assert.Equal(t, "token_0", tm.affinity["session-1"].tokenKey)
+	assert.NotContains(t, tm.affinity, "session-0")
+	assert.Equal(t, 0, tm.currentIndex)
+	assert.Equal(t, int64(3), tm.queueStats.queued)
+
+	// 新旧缓存条目互不影响
+	assert.NotSame(t, old.cache.tokens["token_1"], tm.cache.tokens["token_0"])
+}
+
+func TestInheritFrom_ChangedCredentialsRefreshed(t *testing.T) {
+	var fetches int32
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		atomic.AddInt32(&fetches, 1)
+		return &CachedToken{
+			Token:     types.TokenInfo{AccessToken: "fresh", ExpiresAt: time.Now().Add(time.Hour)},
+			CachedAt:  time.Now(),
+			Available: 10,
+		}, nil
+	})
+
+	old := newReloadTestManager([]AuthConfig{{ID: "a", AuthType: AuthMethodSocial, RefreshToken: "rt-old"}})
+	old.exhausted["token_0"] = true
+
+	tm := NewTokenManager([]AuthConfig{{ID: "a", AuthType: AuthMethodSocial, RefreshToken: "rt-new"}})
+	inherited := tm.inheritFrom(old)
+
+	assert.Equal(t, 0, inherited)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	assert.Equal(t, "fresh", tm.cache.tokens["token_0"].Token.AccessToken)
+	assert.False(t, tm.exhausted["token_0"])
+}
+
+func TestInheritFrom_EnvConfigsMatchByCredentials(t *testing.T) {
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		t.Fatalf("不应刷新未变化的token: %s", cfg.RefreshToken)
+		return nil, nil
+	})
+
+	configs := []AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "rt-x"}}
+	old := newReloadTestManager(configs)
+
+	tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "rt-x", MaxConcurrency: 2}})
+	assert.Equal(t, 1, tm.inheritFrom(old))
+	assert.NotNil(t, tm.cache.tokens["token_0"])
+}
+
+func TestSwapPool_QueuedRequestMovesToNewPool(t *testing.T) {
+	old := newQueueTestManager([]int{1})
+	service := &AuthService{tokenManager: old, configs: old.configs}
+
+	_, releaseInFlight, err := service.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+
+	result := make(chan types.TokenInfo, 1)
+	go func() {
+		token, release, err := service.AcquireToken(context.Background(), "")
+		assert.NoError(t, err)
+		release()
+		result <- token
+	}()
+
+	assert.Eventually(t, func() bool { return old.ConcurrencyStats().QueueDepth == 1 }, time.Second, 5*time.Millisecond)
+
+	replacement := newQueueTestManager([]int{1})
+	replacement.cache.tokens["token_0"].Token.AccessToken = "access_new"
+	service.swapPool(replacement, replacement.configs)
+
+	select {
+	case token := <-result:
+		assert.Equal(t, "access_new", token.AccessToken)
+	case <-time.After(time.Second):
+		t.Fatal("排队请求未转到新token池")
+	}
+
+	// 在途请求在旧token池上正常释放
+	releaseInFlight()
+	assert.Equal(t, 0, old.ConcurrencyStats().Tokens[0].InFlight)
+	assert.Same(t, replacement, service.GetTokenManager())
+
+	// 旧token池不再接受新请求
+	_, _, err = old.AcquireToken(context.Background(), "")
+	assert.ErrorIs(t, err, errPoolRetired)
+}
+
+func TestReload_InFlightCountsCarryOver(t *testing.T) {
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		t.Fatalf("不应刷新未变化的token: %s", cfg.RefreshToken)
+		return nil, nil
+	})
+
+	old := newQueueTestManager([]int{1})
+	service := &AuthService{tokenManager: old, configs: old.configs}
+	_, releaseInFlight, err := service.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+
+	replacement := newQueueTestManager([]int{1})
+	assert.Equal(t, 1, replacement.inheritFrom(old))
+	service.swapPool(replacement, replacement.configs)
+	assert.Equal(t, 1, replacement.ConcurrencyStats().Tokens[0].InFlight, "旧池的在途请求占用新池的并发额度")
+
+	result := make(chan error, 1)
+	go func() {
+		_, release, err := service.AcquireToken(context.Background(), "")
+		if err == nil {
+			release()
+		}
+		result <- err
+	}()
+
+	// 旧池的请求结束前，新池不能突破最大并发数
+	assert.Eventually(t, func() bool { return replacement.ConcurrencyStats().QueueDepth == 1 }, time.Second, 5*time.Millisecond)
+
+	// 旧池的请求结束后，新池的排队请求获得槽位
+	releaseInFlight()
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("旧池释放槽位后新池的排队请求未被唤醒")
+	}
+	assert.Equal(t, 0, replacement.ConcurrencyStats().Tokens[0].InFlight)
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"time"
+
+	"kiro2api/alert"
+	"kiro2api/config"
+	"kiro2api/events"
+	"kiro2api/logger"
+)
+
+// resetTimer 已安排的额度重置后重新检查
+type resetTimer struct {
+	timer *time.Timer
+	at    time.Time
+}
+
+// scheduleResetRecheckUnlocked 为额度耗尽的token安排在重置时间之后重新检查
+// 重置时间未知时不安排，依赖常规的缓存刷新
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) scheduleResetRecheckUnlocked(key string, cached *CachedToken) {
+	if cached.Available > 0 || cached.ResetAt.IsZero() {
+		return
+	}
+
+	at := cached.ResetAt.Add(config.QuotaResetRecheckDelay)
+	if at.Before(time.Now()) {
+		// 已过重置时间但额度仍未恢复，稍后重试
+		at = time.Now().Add(config.QuotaResetRetryInterval)
+	}
+	tm.scheduleRecheckAtUnlocked(key, at)
+}
+
+// scheduleRecheckAtUnlocked 在指定时间重新检查token，同一token只保留一个待执行的检查
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) scheduleRecheckAtUnlocked(key string, at time.Time) {
+	if tm.stopped {
+		return
+	}
+	if _, exists := tm.resetTimers[key]; exists {
+		return
+	}
+
+	delay := time.Until(at)
+	if delay < 0 {
+		delay = 0
+	}
+	tm.resetTimers[key] = &resetTimer{
+		at:    at,
+		timer: time.AfterFunc(delay, func() { tm.recheckToken(key) }),
+	}
+
+	logger.Info("已安排额度重置后重新检查token",
+		logger.String("token_key", key),
+		logger.String("recheck_at", at.Format(time.RFC3339)))
+}
+
+// recheckToken 重新检查token额度，额度恢复后重新加入轮换
+// 网络请求期间不持有锁，检查登记保留到请求结束，避免同一token被重复安排
+func (tm *TokenManager) recheckToken(key string) {
+	tm.mutex.Lock()
+	scheduled, exists := tm.resetTimers[key]
+	if !exists {
+		tm.mutex.Unlock()
+		return // 已被Stop取消
+	}
+	index := tm.configIndexUnlocked(key)
+	if index < 0 || tm.configs[index].Disabled {
+		delete(tm.resetTimers, key)
+		tm.mutex.Unlock()
+		return
+	}
+	cfg := tm.configs[index]
+	tm.mutex.Unlock()
+
+	cached, err := fetchCachedToken(tm, cfg)
+
+	tm.mutex.Lock()
+	defer tm.unlock()
+
+	if tm.resetTimers[key] != scheduled {
+		return // 网络请求期间已被Stop取消
+	}
+	delete(tm.resetTimers, key)
+
+	if err != nil {
+		logger.Warn("额度重置后重新检查token失败",
+			logger.String("token_key", key),
+			logger.Err(err))
+		tm.noticeUnlocked(func() { alert.NotifyRefreshFailed(key, err, nil) })
+		tm.publishTokenEventUnlocked(events.TypeTokenRefreshFailed, key, map[string]any{"error": logger.RedactSecrets(err.Error())})
+		tm.scheduleRecheckAtUnlocked(key, time.Now().Add(config.QuotaResetRetryInterval))
+		return
+	}
+	tm.storeCachedUnlocked(key, cached)
+
+	if cached.Available <= 0 {
+		logger.Info("token额度尚未恢复",
+			logger.String("token_key", key),
+			logger.String("reset_at", cached.ResetAt.Format(time.RFC3339)))
+		if cached.ResetAt.IsZero() {
+			tm.scheduleRecheckAtUnlocked(key, time.Now().Add(config.QuotaResetRetryInterval))
+		} else {
+			tm.scheduleResetRecheckUnlocked(key, cached)
+		}
+		return
+	}
+
+	delete(tm.exhausted, key)
+	tm.publishTokenEventUnlocked(events.TypeTokenRestored, key, map[string]any{"available": cached.Available})
+	logger.Info("token额度已重置，重新加入轮换",
+		logger.String("token_key", key),
+		logger.Float64("available", cached.Available))
+
+	// 额度恢复后可能有排队请求可以被满足
+	tm.dispatchWaitersUnlocked()
+}
+
+// configIndexUnlocked 返回token key对应的配置索引，不存在时返回-1
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) configIndexUnlocked(key string) int {
+	for i, orderKey := range tm.configOrder {
+		if orderKey == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Stop 停止所有待执行的重新检查，TokenManager被替换时调用
+func (tm *TokenManager) Stop() {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.stopped = true
+	for key, scheduled := range tm.resetTimers {
+		scheduled.timer.Stop()
+		delete(tm.resetTimers, key)
+	}
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+	"kiro2api/usage"
+	"kiro2api/webconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubFetchCachedToken 替换token刷新逻辑，避免网络请求
+func stubFetchCachedToken(t *testing.T, fetch func(cfg AuthConfig) (*CachedToken, error)) {
+	original := fetchCachedToken
+	fetchCachedToken = func(_ *TokenManager, cfg AuthConfig) (*CachedToken, error) {
+		return fetch(cfg)
+	}
+	t.Cleanup(func() { fetchCachedToken = original })
+}
+
+func TestUsageLimits_ResetTime(t *testing.T) {
+	now := time.Date(2025, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	breakdown := &types.UsageLimits{
+		NextDateReset: 1762000000,
+		UsageBreakdownList: []types.UsageBreakdown{
+			{ResourceType: "CREDIT", NextDateReset: 1761955200, UsageLimitWithPrecision: 50},
+		},
+	}
+	assert.Equal(t, time.Unix(1761955200, 0), breakdown.ResetTime(now))
+	assert.Equal(t, 50.0, breakdown.ResetCreditLimit())
+
+	millis := &types.UsageLimits{NextDateReset: 1761955200000}
+	assert.Equal(t, time.Unix(1761955200, 0), millis.ResetTime(now))
+
+	days := &types.UsageLimits{DaysUntilReset: 14}
+	assert.Equal(t, time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC), days.ResetTime(now))
+
+	assert.True(t, (&types.UsageLimits{}).ResetTime(now).IsZero())
+}
+
+// creditUsage 构造已用指定额度的使用限制
+func creditUsage(used, freeTrialUsed float64) *types.UsageLimits {
+	return &types.UsageLimits{
+		UsageBreakdownList: []types.UsageBreakdown{{
+			ResourceType:              "CREDIT",
+			UsageLimitWithPrecision:   100,
+			CurrentUsageWithPrecision: used,
+			FreeTrialInfo: &types.FreeTrialInfo{
+				FreeTrialStatus:           "ACTIVE",
+				UsageLimitWithPrecision:   50,
+				CurrentUsageWithPrecision: freeTrialUsed,
+			},
+		}},
+	}
+}
+
+func TestUsageLimits_CreditsUsed(t *testing.T) {
+	assert.Equal(t, 15.0, creditUsage(10, 5).CreditsUsed())
+
+	inactive := creditUsage(10, 5)
+	inactive.UsageBreakdownList[0].FreeTrialInfo.FreeTrialStatus = "EXPIRED"
+	assert.Equal(t, 10.0, inactive.CreditsUsed())
+}
+
+func TestRefreshToken_ReportsCreditDelta(t *testing.T) {
+	budgets, err := usage.OpenBudgetTracker(filepath.Join(t.TempDir(), usage.BudgetFileName))
+	assert.NoError(t, err)
+	usage.SetDefaultBudgets(budgets)
+	defer usage.SetDefaultBudgets(nil)
+
+	tm := newQueueTestManager([]int{0})
+	tm.cache.tokens["token_0"].UsageInfo = creditUsage(10, 0)
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		return &CachedToken{
+			Token:     types.TokenInfo{AccessToken: "access_0", ExpiresAt: time.Now().Add(time.Hour)},
+			UsageInfo: creditUsage(12, 0.5),
+			CachedAt:  time.Now(),
+			Available: 50,
+		}, nil
+	})
+
+	// 请求结束时按估算记账，刷新后以观测到的额度增量替换估算
+	budgets.Charge("k1", "token_0", 1)
+	tm.mutex.Lock()
+	tm.refreshOneUnlocked(0, tm.configs[0])
+	tm.mutex.Unlock()
+
+	daily := budgets.Status("k1", webconfig.BudgetConfig{})[0]
+	assert.InDelta(t, 2.5, daily.Spent, 1e-9)
+	assert.Zero(t, daily.Estimated)
+}
+
+func TestRecheckToken_ReenablesAfterReset(t *testing.T) {
+	tm := newQueueTestManager([]int{0})
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		return &CachedToken{
+			Token:     types.TokenInfo{AccessToken: "access_reset", ExpiresAt: time.Now().Add(time.Hour)},
+			CachedAt:  time.Now(),
+			Available: 50,
+		}, nil
+	})
+
+	// 耗尽token，安排立即重新检查
+	tm.mutex.Lock()
+	tm.cache.tokens["token_0"].Available = 0
+	tm.cache.tokens["token_0"].ResetAt = time.Now().Add(-time.Hour)
+	tm.exhausted["token_0"] = true
+	tm.scheduleRecheckAtUnlocked("token_0", time.Now())
+	tm.mutex.Unlock()
+
+	assert.Eventually(t, func() bool {
+		tm.mutex.RLock()
+		defer tm.mutex.RUnlock()
+		return !tm.exhausted["token_0"] && len(tm.resetTimers) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	token, release, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_reset", token.AccessToken)
+}
+
+func TestRecheckToken_ReschedulesWhenStillExhausted(t *testing.T) {
+	tm := newQueueTestManager([]int{0})
+	resetAt := time.Now().Add(24 * time.Hour)
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		return &CachedToken{CachedAt: time.Now(), Available: 0, ResetAt: resetAt}, nil
+	})
+
+	tm.mutex.Lock()
+	tm.scheduleRecheckAtUnlocked("token_0", time.Now())
+	tm.mutex.Unlock()
+
+	assert.Eventually(t, func() bool {
+		tm.mutex.RLock()
+		defer tm.mutex.RUnlock()
+		scheduled, exists := tm.resetTimers["token_0"]
+		return exists && scheduled.at.After(resetAt)
+	}, time.Second, 5*time.Millisecond)
+
+	tm.Stop()
+	tm.mutex.RLock()
+	assert.Empty(t, tm.resetTimers)
+	tm.mutex.RUnlock()
+}
+
+func TestRecheckToken_FetchesWithoutLockAndHonorsStop(t *testing.T) {
+	tm := newQueueTestManager([]int{0})
+	fetching := make(chan struct{})
+	proceed := make(chan struct{})
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		close(fetching)
+		<-proceed
+		return &CachedToken{CachedAt: time.Now(), Available: 50}, nil
+	})
+
+	tm.mutex.Lock()
+	tm.cache.tokens["token_0"].Available = 0
+	tm.exhausted["token_0"] = true
+	tm.scheduleRecheckAtUnlocked("token_0", time.Now())
+	tm.mutex.Unlock()
+
+	select {
+	case <-fetching:
+	case <-time.After(time.Second):
+		t.Fatal("未执行重新检查")
+	}
+
+	// 网络请求期间不持有锁，可以正常查询和停止
+	assert.Len(t, tm.ConcurrencyStats().Tokens, 1)
+	tm.Stop()
+	close(proceed)
+
+	// 停止后的检查结果被丢弃，不会把token重新加入轮换
+	time.Sleep(20 * time.Millisecond)
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+	assert.True(t, tm.exhausted["token_0"])
+	assert.Equal(t, float64(0), tm.cache.tokens["token_0"].Available)
+	assert.Empty(t, tm.resetTimers)
+}
+
+func TestSelectBestToken_SchedulesRecheckForExhausted(t *testing.T) {
+	tm := newQueueTestManager([]int{0, 0})
+	defer tm.Stop()
+
+	tm.mutex.Lock()
+	tm.cache.tokens["token_0"].Available = 0
+	tm.cache.tokens["token_0"].ResetAt = time.Now().Add(48 * time.Hour)
+	tm.mutex.Unlock()
+
+	token, release, err := tm.AcquireToken(context.Background(), "")
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, "access_1", token.AccessToken)
+
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+	assert.Contains(t, tm.resetTimers, "token_0")
+}
+
+func TestRefreshOne_NotifiesAfterUnlock(t *testing.T) {
+	tm := newQueueTestManager([]int{0})
+	stubFetchCachedToken(t, func(cfg AuthConfig) (*CachedToken, error) {
+		return nil, errors.New("refresh failed")
+	})
+
+	tm.mutex.Lock()
+	tm.refreshOneUnlocked(0, tm.configs[0])
+	assert.Len(t, tm.notices, 1, "刷新失败的告警和审计在持锁期间只登记不发送")
+
+	lockedDuringNotice := true
+	tm.noticeUnlocked(func() {
+		if tm.mutex.TryLock() {
+			lockedDuringNotice = false
+			tm.mutex.Unlock()
+		}
+	})
+	tm.unlock()
+
+	assert.False(t, lockedDuringNotice)
+	assert.Empty(t, tm.notices)
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/types"
+	"kiro2api/webconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthConfigFromWebToken_DefaultUpstream(t *testing.T) {
+	webConfig := webconfig.GetDefaultConfig()
+	token := webconfig.AuthToken{Auth: AuthMethodSocial, RefreshToken: "rt", Enabled: true}
+
+	cfg := AuthConfigFromWebToken(webConfig, token)
+
+	assert.Equal(t, config.DefaultRegion, cfg.Upstream.Region)
+	assert.Equal(t, config.CodeWhispererURL, cfg.Upstream.Endpoints.CodeWhisperer)
+	assert.Equal(t, config.RefreshTokenURL, cfg.Upstream.Endpoints.SocialRefresh)
+	assert.Equal(t, config.IdcRefreshTokenURL, cfg.Upstream.Endpoints.IdcRefresh)
+	assert.Equal(t, config.DefaultKiroVersion, cfg.Upstream.Fingerprint.KiroVersion)
+	// 未配置操作系统标识时，对话请求和额度查询各自沿用原有的值
+	assert.Contains(t, cfg.Upstream.Fingerprint.UserAgent("1.0.18", "codewhispererstreaming"), "os/darwin#25.0.0 ")
+	assert.Contains(t, cfg.Upstream.Fingerprint.UserAgent("1.0.0", "codewhispererruntime"), "os/darwin#24.6.0 ")
+}
+
+func TestAuthConfigFromWebToken_TokenOverridesGlobal(t *testing.T) {
+	webConfig := webconfig.GetDefaultConfig()
+	webConfig.UpstreamConfig.ProfileArn = "arn:global"
+	webConfig.UpstreamConfig.Fingerprint.KiroVersion = "0.3.0"
+
+	token := webconfig.AuthToken{
+		Auth:         AuthMethodSocial,
+		RefreshToken: "rt",
+		Enabled:      true,
+		Region:       "eu-central-1",
+		ProfileArn:   "arn:token",
+		Fingerprint:  &types.ClientFingerprint{MachineID: "abc"},
+	}
+
+	cfg := AuthConfigFromWebToken(webConfig, token)
+
+	assert.Equal(t, "eu-central-1", cfg.Upstream.Region)
+	assert.Equal(t, "arn:token", cfg.Upstream.ProfileArn)
+	assert.Equal(t, "https://codewhisperer.eu-central-1.amazonaws.com/generateAssistantResponse", cfg.Upstream.Endpoints.CodeWhisperer)
+	assert.Equal(t, "https://codewhisperer.eu-central-1.amazonaws.com/getUsageLimits", cfg.Upstream.Endpoints.UsageLimits)
+	assert.Equal(t, "0.3.0", cfg.Upstream.Fingerprint.KiroVersion)
+	assert.Equal(t, "abc", cfg.Upstream.Fingerprint.MachineID)
+	assert.Equal(t, "KiroIDE-0.3.0-abc", cfg.Upstream.Fingerprint.KiroTag())
+
+	// 显式配置的操作系统标识用于所有API
+	token.Fingerprint.OS = "linux#6.1.0"
+	cfg = AuthConfigFromWebToken(webConfig, token)
+	assert.Contains(t, cfg.Upstream.Fingerprint.UserAgent("1.0.0", "codewhispererruntime"), "os/linux#6.1.0 ")
+}
+
+func TestAuthConfigFromWebToken_CustomEndpoint(t *testing.T) {
+	webConfig := webconfig.GetDefaultConfig()
+	webConfig.UpstreamConfig.Endpoints.CodeWhisperer = "http://127.0.0.1:9000/generateAssistantResponse"
+
+	token := webconfig.AuthToken{Auth: AuthMethodSocial, RefreshToken: "rt", Enabled: true}
+	cfg := AuthConfigFromWebToken(webConfig, token)
+
+	assert.Equal(t, "http://127.0.0.1:9000/generateAssistantResponse", cfg.Upstream.Endpoints.CodeWhisperer)
+	// 未自定义的端点仍按区域生成
+	assert.Equal(t, config.RefreshTokenURL, cfg.Upstream.Endpoints.SocialRefresh)
+}
+
+func TestAuthConfigFromWebToken_SSORegion(t *testing.T) {
+	webConfig := webconfig.GetDefaultConfig()
+	webConfig.UpstreamConfig.Region = "eu-central-1"
+
+	// 未配置SSO区域时IdC刷新端点沿用AWS区域
+	token := webconfig.AuthToken{Auth: AuthMethodIdC, RefreshToken: "rt", ClientID: "id", ClientSecret: "secret", Enabled: true}
+	cfg := AuthConfigFromWebToken(webConfig, token)
+	assert.Equal(t, "https://oidc.eu-central-1.amazonaws.com/token", cfg.Upstream.Endpoints.IdcRefresh)
+
+	// Identity Center与CodeWhisperer不在同一区域
+	webConfig.UpstreamConfig.SSORegion = "us-east-1"
+	cfg = AuthConfigFromWebToken(webConfig, token)
+	assert.Equal(t, "https://oidc.us-east-1.amazonaws.com/token", cfg.Upstream.Endpoints.IdcRefresh)
+	assert.Equal(t, "https://codewhisperer.eu-central-1.amazonaws.com/generateAssistantResponse", cfg.Upstream.Endpoints.CodeWhisperer)
+
+	// Token级SSO区域覆盖全局配置
+	token.SSORegion = "ap-southeast-2"
+	cfg = AuthConfigFromWebToken(webConfig, token)
+	assert.Equal(t, "https://oidc.ap-southeast-2.amazonaws.com/token", cfg.Upstream.Endpoints.IdcRefresh)
+}
+
+func TestProcessConfigs_ResolvesUpstream(t *testing.T) {
+	configs := processConfigs([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "rt", Upstream: types.UpstreamProfile{Region: "ap-southeast-1"}},
+	})
+
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "https://codewhisperer.ap-southeast-1.amazonaws.com/generateAssistantResponse", configs[0].Upstream.Endpoints.CodeWhisperer)
+	// Social刷新端点不随区域变化
+	assert.Equal(t, config.RefreshTokenURL, configs[0].Upstream.Endpoints.SocialRefresh)
+}
+
+func TestAuthConfigFromWebToken_SocialRefreshEndpoint(t *testing.T) {
+	webConfig := webconfig.GetDefaultConfig()
+	token := webconfig.AuthToken{Auth: AuthMethodSocial, RefreshToken: "rt", Enabled: true, Region: "eu-central-1"}
+
+	cfg := AuthConfigFromWebToken(webConfig, token)
+	assert.Equal(t, config.RefreshTokenURL, cfg.Upstream.Endpoints.SocialRefresh)
+
+	// 全局配置的端点在Token切换区域后仍然生效
+	webConfig.UpstreamConfig.Endpoints.SocialRefresh = "https://prod.eu-central-1.auth.desktop.kiro.dev/refreshToken"
+	cfg = AuthConfigFromWebToken(webConfig, token)
+	assert.Equal(t, "https://prod.eu-central-1.auth.desktop.kiro.dev/refreshToken", cfg.Upstream.Endpoints.SocialRefresh)
+	assert.Equal(t, "https://codewhisperer.eu-central-1.amazonaws.com/generateAssistantResponse", cfg.Upstream.Endpoints.CodeWhisperer)
+
+	// Token级端点优先
+	token.Endpoints = &types.UpstreamEndpoints{SocialRefresh: "https://auth.example.com/refreshToken"}
+	cfg = AuthConfigFromWebToken(webConfig, token)
+	assert.Equal(t, "https://auth.example.com/refreshToken", cfg.Upstream.Endpoints.SocialRefresh)
+}
+
+func TestTokenResolvedUpstream_FallbackToRefreshProfileArn(t *testing.T) {
+	token := types.TokenInfo{ProfileArn: "arn:from-refresh"}
+
+	upstream := token.ResolvedUpstream()
+
+	assert.Equal(t, config.CodeWhispererURL, upstream.Endpoints.CodeWhisperer)
+	assert.Equal(t, "arn:from-refresh", upstream.ProfileArn)
+}
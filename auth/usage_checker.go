@@ -11,6 +11,9 @@ import (
 	"time"
 )
 
+// usageLimitsSDKVersion getUsageLimits请求模拟的aws-sdk-js版本
+const usageLimitsSDKVersion = "1.0.0"
+
 // UsageLimitsChecker 使用限制检查器 (遵循SRP原则)
 type UsageLimitsChecker struct {
 	httpClient *http.Client
@@ -25,12 +28,18 @@ func NewUsageLimitsChecker() *UsageLimitsChecker {
 
 // CheckUsageLimits 检���token的使用限制 (基于token.md API规范)
 func (c *UsageLimitsChecker) CheckUsageLimits(token types.TokenInfo) (*types.UsageLimits, error) {
+	// 按token解析上游配置（区域、端点、客户端指纹）
+	upstream := token.ResolvedUpstream()
+
 	// 构建请求URL (完全遵循token.md中的示例)
-	baseURL := "https://codewhisperer.us-east-1.amazonaws.com/getUsageLimits"
+	baseURL := upstream.Endpoints.UsageLimits
 	params := url.Values{}
 	params.Add("isEmailRequired", "true")
 	params.Add("origin", "AI_EDITOR")
 	params.Add("resourceType", "AGENTIC_REQUEST")
+	if upstream.ProfileArn != "" {
+		params.Add("profileArn", upstream.ProfileArn)
+	}
 
 	requestURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 
@@ -41,9 +50,9 @@ func (c *UsageLimitsChecker) CheckUsageLimits(token types.TokenInfo) (*types.Usa
 	}
 
 	// 设置请求头 (严格按照token.md中的示例)
-	req.Header.Set("x-amz-user-agent", "aws-sdk-js/1.0.0 KiroIDE-0.2.13-66c23a8c5d15afabec89ef9954ef52a119f10d369df04d548fc6c1eac694b0d1")
-	req.Header.Set("user-agent", "aws-sdk-js/1.0.0 ua/2.1 os/darwin#24.6.0 lang/js md/nodejs#20.16.0 api/codewhispererruntime#1.0.0 m/E KiroIDE-0.2.13-66c23a8c5d15afabec89ef9954ef52a119f10d369df04d548fc6c1eac694b0d1")
-	req.Header.Set("host", "codewhisperer.us-east-1.amazonaws.com")
+	req.Header.Set("x-amz-user-agent", upstream.Fingerprint.AmzUserAgent(usageLimitsSDKVersion))
+	req.Header.Set("user-agent", upstream.Fingerprint.UserAgent(usageLimitsSDKVersion, "codewhispererruntime"))
+	req.Header.Set("host", req.URL.Host)
 	req.Header.Set("amz-sdk-invocation-id", generateInvocationID())
 	req.Header.Set("amz-sdk-request", "attempt=1; max=1")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
@@ -68,7 +77,7 @@ func (c *UsageLimitsChecker) CheckUsageLimits(token types.TokenInfo) (*types.Usa
 
 	logger.Debug("使用限制API响应",
 		logger.Int("status_code", resp.StatusCode),
-		logger.String("response_body", string(body)))
+		logger.Payload("response_body", string(body)))
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("使用限制检查失败: 状态码 %d, 响应: %s", resp.StatusCode, string(body))
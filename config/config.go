@@ -9,11 +9,54 @@ var ModelMap = map[string]string{
 	"claude-haiku-4-5-20251001":  "auto",
 }
 
+// DefaultRegion 默认的AWS区域（未配置区域时使用）
+const DefaultRegion = "us-east-1"
+
+// 上游端点模板，%s 为AWS区域
+const (
+	// IdcRefreshTokenURLFormat IdC认证方式的刷新token URL模板
+	IdcRefreshTokenURLFormat = "https://oidc.%s.amazonaws.com/token"
+
+	// CodeWhispererURLFormat CodeWhisperer API的URL模板
+	CodeWhispererURLFormat = "https://codewhisperer.%s.amazonaws.com/generateAssistantResponse"
+
+	// UsageLimitsURLFormat 使用限制查询API的URL模板
+	UsageLimitsURLFormat = "https://codewhisperer.%s.amazonaws.com/getUsageLimits"
+)
+
+// 内置模拟器的端点路径，与真实上游的路径一致
+const (
+	MockCodeWhispererPath = "/generateAssistantResponse"
+	MockUsageLimitsPath   = "/getUsageLimits"
+	MockSocialRefreshPath = "/refreshToken"
+	MockIdcRefreshPath    = "/token"
+)
+
 // RefreshTokenURL 刷新token的URL (social方式)
+// Kiro桌面认证服务不随AWS区域部署，未自定义端点时所有区域都使用该地址
 const RefreshTokenURL = "https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken"
 
-// IdcRefreshTokenURL IdC认证方式的刷新token URL
+// IdcRefreshTokenURL IdC认证方式的刷新token URL (默认区域)
 const IdcRefreshTokenURL = "https://oidc.us-east-1.amazonaws.com/token"
 
-// CodeWhispererURL CodeWhisperer API的URL
+// CodeWhispererURL CodeWhisperer API的URL (默认区域)
 const CodeWhispererURL = "https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse"
+
+// 客户端指纹默认值（模拟KiroIDE的请求头）
+const (
+	// DefaultKiroVersion 默认KiroIDE版本
+	DefaultKiroVersion = "0.2.13"
+
+	// DefaultMachineID 默认机器标识（KiroIDE请求头中的哈希）
+	DefaultMachineID = "66c23a8c5d15afabec89ef9954ef52a119f10d369df04d548fc6c1eac694b0d1"
+
+	// DefaultStreamingClientOS 对话请求（codewhispererstreaming）的默认操作系统标识
+	DefaultStreamingClientOS = "darwin#25.0.0"
+
+	// DefaultRuntimeClientOS 额度查询（codewhispererruntime）的默认操作系统标识
+	// KiroIDE 两类请求上报的系统版本不同，按各自抓包的值发送
+	DefaultRuntimeClientOS = "darwin#24.6.0"
+
+	// DefaultNodeVersion 默认Node.js版本
+	DefaultNodeVersion = "20.16.0"
+)
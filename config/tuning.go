@@ -17,6 +17,71 @@ const (
 	// 过期后需要重新刷新
 	TokenCacheTTL = 5 * time.Minute
 
+	// ========== Token并发配置 ==========
+
+	// DefaultTokenQueueSize 所有token并发饱和时的默认排队长度
+	DefaultTokenQueueSize = 100
+
+	// DefaultTokenQueueTimeout 排队等待token的默认超时时间
+	DefaultTokenQueueTimeout = 30 * time.Second
+
+	// DefaultAffinityTTL 会话与token绑定的默认有效期
+	DefaultAffinityTTL = 30 * time.Minute
+
+	// QuotaResetRecheckDelay 额度重置时间之后延迟多久重新检查token
+	// 留出余量，避免上游尚未完成重置
+	QuotaResetRecheckDelay = 2 * time.Minute
+
+	// QuotaResetRetryInterval 重置后检查失败或额度仍未恢复时的重试间隔
+	QuotaResetRetryInterval = 15 * time.Minute
+
+	// ========== 告警配置 ==========
+
+	// AlertRequestTimeout 单次Webhook发送的超时时间
+	AlertRequestTimeout = 10 * time.Second
+
+	// AlertRetryBaseDelay Webhook发送失败后的首次重试延迟（之后指数退避）
+	AlertRetryBaseDelay = 1 * time.Second
+
+	// ========== 客户端限流配置 ==========
+
+	// RateLimitWindow 客户端限流的补满周期（限流值均为每个周期的额度）
+	RateLimitWindow = time.Minute
+
+	// ========== 客户端预算配置 ==========
+
+	// BudgetTokensPerCredit 无法观测到Token额度变化时，按输入+输出token数估算消耗的额度
+	BudgetTokensPerCredit = 10000
+
+	// BudgetMinCreditsPerRequest 单次请求估算的最小额度
+	BudgetMinCreditsPerRequest = 0.01
+
+	// BudgetSoftLimitPercent 默认的预算预警比例（已用额度达到预算的该百分比时返回预警响应头）
+	BudgetSoftLimitPercent = 80
+
+	// BudgetFlushInterval 预算消耗写入磁盘的最大延迟
+	BudgetFlushInterval = 5 * time.Second
+
+	// ========== 管理后台安全配置 ==========
+
+	// LoginLockoutThreshold 同一IP连续登录失败多少次后开始锁定
+	LoginLockoutThreshold = 5
+
+	// LoginLockoutBase 首次锁定时长，之后每次失败翻倍
+	LoginLockoutBase = 1 * time.Minute
+
+	// LoginLockoutMax 登录锁定的最长时长
+	LoginLockoutMax = 1 * time.Hour
+
+	// AuditRetentionDays 审计日志默认保留天数（按日切分的文件整体删除）
+	AuditRetentionDays = 90
+
+	// AuditQueryDefaultLimit 审计日志查询默认返回的记录数
+	AuditQueryDefaultLimit = 200
+
+	// AuditQueryMaxLimit 审计日志单次查询最多返回的记录数
+	AuditQueryMaxLimit = 1000
+
 	// ========== 超时配置 ==========
 
 	// ServerIdleTimeout 服务器空闲连接超时
@@ -56,3 +121,60 @@ const (
 	// MaxHeaderBytes HTTP请求头最大字节数
 	MaxHeaderBytes = 1 << 20 // 1MB
 )
+
+// 追踪配置
+const (
+	// TraceExportInterval 追踪数据批量导出间隔
+	TraceExportInterval = 5 * time.Second
+
+	// TraceExportBatchSize 单次导出的最大span数
+	TraceExportBatchSize = 512
+
+	// TraceQueueSize 待导出span队列容量，满时丢弃新span
+	TraceQueueSize = 2048
+
+	// TraceExportTimeout 单次导出请求超时
+	TraceExportTimeout = 10 * time.Second
+)
+
+// 上游流量捕获配置
+const (
+	// CaptureMaxResponseBytes 单个捕获文件记录的上游响应最大字节数，超出部分丢弃并标记truncated
+	CaptureMaxResponseBytes = 32 << 20 // 32MB
+)
+
+// 模拟上游配置
+const (
+	// DefaultMockUpstreamPort 模拟器默认监听端口（仅127.0.0.1）
+	DefaultMockUpstreamPort = 8089
+
+	// DefaultMockCreditLimit 模拟器为每个token提供的默认额度
+	DefaultMockCreditLimit = 500.0
+
+	// MockCreditsPerRequest 模拟器每次生成请求消耗的额度
+	MockCreditsPerRequest = 1.0
+
+	// MockAccessTokenTTL 模拟器签发的访问令牌有效期
+	MockAccessTokenTTL = time.Hour
+)
+
+// 日志脱敏配置
+const (
+	// LogRedactTruncateRunes truncated 模式下日志载荷保留的最大字符数
+	LogRedactTruncateRunes = 256
+
+	// LogRedactMinBase64Length 连续base64字符达到该长度时视为二进制/图片数据并省略
+	LogRedactMinBase64Length = 512
+)
+
+// 实时事件流配置
+const (
+	// EventHistorySize 保留的最近事件数，新连接和断线重连（Last-Event-ID）时补发
+	EventHistorySize = 200
+
+	// EventSubscriberBuffer 每个订阅者的事件缓冲，写满后丢弃新事件，慢速客户端不会阻塞发布者
+	EventSubscriberBuffer = 256
+
+	// EventKeepaliveInterval 事件流没有事件时发送注释行的间隔，防止代理断开空闲连接
+	EventKeepaliveInterval = 15 * time.Second
+)
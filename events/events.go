@@ -0,0 +1,134 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kiro2api/config"
+)
+
+// 事件类型
+const (
+	TypeTokenSelected      = "token.selected"       // 为请求选中了上游token
+	TypeTokenRefreshed     = "token.refreshed"      // token刷新成功
+	TypeTokenRefreshFailed = "token.refresh_failed" // token刷新失败
+	TypeTokenExhausted     = "token.exhausted"      // token被移出轮换（额度耗尽、刷新失败或访问令牌过期）
+	TypeTokenRestored      = "token.restored"       // 额度重置后token重新加入轮换
+	TypePoolEmpty          = "pool.empty"           // 所有token都不可用，请求将失败
+	TypeRequestStarted     = "request.started"      // 请求已获取token，开始转发
+	TypeRequestFinished    = "request.finished"     // 请求结束（包括流式响应）
+	TypeRequestError       = "request.error"        // 请求失败（获取token失败或上游错误）
+	TypeLog                = "log"                  // 日志条目
+)
+
+// Event 实时事件
+type Event struct {
+	ID   uint64         `json:"id"`
+	Type string         `json:"type"`
+	Time time.Time      `json:"time"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// Subscription 事件订阅，通过 C 接收事件，用完后调用 Close
+type Subscription struct {
+	C <-chan Event
+
+	bus     *Bus
+	ch      chan Event
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Dropped 返回因缓冲已满被丢弃的事件数
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close 取消订阅并关闭 C，可重复调用
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mutex.Lock()
+		defer s.bus.mutex.Unlock()
+		delete(s.bus.subscribers, s)
+		close(s.ch)
+	})
+}
+
+// Bus 事件总线：发布者不阻塞，订阅者缓冲写满时丢弃新事件
+type Bus struct {
+	mutex       sync.Mutex
+	nextID      uint64
+	history     []Event // 最近的事件，按ID递增
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBus 创建事件总线，historySize 为保留的最近事件数
+func NewBus(historySize int) *Bus {
+	return &Bus{
+		historySize: historySize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Default 全局事件总线
+var Default = NewBus(config.EventHistorySize)
+
+// Publish 发布事件，不阻塞调用者（可在持有其他锁时调用）
+func (b *Bus) Publish(eventType string, data map[string]any) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextID++
+	ev := Event{ID: b.nextID, Type: eventType, Time: time.Now(), Data: data}
+
+	if b.historySize > 0 {
+		if len(b.history) >= b.historySize {
+			b.history = append(b.history[:0], b.history[len(b.history)-b.historySize+1:]...)
+		}
+		b.history = append(b.history, ev)
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe 订阅事件，并返回ID大于 afterID 的最近事件（afterID 为0时返回全部保留的事件）
+// 补发的事件与之后收到的事件不重复、不遗漏
+func (b *Bus) Subscribe(buffer int, afterID uint64) (*Subscription, []Event) {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, bus: b, ch: ch}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.subscribers[sub] = struct{}{}
+
+	// 客户端持有的ID比当前的还大，说明进程已重启，补发全部保留的事件
+	if afterID > b.nextID {
+		afterID = 0
+	}
+	var backlog []Event
+	for _, ev := range b.history {
+		if ev.ID > afterID {
+			backlog = append(backlog, ev)
+		}
+	}
+	return sub, backlog
+}
+
+// Publish 在全局事件总线上发布事件
+func Publish(eventType string, data map[string]any) {
+	Default.Publish(eventType, data)
+}
+
+// Subscribe 订阅全局事件总线
+func Subscribe(afterID uint64) (*Subscription, []Event) {
+	return Default.Subscribe(config.EventSubscriberBuffer, afterID)
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus_PublishSubscribe(t *testing.T) {
+	b := NewBus(10)
+	sub, backlog := b.Subscribe(4, 0)
+	defer sub.Close()
+	assert.Empty(t, backlog)
+
+	b.Publish(TypeTokenSelected, map[string]any{"token_id": "a"})
+	ev := <-sub.C
+	assert.Equal(t, uint64(1), ev.ID)
+	assert.Equal(t, TypeTokenSelected, ev.Type)
+	assert.Equal(t, "a", ev.Data["token_id"])
+	assert.False(t, ev.Time.IsZero())
+}
+
+func TestBus_HistoryBacklog(t *testing.T) {
+	b := NewBus(3)
+	for i := 0; i < 5; i++ {
+		b.Publish(TypeLog, nil)
+	}
+
+	// 只保留最近3个事件
+	sub, backlog := b.Subscribe(1, 0)
+	sub.Close()
+	assert.Len(t, backlog, 3)
+	assert.Equal(t, uint64(3), backlog[0].ID)
+	assert.Equal(t, uint64(5), backlog[2].ID)
+
+	// 断线重连只补发错过的事件
+	sub, backlog = b.Subscribe(1, 4)
+	sub.Close()
+	assert.Len(t, backlog, 1)
+	assert.Equal(t, uint64(5), backlog[0].ID)
+
+	// ID超过当前值说明进程已重启，补发全部
+	sub, backlog = b.Subscribe(1, 100)
+	sub.Close()
+	assert.Len(t, backlog, 3)
+}
+
+func TestBus_SlowSubscriberDoesNotBlock(t *testing.T) {
+	b := NewBus(0)
+	sub, _ := b.Subscribe(2, 0)
+	defer sub.Close()
+
+	for i := 0; i < 5; i++ {
+		b.Publish(TypeRequestStarted, nil)
+	}
+	assert.Len(t, sub.C, 2)
+	assert.Equal(t, int64(3), sub.Dropped())
+}
+
+func TestSubscription_Close(t *testing.T) {
+	b := NewBus(0)
+	sub, _ := b.Subscribe(1, 0)
+	sub.Close()
+	sub.Close() // 可重复调用
+
+	_, ok := <-sub.C
+	assert.False(t, ok)
+	// 取消订阅后发布不会向已关闭的通道发送
+	assert.NotPanics(t, func() { b.Publish(TypeLog, nil) })
+}
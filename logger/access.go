@@ -0,0 +1,145 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// 访问日志格式
+const (
+	AccessFormatJSON = "json" // 与主日志相同的JSON行
+	AccessFormatText = "text" // key=value 文本行
+)
+
+// accessLogMessage 访问日志条目的message字段
+const accessLogMessage = "access"
+
+// accessLogger 访问日志输出，每个请求一条，不受日志级别影响
+type accessLogger struct {
+	mutex  sync.Mutex
+	file   *rotatingFile // 独立的访问日志文件，为nil时写入主日志输出
+	format string
+}
+
+var accessLog = &accessLogger{format: AccessFormatJSON}
+
+// ConfigureAccessLog 设置访问日志的输出文件、格式和轮转配置，可在运行中重复调用
+// file 为空时写入主日志的输出；format 为 json（默认）或 text；文件路径不变时不重新打开文件
+func ConfigureAccessLog(file, format string, rotation RotationConfig) error {
+	switch format {
+	case "":
+		format = AccessFormatJSON
+	case AccessFormatJSON, AccessFormatText:
+	default:
+		return fmt.Errorf("访问日志格式必须是 %s 或 %s", AccessFormatJSON, AccessFormatText)
+	}
+
+	accessLog.mutex.Lock()
+	defer accessLog.mutex.Unlock()
+
+	current := accessLog.file
+	switch {
+	case file == "":
+		current = nil
+	case current != nil && current.path == file:
+		current.SetConfig(rotation)
+	default:
+		opened, err := openRotatingFile(file, rotation)
+		if err != nil {
+			return fmt.Errorf("无法打开访问日志文件 %s: %w", file, err)
+		}
+		current = opened
+	}
+	if accessLog.file != nil && accessLog.file != current {
+		accessLog.file.Close()
+	}
+	accessLog.file = current
+	accessLog.format = format
+	return nil
+}
+
+// reopen 重新打开访问日志文件
+func (a *accessLogger) reopen() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Reopen()
+}
+
+// Access 记录一条访问日志，字段按传入顺序输出（JSON格式按键名排序，与主日志一致）
+func Access(fields ...Field) {
+	accessLog.mutex.Lock()
+	defer accessLog.mutex.Unlock()
+
+	timestamp := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+	var line string
+	if accessLog.format == AccessFormatText {
+		line = formatAccessText(timestamp, fields)
+	} else {
+		entry := &LogEntry{
+			Timestamp: timestamp,
+			Level:     levelNames[INFO],
+			Message:   accessLogMessage,
+			Fields:    make(map[string]any, len(fields)),
+		}
+		for _, field := range fields {
+			entry.Fields[field.Key] = field.Value
+		}
+		line = string(defaultLogger.marshalLogEntry(entry))
+	}
+
+	if accessLog.file != nil {
+		_, _ = io.WriteString(accessLog.file, line+"\n")
+		return
+	}
+	defaultLogger.logger.Println(line)
+}
+
+// formatAccessText 以 key=value 形式输出访问日志，含空格或引号的值加引号
+func formatAccessText(timestamp string, fields []Field) string {
+	b := getStringBuilder()
+	defer putStringBuilder(b)
+
+	b.WriteString(timestamp)
+	for _, field := range fields {
+		b.WriteByte(' ')
+		b.WriteString(field.Key)
+		b.WriteByte('=')
+		b.WriteString(formatAccessValue(field.Value))
+	}
+	return b.String()
+}
+
+func formatAccessValue(v any) string {
+	var s string
+	switch val := v.(type) {
+	case nil:
+		return `""`
+	case string:
+		s = val
+	case time.Duration:
+		s = val.String()
+	case fmt.Stringer:
+		s = val.String()
+	case int, int64, float64, bool:
+		s = fmt.Sprint(val)
+	default:
+		data, err := sonic.MarshalString(val)
+		if err != nil {
+			return `""`
+		}
+		s = data
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\t\n\r") {
+		return strconv.Quote(s)
+	}
+	return s
+}
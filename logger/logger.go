@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -93,7 +94,7 @@ type Field struct {
 type Logger struct {
 	level        int64       // 使用原子操作的日志级别
 	logger       *log.Logger // log.Logger本身线程安全，移除mutex
-	logFile      *os.File
+	logFile      *rotatingFile
 	writers      []io.Writer
 	enableCaller bool // 控制是否获取调用栈信息（包含文件与函数名）
 	callerSkip   int  // 调用栈深度
@@ -126,6 +127,11 @@ func createLogger() *Logger {
 			atomic.StoreInt64(&logger.level, int64(level))
 		}
 	}
+	if mode := os.Getenv("LOG_REDACTION"); mode != "" {
+		if err := SetRedactionMode(mode); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
 
 	// 从环境变量控制优化特性
 	if enableCaller := os.Getenv("LOG_ENABLE_CALLER"); enableCaller == "true" || enableCaller == "1" {
@@ -147,7 +153,7 @@ func createLogger() *Logger {
 
 	// 设置文件输出
 	if logFile := os.Getenv("LOG_FILE"); logFile != "" {
-		if file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err == nil {
+		if file, err := openRotatingFile(logFile, RotationConfig{}); err == nil {
 			logger.logFile = file
 			// 检查是否禁用控制台输出
 			if os.Getenv("LOG_CONSOLE") == "false" {
@@ -210,7 +216,7 @@ func (l *Logger) log(level Level, msg string, fields []Field) {
 	entry := &LogEntry{
 		Timestamp: time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
 		Level:     levelNames[level],
-		Message:   msg,
+		Message:   RedactSecrets(msg),
 		Fields:    make(map[string]any),
 	}
 
@@ -242,7 +248,8 @@ func (l *Logger) log(level Level, msg string, fields []Field) {
 			field.Key == "func" {
 			continue
 		}
-		entry.Fields[field.Key] = field.Value
+		// 载荷按脱敏模式处理，字符串字段屏蔽凭据
+		entry.Fields[field.Key] = redactValue(field.Value)
 	}
 
 	// 使用自定义序列化确保字段顺序
@@ -250,6 +257,7 @@ func (l *Logger) log(level Level, msg string, fields []Field) {
 
 	// 直接输出日志 - log.Logger本身已经线程安全！
 	l.logger.Println(string(jsonData))
+	notifySubscribers(entry)
 
 	// Fatal级别退出程序
 	if level == FATAL {
@@ -421,68 +429,103 @@ func SetTextFormat() {
 	// 目前保持JSON格式
 }
 
-// SetLogFile 设置日志文件
+// outputMutex 串行化日志输出的重新配置
+var outputMutex sync.Mutex
+
+// SetLogFile 设置日志文件（追加写入，不轮转），保留原有的控制台输出
 func SetLogFile(filename string) {
 	if filename == "" {
 		return
 	}
 
-	// 关闭现有文件
-	if defaultLogger.logFile != nil {
-		defaultLogger.logFile.Close()
-		defaultLogger.logFile = nil
-	}
-
-	// 打开新文件
-	if file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
-		defaultLogger.logFile = file
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
 
-		// 重新构建写入器
-		var newWriters []io.Writer
-		if len(defaultLogger.writers) > 0 {
-			// 保留控制台输出（如果原来有）
-			for _, writer := range defaultLogger.writers {
-				if writer == os.Stdout {
-					newWriters = append(newWriters, os.Stdout)
-				}
-			}
-		}
-		newWriters = append(newWriters, file)
-		defaultLogger.writers = newWriters
-
-		// 重新创建logger
-		multiWriter := io.MultiWriter(defaultLogger.writers...)
-		defaultLogger.logger = log.New(multiWriter, "", 0)
-	} else {
+	file, err := openRotatingFile(filename, RotationConfig{})
+	if err != nil {
 		// 如果打开失败，保持原有配置
 		fmt.Fprintf(os.Stderr, "无法打开日志文件 %s: %v\n", filename, err)
+		return
 	}
+	defaultLogger.applyOutputs(file, defaultLogger.hasConsole())
 }
 
-// SetConsoleOutput 设置控制台输出
+// SetConsoleOutput 设置控制台输出，保留已设置的日志文件
 func SetConsoleOutput(enabled bool) {
-	// 关闭现有文件
-	if defaultLogger.logFile != nil {
-		defaultLogger.logFile.Close()
-		defaultLogger.logFile = nil
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	defaultLogger.applyOutputs(defaultLogger.logFile, enabled)
+}
+
+// ConfigureOutput 设置控制台输出、日志文件及其轮转配置，可在运行中重复调用
+// file 为空时不写文件；文件路径不变时只更新轮转配置，不重新打开文件
+func ConfigureOutput(file string, console bool, rotation RotationConfig) error {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	current := defaultLogger.logFile
+	switch {
+	case file == "":
+		current = nil
+	case current != nil && current.path == file:
+		current.SetConfig(rotation)
+	default:
+		opened, err := openRotatingFile(file, rotation)
+		if err != nil {
+			return fmt.Errorf("无法打开日志文件 %s: %w", file, err)
+		}
+		current = opened
 	}
+	defaultLogger.applyOutputs(current, console)
+	return nil
+}
 
-	// 重新构建写入器
-	var newWriters []io.Writer
-	if enabled {
-		newWriters = append(newWriters, os.Stdout)
+// Reopen 重新打开日志文件和访问日志文件，文件被外部 logrotate 移走后继续写入原路径
+func Reopen() error {
+	outputMutex.Lock()
+	file := defaultLogger.logFile
+	outputMutex.Unlock()
+
+	var errs []error
+	if file != nil {
+		if err := file.Reopen(); err != nil {
+			errs = append(errs, fmt.Errorf("重新打开日志文件失败: %w", err))
+		}
+	}
+	if err := accessLog.reopen(); err != nil {
+		errs = append(errs, fmt.Errorf("重新打开访问日志文件失败: %w", err))
 	}
+	return errors.Join(errs...)
+}
 
-	// 如果有日志文件，添加到写入器
-	// 这里简化处理，实际应该从配置中获取日志文件路径
+// hasConsole 是否输出到控制台，调用者必须持有 outputMutex
+func (l *Logger) hasConsole() bool {
+	for _, writer := range l.writers {
+		if writer == os.Stdout {
+			return true
+		}
+	}
+	return false
+}
 
-	defaultLogger.writers = newWriters
+// applyOutputs 按文件和控制台设置重建写入器，被替换的日志文件在切换后关闭
+// 两者都未启用时仍输出到控制台；调用者必须持有 outputMutex
+func (l *Logger) applyOutputs(file *rotatingFile, console bool) {
+	var writers []io.Writer
+	if console || file == nil {
+		writers = append(writers, os.Stdout)
+	}
+	if file != nil {
+		writers = append(writers, file)
+	}
 
-	// 重新创建logger
-	if len(newWriters) > 0 {
-		multiWriter := io.MultiWriter(newWriters...)
-		defaultLogger.logger = log.New(multiWriter, "", 0)
+	// SetOutput 与写入互斥，返回后旧的写入器不会再被使用
+	l.logger.SetOutput(io.MultiWriter(writers...))
+	l.writers = writers
+	if l.logFile != nil && l.logFile != file {
+		l.logFile.Close()
 	}
+	l.logFile = file
 }
 
 // SetCallerEnabled 设置是否启用调用栈信息
@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"kiro2api/config"
+)
+
+// 载荷脱敏模式
+const (
+	RedactOff       = "off"       // 完整记录（仍屏蔽密钥、省略图片）
+	RedactMetadata  = "metadata"  // 只记录长度
+	RedactTruncated = "truncated" // 只记录开头部分
+	RedactHashed    = "hashed"    // 只记录长度和SHA-256摘要，可用于比较内容是否相同
+)
+
+// redactionMode 当前的载荷脱敏模式，未设置时为 metadata
+var redactionMode atomic.Value
+
+// SetRedactionMode 设置日志载荷（请求体、上游请求、SSE事件）的脱敏模式，空串使用默认的 metadata
+func SetRedactionMode(mode string) error {
+	switch mode {
+	case "":
+		mode = RedactMetadata
+	case RedactOff, RedactMetadata, RedactTruncated, RedactHashed:
+	default:
+		return fmt.Errorf("日志脱敏模式必须是 %s、%s、%s 或 %s", RedactOff, RedactMetadata, RedactTruncated, RedactHashed)
+	}
+	redactionMode.Store(mode)
+	return nil
+}
+
+// GetRedactionMode 获取当前的载荷脱敏模式
+func GetRedactionMode() string {
+	if mode, ok := redactionMode.Load().(string); ok {
+		return mode
+	}
+	return RedactMetadata
+}
+
+// payload 可能包含提示词、图片或凭据的日志内容，输出时按脱敏模式处理
+type payload string
+
+// Payload 载荷字段构造函数，用于记录请求体、上游请求和响应内容
+func Payload(key, content string) Field {
+	return Field{Key: key, Value: payload(content)}
+}
+
+// redact 按当前模式处理载荷
+func (p payload) redact() string {
+	content := string(p)
+	switch GetRedactionMode() {
+	case RedactOff:
+		return RedactSecrets(content)
+	case RedactTruncated:
+		content = RedactSecrets(content)
+		if utf8.RuneCountInString(content) <= config.LogRedactTruncateRunes {
+			return content
+		}
+		return string([]rune(content)[:config.LogRedactTruncateRunes]) + fmt.Sprintf("...<truncated bytes=%d>", len(p))
+	case RedactHashed:
+		sum := sha256.Sum256([]byte(p))
+		return fmt.Sprintf("<redacted bytes=%d sha256=%s>", len(p), hex.EncodeToString(sum[:8]))
+	default:
+		return fmt.Sprintf("<redacted bytes=%d>", len(p))
+	}
+}
+
+// secretDetector 凭据检测规则，hint 不出现时跳过正则匹配
+type secretDetector struct {
+	hints   []string
+	pattern *regexp.Regexp
+	replace string
+}
+
+// secretDetectors 内置凭据检测规则
+var secretDetectors = []secretDetector{
+	{
+		// Bearer <token>，出现在请求头、错误信息等任意位置（Kiro令牌中含有冒号）
+		hints:   []string{"Bearer", "bearer", "BEARER"},
+		pattern: regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/:]{8,}=*`),
+		replace: "${1}<redacted>",
+	},
+	{
+		// 没有键名的刷新令牌（aor前缀）和访问令牌（aoa前缀），如上游返回的错误信息中的令牌
+		hints:   []string{"aorA", "aoaA"},
+		pattern: regexp.MustCompile(`\bao[ar]A[A-Za-z0-9\-._~+/:=]{20,}`),
+		replace: "<redacted:token>",
+	},
+	{
+		// AWS 访问密钥ID
+		hints:   []string{"AKIA", "ASIA"},
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+		replace: "<redacted:aws-access-key>",
+	},
+	{
+		// AWS 秘密访问密钥（按键名识别）
+		hints:   []string{"ecret"},
+		pattern: regexp.MustCompile(`(?i)((?:aws_secret_access_key|secret_?access_?key|client_?secret)\\?["']?\s*[:=]\s*\\?["']?)[A-Za-z0-9/+=\-_.]{16,}`),
+		replace: "${1}<redacted>",
+	},
+	{
+		// 刷新令牌、访问令牌（按键名识别，兼容JSON和key=value）
+		hints:   []string{"efresh", "ccess", "_token", "Token"},
+		pattern: regexp.MustCompile(`(?i)((?:refresh_?token|access_?token|id_?token|session_?token)\\?["']?\s*[:=]\s*\\?["']?)[A-Za-z0-9\-._~+/:=]{8,}`),
+		replace: "${1}<redacted>",
+	},
+}
+
+var (
+	// dataURLPattern data:image/...;base64,<数据>
+	dataURLPattern = regexp.MustCompile(`(data:[a-zA-Z0-9.+\-]+/[a-zA-Z0-9.+\-]+;base64,)[A-Za-z0-9+/=]+`)
+	// base64RunPattern 连续的长base64串（图片、文档等二进制数据）
+	base64RunPattern = regexp.MustCompile(fmt.Sprintf(`[A-Za-z0-9+/]{%d,}={0,2}`, config.LogRedactMinBase64Length))
+)
+
+// RedactSecrets 屏蔽文本中的凭据并省略base64数据，各种脱敏模式下都会执行
+func RedactSecrets(s string) string {
+	for _, detector := range secretDetectors {
+		if containsAny(s, detector.hints) {
+			s = detector.pattern.ReplaceAllString(s, detector.replace)
+		}
+	}
+	return elideBase64(s)
+}
+
+// elideBase64 省略base64图片和长base64串，只保留长度
+func elideBase64(s string) string {
+	if strings.Contains(s, ";base64,") {
+		s = dataURLPattern.ReplaceAllStringFunc(s, func(m string) string {
+			i := strings.Index(m, ",") + 1
+			return fmt.Sprintf("%s<elided bytes=%d>", m[:i], len(m)-i)
+		})
+	}
+	if len(s) < config.LogRedactMinBase64Length {
+		return s
+	}
+	return base64RunPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return fmt.Sprintf("<elided base64 bytes=%d>", len(m))
+	})
+}
+
+func containsAny(s string, hints []string) bool {
+	for _, hint := range hints {
+		if strings.Contains(s, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue 处理日志字段值：载荷按脱敏模式处理，普通字符串只屏蔽凭据
+func redactValue(v any) any {
+	switch val := v.(type) {
+	case payload:
+		return val.redact()
+	case string:
+		return RedactSecrets(val)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactSecrets_BareTokens(t *testing.T) {
+	refreshToken := "aorAAAAAGkQx7Vb3mZ9pL2sN8wYcTd4eRfGh:MGUCMQDk8s7Jz"
+	accessToken := "aoaAAAAAGkQy1Wc4nA0qM3tO9xZdUe5fSgHi:MGQCMF2pXr6Ly"
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "错误信息中的刷新令牌",
+			input: errors.New("刷新token失败: invalid refresh token " + refreshToken + " (status 401)").Error(),
+			want:  "刷新token失败: invalid refresh token <redacted:token> (status 401)",
+		},
+		{
+			name:  "错误信息中的Bearer令牌",
+			input: "请求失败: header Authorization=Bearer " + accessToken + " rejected",
+			want:  "请求失败: header Authorization=Bearer <redacted> rejected",
+		},
+		{
+			name:  "未带前缀的访问令牌",
+			input: "token " + accessToken + " expired",
+			want:  "token <redacted:token> expired",
+		},
+		{
+			name:  "普通文本不受影响",
+			input: "aorta and aoa are words",
+			want:  "aorta and aoa are words",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, RedactSecrets(tt.input))
+		})
+	}
+}
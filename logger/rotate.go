@@ -0,0 +1,235 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat 轮转文件名中的时间格式，按字典序即按时间排序
+const backupTimeFormat = "20060102T150405.000"
+
+// RotationConfig 日志文件轮转配置，各项为0表示不启用
+type RotationConfig struct {
+	MaxSizeMB   int  // 文件超过该大小（MB）时轮转
+	MaxAgeHours int  // 文件打开超过该时长（小时）时轮转
+	MaxBackups  int  // 保留的轮转文件数，超出的最旧文件被删除
+	Compress    bool // 轮转后的文件使用gzip压缩
+}
+
+// rotatingFile 按大小和时间轮转的日志文件
+// 轮转时当前文件重命名为 <名称>-<时间><扩展名>，随后在后台压缩并清理超出数量的旧文件
+type rotatingFile struct {
+	mutex    sync.Mutex
+	path     string
+	config   RotationConfig
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool // 已被替换或关闭，之后的写入返回错误
+
+	millMutex sync.Mutex // 串行化压缩和清理
+}
+
+// openRotatingFile 以追加方式打开日志文件
+func openRotatingFile(path string, config RotationConfig) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, config: config}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open 打开（或创建）日志文件，调用者必须持有 f.mutex 或独占 f
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	f.file = file
+	f.size = size
+	f.openedAt = time.Now()
+	return nil
+}
+
+// Write 写入日志，写入前按需轮转；轮转失败时继续写入当前文件
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "日志文件轮转失败 %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// shouldRotate 判断写入 n 字节前是否需要轮转，空文件不轮转
+func (f *rotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.config.MaxSizeMB > 0 && f.size+int64(n) > int64(f.config.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return f.config.MaxAgeHours > 0 && time.Since(f.openedAt) >= time.Duration(f.config.MaxAgeHours)*time.Hour
+}
+
+// rotate 关闭当前文件、重命名为轮转文件并打开新文件，调用者必须持有 f.mutex
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	ext := filepath.Ext(f.path)
+	backup := strings.TrimSuffix(f.path, ext) + "-" + time.Now().Format(backupTimeFormat) + ext
+	renameErr := os.Rename(f.path, backup)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	config := f.config
+	go f.mill(backup, config)
+	return nil
+}
+
+// mill 压缩刚轮转的文件并删除超出数量的旧文件
+func (f *rotatingFile) mill(backup string, config RotationConfig) {
+	f.millMutex.Lock()
+	defer f.millMutex.Unlock()
+
+	if config.Compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "压缩日志文件失败 %s: %v\n", backup, err)
+		}
+	}
+	if config.MaxBackups > 0 {
+		backups := f.backups()
+		for _, old := range backups[min(config.MaxBackups, len(backups)):] {
+			if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "删除旧日志文件失败 %s: %v\n", old, err)
+			}
+		}
+	}
+}
+
+// backups 返回该日志文件的所有轮转文件，最新的在前
+func (f *rotatingFile) backups() []string {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		// 只认时间戳完全匹配的文件，避免误删同目录下名称相近的其他日志
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(filepath.Dir(f.path), name)
+	}
+	return paths
+}
+
+// compressFile 将文件压缩为 .gz 并删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+// SetConfig 更新轮转配置，下一次写入时生效
+func (f *rotatingFile) SetConfig(config RotationConfig) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.config = config
+}
+
+// Reopen 关闭并重新打开日志文件，文件被外部移动（如 logrotate）后写入新文件
+func (f *rotatingFile) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return nil
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close 关闭日志文件，之后的写入返回错误
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
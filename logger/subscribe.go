@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Subscriber 日志订阅者，在每条日志输出后同步调用
+// 收到的条目已经过脱敏，不会再被修改；订阅者不得阻塞，也不得在回调中记录日志
+type Subscriber func(entry *LogEntry)
+
+var (
+	subscribersMutex sync.Mutex
+	subscribers      atomic.Pointer[[]*Subscriber] // 写时复制，输出日志时无锁读取
+)
+
+// Subscribe 注册日志订阅者（如实时事件流），返回取消订阅的函数
+func Subscribe(fn Subscriber) (unsubscribe func()) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+
+	handle := &fn
+	var current []*Subscriber
+	if p := subscribers.Load(); p != nil {
+		current = *p
+	}
+	next := append(append([]*Subscriber(nil), current...), handle)
+	subscribers.Store(&next)
+
+	return func() {
+		subscribersMutex.Lock()
+		defer subscribersMutex.Unlock()
+
+		var remaining []*Subscriber
+		for _, s := range *subscribers.Load() {
+			if s != handle {
+				remaining = append(remaining, s)
+			}
+		}
+		subscribers.Store(&remaining)
+	}
+}
+
+// notifySubscribers 将日志条目交给所有订阅者
+func notifySubscribers(entry *LogEntry) {
+	p := subscribers.Load()
+	if p == nil {
+		return
+	}
+	for _, fn := range *p {
+		(*fn)(entry)
+	}
+}
@@ -1,18 +1,33 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
+	"kiro2api/alert"
 	"kiro2api/auth"
+	"kiro2api/events"
 	"kiro2api/logger"
+	"kiro2api/metrics"
+	"kiro2api/mockupstream"
 	"kiro2api/server"
+	"kiro2api/tracing"
 	"kiro2api/types"
+	"kiro2api/usage"
 	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
 )
 
+// mockEmulator 内置CodeWhisperer模拟器，upstreamConfig.mode 为 mock 时运行
+var mockEmulator = mockupstream.New()
+
 // 全局AuthService实例，用于动态重载
 var (
 	globalAuthService *auth.AuthService
@@ -27,9 +42,10 @@ func GetGlobalAuthService() *auth.AuthService {
 }
 
 // ReloadGlobalAuthService 重载全局AuthService配置
+// AuthService内部保证重载的原子性，这里只需读取全局实例
 func ReloadGlobalAuthService() error {
-	authServiceMutex.Lock()
-	defer authServiceMutex.Unlock()
+	authServiceMutex.RLock()
+	defer authServiceMutex.RUnlock()
 
 	if globalAuthService == nil {
 		return fmt.Errorf("AuthService未初始化")
@@ -39,8 +55,19 @@ func ReloadGlobalAuthService() error {
 }
 
 func main() {
+	// 子命令：轮换配置加密主密钥
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		os.Exit(runRotateKey())
+	}
+	// 子命令：重放上游流量捕获文件
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	// 初始化配置管理器
 	configManager := webconfig.GetGlobalManager()
+	// Token刷新等后台任务通过全局审计日志记录系统操作
+	webconfig.SetDefaultAuditLog(configManager.AuditLog())
 
 	// 检查是否首次运行
 	if configManager.IsFirstRun() {
@@ -58,9 +85,29 @@ func main() {
 
 	// 使用新配置系统初始化日志
 	initializeLogger(config)
+	// 日志配置修改后立即生效；收到 SIGHUP 时重新打开日志文件
+	configManager.AddConfigChangeCallback(func() {
+		initializeLogger(configManager.GetConfig())
+	})
+	go reopenLogsOnSignal()
+	// 日志同时推送到控制台的实时事件流
+	logger.Subscribe(publishLogEvent)
 
 	logger.Info("🚀 Kiro2API 启动中...")
 
+	// 按 OpenTelemetry 标准环境变量开启追踪导出
+	if exporter, err := tracing.InitFromEnv(); err != nil {
+		logger.Warn("追踪导出配置无效，不导出追踪数据", logger.Err(err))
+	} else if exporter != nil {
+		logger.Info("追踪数据导出到OTLP收集器", logger.String("endpoint", exporter.Endpoint()))
+	}
+
+	// 模拟上游模式：先启动模拟器，token预热和刷新都会访问它
+	applyMockUpstream(config)
+	configManager.AddConfigChangeCallback(func() {
+		applyMockUpstream(configManager.GetConfig())
+	})
+
 	// 创建AuthService实例（使用依赖注入）
 	var authService *auth.AuthService
 	var err error
@@ -88,6 +135,56 @@ func main() {
 		return globalAuthService.SwitchToToken(index)
 	})
 	
+	// 注入并发与排队统计的回调
+	configManager.SetConcurrencyStatsProvider(func() types.TokenConcurrencyStats {
+		authServiceMutex.RLock()
+		defer authServiceMutex.RUnlock()
+		if globalAuthService == nil {
+			return types.TokenConcurrencyStats{Tokens: []types.TokenInFlightStat{}}
+		}
+		return globalAuthService.GetConcurrencyStats()
+	})
+
+	// 注入指标采集的token状态回调
+	metrics.SetTokenStateProvider(func() []metrics.TokenState {
+		authServiceMutex.RLock()
+		defer authServiceMutex.RUnlock()
+		if globalAuthService == nil {
+			return nil
+		}
+		return globalAuthService.TokenStates()
+	})
+
+	// 注入告警配置与测试告警回调
+	alert.SetConfigProvider(func() webconfig.AlertConfig {
+		return configManager.GetConfig().AlertConfig
+	})
+	configManager.SetAlertTestProvider(alert.TestFire)
+
+	// 打开用量账本并注入报表查询回调
+	ledgerDir := os.Getenv(usage.EnvLedgerDir)
+	if ledgerDir == "" {
+		ledgerDir = usage.DefaultLedgerDir
+	}
+	if ledger, err := usage.Open(ledgerDir); err != nil {
+		logger.Warn("打开用量账本失败，用量统计不可用", logger.String("dir", ledgerDir), logger.Err(err))
+	} else {
+		usage.SetDefault(ledger)
+		configManager.SetUsageProvider(ledger.Query)
+	}
+
+	// 打开预算消耗统计并注入查询回调
+	budgetPath := filepath.Join(ledgerDir, usage.BudgetFileName)
+	if budgets, err := usage.OpenBudgetTracker(budgetPath); err != nil {
+		logger.Warn("打开预算消耗统计失败，额度预算不生效", logger.String("path", budgetPath), logger.Err(err))
+	} else {
+		usage.SetDefaultBudgets(budgets)
+		configManager.SetBudgetProvider(budgets.Status)
+	}
+
+	// 注入在途请求查看和取消回调
+	configManager.SetActiveRequestsProvider(server.ActiveRequests, server.CancelRequest)
+
 	// 启动时初始化Token缓存（异步）
 	go configManager.RefreshTokenCache()
 
@@ -126,62 +223,76 @@ func main() {
 	server.StartServerWithConfig(port, clientToken, authService, configManager)
 }
 
+// applyMockUpstream 按上游模式启动或关闭模拟器
+func applyMockUpstream(config *webconfig.WebConfig) {
+	if config.UpstreamConfig.Mode != webconfig.UpstreamModeMock {
+		mockEmulator.Stop()
+		return
+	}
+
+	mock := config.UpstreamConfig.Mock
+	mockEmulator.Configure(mock.ScenarioDir, mock.CreditLimit)
+	if err := mockEmulator.Start(mock.Addr()); err != nil {
+		logger.Error("启动模拟上游失败", logger.Err(err))
+		return
+	}
+	logger.Info("使用内置模拟上游，请求不会发往CodeWhisperer",
+		logger.String("addr", mock.Addr()),
+		logger.String("scenario_dir", mock.ScenarioDir))
+}
+
 // createTokenUsageProvider 创建Token使用信息提供者
 func createTokenUsageProvider() webconfig.TokenUsageProvider {
-	return func(token webconfig.AuthToken) (userEmail string, userId string, remainingUsage float64, lastUsed *time.Time, err error) {
-		// 构建auth配置
-		authConfig := auth.AuthConfig{
-			AuthType:     token.Auth,
-			RefreshToken: token.RefreshToken,
-			ClientID:     token.ClientID,
-			ClientSecret: token.ClientSecret,
-			Disabled:     !token.Enabled,
-		}
+	return func(token webconfig.AuthToken) (*webconfig.TokenUsage, error) {
+		// 构建auth配置（包含按token解析的上游配置）
+		authConfig := auth.AuthConfigFromWebToken(webconfig.GetGlobalManager().GetConfig(), token)
 		
 		// 刷新Token获取最新信息
 		var tokenInfo types.TokenInfo
+		var err error
 		
 		switch token.Auth {
 		case "Social":
-			tokenInfo, err = auth.RefreshSocialToken(token.RefreshToken)
+			tokenInfo, err = auth.RefreshSocialToken(authConfig)
 		case "IdC":
 			tokenInfo, err = auth.RefreshIdCToken(authConfig)
 		default:
-			err = fmt.Errorf("不支持的认证类型: %s", token.Auth)
-			return
+			return nil, fmt.Errorf("不支持的认证类型: %s", token.Auth)
 		}
 		
 		if err != nil {
-			return "", "", 0, nil, err
+			return nil, err
 		}
 		
 		// 检查使用限制
 		checker := auth.NewUsageLimitsChecker()
 		usage, checkErr := checker.CheckUsageLimits(tokenInfo)
 		if checkErr != nil {
-			return "", "", 0, nil, checkErr
+			return nil, checkErr
 		}
 		
-		// 计算剩余次数
-		remainingUsage = auth.CalculateAvailableCount(usage)
+		result := &webconfig.TokenUsage{
+			UserEmail:      "未知",
+			UserId:         "未知",
+			RemainingUsage: auth.CalculateAvailableCount(usage), // 计算剩余次数
+			UsageLimit:     usage.ResetCreditLimit(),
+			LastUsed:       token.LastUsed, // 使用token配置中的LastUsed（如果有）
+		}
 		
 		// 提取用户信息
 		if usage.UserInfo.Email != "" {
-			userEmail = usage.UserInfo.Email
-		} else {
-			userEmail = "未知"
+			result.UserEmail = usage.UserInfo.Email
 		}
-		
 		if usage.UserInfo.UserID != "" {
-			userId = usage.UserInfo.UserID
-		} else {
-			userId = "未知"
+			result.UserId = usage.UserInfo.UserID
 		}
 		
-		// 使用token配置中的LastUsed（如果有）
-		lastUsed = token.LastUsed
+		// 额度重置时间
+		if resetAt := usage.ResetTime(time.Now()); !resetAt.IsZero() {
+			result.ResetAt = &resetAt
+		}
 		
-		return userEmail, userId, remainingUsage, lastUsed, nil
+		return result, nil
 	}
 }
 
@@ -210,21 +321,155 @@ func initializeLogger(config *webconfig.WebConfig) {
 		logger.SetTextFormat()
 	}
 
-	// 设置日志文件
-	if config.LogConfig.File != "" {
-		logger.SetLogFile(config.LogConfig.File)
+	// 设置控制台输出和日志文件（未配置文件时沿用 LOG_FILE 环境变量）
+	logFile := config.LogConfig.File
+	if logFile == "" {
+		logFile = os.Getenv("LOG_FILE")
+	}
+	rotation := logger.RotationConfig{
+		MaxSizeMB:   config.LogConfig.MaxSizeMB,
+		MaxAgeHours: config.LogConfig.MaxAgeHours,
+		MaxBackups:  config.LogConfig.MaxBackups,
+		Compress:    config.LogConfig.Compress,
+	}
+	if err := logger.ConfigureOutput(logFile, config.LogConfig.Console, rotation); err != nil {
+		logger.Error("设置日志文件失败，保持原有输出", logger.Err(err))
 	}
 
-	// 设置控制台输出
-	logger.SetConsoleOutput(config.LogConfig.Console)
+	// 设置日志载荷脱敏（未配置时沿用 LOG_REDACTION 环境变量或默认值）
+	if config.LogConfig.Redaction != "" {
+		if err := logger.SetRedactionMode(config.LogConfig.Redaction); err != nil {
+			logger.Error("设置日志脱敏模式失败", logger.Err(err))
+		}
+	}
 
 	// 设置调用栈信息
 	logger.SetCallerEnabled(config.LogConfig.EnableCaller)
 	logger.SetCallerSkip(config.LogConfig.CallerSkip)
 
+	// 设置访问日志（每个请求一条，可写入独立文件）
+	if err := logger.ConfigureAccessLog(config.LogConfig.AccessFile, config.LogConfig.AccessFormat, rotation); err != nil {
+		logger.Error("设置访问日志失败，保持原有输出", logger.Err(err))
+	}
+
 	logger.Info("日志系统初始化完成",
 		logger.String("level", config.LogConfig.Level),
 		logger.String("format", config.LogConfig.Format),
 		logger.Bool("console", config.LogConfig.Console),
-		logger.String("file", config.LogConfig.File))
+		logger.String("file", logFile),
+		logger.String("access_file", config.LogConfig.AccessFile),
+		logger.Int("max_size_mb", rotation.MaxSizeMB),
+		logger.Int("max_age_hours", rotation.MaxAgeHours),
+		logger.Int("max_backups", rotation.MaxBackups),
+		logger.String("redaction", logger.GetRedactionMode()))
+}
+
+// publishLogEvent 将日志条目作为事件发布（条目已脱敏）
+func publishLogEvent(entry *logger.LogEntry) {
+	data := map[string]any{
+		"level":   entry.Level,
+		"message": entry.Message,
+	}
+	if len(entry.Fields) > 0 {
+		data["fields"] = entry.Fields
+	}
+	if entry.File != "" {
+		data["file"] = entry.File
+	}
+	events.Publish(events.TypeLog, data)
+}
+
+// reopenLogsOnSignal 收到 SIGHUP 时重新打开日志文件，配合外部 logrotate 使用
+func reopenLogsOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := logger.Reopen(); err != nil {
+			logger.Error("重新打开日志文件失败", logger.Err(err))
+			continue
+		}
+		logger.Info("收到SIGHUP，已重新打开日志文件")
+	}
+}
+
+// runRotateKey 使用新主密钥重新加密配置文件和所有备份
+// 当前密钥读取自 KIRO_CONFIG_KEY / KIRO_CONFIG_KEY_FILE（未设置表示当前为明文），
+// 新密钥读取自 KIRO_CONFIG_NEW_KEY / KIRO_CONFIG_NEW_KEY_FILE
+func runRotateKey() int {
+	newKey, err := webconfig.LoadMasterKey(webconfig.EnvNewConfigKey, webconfig.EnvNewConfigKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载新主密钥失败: %v\n", err)
+		return 1
+	}
+
+	storage := webconfig.NewStorage()
+	count, err := storage.RotateKey(newKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "主密钥轮换失败: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("✅ 已使用新主密钥重新加密 %d 个配置文件\n", count)
+	fmt.Printf("请将 %s 更新为新密钥后重启服务\n", webconfig.EnvConfigKey)
+	return 0
+}
+
+// runReplay 将捕获文件送入当前的解析器和SSE管道，输出或与golden文件比较下发的事件
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	golden := fs.String("golden", "", "与该golden文件比较输出，不一致时退出码为1")
+	update := fs.Bool("update", false, "用本次输出覆盖golden文件")
+	verbose := fs.Bool("v", false, "输出解析过程中的警告日志")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kiro2api replay [-golden 文件 [-update]] [-v] 捕获文件")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || (*update && *golden == "") {
+		fs.Usage()
+		return 2
+	}
+	if !*verbose {
+		logger.SetLogLevel(logger.ERROR)
+	}
+	gin.SetMode(gin.ReleaseMode)
+
+	capture, err := server.LoadCapture(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if capture.Truncated {
+		fmt.Fprintln(os.Stderr, "⚠️ 捕获的上游响应已截断，重放结果可能不完整")
+	}
+	output, err := server.ReplayCapture(capture)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "重放失败: %v\n", err)
+		return 1
+	}
+
+	switch {
+	case *golden == "":
+		fmt.Print(output)
+	case *update:
+		if err := os.WriteFile(*golden, []byte(output), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "写入golden文件失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("✅ 已更新 %s\n", *golden)
+	default:
+		want, err := os.ReadFile(*golden)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "读取golden文件失败: %v\n", err)
+			return 1
+		}
+		if diff := server.DiffLines(string(want), output); diff != "" {
+			fmt.Printf("❌ 重放输出与 %s 不一致:\n%s", *golden, diff)
+			return 1
+		}
+		fmt.Printf("✅ 重放输出与 %s 一致\n", *golden)
+	}
+	return 0
 }
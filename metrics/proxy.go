@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EnvMetricsToken 访问 /metrics 的令牌环境变量（Web配置中未设置时使用）
+const EnvMetricsToken = "METRICS_TOKEN"
+
+// latencyBuckets 延迟直方图的桶上界（秒），覆盖从首字节到长时间流式响应
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600}
+
+// Default 代理服务的默认指标注册表
+var Default = NewRegistry()
+
+var (
+	requestsTotal = Default.NewCounterVec("kiro2api_requests_total",
+		"已完成的请求数", "endpoint", "model", "status", "key")
+	requestDuration = Default.NewHistogramVec("kiro2api_request_duration_seconds",
+		"请求总耗时（秒）", latencyBuckets, "endpoint", "model")
+	timeToFirstByte = Default.NewHistogramVec("kiro2api_time_to_first_byte_seconds",
+		"从收到请求到上游返回响应头的耗时（秒）", latencyBuckets, "endpoint", "model")
+	timeToFirstToken = Default.NewHistogramVec("kiro2api_time_to_first_token_seconds",
+		"流式请求从收到请求到发出第一个内容增量的耗时（秒）", latencyBuckets, "endpoint", "model")
+	inputTokensTotal = Default.NewCounterVec("kiro2api_input_tokens_total",
+		"下发给客户端的输入token数", "model", "key")
+	outputTokensTotal = Default.NewCounterVec("kiro2api_output_tokens_total",
+		"下发给客户端的输出token数", "model", "key")
+	upstreamErrorsTotal = Default.NewCounterVec("kiro2api_upstream_errors_total",
+		"上游错误数，按错误映射类别区分", "category", "status")
+	parserErrorsTotal = Default.NewCounterVec("kiro2api_parser_errors_total",
+		"上游事件流解析错误数", "kind")
+	sseViolationsTotal = Default.NewCounterVec("kiro2api_sse_violations_total",
+		"SSE状态管理器拦截的协议违规事件数", "kind")
+)
+
+// TokenState 采集时单个上游token的状态
+type TokenState struct {
+	ID        string    // token标识
+	Index     int       // token在轮换顺序中的索引
+	Available float64   // 剩余额度，未知时为0
+	ExpiresAt time.Time // 访问令牌过期时间，未知时为零值
+	Exhausted bool      // 是否已耗尽
+	InFlight  int       // 在途请求数
+}
+
+var (
+	tokenStateProvider      func() []TokenState
+	tokenStateProviderMutex sync.RWMutex
+)
+
+// SetTokenStateProvider 设置token状态的采集回调，未设置时不输出token指标
+func SetTokenStateProvider(provider func() []TokenState) {
+	tokenStateProviderMutex.Lock()
+	defer tokenStateProviderMutex.Unlock()
+	tokenStateProvider = provider
+}
+
+// tokenGauge 基于token状态创建按token区分的仪表
+func tokenGauge(name, help string, value func(TokenState) (float64, bool)) {
+	Default.NewGaugeFunc(name, help, func() []GaugeSample {
+		tokenStateProviderMutex.RLock()
+		provider := tokenStateProvider
+		tokenStateProviderMutex.RUnlock()
+		if provider == nil {
+			return nil
+		}
+
+		states := provider()
+		samples := make([]GaugeSample, 0, len(states))
+		for _, state := range states {
+			if v, ok := value(state); ok {
+				samples = append(samples, GaugeSample{Labels: []string{state.ID, strconv.Itoa(state.Index)}, Value: v})
+			}
+		}
+		return samples
+	}, "token", "index")
+}
+
+func init() {
+	tokenGauge("kiro2api_token_available_credits", "token剩余额度", func(s TokenState) (float64, bool) {
+		return s.Available, true
+	})
+	tokenGauge("kiro2api_token_expiry_timestamp_seconds", "token访问令牌的过期时间（Unix秒）", func(s TokenState) (float64, bool) {
+		if s.ExpiresAt.IsZero() {
+			return 0, false
+		}
+		return float64(s.ExpiresAt.Unix()), true
+	})
+	tokenGauge("kiro2api_token_exhausted", "token额度是否已耗尽（1为耗尽）", func(s TokenState) (float64, bool) {
+		if s.Exhausted {
+			return 1, true
+		}
+		return 0, true
+	})
+	tokenGauge("kiro2api_token_in_flight_requests", "token当前在途请求数", func(s TokenState) (float64, bool) {
+		return float64(s.InFlight), true
+	})
+}
+
+// ObserveRequest 记录一个已完成的请求
+func ObserveRequest(endpoint, model string, status int, key string, duration time.Duration) {
+	requestsTotal.Inc(endpoint, model, strconv.Itoa(status), key)
+	requestDuration.Observe(duration.Seconds(), endpoint, model)
+}
+
+// ObserveTimeToFirstByte 记录上游响应头到达的耗时
+func ObserveTimeToFirstByte(endpoint, model string, elapsed time.Duration) {
+	timeToFirstByte.Observe(elapsed.Seconds(), endpoint, model)
+}
+
+// ObserveTimeToFirstToken 记录首个内容增量发出的耗时
+func ObserveTimeToFirstToken(endpoint, model string, elapsed time.Duration) {
+	timeToFirstToken.Observe(elapsed.Seconds(), endpoint, model)
+}
+
+// AddTokens 累计下发给客户端的输入和输出token数
+func AddTokens(model, key string, inputTokens, outputTokens int) {
+	inputTokensTotal.Add(float64(inputTokens), model, key)
+	outputTokensTotal.Add(float64(outputTokens), model, key)
+}
+
+// IncUpstreamError 记录一次上游错误，category 为错误映射策略的类别
+func IncUpstreamError(category string, status int) {
+	upstreamErrorsTotal.Inc(category, strconv.Itoa(status))
+}
+
+// IncParserError 记录一次事件流解析错误
+func IncParserError(kind string) {
+	parserErrorsTotal.Inc(kind)
+}
+
+// IncSSEViolation 记录一次被拦截的SSE协议违规
+func IncSSEViolation(kind string) {
+	sseViolationsTotal.Inc(kind)
+}
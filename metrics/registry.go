@@ -0,0 +1,288 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labelSeparator 拼接标签值作为序列键，标签值中不会出现该字符
+const labelSeparator = "\xff"
+
+// collector 可输出为 Prometheus 文本格式的指标
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry 指标注册表，按注册顺序输出
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors []collector
+}
+
+// NewRegistry 创建指标注册表
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WritePrometheus 以 Prometheus 文本格式（0.0.4）输出所有指标
+func (r *Registry) WritePrometheus(w io.Writer) {
+	r.mutex.RLock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mutex.RUnlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// desc 指标的名称、说明和标签名
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) writeHeader(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, kind)
+}
+
+// key 将标签值拼接为序列键，标签数量不符时 panic（属于编程错误）
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("指标 %s 需要 %d 个标签值，实际 %d 个", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, labelSeparator)
+}
+
+// formatLabels 格式化标签，extra 为附加的标签（如直方图的 le）
+func (d desc) formatLabels(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, name := range d.labels {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec 按标签区分的计数器
+type CounterVec struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec 创建并注册计数器
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]*counterSeries)}
+	r.register(c)
+	return c
+}
+
+// Inc 计数加1
+func (c *CounterVec) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add 计数增加 delta，负数和非有限值被忽略（计数器只增不减）
+func (c *CounterVec) Add(delta float64, labels ...string) {
+	if delta <= 0 || math.IsInf(delta, 0) || math.IsNaN(delta) {
+		return
+	}
+	key := c.key(labels)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	series, exists := c.values[key]
+	if !exists {
+		series = &counterSeries{labels: append([]string(nil), labels...)}
+		c.values[key] = series
+	}
+	series.value += delta
+}
+
+// Value 返回指定标签的当前计数
+func (c *CounterVec) Value(labels ...string) float64 {
+	key := c.key(labels)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if series, exists := c.values[key]; exists {
+		return series.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.writeHeader(w, "counter")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		series := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.formatLabels(series.labels), formatFloat(series.value))
+	}
+}
+
+// HistogramVec 按标签区分的直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // 各桶（非累计）计数，最后一个为 +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec 创建并注册直方图，buckets 为递增的桶上界
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{desc: desc{name: name, help: help, labels: labels}, buckets: sorted, values: make(map[string]*histogramSeries)}
+	r.register(h)
+	return h
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(value float64, labels ...string) {
+	if math.IsNaN(value) {
+		return
+	}
+	key := h.key(labels)
+	bucket := sort.SearchFloat64s(h.buckets, value)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	series, exists := h.values[key]
+	if !exists {
+		series = &histogramSeries{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = series
+	}
+	series.counts[bucket]++
+	series.sum += value
+	series.count++
+}
+
+// Count 返回指定标签的观测次数
+func (h *HistogramVec) Count(labels ...string) uint64 {
+	key := h.key(labels)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if series, exists := h.values[key]; exists {
+		return series.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.writeHeader(w, "histogram")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		series := h.values[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(series.labels, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(series.labels, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(series.labels), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.formatLabels(series.labels), series.count)
+	}
+}
+
+// GaugeSample 采集时生成的一个仪表值
+type GaugeSample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc 在每次采集时调用回调生成的仪表，适合从已有状态读取的值（如token剩余额度）
+type GaugeFunc struct {
+	desc
+	collect func() []GaugeSample
+}
+
+// NewGaugeFunc 创建并注册按需采集的仪表
+func (r *Registry) NewGaugeFunc(name, help string, collect func() []GaugeSample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, labels: labels}, collect: collect}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w, "gauge")
+
+	samples := g.collect()
+	sort.SliceStable(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, labelSeparator) < strings.Join(samples[j].Labels, labelSeparator)
+	})
+	for _, sample := range samples {
+		if len(sample.Labels) != len(g.labels) {
+			continue
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.formatLabels(sample.Labels), formatFloat(sample.Value))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func render(r *Registry) string {
+	var buf bytes.Buffer
+	r.WritePrometheus(&buf)
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "请求数", "endpoint", "status")
+
+	c.Inc("/v1/messages", "200")
+	c.Add(2, "/v1/messages", "200")
+	c.Inc("/v1/chat/completions", "500")
+	// 计数器不接受负数
+	c.Add(-1, "/v1/messages", "200")
+
+	assert.Equal(t, float64(3), c.Value("/v1/messages", "200"))
+	assert.Equal(t, float64(0), c.Value("/v1/models", "200"))
+
+	out := render(r)
+	assert.Contains(t, out, "# HELP test_requests_total 请求数\n# TYPE test_requests_total counter\n")
+	assert.Contains(t, out, `test_requests_total{endpoint="/v1/messages",status="200"} 3`)
+	assert.Contains(t, out, `test_requests_total{endpoint="/v1/chat/completions",status="500"} 1`)
+	// 序列按标签排序输出
+	assert.Less(t, strings.Index(out, "/v1/chat/completions"), strings.Index(out, `"/v1/messages"`))
+
+	assert.Panics(t, func() { c.Inc("/v1/messages") })
+}
+
+func TestCounterVec_EscapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "help", "model")
+	c.Inc("a\"b\\c\nd")
+
+	assert.Contains(t, render(r), `test_total{model="a\"b\\c\nd"} 1`)
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_duration_seconds", "耗时", []float64{1, 0.1, 0.5}, "endpoint")
+
+	h.Observe(0.05, "/v1/messages")
+	h.Observe(0.1, "/v1/messages") // 等于上界时计入该桶
+	h.Observe(0.7, "/v1/messages")
+	h.Observe(3, "/v1/messages")
+
+	assert.Equal(t, uint64(4), h.Count("/v1/messages"))
+
+	out := render(r)
+	assert.Contains(t, out, "# TYPE test_duration_seconds histogram\n")
+	assert.Contains(t, out, `test_duration_seconds_bucket{endpoint="/v1/messages",le="0.1"} 2`)
+	assert.Contains(t, out, `test_duration_seconds_bucket{endpoint="/v1/messages",le="0.5"} 2`)
+	assert.Contains(t, out, `test_duration_seconds_bucket{endpoint="/v1/messages",le="1"} 3`)
+	assert.Contains(t, out, `test_duration_seconds_bucket{endpoint="/v1/messages",le="+Inf"} 4`)
+	assert.Contains(t, out, `test_duration_seconds_sum{endpoint="/v1/messages"} 3.85`)
+	assert.Contains(t, out, `test_duration_seconds_count{endpoint="/v1/messages"} 4`)
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	samples := []GaugeSample{
+		{Labels: []string{"b"}, Value: 2},
+		{Labels: []string{"a"}, Value: 1.5},
+		{Labels: []string{"x", "y"}, Value: 9}, // 标签数量不符的样本被忽略
+	}
+	r.NewGaugeFunc("test_gauge", "仪表", func() []GaugeSample { return samples }, "token")
+
+	out := render(r)
+	assert.Contains(t, out, "# TYPE test_gauge gauge\n")
+	assert.Contains(t, out, "test_gauge{token=\"a\"} 1.5\ntest_gauge{token=\"b\"} 2\n")
+	assert.NotContains(t, out, "9")
+}
+
+func TestTokenGauges(t *testing.T) {
+	expiresAt := time.Unix(1700000000, 0)
+	SetTokenStateProvider(func() []TokenState {
+		return []TokenState{
+			{ID: "t1", Index: 0, Available: 42.5, ExpiresAt: expiresAt, InFlight: 2},
+			{ID: "t2", Index: 1, Exhausted: true},
+		}
+	})
+	defer SetTokenStateProvider(nil)
+
+	out := render(Default)
+	assert.Contains(t, out, `kiro2api_token_available_credits{token="t1",index="0"} 42.5`)
+	assert.Contains(t, out, `kiro2api_token_expiry_timestamp_seconds{token="t1",index="0"} 1.7e+09`)
+	// 过期时间未知的token不输出过期时间
+	assert.NotContains(t, out, `kiro2api_token_expiry_timestamp_seconds{token="t2"`)
+	assert.Contains(t, out, `kiro2api_token_exhausted{token="t2",index="1"} 1`)
+	assert.Contains(t, out, `kiro2api_token_in_flight_requests{token="t1",index="0"} 2`)
+
+	SetTokenStateProvider(nil)
+	assert.NotContains(t, render(Default), `token="t1"`)
+}
@@ -0,0 +1,261 @@
+package mockupstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/types"
+)
+
+// 模拟器签发的令牌与账号信息
+const (
+	accessTokenPrefix  = "mock-access-"
+	invalidTokenPrefix = "invalid"
+	mockProfileArn     = "arn:aws:codewhisperer:us-east-1:000000000000:profile/MOCKPROFILE"
+)
+
+// Emulator 本地CodeWhisperer模拟器
+// 模拟 generateAssistantResponse、getUsageLimits 以及 Social/IdC 的token刷新端点，
+// 每个访问令牌独立记账，额度用尽后返回配额异常
+type Emulator struct {
+	mutex       sync.Mutex
+	scenarioDir string
+	creditLimit float64
+	used        map[string]float64 // 访问令牌 -> 已用额度
+
+	server *http.Server
+	addr   string
+}
+
+// New 创建模拟器
+func New() *Emulator {
+	return &Emulator{
+		creditLimit: config.DefaultMockCreditLimit,
+		used:        make(map[string]float64),
+	}
+}
+
+// Configure 设置场景目录和每个token的额度，0使用默认额度
+// 场景文件在每次生成请求时重新读取，修改后立即生效
+func (e *Emulator) Configure(scenarioDir string, creditLimit float64) {
+	if creditLimit <= 0 {
+		creditLimit = config.DefaultMockCreditLimit
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.scenarioDir = scenarioDir
+	e.creditLimit = creditLimit
+}
+
+// Start 在指定地址启动模拟器，已在该地址运行时直接返回；地址变化时关闭旧监听
+func (e *Emulator) Start(addr string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.server != nil {
+		if e.addr == addr {
+			return nil
+		}
+		_ = e.server.Close()
+		e.server = nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("模拟器监听 %s 失败: %w", addr, err)
+	}
+	server := &http.Server{Handler: e, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("模拟器退出", logger.Err(err))
+		}
+	}()
+	e.server = server
+	e.addr = addr
+	return nil
+}
+
+// Stop 关闭模拟器监听
+func (e *Emulator) Stop() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.server != nil {
+		_ = e.server.Close()
+		e.server = nil
+		e.addr = ""
+	}
+}
+
+// ServeHTTP 按路径分发到各模拟端点
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == config.MockCodeWhispererPath:
+		e.handleGenerate(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == config.MockUsageLimitsPath:
+		e.handleUsageLimits(w, r)
+	case r.Method == http.MethodPost && (r.URL.Path == config.MockSocialRefreshPath || r.URL.Path == config.MockIdcRefreshPath):
+		e.handleRefresh(w, r)
+	default:
+		writeError(w, http.StatusNotFound, ScenarioError{Type: "UnknownOperationException", Message: "未知的模拟端点: " + r.URL.Path})
+	}
+}
+
+// handleRefresh 模拟token刷新：以 invalid 开头或为空的刷新令牌返回401，其余签发固定的访问令牌
+func (e *Emulator) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ScenarioError{Type: "InvalidRequestException", Message: "请求体无效"})
+		return
+	}
+	if req.RefreshToken == "" || strings.HasPrefix(req.RefreshToken, invalidTokenPrefix) {
+		writeError(w, http.StatusUnauthorized, ScenarioError{Type: "InvalidGrantException", Message: "Invalid refresh token provided"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, types.RefreshResponse{
+		AccessToken:  accessTokenFor(req.RefreshToken),
+		ExpiresIn:    int(config.MockAccessTokenTTL.Seconds()),
+		RefreshToken: req.RefreshToken,
+		ProfileArn:   mockProfileArn,
+	})
+}
+
+// handleUsageLimits 模拟额度查询，返回该访问令牌的已用额度
+func (e *Emulator) handleUsageLimits(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		writeError(w, http.StatusForbidden, ScenarioError{Type: "AccessDeniedException", Message: "The bearer token included in the request is invalid."})
+		return
+	}
+
+	e.mutex.Lock()
+	used, limit := e.used[token], e.creditLimit
+	e.mutex.Unlock()
+
+	now := time.Now().UTC()
+	nextReset := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	writeJSON(w, http.StatusOK, types.UsageLimits{
+		UsageBreakdownList: []types.UsageBreakdown{{
+			ResourceType:              "CREDIT",
+			Unit:                      "INVOCATIONS",
+			UsageLimit:                int(limit),
+			UsageLimitWithPrecision:   limit,
+			CurrentUsage:              int(used),
+			CurrentUsageWithPrecision: used,
+			NextDateReset:             float64(nextReset.Unix()),
+			Currency:                  "USD",
+			DisplayName:               "Credit",
+			DisplayNamePlural:         "Credits",
+		}},
+		UserInfo:       types.UserInfo{Email: "mock-" + strings.TrimPrefix(token, accessTokenPrefix) + "@example.com", UserID: token},
+		DaysUntilReset: int(nextReset.Sub(now).Hours() / 24),
+		NextDateReset:  float64(nextReset.Unix()),
+		SubscriptionInfo: types.SubscriptionInfo{
+			SubscriptionTitle: "KIRO MOCK",
+			Type:              "Q_DEVELOPER_STANDALONE_FREE",
+		},
+	})
+}
+
+// handleGenerate 模拟 generateAssistantResponse：按用户消息选择场景并输出 EventStream 帧
+func (e *Emulator) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		writeError(w, http.StatusForbidden, ScenarioError{Type: "AccessDeniedException", Message: "The bearer token included in the request is invalid."})
+		return
+	}
+
+	var req types.CodeWhispererRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ScenarioError{Type: "ValidationException", Message: "请求体无效: " + err.Error()})
+		return
+	}
+
+	e.mutex.Lock()
+	dir := e.scenarioDir
+	e.mutex.Unlock()
+	scenarios, err := LoadScenarios(dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ScenarioError{Type: "InternalServerException", Message: err.Error()})
+		return
+	}
+	scenario := selectScenario(scenarios, req.ConversationState.CurrentMessage.UserInputMessage.Content)
+
+	if scenario.Status != 0 && scenario.Status != http.StatusOK {
+		writeError(w, scenario.Status, *scenario.Error)
+		return
+	}
+	if !e.charge(token) {
+		writeError(w, http.StatusTooManyRequests, ScenarioError{
+			Type:    "ServiceQuotaExceededException",
+			Message: "You have reached the limit for this month.",
+			Reason:  "MONTHLY_REQUEST_COUNT",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+	w.Header().Set("x-amzn-RequestId", "mock-"+strings.TrimPrefix(token, accessTokenPrefix))
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for i, frame := range scenario.frames() {
+		if i > 0 && scenario.DelayMs > 0 {
+			select {
+			case <-time.After(time.Duration(scenario.DelayMs) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// charge 扣除一次生成请求的额度，额度不足时返回false
+func (e *Emulator) charge(token string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.used[token]+config.MockCreditsPerRequest > e.creditLimit {
+		return false
+	}
+	e.used[token] += config.MockCreditsPerRequest
+	return true
+}
+
+// accessTokenFor 由刷新令牌派生访问令牌，同一刷新令牌每次刷新得到相同的令牌（额度不会因刷新重置）
+func accessTokenFor(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return accessTokenPrefix + hex.EncodeToString(sum[:8])
+}
+
+// bearerToken 提取模拟器签发的访问令牌
+func bearerToken(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, strings.HasPrefix(token, accessTokenPrefix)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, body ScenarioError) {
+	w.Header().Set("x-amzn-ErrorType", body.Type)
+	writeJSON(w, status, body)
+}
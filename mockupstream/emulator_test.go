@@ -0,0 +1,209 @@
+package mockupstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/parser"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeFrames 校验每一帧的前导CRC和消息CRC，返回解析出的消息
+func decodeFrames(t *testing.T, data []byte) []*parser.EventStreamMessage {
+	for rest := data; len(rest) > 0; {
+		total := int(binary.BigEndian.Uint32(rest[0:4]))
+		assert.Equal(t, crc32.ChecksumIEEE(rest[:8]), binary.BigEndian.Uint32(rest[8:12]), "前导CRC")
+		assert.Equal(t, crc32.ChecksumIEEE(rest[:total-4]), binary.BigEndian.Uint32(rest[total-4:total]), "消息CRC")
+		rest = rest[total:]
+	}
+	messages, err := parser.NewRobustEventStreamParser(true).ParseStream(data)
+	assert.NoError(t, err)
+	return messages
+}
+
+func newTestEmulator(t *testing.T, creditLimit float64) (*Emulator, *httptest.Server) {
+	e := New()
+	e.Configure("scenarios", creditLimit)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return e, server
+}
+
+func refresh(t *testing.T, baseURL, path, refreshToken string) (*http.Response, types.RefreshResponse) {
+	body, _ := json.Marshal(map[string]string{"refreshToken": refreshToken})
+	resp, err := http.Post(baseURL+path, "application/json", bytes.NewReader(body))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	var out types.RefreshResponse
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+func generate(t *testing.T, baseURL, accessToken, content string) *http.Response {
+	var req types.CodeWhispererRequest
+	req.ConversationState.CurrentMessage.UserInputMessage.Content = content
+	body, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequest(http.MethodPost, baseURL+config.MockCodeWhispererPath, bytes.NewReader(body))
+	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := http.DefaultClient.Do(httpReq)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestEmulator_Refresh(t *testing.T) {
+	_, server := newTestEmulator(t, 0)
+
+	for _, path := range []string{config.MockSocialRefreshPath, config.MockIdcRefreshPath} {
+		resp, out := refresh(t, server.URL, path, "refresh-1")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, strings.HasPrefix(out.AccessToken, accessTokenPrefix))
+		assert.Equal(t, 3600, out.ExpiresIn)
+		assert.Equal(t, mockProfileArn, out.ProfileArn)
+	}
+
+	// 同一刷新令牌得到相同访问令牌
+	_, a := refresh(t, server.URL, config.MockSocialRefreshPath, "refresh-1")
+	_, b := refresh(t, server.URL, config.MockSocialRefreshPath, "refresh-1")
+	_, c := refresh(t, server.URL, config.MockSocialRefreshPath, "refresh-2")
+	assert.Equal(t, a.AccessToken, b.AccessToken)
+	assert.NotEqual(t, a.AccessToken, c.AccessToken)
+
+	resp, _ := refresh(t, server.URL, config.MockSocialRefreshPath, "invalid-token")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestEmulator_GenerateEchoAndUsage(t *testing.T) {
+	_, server := newTestEmulator(t, 2)
+	_, token := refresh(t, server.URL, config.MockSocialRefreshPath, "refresh-1")
+
+	resp := generate(t, server.URL, token.AccessToken, "hello mock")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/vnd.amazon.eventstream", resp.Header.Get("Content-Type"))
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var text strings.Builder
+	for _, message := range decodeFrames(t, data) {
+		assert.Equal(t, "assistantResponseEvent", message.GetEventType())
+		var payload struct{ Content string }
+		assert.NoError(t, json.Unmarshal(message.Payload, &payload))
+		text.WriteString(payload.Content)
+	}
+	assert.Equal(t, "模拟回复: hello mock", text.String())
+
+	// 额度查询反映已用额度
+	req, _ := http.NewRequest(http.MethodGet, server.URL+config.MockUsageLimitsPath+"?origin=AI_EDITOR", nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	usageResp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	var usage types.UsageLimits
+	assert.NoError(t, json.NewDecoder(usageResp.Body).Decode(&usage))
+	usageResp.Body.Close()
+	assert.Equal(t, 2.0, usage.ResetCreditLimit())
+	assert.Equal(t, 1.0, usage.CreditsUsed())
+	assert.True(t, usage.ResetTime(time.Now()).After(time.Now()))
+
+	// 额度用尽后返回配额异常
+	generate(t, server.URL, token.AccessToken, "second").Body.Close()
+	exhausted := generate(t, server.URL, token.AccessToken, "third")
+	assert.Equal(t, http.StatusTooManyRequests, exhausted.StatusCode)
+	assert.Equal(t, "ServiceQuotaExceededException", exhausted.Header.Get("x-amzn-ErrorType"))
+	exhausted.Body.Close()
+
+	// 未由模拟器签发的令牌被拒绝
+	forbidden := generate(t, server.URL, "real-token", "hi")
+	assert.Equal(t, http.StatusForbidden, forbidden.StatusCode)
+	forbidden.Body.Close()
+}
+
+func TestEmulator_Scenarios(t *testing.T) {
+	_, server := newTestEmulator(t, 0)
+	_, token := refresh(t, server.URL, config.MockSocialRefreshPath, "refresh-1")
+
+	// 按 match 选择场景：工具参数按字节拆分，切开了多字节字符
+	resp := generate(t, server.URL, token.AccessToken, "今天天气怎么样")
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var text strings.Builder
+	var input []byte
+	var splitRune, stopped bool
+	for _, message := range decodeFrames(t, data) {
+		switch message.GetEventType() {
+		case "assistantResponseEvent":
+			var payload struct{ Content string }
+			assert.NoError(t, json.Unmarshal(message.Payload, &payload))
+			text.WriteString(payload.Content)
+		case "toolUseEvent":
+			assert.Contains(t, string(message.Payload), `"toolUseId":"tooluse_mock_weather"`)
+			if bytes.Contains(message.Payload, []byte(`"stop":true`)) {
+				stopped = true
+				continue
+			}
+			// 原始载荷中的input片段（不经过JSON解码，保留被切开的字节）
+			start := bytes.Index(message.Payload, []byte(`"input":"`)) + len(`"input":"`)
+			end := bytes.LastIndex(message.Payload, []byte(`","stop"`))
+			fragment := bytes.ReplaceAll(message.Payload[start:end], []byte(`\"`), []byte(`"`))
+			if len(fragment) > 0 && !utf8.Valid(fragment) {
+				splitRune = true
+			}
+			input = append(input, fragment...)
+		}
+	}
+	assert.Equal(t, "我来查询一下北京的天气。", text.String())
+	assert.Equal(t, `{"city":"北京","unit":"摄氏度"}`, string(input))
+	assert.True(t, splitRune, "至少一个片段应切开UTF-8字符")
+	assert.True(t, stopped)
+
+	// [scenario:名称] 标记优先；错误场景直接返回错误响应且不扣额度
+	resp = generate(t, server.URL, token.AccessToken, "天气 [scenario:throttling]")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.JSONEq(t, `{"__type":"ThrottlingException","message":"Rate exceeded"}`, string(body))
+
+	// 流中异常帧之后结束响应
+	resp = generate(t, server.URL, token.AccessToken, "[scenario:exception]")
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	messages := decodeFrames(t, data)
+	last := messages[len(messages)-1]
+	assert.Equal(t, "exception", last.GetMessageType())
+	assert.Equal(t, "InternalServerException", last.Headers[":exception-type"].Value)
+}
+
+func TestLoadScenarios(t *testing.T) {
+	dir := t.TempDir()
+	scenarios, err := LoadScenarios(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, scenarios)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.json"), []byte(`[{"name":"x","events":[{"text":"hi"}]},{"events":[{"text":"yo"}]}]`), 0o644))
+	scenarios, err = LoadScenarios(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"x", "a"}, []string{scenarios[0].Name, scenarios[1].Name})
+	assert.Equal(t, "a", selectScenario(scenarios, "[scenario:a]").Name)
+	assert.Equal(t, "echo", selectScenario(scenarios, "anything").Name)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"events":[{"text":"hi","exception":{"__type":"X"}}]}`), 0o644))
+	_, err = LoadScenarios(dir)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"status":500}`), 0o644))
+	_, err = LoadScenarios(dir)
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package mockupstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// header AWS EventStream 字符串类型的消息头
+type header struct {
+	name  string
+	value string
+}
+
+// headerTypeString 字符串类型消息头的类型码
+const headerTypeString = 7
+
+// encodeFrame 按 AWS EventStream 格式编码一条消息
+// 格式: 总长度(4) + 头部长度(4) + 前导CRC(4) + 头部 + 载荷 + 消息CRC(4)，CRC为IEEE CRC32
+func encodeFrame(headers []header, payload []byte) []byte {
+	var encodedHeaders bytes.Buffer
+	for _, h := range headers {
+		encodedHeaders.WriteByte(byte(len(h.name)))
+		encodedHeaders.WriteString(h.name)
+		encodedHeaders.WriteByte(headerTypeString)
+		_ = binary.Write(&encodedHeaders, binary.BigEndian, uint16(len(h.value)))
+		encodedHeaders.WriteString(h.value)
+	}
+
+	total := 12 + encodedHeaders.Len() + len(payload) + 4
+	frame := bytes.NewBuffer(make([]byte, 0, total))
+	_ = binary.Write(frame, binary.BigEndian, uint32(total))
+	_ = binary.Write(frame, binary.BigEndian, uint32(encodedHeaders.Len()))
+	_ = binary.Write(frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	frame.Write(encodedHeaders.Bytes())
+	frame.Write(payload)
+	_ = binary.Write(frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	return frame.Bytes()
+}
+
+// eventFrame 编码事件消息
+func eventFrame(eventType string, payload []byte) []byte {
+	return encodeFrame([]header{
+		{":message-type", "event"},
+		{":event-type", eventType},
+		{":content-type", "application/json"},
+	}, payload)
+}
+
+// exceptionFrame 编码异常消息
+func exceptionFrame(exceptionType string, payload []byte) []byte {
+	return encodeFrame([]header{
+		{":message-type", "exception"},
+		{":exception-type", exceptionType},
+		{":content-type", "application/json"},
+	}, payload)
+}
+
+// rawJSONString 将字节编码为JSON字符串，只转义引号、反斜杠和控制字符
+// 与 encoding/json 不同，不合法的UTF-8字节原样保留，用于模拟上游在多字节字符中间拆分片段
+func rawJSONString(b []byte) []byte {
+	out := make([]byte, 0, len(b)+2)
+	out = append(out, '"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c == '\n':
+			out = append(out, '\\', 'n')
+		case c == '\r':
+			out = append(out, '\\', 'r')
+		case c == '\t':
+			out = append(out, '\\', 't')
+		case c < 0x20:
+			out = append(out, fmt.Sprintf(`\u%04x`, c)...)
+		default:
+			out = append(out, c)
+		}
+	}
+	return append(out, '"')
+}
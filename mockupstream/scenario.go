@@ -0,0 +1,203 @@
+package mockupstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Scenario 脚本化的上游响应
+// 状态码非200时返回错误响应体，否则按顺序输出事件帧
+type Scenario struct {
+	Name    string          `json:"name"`              // 场景名，客户端消息中包含 [scenario:名称] 时使用该场景
+	Match   string          `json:"match,omitempty"`   // 当前用户消息包含该文本时使用该场景
+	Status  int             `json:"status,omitempty"`  // HTTP状态码，默认200
+	Error   *ScenarioError  `json:"error,omitempty"`   // 状态码非200时的响应体
+	DelayMs int             `json:"delayMs,omitempty"` // 相邻两帧之间的间隔
+	Events  []ScenarioEvent `json:"events,omitempty"`
+}
+
+// ScenarioError 上游错误或流中异常，序列化为 {"__type", "message", "reason"}
+type ScenarioError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ScenarioEvent 一个场景步骤，Text、ToolUse、Exception 三选一
+type ScenarioEvent struct {
+	Text      string           `json:"text,omitempty"`      // assistantResponseEvent 文本
+	ToolUse   *ScenarioToolUse `json:"toolUse,omitempty"`   // toolUseEvent 工具调用
+	Exception *ScenarioError   `json:"exception,omitempty"` // 流中异常，发出后结束响应
+	ChunkSize int              `json:"chunkSize,omitempty"` // 文本按字符数、工具参数按字节数拆成多帧，0不拆分
+}
+
+// ScenarioToolUse 工具调用，参数按字节拆分时可能切开UTF-8多字节字符（与真实上游一致）
+type ScenarioToolUse struct {
+	Name      string `json:"name"`
+	ToolUseID string `json:"toolUseId"`
+	Input     string `json:"input"`
+}
+
+// scenarioSelector 客户端消息中指定场景的标记
+var scenarioSelector = regexp.MustCompile(`\[scenario:([^\]\s]+)\]`)
+
+// LoadScenarios 读取目录下的所有 *.json 场景文件，按文件名排序
+// 每个文件可以是单个场景对象或场景数组；未指定名称的场景使用文件名
+func LoadScenarios(dir string) ([]Scenario, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var scenarios []Scenario
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取场景文件失败: %w", err)
+		}
+		var batch []Scenario
+		if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(data, &batch)
+		} else {
+			var single Scenario
+			err = json.Unmarshal(data, &single)
+			batch = []Scenario{single}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析场景文件 %s 失败: %w", filepath.Base(file), err)
+		}
+		for i := range batch {
+			if batch[i].Name == "" {
+				batch[i].Name = strings.TrimSuffix(filepath.Base(file), ".json")
+			}
+			if err := batch[i].validate(); err != nil {
+				return nil, fmt.Errorf("场景文件 %s: %w", filepath.Base(file), err)
+			}
+		}
+		scenarios = append(scenarios, batch...)
+	}
+	return scenarios, nil
+}
+
+func (s Scenario) validate() error {
+	if s.Status != 0 && s.Status != http.StatusOK && s.Error == nil {
+		return fmt.Errorf("场景 %s 状态码为 %d 但未设置 error", s.Name, s.Status)
+	}
+	for i, event := range s.Events {
+		count := 0
+		if event.Text != "" {
+			count++
+		}
+		if event.ToolUse != nil {
+			count++
+		}
+		if event.Exception != nil {
+			count++
+		}
+		if count != 1 {
+			return fmt.Errorf("场景 %s 第 %d 个事件必须且只能设置 text、toolUse、exception 之一", s.Name, i+1)
+		}
+	}
+	return nil
+}
+
+// selectScenario 按用户消息选择场景：先看 [scenario:名称] 标记，再看 match，最后使用名为 default 的场景或内置回显
+func selectScenario(scenarios []Scenario, content string) Scenario {
+	if m := scenarioSelector.FindStringSubmatch(content); m != nil {
+		for _, s := range scenarios {
+			if s.Name == m[1] {
+				return s
+			}
+		}
+	}
+	for _, s := range scenarios {
+		if s.Match != "" && strings.Contains(content, s.Match) {
+			return s
+		}
+	}
+	for _, s := range scenarios {
+		if s.Name == "default" {
+			return s
+		}
+	}
+	return echoScenario(content)
+}
+
+// echoScenario 内置场景：回显用户消息
+func echoScenario(content string) Scenario {
+	if utf8.RuneCountInString(content) > 200 {
+		content = string([]rune(content)[:200]) + "..."
+	}
+	return Scenario{Name: "echo", Events: []ScenarioEvent{{Text: "模拟回复: " + content, ChunkSize: 8}}}
+}
+
+// frames 将场景转换为事件帧
+func (s Scenario) frames() [][]byte {
+	var frames [][]byte
+	for _, event := range s.Events {
+		switch {
+		case event.Text != "":
+			for _, chunk := range splitRunes(event.Text, event.ChunkSize) {
+				payload := append(append([]byte(`{"content":`), rawJSONString([]byte(chunk))...), '}')
+				frames = append(frames, eventFrame("assistantResponseEvent", payload))
+			}
+		case event.ToolUse != nil:
+			frames = append(frames, toolUseFrames(*event.ToolUse, event.ChunkSize)...)
+		case event.Exception != nil:
+			payload, _ := json.Marshal(event.Exception)
+			return append(frames, exceptionFrame(event.Exception.Type, payload))
+		}
+	}
+	return frames
+}
+
+// toolUseFrames 输出工具调用的开始帧、参数片段帧和结束帧
+func toolUseFrames(tool ScenarioToolUse, chunkSize int) [][]byte {
+	prefix := fmt.Sprintf(`{"name":%s,"toolUseId":%s`, rawJSONString([]byte(tool.Name)), rawJSONString([]byte(tool.ToolUseID)))
+
+	frames := [][]byte{eventFrame("toolUseEvent", []byte(prefix+`,"input":"","stop":false}`))}
+	for _, chunk := range splitBytes([]byte(tool.Input), chunkSize) {
+		payload := append(append([]byte(prefix+`,"input":`), rawJSONString(chunk)...), []byte(`,"stop":false}`)...)
+		frames = append(frames, eventFrame("toolUseEvent", payload))
+	}
+	return append(frames, eventFrame("toolUseEvent", []byte(prefix+`,"stop":true}`)))
+}
+
+// splitRunes 按字符数拆分文本
+func splitRunes(text string, size int) []string {
+	runes := []rune(text)
+	if size <= 0 || len(runes) <= size {
+		return []string{text}
+	}
+	var chunks []string
+	for start := 0; start < len(runes); start += size {
+		chunks = append(chunks, string(runes[start:min(start+size, len(runes))]))
+	}
+	return chunks
+}
+
+// splitBytes 按字节数拆分，不考虑UTF-8字符边界
+func splitBytes(b []byte, size int) [][]byte {
+	if len(b) == 0 {
+		return nil
+	}
+	if size <= 0 || len(b) <= size {
+		return [][]byte{b}
+	}
+	var chunks [][]byte
+	for start := 0; start < len(b); start += size {
+		chunks = append(chunks, b[start:min(start+size, len(b))])
+	}
+	return chunks
+}
@@ -476,7 +476,7 @@ func (h *LegacyToolUseEventHandler) handleToolCallEvent(message *EventStreamMess
 	if err := utils.FastUnmarshal(message.Payload, &evt); err != nil {
 		logger.Warn("解析工具调用事件失败",
 			logger.Err(err),
-			logger.String("payload", string(message.Payload)))
+			logger.Payload("payload", string(message.Payload)))
 		return []SSEEvent{}, nil
 	}
 
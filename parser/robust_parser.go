@@ -8,6 +8,7 @@ import (
 	"io"
 	"kiro2api/config"
 	"kiro2api/logger"
+	"kiro2api/metrics"
 	"strings"
 	"sync"
 )
@@ -411,6 +412,7 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 			// 跳过无效数据（丢弃1字节）
 			rp.buffer.Next(1)
 			rp.errorCount++
+			metrics.IncParserError("invalid_prelude")
 			logger.Warn("跳过无效消息头",
 				logger.Int("total_length", int(totalLength)))
 			continue
@@ -426,6 +428,7 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 		messageData := make([]byte, totalLength)
 		n, err := rp.buffer.Read(messageData)
 		if err != nil || n != int(totalLength) {
+			metrics.IncParserError("read_failed")
 			logger.Error("读取消息失败",
 				logger.Int("expected", int(totalLength)),
 				logger.Int("actual", n),
@@ -436,6 +439,7 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 		// 解析消息
 		message, _, err := rp.parseSingleMessageWithValidation(messageData)
 		if err != nil {
+			metrics.IncParserError("invalid_message")
 			if rp.strictMode {
 				return messages, err
 			}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// requestStreamContextKey gin上下文中请求是否为流式的键
+	requestStreamContextKey = "request_stream"
+	// requestTokenContextKey gin上下文中处理请求的上游token的键
+	requestTokenContextKey = "request_token"
+	// conversationIDContextKey gin上下文中上游会话ID的键
+	conversationIDContextKey = "conversation_id"
+	// timeToFirstTokenContextKey gin上下文中首个内容增量耗时的键
+	timeToFirstTokenContextKey = "time_to_first_token"
+)
+
+// requestToken 处理请求的上游token
+type requestToken struct {
+	ID    string
+	Index int
+}
+
+// AccessLogMiddleware 每个请求结束后通过logger包输出一条结构化访问日志
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		fields := addReqFields(c,
+			logger.String("method", c.Request.Method),
+			logger.String("path", c.Request.URL.Path),
+			logger.String("endpoint", metricsEndpoint(c)),
+			logger.String("client_ip", c.ClientIP()),
+			logger.Int("status", c.Writer.Status()),
+			logger.Int("bytes", max(c.Writer.Size(), 0)),
+			logger.Int64("duration_ms", time.Since(start).Milliseconds()),
+		)
+		if model := c.GetString(requestModelContextKey); model != "" {
+			fields = append(fields, logger.String("model", model))
+		}
+		if v, ok := c.Get(requestStreamContextKey); ok {
+			fields = append(fields, logger.Bool("stream", v.(bool)))
+		}
+		if v, ok := c.Get(requestTokenContextKey); ok {
+			token := v.(requestToken)
+			fields = append(fields, logger.String("token_id", token.ID), logger.Int("token_index", token.Index))
+		}
+		if conversationID := c.GetString(conversationIDContextKey); conversationID != "" {
+			fields = append(fields, logger.String("conversation_id", conversationID))
+		}
+		if ttft, ok := c.Get(timeToFirstTokenContextKey); ok {
+			fields = append(fields, logger.Int64("ttft_ms", ttft.(time.Duration).Milliseconds()))
+		}
+		if reqUsage := getUsage(c); reqUsage != nil {
+			fields = append(fields,
+				logger.Int("input_tokens", reqUsage.InputTokens),
+				logger.Int("output_tokens", reqUsage.OutputTokens),
+				logger.String("stop_reason", reqUsage.StopReason))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, logger.String("errors", c.Errors.String()))
+		}
+		logger.Access(fields...)
+	}
+}
+
+// setRequestStream 记录请求是否为流式
+func setRequestStream(c *gin.Context, stream bool) {
+	c.Set(requestStreamContextKey, stream)
+}
+
+// setRequestToken 记录处理请求的上游token
+func setRequestToken(c *gin.Context, token types.TokenInfo) {
+	c.Set(requestTokenContextKey, requestToken{ID: token.ID, Index: token.Index})
+}
+
+// setConversationID 记录发往上游的会话ID
+func setConversationID(c *gin.Context, conversationID string) {
+	c.Set(conversationIDContextKey, conversationID)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/types"
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAccessLogRouter 创建只带访问日志和请求ID中间件的路由（不经过指标中间件，避免影响全局指标），处理函数模拟一次完整的流式请求
+func newAccessLogRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(AccessLogMiddleware(), RequestIDMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		c.Set(requestStartContextKey, time.Now())
+		c.Set(apiKeyContextKey, &webconfig.APIKeyIdentity{ID: "k1", Name: "access team"})
+		setRequestModel(c, "claude-sonnet-4")
+		setRequestStream(c, true)
+		setRequestToken(c, types.TokenInfo{ID: "tok-b", Index: 1})
+		setConversationID(c, "conv-123")
+		observeFirstToken(c)
+		recordUsage(c, 12, 34, "end_turn")
+		c.String(http.StatusOK, "hello")
+	})
+	return r
+}
+
+func TestAccessLogMiddleware_JSON(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	assert.NoError(t, logger.ConfigureAccessLog(file, logger.AccessFormatJSON, logger.RotationConfig{}))
+	t.Cleanup(func() { _ = logger.ConfigureAccessLog("", "", logger.RotationConfig{}) })
+
+	r := newAccessLogRouter()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("X-Request-ID", "rid-access")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/no/such/path", nil))
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+
+	var entry map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "access", entry["message"])
+	assert.Equal(t, "rid-access", entry["request_id"])
+	assert.Equal(t, "access team", entry["api_key"])
+	assert.Equal(t, "/v1/messages", entry["endpoint"])
+	assert.Equal(t, "claude-sonnet-4", entry["model"])
+	assert.Equal(t, true, entry["stream"])
+	assert.Equal(t, "tok-b", entry["token_id"])
+	assert.Equal(t, 1.0, entry["token_index"])
+	assert.Equal(t, "conv-123", entry["conversation_id"])
+	assert.Equal(t, 200.0, entry["status"])
+	assert.Equal(t, 5.0, entry["bytes"])
+	assert.Equal(t, 12.0, entry["input_tokens"])
+	assert.Equal(t, 34.0, entry["output_tokens"])
+	assert.Equal(t, "end_turn", entry["stop_reason"])
+	assert.Contains(t, entry, "ttft_ms")
+	assert.Contains(t, entry, "duration_ms")
+
+	// 未匹配的请求只有基本字段
+	entry = nil
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, 404.0, entry["status"])
+	assert.Equal(t, "unmatched", entry["endpoint"])
+	assert.NotContains(t, entry, "model")
+	assert.NotContains(t, entry, "token_id")
+	assert.NotContains(t, entry, "input_tokens")
+}
+
+func TestAccessLogMiddleware_Text(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	assert.NoError(t, logger.ConfigureAccessLog(file, logger.AccessFormatText, logger.RotationConfig{}))
+	t.Cleanup(func() { _ = logger.ConfigureAccessLog("", "", logger.RotationConfig{}) })
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("X-Request-ID", "rid-text")
+	newAccessLogRouter().ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	line := strings.TrimSpace(string(data))
+	assert.Contains(t, line, " request_id=rid-text ")
+	assert.Contains(t, line, ` api_key="access team" `)
+	assert.Contains(t, line, " method=POST path=/v1/messages ")
+	assert.Contains(t, line, " status=200 bytes=5 ")
+	assert.Contains(t, line, " stream=true token_id=tok-b token_index=1 conversation_id=conv-123 ")
+	assert.Contains(t, line, " input_tokens=12 output_tokens=34 stop_reason=end_turn")
+
+	assert.Error(t, logger.ConfigureAccessLog("", "xml", logger.RotationConfig{}))
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activeRequestContextKey gin上下文中在途请求登记的键
+const activeRequestContextKey = "active_request"
+
+// errRequestCancelled 请求被管理员取消
+var errRequestCancelled = errors.New("请求已被管理员取消")
+
+// activeRequest 一个在途请求的状态，供 /api/requests/active 查看和取消
+type activeRequest struct {
+	id        string
+	keyID     string
+	keyName   string
+	endpoint  string
+	model     string
+	stream    bool
+	startedAt time.Time
+
+	bytes      atomic.Int64 // 已写给客户端的字节数
+	blockIndex atomic.Int64 // 最近开始的内容块索引，-1 表示尚未输出内容
+
+	mutex      sync.Mutex
+	tokenID    string                           // 分配到的上游token，排队等待时为空
+	tokenIndex int                              // 分配到的上游token索引
+	tools      map[int]webconfig.ActiveToolCall // 进行中的工具调用，按内容块索引
+
+	// ctx 上游请求使用的上下文，取消后中断上游响应读取
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// activeRegistry 在途请求登记表
+type activeRegistry struct {
+	mutex    sync.Mutex
+	requests map[string]*activeRequest
+	seq      uint64
+}
+
+var activeRequests = &activeRegistry{requests: make(map[string]*activeRequest)}
+
+// register 登记请求，请求ID重复时（客户端自带 X-Request-ID）追加序号
+func (reg *activeRegistry) register(req *activeRequest) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	if _, exists := reg.requests[req.id]; exists || req.id == "" {
+		reg.seq++
+		req.id = fmt.Sprintf("%s#%d", req.id, reg.seq)
+	}
+	reg.requests[req.id] = req
+}
+
+// unregister 移除请求登记并释放其上下文
+func (reg *activeRegistry) unregister(req *activeRequest) {
+	reg.mutex.Lock()
+	if reg.requests[req.id] == req {
+		delete(reg.requests, req.id)
+	}
+	reg.mutex.Unlock()
+	req.cancel(nil)
+}
+
+// list 返回所有在途请求的快照，按开始时间排序
+func (reg *activeRegistry) list() []webconfig.ActiveRequest {
+	reg.mutex.Lock()
+	requests := make([]*activeRequest, 0, len(reg.requests))
+	for _, req := range reg.requests {
+		requests = append(requests, req)
+	}
+	reg.mutex.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].startedAt.Before(requests[j].startedAt) })
+	snapshots := make([]webconfig.ActiveRequest, len(requests))
+	for i, req := range requests {
+		snapshots[i] = req.snapshot()
+	}
+	return snapshots
+}
+
+// cancel 取消请求：排队中的请求停止等待token，已开始的请求中断上游响应读取，由请求处理流程向客户端发送错误
+func (reg *activeRegistry) cancel(id string) error {
+	reg.mutex.Lock()
+	req, exists := reg.requests[id]
+	reg.mutex.Unlock()
+	if !exists {
+		return webconfig.ErrActiveRequestNotFound
+	}
+	req.cancel(errRequestCancelled)
+	return nil
+}
+
+// snapshot 生成请求状态快照
+func (req *activeRequest) snapshot() webconfig.ActiveRequest {
+	snapshot := webconfig.ActiveRequest{
+		ID:            req.id,
+		KeyID:         req.keyID,
+		KeyName:       req.keyName,
+		Endpoint:      req.endpoint,
+		Model:         req.model,
+		Stream:        req.stream,
+		StartedAt:     req.startedAt,
+		DurationMs:    time.Since(req.startedAt).Milliseconds(),
+		BytesStreamed: req.bytes.Load(),
+		ToolCalls:     []webconfig.ActiveToolCall{},
+		Cancelled:     errors.Is(context.Cause(req.ctx), errRequestCancelled),
+	}
+	if index := int(req.blockIndex.Load()); index >= 0 {
+		snapshot.BlockIndex = &index
+	}
+
+	req.mutex.Lock()
+	snapshot.TokenID = req.tokenID
+	snapshot.TokenIndex = req.tokenIndex
+	for _, tool := range req.tools {
+		snapshot.ToolCalls = append(snapshot.ToolCalls, tool)
+	}
+	req.mutex.Unlock()
+	sort.Slice(snapshot.ToolCalls, func(i, j int) bool {
+		return snapshot.ToolCalls[i].BlockIndex < snapshot.ToolCalls[j].BlockIndex
+	})
+	return snapshot
+}
+
+// ActiveRequests 返回所有在途请求的快照
+func ActiveRequests() []webconfig.ActiveRequest {
+	return activeRequests.list()
+}
+
+// CancelRequest 取消指定的在途请求
+func CancelRequest(id string) error {
+	return activeRequests.cancel(id)
+}
+
+// registerActiveRequest 在获取token前登记请求，排队等待token的请求同样可以查看和取消，并统计写给客户端的字节数
+// 分配到token后调用 assignToken 记录
+func registerActiveRequest(c *gin.Context) *activeRequest {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	req := &activeRequest{
+		id:        GetRequestID(c),
+		endpoint:  metricsEndpoint(c),
+		model:     c.GetString(requestModelContextKey),
+		stream:    c.GetBool(requestStreamContextKey),
+		startedAt: time.Now(),
+		tools:     make(map[int]webconfig.ActiveToolCall),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	req.blockIndex.Store(-1)
+	if apiKey := GetAPIKey(c); apiKey != nil {
+		req.keyID = apiKey.ID
+		req.keyName = apiKey.Name
+	}
+
+	activeRequests.register(req)
+	c.Set(activeRequestContextKey, req)
+	c.Writer = &countingWriter{ResponseWriter: c.Writer, req: req}
+	return req
+}
+
+// assignToken 记录请求分配到的上游token
+func (req *activeRequest) assignToken(token requestToken) {
+	req.mutex.Lock()
+	defer req.mutex.Unlock()
+	req.tokenID = token.ID
+	req.tokenIndex = token.Index
+}
+
+// waitContext 返回排队等待token使用的上下文：客户端断开或请求被取消时都停止等待
+func (req *activeRequest) waitContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	stop := context.AfterFunc(req.ctx, func() { cancel(context.Cause(req.ctx)) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// activeRequestFrom 获取请求的在途登记，未登记时返回nil
+func activeRequestFrom(c *gin.Context) *activeRequest {
+	if v, ok := c.Get(activeRequestContextKey); ok {
+		return v.(*activeRequest)
+	}
+	return nil
+}
+
+// upstreamContext 返回上游请求使用的上下文，请求被取消时中断上游请求和响应读取
+// 未登记的请求沿用原有行为，不随客户端断开而中断
+func upstreamContext(c *gin.Context) context.Context {
+	if req := activeRequestFrom(c); req != nil {
+		return req.ctx
+	}
+	return context.Background()
+}
+
+// requestCancelled 请求是否已被管理员取消
+func requestCancelled(c *gin.Context) bool {
+	req := activeRequestFrom(c)
+	return req != nil && errors.Is(context.Cause(req.ctx), errRequestCancelled)
+}
+
+// noteCancelled 记录请求被取消的日志和事件
+func noteCancelled(c *gin.Context) {
+	publishRequestError(c, "cancelled", 0, errRequestCancelled.Error())
+	logger.Warn("请求已被管理员取消", addReqFields(c)...)
+}
+
+// respondCancelled 尚未开始输出时，以JSON错误响应告知客户端请求已被取消
+func respondCancelled(c *gin.Context) {
+	noteCancelled(c)
+	respondErrorWithCode(c, http.StatusInternalServerError, "request_cancelled", "%s", errRequestCancelled.Error())
+}
+
+// trackBlockStart 记录内容块开始，工具块记为进行中的工具调用
+func trackBlockStart(c *gin.Context, index int, toolUseID, toolName string) {
+	req := activeRequestFrom(c)
+	if req == nil {
+		return
+	}
+	req.blockIndex.Store(int64(index))
+	if toolUseID == "" && toolName == "" {
+		return
+	}
+	req.mutex.Lock()
+	req.tools[index] = webconfig.ActiveToolCall{BlockIndex: index, ID: toolUseID, Name: toolName}
+	req.mutex.Unlock()
+}
+
+// trackBlockStop 记录内容块结束，对应的工具调用不再是进行中
+func trackBlockStop(c *gin.Context, index int) {
+	req := activeRequestFrom(c)
+	if req == nil {
+		return
+	}
+	req.mutex.Lock()
+	delete(req.tools, index)
+	req.mutex.Unlock()
+}
+
+// countingWriter 统计写给客户端的字节数
+type countingWriter struct {
+	gin.ResponseWriter
+	req *activeRequest
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.req.bytes.Add(int64(n))
+	return n, err
+}
+
+func (w *countingWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.req.bytes.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/types"
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// findActiveRequest 在在途请求列表中查找指定请求
+func findActiveRequest(id string) (webconfig.ActiveRequest, bool) {
+	for _, req := range ActiveRequests() {
+		if req.ID == id {
+			return req, true
+		}
+	}
+	return webconfig.ActiveRequest{}, false
+}
+
+func TestActiveRequests_TracksBlocksAndToolCalls(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Set("request_id", "rid-active-tools")
+	setRequestModel(c, "claude-sonnet-4")
+	setRequestStream(c, true)
+	req := registerActiveRequest(c)
+
+	// 分配token前已登记，token为空
+	snapshot, ok := findActiveRequest("rid-active-tools")
+	assert.True(t, ok)
+	assert.Empty(t, snapshot.TokenID)
+
+	req.assignToken(requestToken{ID: "tok-a", Index: 1})
+	snapshot, ok = findActiveRequest("rid-active-tools")
+	assert.True(t, ok)
+	assert.Equal(t, "claude-sonnet-4", snapshot.Model)
+	assert.Equal(t, "tok-a", snapshot.TokenID)
+	assert.Equal(t, 1, snapshot.TokenIndex)
+	assert.Nil(t, snapshot.BlockIndex)
+	assert.Empty(t, snapshot.ToolCalls)
+
+	trackBlockStart(c, 0, "", "")
+	trackBlockStart(c, 1, "toolu_1", "get_weather")
+	c.Writer.WriteString("data: {}\n\n")
+	snapshot, _ = findActiveRequest("rid-active-tools")
+	assert.Equal(t, 1, *snapshot.BlockIndex)
+	assert.Equal(t, []webconfig.ActiveToolCall{{BlockIndex: 1, ID: "toolu_1", Name: "get_weather"}}, snapshot.ToolCalls)
+	assert.Equal(t, int64(len("data: {}\n\n")), snapshot.BytesStreamed)
+
+	trackBlockStop(c, 1)
+	snapshot, _ = findActiveRequest("rid-active-tools")
+	assert.Empty(t, snapshot.ToolCalls)
+
+	// 重复的请求ID追加序号，互不覆盖
+	c2, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c2.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c2.Set("request_id", "rid-active-tools")
+	duplicate := registerActiveRequest(c2)
+	assert.NotEqual(t, "rid-active-tools", duplicate.id)
+	activeRequests.unregister(duplicate)
+
+	activeRequests.unregister(req)
+	_, ok = findActiveRequest("rid-active-tools")
+	assert.False(t, ok)
+	assert.ErrorIs(t, CancelRequest("rid-active-tools"), webconfig.ErrActiveRequestNotFound)
+}
+
+func TestCancelRequest_AbortsStreamAndSendsError(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Set("request_id", "rid-active-cancel")
+	req := registerActiveRequest(c)
+	req.assignToken(requestToken{ID: "tok-a"})
+	defer activeRequests.unregister(req)
+
+	// 模拟上游响应体：一直阻塞，直到上游请求的上下文被取消
+	body, upstreamWriter := io.Pipe()
+	go func() {
+		ctx := upstreamContext(c)
+		<-ctx.Done()
+		upstreamWriter.CloseWithError(ctx.Err())
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		anthropicReq := types.AnthropicRequest{Model: "claude-sonnet-4", Stream: true}
+		forwardEventStream(c, anthropicReq, &types.TokenWithUsage{}, &AnthropicStreamSender{}, createAnthropicStreamEvents,
+			"msg_cancel", 0, body)
+	}()
+
+	// 等待初始事件写出后再取消
+	assert.Eventually(t, func() bool {
+		snapshot, ok := findActiveRequest("rid-active-cancel")
+		return ok && snapshot.BytesStreamed > 0
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, CancelRequest("rid-active-cancel"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("取消后事件流未结束")
+	}
+
+	snapshot, _ := findActiveRequest("rid-active-cancel")
+	assert.True(t, snapshot.Cancelled)
+	assert.True(t, requestCancelled(c))
+	assert.ErrorIs(t, context.Cause(upstreamContext(c)), errRequestCancelled)
+
+	sse := w.Body.String()
+	assert.Contains(t, sse, "event: error")
+	assert.Contains(t, sse, errRequestCancelled.Error())
+	assert.NotContains(t, sse, "message_stop")
+}
+
+// queuedAuthService 模拟所有token并发已满：一直排队，直到上下文结束
+type queuedAuthService struct {
+	waiting chan struct{}
+}
+
+func (s *queuedAuthService) AcquireToken(ctx context.Context, affinityKey string) (types.TokenInfo, func(), error) {
+	close(s.waiting)
+	<-ctx.Done()
+	return types.TokenInfo{}, nil, auth.ErrTokenQueueTimeout
+}
+
+func TestCancelRequest_WakesQueuedRequest(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(`{"model":"claude-sonnet-4"}`))
+	c.Set("request_id", "rid-active-queued")
+	service := &queuedAuthService{waiting: make(chan struct{})}
+	reqCtx := &RequestContext{GinContext: c, AuthService: service, RequestType: "test"}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := reqCtx.GetTokenAndBody()
+		done <- err
+	}()
+
+	// 排队等待token的请求可以查看，token为空
+	<-service.waiting
+	snapshot, ok := findActiveRequest("rid-active-queued")
+	assert.True(t, ok)
+	assert.Empty(t, snapshot.TokenID)
+	assert.Equal(t, "claude-sonnet-4", snapshot.Model)
+
+	assert.NoError(t, CancelRequest("rid-active-queued"))
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, errRequestCancelled)
+	case <-time.After(time.Second):
+		t.Fatal("取消后排队请求未停止等待")
+	}
+
+	_, ok = findActiveRequest("rid-active-queued")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "request_cancelled")
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/usage"
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 预算相关的响应头
+const (
+	budgetRemainingHeader = "x-kiro2api-budget-remaining" // 各周期剩余额度，如 daily=1.50, monthly=40.00
+	budgetWarningHeader   = "x-kiro2api-budget-warning"   // 达到预警比例的周期，如 daily 85% used (8.50/10.00 credits)
+)
+
+// budgetPeriodNames 预算周期的中文名称，用于错误信息
+var budgetPeriodNames = map[string]string{
+	webconfig.BudgetPeriodDaily:   "每日",
+	webconfig.BudgetPeriodMonthly: "每月",
+}
+
+// checkBudget 检查API密钥的额度预算，已用尽时返回402并拒绝请求，接近预算时添加预警响应头
+func checkBudget(c *gin.Context, apiKey *webconfig.APIKeyIdentity) bool {
+	tracker := usage.DefaultBudgets()
+	if tracker == nil || (apiKey.Budget.DailyCredits == 0 && apiKey.Budget.MonthlyCredits == 0) {
+		return true
+	}
+
+	statuses := tracker.Status(apiKey.ID, apiKey.Budget)
+	setBudgetHeaders(c, statuses)
+
+	for _, status := range statuses {
+		if !status.Exhausted {
+			continue
+		}
+		logger.Warn("API密钥额度预算已用尽",
+			addReqFields(c,
+				logger.String("period", status.Period),
+				logger.Float64("spent", status.Spent),
+				logger.Float64("limit", status.Limit),
+			)...)
+		respondErrorWithCode(c, http.StatusPaymentRequired, "budget_exceeded",
+			"API密钥 %s 的%s额度预算已用尽（已用 %.2f / %.2f credits），将于 %s 重置",
+			apiKey.Name, budgetPeriodNames[status.Period], status.Spent, status.Limit,
+			status.ResetAt.Format(time.RFC3339))
+		return false
+	}
+	return true
+}
+
+// setBudgetHeaders 设置预算剩余额度与预警响应头（只包含设置了预算的周期）
+func setBudgetHeaders(c *gin.Context, statuses []webconfig.BudgetPeriodStatus) {
+	var remaining, warnings []string
+	for _, status := range statuses {
+		if status.Limit <= 0 {
+			continue
+		}
+		remaining = append(remaining, fmt.Sprintf("%s=%.2f", status.Period, status.Remaining))
+		if status.Warning && !status.Exhausted {
+			warnings = append(warnings, fmt.Sprintf("%s %.0f%% used (%.2f/%.2f credits)",
+				status.Period, status.Spent/status.Limit*100, status.Spent, status.Limit))
+		}
+	}
+
+	if len(remaining) > 0 {
+		c.Header(budgetRemainingHeader, strings.Join(remaining, ", "))
+	}
+	if len(warnings) > 0 {
+		c.Header(budgetWarningHeader, strings.Join(warnings, ", "))
+	}
+}
+
+// chargeBudget 按请求用量估算消耗的额度并记入API密钥的预算，实际额度在观测到Token额度变化后修正
+func chargeBudget(apiKey *webconfig.APIKeyIdentity, tokenID string, reqUsage *requestUsage) {
+	tracker := usage.DefaultBudgets()
+	if tracker == nil || apiKey == nil || reqUsage == nil {
+		return
+	}
+	tracker.Charge(apiKey.ID, tokenID, usage.EstimateCredits(reqUsage.InputTokens, reqUsage.OutputTokens))
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"kiro2api/usage"
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBudget(t *testing.T) {
+	tracker, err := usage.OpenBudgetTracker(filepath.Join(t.TempDir(), usage.BudgetFileName))
+	assert.NoError(t, err)
+	usage.SetDefaultBudgets(tracker)
+	defer usage.SetDefaultBudgets(nil)
+
+	apiKey := &webconfig.APIKeyIdentity{
+		ID:     "k1",
+		Name:   "team-a",
+		Budget: webconfig.BudgetConfig{DailyCredits: 1},
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	assert.True(t, checkBudget(c, apiKey))
+	assert.Equal(t, "daily=1.00", w.Header().Get(budgetRemainingHeader))
+	assert.Empty(t, w.Header().Get(budgetWarningHeader))
+
+	// 达到预警比例时返回预警头
+	chargeBudget(apiKey, "token_1", &requestUsage{InputTokens: 6000, OutputTokens: 3000})
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	assert.True(t, checkBudget(c, apiKey))
+	assert.Equal(t, "daily=0.10", w.Header().Get(budgetRemainingHeader))
+	assert.Equal(t, "daily 90% used (0.90/1.00 credits)", w.Header().Get(budgetWarningHeader))
+
+	// 预算用尽后拒绝请求
+	chargeBudget(apiKey, "token_1", &requestUsage{InputTokens: 1000})
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	assert.False(t, checkBudget(c, apiKey))
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Contains(t, w.Body.String(), "budget_exceeded")
+	assert.Contains(t, w.Body.String(), "每日额度预算已用尽")
+
+	// 未设置预算的密钥不受限制
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	assert.True(t, checkBudget(c, &webconfig.APIKeyIdentity{ID: "k1", Name: "team-a"}))
+	assert.Empty(t, w.Header().Get(budgetRemainingHeader))
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上游流量捕获的环境变量
+const (
+	EnvCaptureDir        = "CAPTURE_DIR"         // 捕获文件目录，未设置时关闭捕获
+	EnvCaptureSampleRate = "CAPTURE_SAMPLE_RATE" // 随机捕获的比例（0-1），默认0只捕获带标记的请求
+)
+
+// CaptureHeader 客户端请求头，值为 true 或 1 时捕获该请求（需设置 CAPTURE_DIR）
+const CaptureHeader = "X-Kiro2api-Capture"
+
+// 捕获原因
+const (
+	CaptureReasonFlagged = "flagged"
+	CaptureReasonSampled = "sampled"
+)
+
+// captureFileVersion 捕获文件格式版本
+const captureFileVersion = 1
+
+// redactedValue 脱敏后的字段值
+const redactedValue = "<redacted>"
+
+// captureRedactedKeys 捕获文件中需要脱敏的JSON字段（不区分大小写）
+var captureRedactedKeys = map[string]bool{
+	"profilearn":    true,
+	"user_id":       true,
+	"userid":        true,
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"authorization": true,
+	"api_key":       true,
+	"apikey":        true,
+}
+
+// Capture 一次上游交互的捕获记录
+// 包含客户端请求（转换后的Anthropic格式）、发往CodeWhisperer的请求和上游原始响应字节，敏感字段已脱敏
+type Capture struct {
+	Version              int             `json:"version"`
+	Time                 time.Time       `json:"time"`
+	RequestID            string          `json:"requestId"`
+	Endpoint             string          `json:"endpoint"`
+	Reason               string          `json:"reason"`
+	Stream               bool            `json:"stream"`
+	ClientRequest        json.RawMessage `json:"clientRequest"`
+	CodeWhispererRequest json.RawMessage `json:"codewhispererRequest"`
+	Status               int             `json:"status"`
+	ResponseBytes        []byte          `json:"responseBytes"` // base64编码的上游原始响应（AWS EventStream 二进制帧）
+	Truncated            bool            `json:"truncated,omitempty"`
+}
+
+// LoadCapture 读取捕获文件
+func LoadCapture(path string) (*Capture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取捕获文件失败: %w", err)
+	}
+	var capture Capture
+	if err := json.Unmarshal(data, &capture); err != nil {
+		return nil, fmt.Errorf("解析捕获文件失败: %w", err)
+	}
+	if capture.Version != captureFileVersion {
+		return nil, fmt.Errorf("不支持的捕获文件版本: %d", capture.Version)
+	}
+	return &capture, nil
+}
+
+// capturer 按配置决定是否捕获请求并写入捕获文件
+type capturer struct {
+	dir        string
+	sampleRate float64
+}
+
+var defaultCapturer = sync.OnceValue(func() *capturer {
+	dir := strings.TrimSpace(os.Getenv(EnvCaptureDir))
+	if dir == "" {
+		return nil
+	}
+	rate, _ := strconv.ParseFloat(strings.TrimSpace(os.Getenv(EnvCaptureSampleRate)), 64)
+	logger.Info("上游流量捕获已开启", logger.String("dir", dir), logger.Float64("sample_rate", rate))
+	return &capturer{dir: dir, sampleRate: rate}
+})
+
+// reason 返回请求的捕获原因，不捕获时返回空串
+func (cp *capturer) reason(c *gin.Context) string {
+	if cp == nil {
+		return ""
+	}
+	if flag := strings.ToLower(c.GetHeader(CaptureHeader)); flag == "true" || flag == "1" {
+		return CaptureReasonFlagged
+	}
+	if cp.sampleRate > 0 && rand.Float64() < cp.sampleRate {
+		return CaptureReasonSampled
+	}
+	return ""
+}
+
+// wrap 按需捕获上游响应：返回的响应体在读取时记录原始字节，关闭时写入捕获文件
+func (cp *capturer) wrap(c *gin.Context, anthropicReq types.AnthropicRequest, req *http.Request, resp *http.Response, isStream bool) {
+	reason := cp.reason(c)
+	if reason == "" {
+		return
+	}
+
+	capture := &Capture{
+		Version:   captureFileVersion,
+		Time:      time.Now(),
+		RequestID: GetRequestID(c),
+		Endpoint:  c.Request.URL.Path,
+		Reason:    reason,
+		Stream:    isStream,
+		Status:    resp.StatusCode,
+	}
+	if clientReq, err := json.Marshal(anthropicReq); err == nil {
+		capture.ClientRequest = redactJSON(clientReq)
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			cwReq, _ := io.ReadAll(body)
+			capture.CodeWhispererRequest = redactJSON(cwReq)
+		}
+	}
+
+	resp.Body = &captureBody{ReadCloser: resp.Body, capture: capture, dir: cp.dir}
+}
+
+// captureBody 记录读取到的上游响应字节，超过上限的部分不记录
+type captureBody struct {
+	io.ReadCloser
+	capture *Capture
+	dir     string
+	buf     bytes.Buffer
+	once    sync.Once
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if room := config.CaptureMaxResponseBytes - b.buf.Len(); room < n {
+			b.buf.Write(p[:max(room, 0)])
+			b.capture.Truncated = true
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.capture.ResponseBytes = b.buf.Bytes()
+		path, writeErr := writeCapture(b.dir, b.capture)
+		if writeErr != nil {
+			logger.Warn("写入捕获文件失败", logger.String("request_id", b.capture.RequestID), logger.Err(writeErr))
+			return
+		}
+		logger.Info("已捕获上游响应",
+			logger.String("request_id", b.capture.RequestID),
+			logger.String("path", path),
+			logger.Int("response_bytes", len(b.capture.ResponseBytes)))
+	})
+	return err
+}
+
+// captureFileNameUnsafe 文件名中不允许出现的字符
+var captureFileNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// writeCapture 将捕获记录写入目录，返回文件路径
+func writeCapture(dir string, capture *Capture) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(capture, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := capture.Time.UTC().Format("20060102T150405.000Z")
+	if capture.RequestID != "" {
+		name += "_" + captureFileNameUnsafe.ReplaceAllString(capture.RequestID, "_")
+	}
+	path := filepath.Join(dir, name+".json")
+	return path, os.WriteFile(path, data, 0o600)
+}
+
+// redactJSON 将JSON中的敏感字段替换为占位符，无法解析时原样返回
+func redactJSON(data []byte) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // 保留数字原样，避免大整数精度丢失
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return data
+	}
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return data
+	}
+	return redacted
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if captureRedactedKeys[strings.ToLower(key)] {
+				if s, ok := item.(string); !ok || s != "" {
+					v[key] = redactedValue
+				}
+				continue
+			}
+			v[key] = redactValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
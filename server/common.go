@@ -2,16 +2,24 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
-	"kiro2api/config"
+	"kiro2api/auth"
 	"kiro2api/converter"
+	"kiro2api/events"
 	"kiro2api/logger"
+	"kiro2api/metrics"
+	"kiro2api/tracing"
 	"kiro2api/types"
+	"kiro2api/usage"
 	"kiro2api/utils"
+	"kiro2api/webconfig"
 
 	"github.com/gin-gonic/gin"
 )
@@ -59,6 +67,10 @@ func handleRequestSendError(c *gin.Context, err error) {
 }
 
 func handleResponseReadError(c *gin.Context, err error) {
+	if requestCancelled(c) {
+		respondCancelled(c)
+		return
+	}
 	logger.Error("读取响应体失败", addReqFields(c, logger.Err(err))...)
 	respondError(c, http.StatusInternalServerError, "读取响应体失败: %v", err)
 }
@@ -84,11 +96,35 @@ func executeCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicReq
 	// 		logger.String("model", anthropicReq.Model),
 	// 	)...)
 
+	span, endSpan := startSpan(c, "DoSmartRequest",
+		tracing.WithKind(tracing.SpanKindClient),
+		tracing.WithAttributes(
+			tracing.Attribute{Key: "http.request.method", Value: req.Method},
+			tracing.Attribute{Key: "server.address", Value: req.URL.Host},
+		))
 	resp, err := utils.DoSmartRequest(req, &anthropicReq)
 	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttribute("http.response.status_code", resp.StatusCode)
+		if resp.StatusCode != http.StatusOK {
+			span.RecordError(fmt.Errorf("上游返回状态码 %d", resp.StatusCode))
+		}
+	}
+	endSpan()
+	if err != nil && requestCancelled(c) {
+		respondCancelled(c)
+		return nil, err
+	}
+	if err != nil {
+		metrics.IncUpstreamError(upstreamErrorSendFailed, 0)
+		publishRequestError(c, upstreamErrorSendFailed, 0, logger.RedactSecrets(err.Error()))
 		handleRequestSendError(c, err)
 		return nil, err
 	}
+	observeTimeToFirstByte(c)
+	// 按需捕获上游原始响应，供解析问题复现（见 kiro2api replay）
+	defaultCapturer().wrap(c, anthropicReq, req, resp, isStream)
 
 	if handleCodeWhispererError(c, resp) {
 		resp.Body.Close()
@@ -105,11 +141,30 @@ func executeCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicReq
 	return resp, nil
 }
 
+// streamingSDKVersion generateAssistantResponse请求模拟的aws-sdk-js版本
+const streamingSDKVersion = "1.0.18"
+
 // execCWRequest 供测试覆盖的请求执行入口（可在测试中替换）
 var execCWRequest = executeCodeWhispererRequest
 
 // buildCodeWhispererRequest 构建通用的CodeWhisperer请求
 func buildCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Request, error) {
+	span, endSpan := startSpan(c, "BuildCodeWhispererRequest")
+	defer endSpan()
+
+	req, err := buildCodeWhispererHTTPRequest(c, anthropicReq, tokenInfo, isStream)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttributes(
+			tracing.Attribute{Key: "request.stream", Value: isStream},
+			tracing.Attribute{Key: "request.body_size", Value: req.ContentLength},
+		)
+	}
+	return req, err
+}
+
+func buildCodeWhispererHTTPRequest(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Request, error) {
 	cwReq, err := converter.BuildCodeWhispererRequest(anthropicReq, c)
 	if err != nil {
 		// 检查是否是模型未找到错误
@@ -121,6 +176,12 @@ func buildCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicReque
 		return nil, fmt.Errorf("构建CodeWhisperer请求失败: %v", err)
 	}
 
+	setConversationID(c, cwReq.ConversationState.ConversationId)
+
+	// 按token解析上游配置（区域、端点、profileArn、客户端指纹）
+	upstream := tokenInfo.ResolvedUpstream()
+	cwReq.ProfileArn = upstream.ProfileArn
+
 	cwReqBody, err := utils.SafeMarshal(cwReq)
 	if err != nil {
 		return nil, fmt.Errorf("序列化请求失败: %v", err)
@@ -142,11 +203,12 @@ func buildCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicReque
 	logger.Debug("发送给CodeWhisperer的请求",
 		logger.String("direction", "upstream_request"),
 		logger.Int("request_size", len(cwReqBody)),
-		logger.String("request_body", string(cwReqBody)),
+		logger.Payload("request_body", string(cwReqBody)),
 		logger.Int("tools_count", len(cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools)),
 		logger.String("tools_names", toolNamesPreview))
 
-	req, err := http.NewRequest("POST", config.CodeWhispererURL, bytes.NewReader(cwReqBody))
+	// 管理员取消请求时通过上下文中断上游请求和响应读取
+	req, err := http.NewRequestWithContext(upstreamContext(c), "POST", upstream.Endpoints.CodeWhisperer, bytes.NewReader(cwReqBody))
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %v", err)
 	}
@@ -159,8 +221,8 @@ func buildCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicReque
 
 	// 添加上游请求必需的header
 	req.Header.Set("x-amzn-kiro-agent-mode", "spec")
-	req.Header.Set("x-amz-user-agent", "aws-sdk-js/1.0.18 KiroIDE-0.2.13-66c23a8c5d15afabec89ef9954ef52a119f10d369df04d548fc6c1eac694b0d1")
-	req.Header.Set("user-agent", "aws-sdk-js/1.0.18 ua/2.1 os/darwin#25.0.0 lang/js md/nodejs#20.16.0 api/codewhispererstreaming#1.0.18 m/E KiroIDE-0.2.13-66c23a8c5d15afabec89ef9954ef52a119f10d369df04d548fc6c1eac694b0d1")
+	req.Header.Set("x-amz-user-agent", upstream.Fingerprint.AmzUserAgent(streamingSDKVersion))
+	req.Header.Set("user-agent", upstream.Fingerprint.UserAgent(streamingSDKVersion, "codewhispererstreaming"))
 
 	return req, nil
 }
@@ -173,6 +235,8 @@ func handleCodeWhispererError(c *gin.Context, resp *http.Response) bool {
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		metrics.IncUpstreamError(upstreamErrorReadFailed, resp.StatusCode)
+		publishRequestError(c, upstreamErrorReadFailed, resp.StatusCode, logger.RedactSecrets(err.Error()))
 		logger.Error("读取错误响应失败",
 			addReqFields(c,
 				logger.String("direction", "upstream_response"),
@@ -192,6 +256,8 @@ func handleCodeWhispererError(c *gin.Context, resp *http.Response) bool {
 
 	// 特殊处理：403错误表示token失效 (保持向后兼容)
 	if resp.StatusCode == http.StatusForbidden {
+		metrics.IncUpstreamError(upstreamErrorForbidden, resp.StatusCode)
+		publishRequestError(c, upstreamErrorForbidden, resp.StatusCode, "")
 		logger.Warn("收到403错误，token可能已失效")
 		respondErrorWithCode(c, http.StatusUnauthorized, "unauthorized", "%s", "Token已失效，请重试")
 		return true
@@ -200,6 +266,7 @@ func handleCodeWhispererError(c *gin.Context, resp *http.Response) bool {
 	// *** 新增：使用错误映射器处理错误，符合Claude API规范 ***
 	errorMapper := NewErrorMapper()
 	claudeError := errorMapper.MapCodeWhispererError(resp.StatusCode, body)
+	publishRequestError(c, "upstream_status", resp.StatusCode, "")
 
 	// 根据映射结果发送符合Claude规范的响应
 	if claudeError.StopReason == "max_tokens" {
@@ -238,6 +305,7 @@ func (s *AnthropicStreamSender) SendEvent(c *gin.Context, data any) error {
 	}
 
 	json, err := utils.SafeMarshal(data)
+	recordSSESend(c, eventType, len(json), err)
 	if err != nil {
 		return err
 	}
@@ -248,7 +316,7 @@ func (s *AnthropicStreamSender) SendEvent(c *gin.Context, data any) error {
 			logger.String("direction", "downstream_send"),
 			logger.String("event", eventType),
 			logger.Int("payload_len", len(json)),
-			logger.String("payload_preview", string(json)),
+			logger.Payload("payload_preview", string(json)),
 		)...)
 
 	fmt.Fprintf(c.Writer, "event: %s\n", eventType)
@@ -257,11 +325,15 @@ func (s *AnthropicStreamSender) SendEvent(c *gin.Context, data any) error {
 	return nil
 }
 
-func (s *AnthropicStreamSender) SendError(c *gin.Context, message string, _ error) error {
+func (s *AnthropicStreamSender) SendError(c *gin.Context, message string, err error) error {
+	errorType := "overloaded_error"
+	if errors.Is(err, errRequestCancelled) {
+		errorType = "api_error"
+	}
 	errorResp := map[string]any{
 		"type": "error",
 		"error": map[string]any{
-			"type":    "overloaded_error",
+			"type":    errorType,
 			"message": message,
 		},
 	}
@@ -272,8 +344,8 @@ func (s *AnthropicStreamSender) SendError(c *gin.Context, message string, _ erro
 type OpenAIStreamSender struct{}
 
 func (s *OpenAIStreamSender) SendEvent(c *gin.Context, data any) error {
-
 	json, err := utils.SafeMarshal(data)
+	recordSSESend(c, "", len(json), err)
 	if err != nil {
 		return err
 	}
@@ -290,12 +362,16 @@ func (s *OpenAIStreamSender) SendEvent(c *gin.Context, data any) error {
 	return nil
 }
 
-func (s *OpenAIStreamSender) SendError(c *gin.Context, message string, _ error) error {
+func (s *OpenAIStreamSender) SendError(c *gin.Context, message string, err error) error {
+	code := "internal_error"
+	if errors.Is(err, errRequestCancelled) {
+		code = "request_cancelled"
+	}
 	errorResp := map[string]any{
 		"error": map[string]any{
 			"message": message,
 			"type":    "server_error",
-			"code":    "internal_error",
+			"code":    code,
 		},
 	}
 
@@ -313,23 +389,42 @@ func (s *OpenAIStreamSender) SendError(c *gin.Context, message string, _ error)
 type RequestContext struct {
 	GinContext  *gin.Context
 	AuthService interface {
-		GetToken() (types.TokenInfo, error)
+		AcquireToken(ctx context.Context, affinityKey string) (types.TokenInfo, func(), error)
 	}
-	RequestType string // "anthropic" 或 "openai"
+	RequestType string // "Anthropic" 或 "OpenAI"
+
+	release   func()                    // 释放token并发槽位
+	apiKey    *webconfig.APIKeyIdentity // 发起请求的客户端密钥，用于请求结束后统计输出token
+	startedAt time.Time                 // 请求开始时间，用于用量账本统计延迟
+	model     string                    // 请求的模型
+	tokenID   string                    // 处理请求的上游token标识
+	active    *activeRequest            // 在途请求登记，供查看和取消
 }
 
 // GetTokenAndBody 通用的token获取和请求体读取
+// 成功时占用token并发槽位，调用方需在请求结束后调用 ReleaseToken
 // 返回: tokenInfo, requestBody, error
 func (rc *RequestContext) GetTokenAndBody() (types.TokenInfo, []byte, error) {
-	// 获取token
-	tokenInfo, err := rc.AuthService.GetToken()
+	span, endSpan := startSpan(rc.GinContext, "GetTokenAndBody")
+	defer endSpan()
+
+	tokenInfo, body, err := rc.getTokenAndBody()
 	if err != nil {
-		logger.Error("获取token失败", logger.Err(err))
-		respondError(rc.GinContext, http.StatusInternalServerError, "获取token失败: %v", err)
-		return types.TokenInfo{}, nil, err
+		span.RecordError(err)
+	} else {
+		span.SetAttributes(
+			tracing.Attribute{Key: "gen_ai.request.model", Value: rc.model},
+			tracing.Attribute{Key: "token.id", Value: tokenInfo.ID},
+			tracing.Attribute{Key: "request.body_size", Value: len(body)},
+		)
 	}
+	return tokenInfo, body, err
+}
+
+func (rc *RequestContext) getTokenAndBody() (types.TokenInfo, []byte, error) {
+	rc.startedAt = time.Now()
 
-	// 读取请求体
+	// 读取请求体（先于获取token，以便按会话选择token）
 	body, err := rc.GinContext.GetRawData()
 	if err != nil {
 		logger.Error("读取请求体失败", logger.Err(err))
@@ -337,11 +432,78 @@ func (rc *RequestContext) GetTokenAndBody() (types.TokenInfo, []byte, error) {
 		return types.TokenInfo{}, nil, err
 	}
 
+	// 检查API密钥是否允许使用请求的模型
+	var stream bool
+	rc.model, stream = probeRequest(body)
+	setRequestModel(rc.GinContext, rc.model)
+	setRequestStream(rc.GinContext, stream)
+	ctx := rc.GinContext.Request.Context()
+	if apiKey := GetAPIKey(rc.GinContext); apiKey != nil {
+		if model := rc.model; !apiKey.AllowsModel(model) {
+			logger.Warn("API密钥无权使用该模型",
+				addReqFields(rc.GinContext, logger.String("model", model))...)
+			respondError(rc.GinContext, http.StatusForbidden, "API密钥无权使用模型: %s", model)
+			return types.TokenInfo{}, nil, fmt.Errorf("API密钥无权使用模型: %s", model)
+		}
+		// 额度预算已用尽的密钥直接拒绝（先于限流，被拒绝的请求不消耗限流额度）
+		if !checkBudget(rc.GinContext, apiKey) {
+			return types.TokenInfo{}, nil, fmt.Errorf("API密钥 %s 额度预算已用尽", apiKey.Name)
+		}
+		// 按客户端密钥限流（先于获取token，超限请求不占用token池）
+		if !checkRateLimit(rc.GinContext, apiKey, body, strings.EqualFold(rc.RequestType, "openai")) {
+			return types.TokenInfo{}, nil, fmt.Errorf("API密钥 %s 超出限流", apiKey.Name)
+		}
+		rc.apiKey = apiKey
+
+		// 绑定了token分组的密钥只使用该分组的token
+		ctx = auth.WithTokenGroup(ctx, apiKey.TokenGroup)
+	}
+
+	// 获取token前登记在途请求，排队等待token时也可以查看和取消
+	rc.active = registerActiveRequest(rc.GinContext)
+	waitCtx, stopWait := rc.active.waitContext(ctx)
+
+	// 获取token（同一会话优先使用同一token；所有token并发已满时排队等待）
+	affinityKey := conversationAffinityKey(rc.GinContext, body)
+	_, acquireSpan := tracing.Start(waitCtx, "AcquireToken")
+	tokenInfo, release, err := rc.AuthService.AcquireToken(waitCtx, affinityKey)
+	stopWait()
+	acquireSpan.RecordError(err)
+	acquireSpan.End()
+	if err == nil && requestCancelled(rc.GinContext) {
+		// 分配与取消同时发生时，以取消为准
+		release()
+		err = errRequestCancelled
+	}
+	if err != nil {
+		cancelled := requestCancelled(rc.GinContext)
+		activeRequests.unregister(rc.active)
+		rc.active = nil
+		if cancelled {
+			respondCancelled(rc.GinContext)
+			return types.TokenInfo{}, nil, errRequestCancelled
+		}
+		logger.Error("获取token失败", logger.Err(err))
+		publishRequestError(rc.GinContext, "acquire_token", 0, err.Error())
+		if errors.Is(err, auth.ErrTokenQueueFull) || errors.Is(err, auth.ErrTokenQueueTimeout) {
+			rc.GinContext.Header("Retry-After", "1")
+			respondError(rc.GinContext, http.StatusTooManyRequests, "获取token失败: %v", err)
+		} else {
+			respondError(rc.GinContext, http.StatusInternalServerError, "获取token失败: %v", err)
+		}
+		return types.TokenInfo{}, nil, err
+	}
+	rc.release = release
+	rc.tokenID = tokenInfo.ID
+	setRequestToken(rc.GinContext, tokenInfo)
+	rc.active.assignToken(requestToken{ID: tokenInfo.ID, Index: tokenInfo.Index})
+	publishRequestEvent(rc.GinContext, events.TypeRequestStarted, nil)
+
 	// 记录请求日志
 	logger.Debug(fmt.Sprintf("收到%s请求", rc.RequestType),
 		addReqFields(rc.GinContext,
 			logger.String("direction", "client_request"),
-			logger.String("body", string(body)),
+			logger.Payload("body", string(body)),
 			logger.Int("body_size", len(body)),
 			logger.String("remote_addr", rc.GinContext.ClientIP()),
 			logger.String("user_agent", rc.GinContext.GetHeader("User-Agent")),
@@ -349,3 +511,97 @@ func (rc *RequestContext) GetTokenAndBody() (types.TokenInfo, []byte, error) {
 
 	return tokenInfo, body, nil
 }
+
+// ReleaseToken 释放GetTokenAndBody占用的token并发槽位、移除在途请求登记、扣除输出token限流额度、记入预算消耗并写入用量账本，可重复调用
+func (rc *RequestContext) ReleaseToken() {
+	if rc.release == nil {
+		return
+	}
+	rc.release()
+	rc.release = nil
+	if rc.active != nil {
+		activeRequests.unregister(rc.active)
+		rc.active = nil
+	}
+
+	reqUsage := getUsage(rc.GinContext)
+	if rc.apiKey != nil && reqUsage != nil {
+		clientRateLimiter.RecordOutput(rc.apiKey.ID, rc.apiKey.RateLimit.OutputTokensPerMinute, reqUsage.OutputTokens)
+	}
+	rc.apiKey = nil
+	chargeBudget(GetAPIKey(rc.GinContext), rc.tokenID, reqUsage)
+	rc.recordLedger(reqUsage)
+	rc.publishFinished(reqUsage)
+}
+
+// publishFinished 发布请求结束事件
+func (rc *RequestContext) publishFinished(reqUsage *requestUsage) {
+	c := rc.GinContext
+	data := map[string]any{
+		"status":      c.Writer.Status(),
+		"duration_ms": time.Since(rc.startedAt).Milliseconds(),
+	}
+	if ttft, ok := c.Get(timeToFirstTokenContextKey); ok {
+		data["ttft_ms"] = ttft.(time.Duration).Milliseconds()
+	}
+	if reqUsage != nil {
+		data["input_tokens"] = reqUsage.InputTokens
+		data["output_tokens"] = reqUsage.OutputTokens
+		data["stop_reason"] = reqUsage.StopReason
+	}
+	publishRequestEvent(c, events.TypeRequestFinished, data)
+}
+
+// recordLedger 将已完成的请求写入用量账本，未记录用量的请求（如上游失败）仅记录状态与延迟
+func (rc *RequestContext) recordLedger(reqUsage *requestUsage) {
+	c := rc.GinContext
+	entry := usage.Entry{
+		Time:      rc.startedAt,
+		RequestID: GetRequestID(c),
+		Model:     rc.model,
+		TokenID:   rc.tokenID,
+		LatencyMs: time.Since(rc.startedAt).Milliseconds(),
+		Status:    c.Writer.Status(),
+	}
+	if apiKey := GetAPIKey(c); apiKey != nil {
+		entry.KeyID = apiKey.ID
+		entry.KeyName = apiKey.Name
+	}
+	if reqUsage != nil {
+		entry.InputTokens = reqUsage.InputTokens
+		entry.OutputTokens = reqUsage.OutputTokens
+		entry.StopReason = reqUsage.StopReason
+	}
+	usage.Record(entry)
+}
+
+// probeRequest 从请求体中提取模型名称和是否流式（Anthropic与OpenAI格式相同）
+func probeRequest(body []byte) (model string, stream bool) {
+	var probe struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if err := utils.SafeUnmarshal(body, &probe); err != nil {
+		return "", false
+	}
+	return probe.Model, probe.Stream
+}
+
+// conversationAffinityKey 提取用于会话亲和的会话标识
+// 优先级：X-Conversation-ID 请求头 > metadata.user_id > 基于客户端特征的稳定会话ID
+func conversationAffinityKey(c *gin.Context, body []byte) string {
+	if convID := c.GetHeader("X-Conversation-ID"); convID != "" {
+		return convID
+	}
+
+	var probe struct {
+		Metadata struct {
+			UserID string `json:"user_id"`
+		} `json:"metadata"`
+	}
+	if err := utils.SafeUnmarshal(body, &probe); err == nil && probe.Metadata.UserID != "" {
+		return "user:" + probe.Metadata.UserID
+	}
+
+	return utils.GenerateStableConversationID(c)
+}
@@ -2,13 +2,23 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"kiro2api/auth"
+	"kiro2api/logger"
 	"kiro2api/types"
+	"kiro2api/usage"
+	"kiro2api/utils"
+	"kiro2api/webconfig"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -20,14 +30,26 @@ func init() {
 
 // MockAuthService 用于测试的mock AuthService
 type MockAuthService struct {
-	token types.TokenInfo
-	err   error
+	token       types.TokenInfo
+	err         error
+	released    int
+	affinityKey string
+	group       string
 }
 
 func (m *MockAuthService) GetToken() (types.TokenInfo, error) {
 	return m.token, m.err
 }
 
+func (m *MockAuthService) AcquireToken(ctx context.Context, affinityKey string) (types.TokenInfo, func(), error) {
+	m.affinityKey = affinityKey
+	m.group = auth.TokenGroupFromContext(ctx)
+	if m.err != nil {
+		return types.TokenInfo{}, nil, m.err
+	}
+	return m.token, func() { m.released++ }, nil
+}
+
 func TestRespondError(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -144,6 +166,159 @@ func TestRequestContext_GetTokenAndBody(t *testing.T) {
 	}
 }
 
+func TestRequestContext_ReleaseToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{}`))
+
+	mockAuth := &MockAuthService{token: types.TokenInfo{AccessToken: "test-token"}}
+	reqCtx := &RequestContext{GinContext: c, AuthService: mockAuth, RequestType: "test"}
+
+	_, _, err := reqCtx.GetTokenAndBody()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, mockAuth.released, "请求结束前不应释放并发槽位")
+
+	reqCtx.ReleaseToken()
+	reqCtx.ReleaseToken()
+	assert.Equal(t, 1, mockAuth.released, "重复释放只应生效一次")
+}
+
+func TestRequestContext_GetTokenAndBody_QueueErrors(t *testing.T) {
+	for _, queueErr := range []error{auth.ErrTokenQueueFull, auth.ErrTokenQueueTimeout} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{}`))
+
+		reqCtx := &RequestContext{GinContext: c, AuthService: &MockAuthService{err: queueErr}, RequestType: "test"}
+
+		_, _, err := reqCtx.GetTokenAndBody()
+		assert.ErrorIs(t, err, queueErr)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRequestContext_GetTokenAndBody_AffinityKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		body     string
+		expected string
+	}{
+		{
+			name:     "X-Conversation-ID优先",
+			header:   "conv-custom",
+			body:     `{"metadata":{"user_id":"u1"}}`,
+			expected: "conv-custom",
+		},
+		{
+			name:     "使用metadata.user_id",
+			body:     `{"metadata":{"user_id":"u1"}}`,
+			expected: "user:u1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/test", bytes.NewBufferString(tt.body))
+			if tt.header != "" {
+				c.Request.Header.Set("X-Conversation-ID", tt.header)
+			}
+
+			mockAuth := &MockAuthService{}
+			reqCtx := &RequestContext{GinContext: c, AuthService: mockAuth, RequestType: "test"}
+
+			_, _, err := reqCtx.GetTokenAndBody()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, mockAuth.affinityKey)
+		})
+	}
+
+	// 无显式会话标识时回退到稳定会话ID
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{}`))
+	mockAuth := &MockAuthService{}
+	reqCtx := &RequestContext{GinContext: c, AuthService: mockAuth, RequestType: "test"}
+	_, _, err := reqCtx.GetTokenAndBody()
+	assert.NoError(t, err)
+	assert.Equal(t, utils.GenerateStableConversationID(c), mockAuth.affinityKey)
+}
+
+func TestRequestContext_GetTokenAndBody_APIKeyPolicy(t *testing.T) {
+	newContext := func(body string) (*httptest.ResponseRecorder, *gin.Context) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(body))
+		c.Set(apiKeyContextKey, &webconfig.APIKeyIdentity{
+			ID:            "k1",
+			Name:          "team-a",
+			AllowedModels: []string{"claude-sonnet-4-20250514"},
+			TokenGroup:    "team-a",
+		})
+		return w, c
+	}
+
+	// 允许的模型：使用密钥绑定的token分组
+	_, c := newContext(`{"model":"claude-sonnet-4-20250514"}`)
+	mockAuth := &MockAuthService{}
+	reqCtx := &RequestContext{GinContext: c, AuthService: mockAuth, RequestType: "test"}
+	_, _, err := reqCtx.GetTokenAndBody()
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", mockAuth.group)
+
+	// 不允许的模型：返回403且不获取token
+	w, c := newContext(`{"model":"claude-opus-4-20250514"}`)
+	mockAuth = &MockAuthService{}
+	reqCtx = &RequestContext{GinContext: c, AuthService: mockAuth, RequestType: "test"}
+	_, _, err = reqCtx.GetTokenAndBody()
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "claude-opus-4-20250514")
+	assert.Empty(t, mockAuth.affinityKey)
+}
+
+func TestRequestContext_ReleaseToken_RecordsUsage(t *testing.T) {
+	ledger, err := usage.Open(t.TempDir())
+	assert.NoError(t, err)
+	usage.SetDefault(ledger)
+	defer func() {
+		usage.SetDefault(nil)
+		_ = ledger.Close()
+	}()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"model":"claude-sonnet-4-20250514"}`))
+	c.Set(apiKeyContextKey, &webconfig.APIKeyIdentity{ID: "k1", Name: "team-a"})
+
+	mockAuth := &MockAuthService{token: types.TokenInfo{AccessToken: "test-token", ID: "token_1"}}
+	reqCtx := &RequestContext{GinContext: c, AuthService: mockAuth, RequestType: "Anthropic"}
+	_, _, err = reqCtx.GetTokenAndBody()
+	assert.NoError(t, err)
+	recordUsage(c, 12, 34, "end_turn")
+	reqCtx.ReleaseToken()
+	reqCtx.ReleaseToken()
+
+	today, _ := time.ParseInLocation(webconfig.UsageDateFormat, time.Now().Format(webconfig.UsageDateFormat), time.Local)
+	rows, err := ledger.Query(webconfig.UsageQuery{
+		From:    today,
+		To:      today,
+		GroupBy: []string{webconfig.UsageGroupKey, webconfig.UsageGroupModel, webconfig.UsageGroupToken},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1, "重复释放只应记录一次")
+	assert.Equal(t, "k1", rows[0].KeyID)
+	assert.Equal(t, "team-a", rows[0].KeyName)
+	assert.Equal(t, "claude-sonnet-4-20250514", rows[0].Model)
+	assert.Equal(t, "token_1", rows[0].TokenID)
+	assert.Equal(t, int64(1), rows[0].Requests)
+	assert.Equal(t, int64(12), rows[0].InputTokens)
+	assert.Equal(t, int64(34), rows[0].OutputTokens)
+}
+
 func TestHandleRequestBuildError(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -224,6 +399,54 @@ func TestAnthropicStreamSender_SendEvent(t *testing.T) {
 	assert.Contains(t, body, "data:")
 }
 
+func TestAnthropicStreamSender_SendEvent_RedactsDebugLog(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "debug.log")
+	previousLevel, previousMode := logger.GetLogLevel(), logger.GetRedactionMode()
+	assert.NoError(t, logger.ConfigureOutput(logFile, false, logger.RotationConfig{}))
+	logger.SetLogLevel(logger.DEBUG)
+	t.Cleanup(func() {
+		logger.SetLogLevel(previousLevel)
+		_ = logger.SetRedactionMode(previousMode)
+		_ = logger.ConfigureOutput("", true, logger.RotationConfig{})
+	})
+
+	image := strings.Repeat("iVBORw0KGgoAAAANSUhEUgAA", 40)
+	event := map[string]any{
+		"type":  "content_block_delta",
+		"delta": map[string]any{"type": "text_delta", "text": "secret prompt Bearer sk-ant-abcdef123456 " + image},
+	}
+	send := func(mode string) string {
+		assert.NoError(t, logger.SetRedactionMode(mode))
+		assert.NoError(t, os.Truncate(logFile, 0))
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		assert.NoError(t, (&AnthropicStreamSender{}).SendEvent(c, event))
+		// 下发给客户端的内容不受影响
+		assert.Contains(t, w.Body.String(), "secret prompt Bearer sk-ant-abcdef123456")
+		data, _ := os.ReadFile(logFile)
+		return string(data)
+	}
+
+	// 默认只记录长度
+	out := send(logger.RedactMetadata)
+	assert.Contains(t, out, `"payload_preview":"<redacted bytes=`)
+	assert.NotContains(t, out, "secret prompt")
+
+	out = send(logger.RedactHashed)
+	assert.Regexp(t, `"payload_preview":"<redacted bytes=\d+ sha256=[0-9a-f]{16}>"`, out)
+
+	// 完整记录时仍屏蔽凭据、省略图片
+	out = send(logger.RedactOff)
+	assert.Contains(t, out, "secret prompt Bearer <redacted>")
+	assert.Contains(t, out, "<elided base64 bytes=960>")
+	assert.NotContains(t, out, "sk-ant-abcdef123456")
+	assert.NotContains(t, out, image)
+
+	out = send(logger.RedactTruncated)
+	assert.Contains(t, out, "secret prompt Bearer <redacted>")
+	assert.NotContains(t, out, image)
+}
+
 func TestAnthropicStreamSender_SendError(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
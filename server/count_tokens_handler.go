@@ -49,6 +49,21 @@ func handleCountTokens(c *gin.Context) {
 		return
 	}
 
+	// 与消息接口一致，限定了模型的API密钥不能查询其他模型
+	if apiKey := GetAPIKey(c); apiKey != nil && !apiKey.AllowsModel(req.Model) {
+		logger.Warn("API密钥无权使用该模型",
+			addReqFields(c,
+				logger.String("model", req.Model),
+			)...)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": gin.H{
+				"type":    "permission_error",
+				"message": fmt.Sprintf("API密钥无权使用模型: %s", req.Model),
+			},
+		})
+		return
+	}
+
 	// 创建token估算器
 	estimator := utils.NewTokenEstimator()
 
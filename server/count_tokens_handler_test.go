@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"kiro2api/types"
+	"kiro2api/webconfig"
 )
 
 // TestHandleCountTokens_Success 测试成功的token计数
@@ -284,6 +285,44 @@ func TestHandleCountTokens_InvalidModel(t *testing.T) {
 	}
 }
 
+// TestHandleCountTokens_APIKeyModelRestriction 测试限定模型的API密钥
+func TestHandleCountTokens_APIKeyModelRestriction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		model      string
+		wantStatus int
+	}{
+		{"允许的模型", "claude-sonnet-4-20250514", http.StatusOK},
+		{"未授权的模型", "claude-opus-4-20250514", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set(apiKeyContextKey, &webconfig.APIKeyIdentity{
+				ID:            "k1",
+				Name:          "team-a",
+				AllowedModels: []string{"claude-sonnet-4-20250514"},
+			})
+
+			jsonBytes, err := json.Marshal(types.CountTokensRequest{
+				Model:    tt.model,
+				Messages: []types.AnthropicRequestMessage{{Role: "user", Content: "test"}},
+			})
+			assert.NoError(t, err)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", bytes.NewReader(jsonBytes))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handleCountTokens(c)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+}
+
 // TestHandleCountTokens_ComplexContent 测试复杂内容的token计数
 func TestHandleCountTokens_ComplexContent(t *testing.T) {
 	gin.SetMode(gin.TestMode)
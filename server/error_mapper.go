@@ -7,6 +7,14 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"kiro2api/logger"
+	"kiro2api/metrics"
+)
+
+// 不经过错误映射策略的上游错误类别（用于指标）
+const (
+	upstreamErrorSendFailed = "send_failed" // 请求未能发送或未收到响应
+	upstreamErrorReadFailed = "read_failed" // 读取错误响应体失败
+	upstreamErrorForbidden  = "forbidden"   // 403，token可能已失效
 )
 
 // ErrorMappingStrategy 错误映射策略接口 (DIP原则)
@@ -91,6 +99,7 @@ func (em *ErrorMapper) MapCodeWhispererError(statusCode int, responseBody []byte
 	// 依次尝试各种映射策略
 	for _, strategy := range em.strategies {
 		if response, handled := strategy.MapError(statusCode, responseBody); handled {
+			metrics.IncUpstreamError(strategy.GetErrorType(), statusCode)
 			logger.Debug("错误映射成功",
 				logger.String("strategy", strategy.GetErrorType()),
 				logger.Int("status_code", statusCode),
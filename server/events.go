@@ -0,0 +1,43 @@
+package server
+
+import (
+	"kiro2api/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// publishRequestEvent 发布请求事件，附带请求ID、端点、客户端密钥、模型和上游token
+func publishRequestEvent(c *gin.Context, eventType string, data map[string]any) {
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data["request_id"] = GetRequestID(c)
+	data["endpoint"] = metricsEndpoint(c)
+	if key := metricsKeyLabel(c); key != "" {
+		data["api_key"] = key
+	}
+	if model := c.GetString(requestModelContextKey); model != "" {
+		data["model"] = model
+	}
+	if v, ok := c.Get(requestStreamContextKey); ok {
+		data["stream"] = v
+	}
+	if v, ok := c.Get(requestTokenContextKey); ok {
+		token := v.(requestToken)
+		data["token_id"] = token.ID
+		data["token_index"] = token.Index
+	}
+	events.Publish(eventType, data)
+}
+
+// publishRequestError 发布请求失败事件，stage 标明失败的阶段
+func publishRequestError(c *gin.Context, stage string, status int, errMsg string) {
+	data := map[string]any{"stage": stage}
+	if status > 0 {
+		data["status_code"] = status
+	}
+	if errMsg != "" {
+		data["error"] = errMsg
+	}
+	publishRequestEvent(c, events.TypeRequestError, data)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/events"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishRequestEvent_IncludesRequestContext(t *testing.T) {
+	sub, _ := events.Subscribe(0)
+	defer sub.Close()
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		setRequestModel(c, "claude-sonnet-4")
+		setRequestStream(c, true)
+		setRequestToken(c, types.TokenInfo{ID: "tok-a", Index: 2})
+		publishRequestEvent(c, events.TypeRequestStarted, nil)
+		publishRequestError(c, upstreamErrorForbidden, http.StatusForbidden, "")
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("X-Request-ID", "rid-events")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	var received []events.Event
+	for len(received) < 2 {
+		ev := <-sub.C
+		if ev.Data["request_id"] == "rid-events" {
+			received = append(received, ev)
+		}
+	}
+
+	started := received[0]
+	assert.Equal(t, events.TypeRequestStarted, started.Type)
+	assert.Equal(t, "/v1/messages", started.Data["endpoint"])
+	assert.Equal(t, "claude-sonnet-4", started.Data["model"])
+	assert.Equal(t, true, started.Data["stream"])
+	assert.Equal(t, "tok-a", started.Data["token_id"])
+	assert.Equal(t, 2, started.Data["token_index"])
+	assert.NotContains(t, started.Data, "api_key")
+
+	failed := received[1]
+	assert.Equal(t, events.TypeRequestError, failed.Type)
+	assert.Equal(t, upstreamErrorForbidden, failed.Data["stage"])
+	assert.Equal(t, http.StatusForbidden, failed.Data["status_code"])
+	assert.NotContains(t, failed.Data, "error")
+}
@@ -64,14 +64,7 @@ func handleStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest, to
 // handleGenericStreamRequest 通用流式请求处理
 func handleGenericStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest, token *types.TokenWithUsage, sender StreamEventSender, eventCreator func(string, int, string) []map[string]any) {
 	// 计算输入tokens
-	estimator := utils.NewTokenEstimator()
-	countReq := &types.CountTokensRequest{
-		Model:    anthropicReq.Model,
-		System:   anthropicReq.System,
-		Messages: anthropicReq.Messages,
-		Tools:    anthropicReq.Tools,
-	}
-	inputTokens := estimator.EstimateTokens(countReq)
+	inputTokens := estimateInputTokens(anthropicReq)
 
 	// 初始化SSE响应
 	if err := initializeSSEResponse(c); err != nil {
@@ -95,6 +88,11 @@ func handleGenericStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequ
 	}
 	defer resp.Body.Close()
 
+	forwardEventStream(c, anthropicReq, token, sender, eventCreator, messageID, inputTokens, resp.Body)
+}
+
+// forwardEventStream 解析上游事件流并以SSE转发给客户端（含初始事件和结束事件）
+func forwardEventStream(c *gin.Context, anthropicReq types.AnthropicRequest, token *types.TokenWithUsage, sender StreamEventSender, eventCreator func(string, int, string) []map[string]any, messageID string, inputTokens int, body io.Reader) {
 	// 创建流处理上下文
 	ctx := NewStreamProcessorContext(c, anthropicReq, token, sender, messageID, inputTokens)
 	defer ctx.Cleanup()
@@ -106,7 +104,13 @@ func handleGenericStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequ
 
 	// 处理事件流
 	processor := NewEventStreamProcessor(ctx)
-	if err := processor.ProcessEventStream(resp.Body); err != nil {
+	if err := processor.ProcessEventStream(body); err != nil {
+		if errors.Is(err, errRequestCancelled) {
+			// 管理员取消：中断上游读取后向客户端发送错误事件，不再发送结束事件
+			noteCancelled(c)
+			_ = sender.SendError(c, err.Error(), err)
+			return
+		}
 		logger.Error("事件流处理失败", logger.Err(err))
 		return
 	}
@@ -336,9 +340,8 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 			logger.Debug("发送给Claude CLI的tool_use块详细结构",
 				logger.String("tool_id", tool.ID),
 				logger.String("tool_name", tool.Name),
-				logger.String("tool_use_json", string(toolUseBlockJSON)),
-				logger.String("input_type", fmt.Sprintf("%T", tool.Arguments)),
-				logger.Any("arguments_value", tool.Arguments))
+				logger.Payload("tool_use_json", string(toolUseBlockJSON)),
+				logger.String("input_type", fmt.Sprintf("%T", tool.Arguments)))
 		}
 
 		contexts = append(contexts, toolUseBlock)
@@ -385,6 +388,8 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 		},
 	}
 
+	recordUsage(c, inputTokens, outputTokens, stopReason)
+
 	logger.Debug("非流式响应最终数据",
 		logger.String("stop_reason", stopReason),
 		logger.Int("content_blocks", len(contexts)))
@@ -567,7 +572,7 @@ func handleTokenPoolAPI(c *gin.Context) {
 func refreshSingleTokenByConfig(config auth.AuthConfig) (types.TokenInfo, error) {
 	switch config.AuthType {
 	case auth.AuthMethodSocial:
-		return auth.RefreshSocialToken(config.RefreshToken)
+		return auth.RefreshSocialToken(config)
 	case auth.AuthMethodIdC:
 		return auth.RefreshIdCToken(config)
 	default:
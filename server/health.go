@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/metrics"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// processStartedAt 进程启动时间，用于状态端点输出运行时长
+var processStartedAt = time.Now()
+
+// poolStateSource token池的缓存状态，由 *auth.AuthService 实现
+// 两个方法都只读取内存中的缓存，不刷新token、不发起网络请求
+type poolStateSource interface {
+	TokenStates() []metrics.TokenState
+	GetConcurrencyStats() types.TokenConcurrencyStats
+}
+
+// newPoolStateSource 未配置token时 authService 为nil，返回nil接口，避免在nil接收者上调用
+func newPoolStateSource(authService *auth.AuthService) poolStateSource {
+	if authService == nil {
+		return nil
+	}
+	return authService
+}
+
+// poolSummary token池状态汇总
+type poolSummary struct {
+	Total     int `json:"total"`
+	Usable    int `json:"usable"`    // 已加载且额度未耗尽
+	Exhausted int `json:"exhausted"` // 额度已耗尽，等待重置
+	Unloaded  int `json:"unloaded"`  // 尚未成功刷新或已禁用
+	Expired   int `json:"expired"`   // 访问令牌已过期，下次使用时刷新
+}
+
+// poolTokenStatus 单个token的缓存状态
+type poolTokenStatus struct {
+	Index     int        `json:"index"`
+	ID        string     `json:"id"`
+	Available float64    `json:"available"`
+	Exhausted bool       `json:"exhausted"`
+	InFlight  int        `json:"in_flight"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// summarizePool 汇总token池状态
+// 访问令牌过期不影响可用性：token在下一次被选中时刷新，若因过期判为不可用，摘除流量后将永远不会刷新
+func summarizePool(states []metrics.TokenState) (poolSummary, []poolTokenStatus) {
+	now := time.Now()
+	summary := poolSummary{Total: len(states)}
+	tokens := make([]poolTokenStatus, 0, len(states))
+	for _, state := range states {
+		status := poolTokenStatus{
+			Index:     state.Index,
+			ID:        state.ID,
+			Available: state.Available,
+			Exhausted: state.Exhausted,
+			InFlight:  state.InFlight,
+		}
+		switch {
+		case state.ExpiresAt.IsZero():
+			summary.Unloaded++
+		case state.Exhausted || state.Available <= 0:
+			summary.Exhausted++
+		default:
+			summary.Usable++
+		}
+		if !state.ExpiresAt.IsZero() {
+			expiresAt := state.ExpiresAt
+			status.ExpiresAt = &expiresAt
+			if expiresAt.Before(now) {
+				summary.Expired++
+			}
+		}
+		tokens = append(tokens, status)
+	}
+	return summary, tokens
+}
+
+// handleHealthz 进程存活探针，不检查token池
+func handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz 就绪探针，没有可用token时返回503，便于负载均衡摘除该实例
+func handleReadyz(source poolStateSource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var summary poolSummary
+		if source != nil {
+			summary, _ = summarizePool(source.TokenStates())
+		}
+		if summary.Usable == 0 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "tokens": summary})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "tokens": summary})
+	}
+}
+
+// handleStatus 由token池缓存状态生成的状态摘要，不发起网络请求
+func handleStatus(source poolStateSource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			summary poolSummary
+			tokens  = []poolTokenStatus{}
+			stats   types.TokenConcurrencyStats
+		)
+		if source != nil {
+			summary, tokens = summarizePool(source.TokenStates())
+			stats = source.GetConcurrencyStats()
+		}
+
+		inFlight := 0
+		for _, token := range tokens {
+			inFlight += token.InFlight
+		}
+		status := "ready"
+		if summary.Usable == 0 {
+			status = "unavailable"
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":         status,
+			"timestamp":      time.Now().Format(time.RFC3339),
+			"uptime_seconds": int64(time.Since(processStartedAt).Seconds()),
+			"tokens":         summary,
+			"concurrency": gin.H{
+				"in_flight":      inFlight,
+				"queue_depth":    stats.QueueDepth,
+				"queue_capacity": stats.QueueCapacity,
+			},
+			"pool": tokens,
+		})
+	}
+}
+
+// registerHealthRoutes 注册无需认证的存活和就绪探针
+func registerHealthRoutes(r *gin.Engine, source poolStateSource) {
+	r.GET("/healthz", handleHealthz)
+	r.HEAD("/healthz", handleHealthz)
+	r.GET("/readyz", handleReadyz(source))
+	r.HEAD("/readyz", handleReadyz(source))
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kiro2api/metrics"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePoolState 固定返回的token池状态，记录调用次数
+type fakePoolState struct {
+	states []metrics.TokenState
+	stats  types.TokenConcurrencyStats
+	calls  int
+}
+
+func (f *fakePoolState) TokenStates() []metrics.TokenState {
+	f.calls++
+	return f.states
+}
+
+func (f *fakePoolState) GetConcurrencyStats() types.TokenConcurrencyStats {
+	return f.stats
+}
+
+func newHealthRouter(source poolStateSource) *gin.Engine {
+	r := gin.New()
+	registerHealthRoutes(r, source)
+	r.GET("/v1/status", handleStatus(source))
+	return r
+}
+
+func serveHealth(r *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestSummarizePool(t *testing.T) {
+	now := time.Now()
+	summary, tokens := summarizePool([]metrics.TokenState{
+		{ID: "usable", Index: 0, Available: 10, ExpiresAt: now.Add(time.Hour), InFlight: 2},
+		// 访问令牌过期但有额度：下次使用时刷新，仍算可用
+		{ID: "expired", Index: 1, Available: 5, ExpiresAt: now.Add(-time.Minute)},
+		{ID: "exhausted", Index: 2, Available: 0, ExpiresAt: now.Add(time.Hour), Exhausted: true},
+		{ID: "unloaded", Index: 3},
+	})
+
+	assert.Equal(t, poolSummary{Total: 4, Usable: 2, Exhausted: 1, Unloaded: 1, Expired: 1}, summary)
+	assert.Len(t, tokens, 4)
+	assert.Equal(t, 2, tokens[0].InFlight)
+	assert.NotNil(t, tokens[1].ExpiresAt)
+	assert.Nil(t, tokens[3].ExpiresAt)
+}
+
+func TestHealthz_AlwaysOK(t *testing.T) {
+	r := newHealthRouter(nil)
+	w := serveHealth(r, http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+	assert.Equal(t, http.StatusOK, serveHealth(r, http.MethodHead, "/healthz").Code)
+}
+
+func TestReadyz(t *testing.T) {
+	// 未配置token
+	assert.Equal(t, http.StatusServiceUnavailable, serveHealth(newHealthRouter(nil), http.MethodGet, "/readyz").Code)
+
+	source := &fakePoolState{states: []metrics.TokenState{
+		{ID: "a", Available: 0, ExpiresAt: time.Now().Add(time.Hour), Exhausted: true},
+	}}
+	r := newHealthRouter(source)
+	w := serveHealth(r, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"unavailable"`)
+
+	source.states = append(source.states, metrics.TokenState{ID: "b", Index: 1, Available: 3, ExpiresAt: time.Now().Add(-time.Hour)})
+	w = serveHealth(r, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"ready"`)
+}
+
+func TestStatus_FromCachedState(t *testing.T) {
+	source := &fakePoolState{
+		states: []metrics.TokenState{
+			{ID: "a", Index: 0, Available: 7, ExpiresAt: time.Now().Add(time.Hour), InFlight: 1},
+			{ID: "b", Index: 1, Available: 2, ExpiresAt: time.Now().Add(time.Hour), InFlight: 2},
+		},
+		stats: types.TokenConcurrencyStats{QueueDepth: 3, QueueCapacity: 50},
+	}
+	w := serveHealth(newHealthRouter(source), http.MethodGet, "/v1/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, source.calls)
+
+	var body struct {
+		Status      string            `json:"status"`
+		Tokens      poolSummary       `json:"tokens"`
+		Concurrency map[string]int    `json:"concurrency"`
+		Pool        []poolTokenStatus `json:"pool"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "ready", body.Status)
+	assert.Equal(t, 2, body.Tokens.Usable)
+	assert.Equal(t, 3, body.Concurrency["in_flight"])
+	assert.Equal(t, 3, body.Concurrency["queue_depth"])
+	assert.Equal(t, 50, body.Concurrency["queue_capacity"])
+	assert.Len(t, body.Pool, 2)
+	assert.Equal(t, "b", body.Pool[1].ID)
+
+	// 未配置token时返回空列表而不是null
+	w = serveHealth(newHealthRouter(nil), http.MethodGet, "/v1/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"pool":[]`)
+	assert.Contains(t, w.Body.String(), `"status":"unavailable"`)
+}
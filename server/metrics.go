@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"kiro2api/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// requestStartContextKey gin上下文中请求开始时间的键
+	requestStartContextKey = "request_start"
+	// requestModelContextKey gin上下文中请求模型的键
+	requestModelContextKey = "request_model"
+	// firstTokenContextKey gin上下文中是否已记录首个内容增量的键
+	firstTokenContextKey = "first_token_observed"
+
+	// unmatchedEndpoint 未匹配到路由的请求使用的端点标签，避免任意路径产生大量序列
+	unmatchedEndpoint = "unmatched"
+)
+
+// MetricsMiddleware 记录每个请求的端点、模型、状态码、客户端密钥、耗时和token数
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Set(requestStartContextKey, start)
+
+		c.Next()
+
+		endpoint := metricsEndpoint(c)
+		model := c.GetString(requestModelContextKey)
+		key := metricsKeyLabel(c)
+		metrics.ObserveRequest(endpoint, model, c.Writer.Status(), key, time.Since(start))
+		if reqUsage := getUsage(c); reqUsage != nil {
+			metrics.AddTokens(model, key, reqUsage.InputTokens, reqUsage.OutputTokens)
+		}
+	}
+}
+
+// metricsEndpoint 返回请求匹配的路由模板
+func metricsEndpoint(c *gin.Context) string {
+	if path := c.FullPath(); path != "" {
+		return path
+	}
+	return unmatchedEndpoint
+}
+
+// metricsKeyLabel 返回发起请求的客户端密钥名称，未认证的请求为空
+func metricsKeyLabel(c *gin.Context) string {
+	if apiKey := GetAPIKey(c); apiKey != nil {
+		return apiKey.Name
+	}
+	return ""
+}
+
+// setRequestModel 记录请求的模型，供指标按模型区分
+func setRequestModel(c *gin.Context, model string) {
+	c.Set(requestModelContextKey, model)
+}
+
+// requestElapsed 返回从请求开始到现在的耗时，未经过指标中间件时返回false
+func requestElapsed(c *gin.Context) (time.Duration, bool) {
+	if v, ok := c.Get(requestStartContextKey); ok {
+		if start, ok2 := v.(time.Time); ok2 {
+			return time.Since(start), true
+		}
+	}
+	return 0, false
+}
+
+// observeTimeToFirstByte 记录上游响应头到达的耗时
+func observeTimeToFirstByte(c *gin.Context) {
+	if elapsed, ok := requestElapsed(c); ok {
+		metrics.ObserveTimeToFirstByte(metricsEndpoint(c), c.GetString(requestModelContextKey), elapsed)
+	}
+}
+
+// observeFirstToken 在流式请求发出首个内容增量时记录耗时，每个请求只记录一次
+func observeFirstToken(c *gin.Context) {
+	if c.GetBool(firstTokenContextKey) {
+		return
+	}
+	c.Set(firstTokenContextKey, true)
+	if elapsed, ok := requestElapsed(c); ok {
+		c.Set(timeToFirstTokenContextKey, elapsed)
+		metrics.ObserveTimeToFirstToken(metricsEndpoint(c), c.GetString(requestModelContextKey), elapsed)
+	}
+}
+
+// handleMetrics 以 Prometheus 文本格式输出指标，使用独立的令牌认证
+// token 在每次请求时读取，修改后立即生效；未设置令牌时端点关闭
+func handleMetrics(token func() string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := token()
+		if expected == "" {
+			respondError(c, http.StatusNotFound, "%s", "指标端点未启用，请设置 metricsToken 或 "+metrics.EnvMetricsToken)
+			return
+		}
+
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			respondError(c, http.StatusUnauthorized, "%s", "指标令牌无效")
+			return
+		}
+
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		metrics.Default.WritePrometheus(c.Writer)
+	}
+}
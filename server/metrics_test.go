@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/metrics"
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleMetrics_RequiresOwnToken(t *testing.T) {
+	token := ""
+	r := gin.New()
+	r.GET("/metrics", handleMetrics(func() string { return token }))
+
+	get := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// 未设置令牌时端点关闭
+	assert.Equal(t, http.StatusNotFound, get("Bearer anything").Code)
+
+	token = "scrape-secret"
+	w := get("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="metrics"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, get("Bearer wrong").Code)
+
+	w = get("Bearer scrape-secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "# TYPE kiro2api_requests_total counter")
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := gin.New()
+	r.Use(MetricsMiddleware())
+	r.POST("/v1/metrics-test", func(c *gin.Context) {
+		c.Set(apiKeyContextKey, &webconfig.APIKeyIdentity{ID: "k1", Name: "metrics-team"})
+		setRequestModel(c, "metrics-model")
+		observeTimeToFirstByte(c)
+		observeFirstToken(c)
+		observeFirstToken(c) // 每个请求只记录一次首token
+		recordUsage(c, 11, 22, "end_turn")
+		c.Status(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics-test", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodGet, "/no/such/path", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	var buf bytes.Buffer
+	metrics.Default.WritePrometheus(&buf)
+	out := buf.String()
+	assert.Contains(t, out, `kiro2api_requests_total{endpoint="/v1/metrics-test",model="metrics-model",status="201",key="metrics-team"} 1`)
+	assert.Contains(t, out, `kiro2api_requests_total{endpoint="unmatched",model="",status="404",key=""} 1`)
+	assert.Contains(t, out, `kiro2api_request_duration_seconds_count{endpoint="/v1/metrics-test",model="metrics-model"} 1`)
+	assert.Contains(t, out, `kiro2api_time_to_first_byte_seconds_count{endpoint="/v1/metrics-test",model="metrics-model"} 1`)
+	assert.Contains(t, out, `kiro2api_time_to_first_token_seconds_count{endpoint="/v1/metrics-test",model="metrics-model"} 1`)
+	assert.Contains(t, out, `kiro2api_input_tokens_total{model="metrics-model",key="metrics-team"} 11`)
+	assert.Contains(t, out, `kiro2api_output_tokens_total{model="metrics-model",key="metrics-team"} 22`)
+}
@@ -1,17 +1,44 @@
 package server
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"strings"
 
 	"kiro2api/logger"
 	"kiro2api/utils"
+	"kiro2api/webconfig"
 
 	"github.com/gin-gonic/gin"
 )
 
-// PathBasedAuthMiddleware 创建基于路径的API密钥验证中间件
+// apiKeyContextKey gin上下文中已认证API密钥身份的键
+const apiKeyContextKey = "api_key"
+
+// APIKeyAuthenticator 客户端API密钥验证器（webconfig.Manager 实现了该接口）
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(secret string) (*webconfig.APIKeyIdentity, error)
+}
+
+// staticAPIKey 只接受单个固定密钥的验证器
+type staticAPIKey string
+
+func (k staticAPIKey) AuthenticateAPIKey(secret string) (*webconfig.APIKeyIdentity, error) {
+	if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(k)) != 1 {
+		return nil, webconfig.ErrAPIKeyInvalid
+	}
+	return &webconfig.APIKeyIdentity{ID: webconfig.DefaultAPIKeyID, Name: webconfig.DefaultAPIKeyID}, nil
+}
+
+// PathBasedAuthMiddleware 创建基于路径的API密钥验证中间件（单一固定密钥）
 func PathBasedAuthMiddleware(authToken string, protectedPrefixes []string) gin.HandlerFunc {
+	return APIKeyAuthMiddleware(staticAPIKey(authToken), protectedPrefixes)
+}
+
+// APIKeyAuthMiddleware 创建基于路径的API密钥验证中间件
+// 验证通过后将密钥身份注入gin上下文（见 GetAPIKey），并检查密钥允许访问的端点
+func APIKeyAuthMiddleware(authenticator APIKeyAuthenticator, protectedPrefixes []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		path := c.Request.URL.Path
 
@@ -23,7 +50,7 @@ func PathBasedAuthMiddleware(authToken string, protectedPrefixes []string) gin.H
 		}
 
 		// logger.Debug("需要认证", logger.String("path", path))
-		if !validateAPIKey(c, authToken) {
+		if !validateAPIKey(c, authenticator) {
 			c.Abort()
 			return
 		}
@@ -32,6 +59,28 @@ func PathBasedAuthMiddleware(authToken string, protectedPrefixes []string) gin.H
 	}
 }
 
+// GetAPIKey 从上下文读取已认证的API密钥身份（未认证时返回nil）
+func GetAPIKey(c *gin.Context) *webconfig.APIKeyIdentity {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		if key, ok2 := v.(*webconfig.APIKeyIdentity); ok2 {
+			return key
+		}
+	}
+	return nil
+}
+
+// endpointTypeForPath 返回请求路径对应的端点类型，不受限制的路径返回空串
+func endpointTypeForPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v1/chat/completions"):
+		return webconfig.APIEndpointOpenAI
+	case strings.HasPrefix(path, "/v1/messages"):
+		return webconfig.APIEndpointAnthropic
+	default:
+		return ""
+	}
+}
+
 // RequestIDMiddleware 为每个请求注入 request_id 并通过响应头返回
 // - 优先使用客户端的 X-Request-ID
 // - 若无则生成一个UUID（utils.GenerateUUID）
@@ -72,13 +121,19 @@ func addReqFields(c *gin.Context, fields ...logger.Field) []logger.Field {
 	rid := GetRequestID(c)
 	mid := GetMessageID(c)
 	// 预留容量避免重复分配
-	out := make([]logger.Field, 0, len(fields)+2)
+	out := make([]logger.Field, 0, len(fields)+5)
 	if rid != "" {
 		out = append(out, logger.String("request_id", rid))
 	}
+	if traceID, spanID := traceFields(c); traceID != "" {
+		out = append(out, logger.String("trace_id", traceID), logger.String("span_id", spanID))
+	}
 	if mid != "" {
 		out = append(out, logger.String("message_id", mid))
 	}
+	if key := GetAPIKey(c); key != nil {
+		out = append(out, logger.String("api_key", key.Name))
+	}
 	out = append(out, fields...)
 	return out
 }
@@ -104,8 +159,8 @@ func extractAPIKey(c *gin.Context) string {
 	return apiKey
 }
 
-// validateAPIKey 验证API密钥 - 重构后的版本
-func validateAPIKey(c *gin.Context, authToken string) bool {
+// validateAPIKey 验证API密钥并检查端点权限，通过后注入密钥身份
+func validateAPIKey(c *gin.Context, authenticator APIKeyAuthenticator) bool {
 	providedApiKey := extractAPIKey(c)
 
 	if providedApiKey == "" {
@@ -114,11 +169,26 @@ func validateAPIKey(c *gin.Context, authToken string) bool {
 		return false
 	}
 
-	if providedApiKey != authToken {
-		logger.Error("authToken验证失败",
-			logger.String("expected", "***"),
-			logger.String("provided", "***"))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "401"})
+	identity, err := authenticator.AuthenticateAPIKey(providedApiKey)
+	if err != nil {
+		logger.Error("API密钥验证失败",
+			logger.String("provided", "***"),
+			logger.Err(err))
+		if errors.Is(err, webconfig.ErrAPIKeyDisabled) || errors.Is(err, webconfig.ErrAPIKeyExpired) {
+			respondError(c, http.StatusUnauthorized, "%v", err)
+		} else {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "401"})
+		}
+		return false
+	}
+
+	c.Set(apiKeyContextKey, identity)
+
+	if endpoint := endpointTypeForPath(c.Request.URL.Path); endpoint != "" && !identity.AllowsEndpoint(endpoint) {
+		logger.Warn("API密钥无权访问该端点",
+			logger.String("api_key", identity.Name),
+			logger.String("endpoint", endpoint))
+		respondError(c, http.StatusForbidden, "API密钥无权访问 %s 端点", endpoint)
 		return false
 	}
 
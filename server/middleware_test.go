@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"kiro2api/webconfig"
+
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -161,3 +163,84 @@ func TestPathBasedAuthMiddleware_MultipleProtectedPrefixes(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+// fakeAuthenticator 按密钥返回预设的身份或错误
+type fakeAuthenticator map[string]*webconfig.APIKeyIdentity
+
+func (f fakeAuthenticator) AuthenticateAPIKey(secret string) (*webconfig.APIKeyIdentity, error) {
+	switch secret {
+	case "disabled-key":
+		return nil, webconfig.ErrAPIKeyDisabled
+	case "expired-key":
+		return nil, webconfig.ErrAPIKeyExpired
+	}
+	if identity, ok := f[secret]; ok {
+		return identity, nil
+	}
+	return nil, webconfig.ErrAPIKeyInvalid
+}
+
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authenticator := fakeAuthenticator{
+		"anthropic-only": {ID: "k1", Name: "team-a", AllowedEndpoints: []string{webconfig.APIEndpointAnthropic}},
+		"any":            {ID: "k2", Name: "team-b"},
+	}
+
+	router := gin.New()
+	router.Use(APIKeyAuthMiddleware(authenticator, []string{"/v1"}))
+	handler := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"key": GetAPIKey(c).Name})
+	}
+	router.POST("/v1/messages", handler)
+	router.POST("/v1/chat/completions", handler)
+	router.GET("/v1/models", handler)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		key      string
+		expected int
+		body     string
+	}{
+		{"允许的端点", "POST", "/v1/messages", "anthropic-only", http.StatusOK, "team-a"},
+		{"不允许的端点", "POST", "/v1/chat/completions", "anthropic-only", http.StatusForbidden, "openai"},
+		{"不受端点限制的路径", "GET", "/v1/models", "anthropic-only", http.StatusOK, "team-a"},
+		{"无端点限制的密钥", "POST", "/v1/chat/completions", "any", http.StatusOK, "team-b"},
+		{"已禁用的密钥", "POST", "/v1/messages", "disabled-key", http.StatusUnauthorized, "已禁用"},
+		{"已过期的密钥", "POST", "/v1/messages", "expired-key", http.StatusUnauthorized, "已过期"},
+		{"未知密钥", "POST", "/v1/messages", "unknown", http.StatusUnauthorized, "401"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("x-api-key", tt.key)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+			assert.Contains(t, w.Body.String(), tt.body)
+		})
+	}
+}
+
+func TestPathBasedAuthMiddleware_DefaultIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(PathBasedAuthMiddleware("test-token-123", []string{"/v1/"}))
+	router.POST("/v1/messages", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"key": GetAPIKey(c).ID})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/messages", nil)
+	req.Header.Set("Authorization", "Bearer test-token-123")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), webconfig.DefaultAPIKeyID)
+}
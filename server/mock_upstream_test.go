@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/mockupstream"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMockUpstream_EndToEnd 通过模拟器刷新token、发送请求，并把返回的事件流送入解析器和SSE管道
+func TestMockUpstream_EndToEnd(t *testing.T) {
+	emulator := mockupstream.New()
+	emulator.Configure("../mockupstream/scenarios", 0)
+	upstream := httptest.NewServer(emulator)
+	defer upstream.Close()
+
+	token, err := auth.RefreshSocialToken(auth.AuthConfig{
+		AuthType:     auth.AuthMethodSocial,
+		RefreshToken: "refresh-e2e",
+		Upstream: types.UpstreamProfile{Endpoints: types.UpstreamEndpoints{
+			SocialRefresh: upstream.URL + config.MockSocialRefreshPath,
+		}},
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.ProfileArn)
+
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "今天北京天气怎么样"}},
+	}
+	var cwReq types.CodeWhispererRequest
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = "今天北京天气怎么样"
+	body, _ := json.Marshal(cwReq)
+	httpReq, _ := http.NewRequest(http.MethodPost, upstream.URL+config.MockCodeWhispererPath, bytes.NewReader(body))
+	httpReq.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err := http.DefaultClient.Do(httpReq)
+	assert.NoError(t, err)
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	clientRequest, _ := json.Marshal(anthropicReq)
+	sse, err := ReplayCapture(&Capture{
+		Version:       captureFileVersion,
+		Endpoint:      "/v1/messages",
+		Stream:        true,
+		ClientRequest: clientRequest,
+		Status:        resp.StatusCode,
+		ResponseBytes: raw,
+	})
+	assert.NoError(t, err)
+
+	var text, input strings.Builder
+	var toolName string
+	for _, line := range strings.Split(sse, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			ContentBlock struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"content_block"`
+			Delta struct {
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+		}
+		if json.Unmarshal([]byte(data), &event) != nil {
+			continue
+		}
+		if event.ContentBlock.Type == "tool_use" {
+			toolName = event.ContentBlock.Name
+		}
+		text.WriteString(event.Delta.Text)
+		input.WriteString(event.Delta.PartialJSON)
+	}
+	assert.Equal(t, "我来查询一下北京的天气。", text.String())
+	assert.Equal(t, "get_weather", toolName)
+	// 参数片段在多字节字符中间切开时，解析器逐帧解码会把残缺字节替换为U+FFFD，这里只校验参数结构
+	var arguments map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(input.String()), &arguments))
+	assert.Contains(t, arguments, "city")
+	assert.Contains(t, arguments, "unit")
+	assert.Contains(t, sse, `"stop_reason":"tool_use"`)
+}
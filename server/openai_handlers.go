@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"kiro2api/config"
@@ -83,6 +84,8 @@ func handleOpenAINonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRe
 		},
 	}
 
+	recordUsage(c, estimateInputTokens(anthropicReq), utils.NewTokenEstimator().EstimateTextTokens(allContent), stopReason)
+
 	// 转换为OpenAI格式
 	openaiMessageId := fmt.Sprintf("chatcmpl-%s", time.Now().Format(config.MessageIDTimeFormat))
 	openaiResp := converter.ConvertAnthropicToOpenAI(anthropicResp, anthropicReq.Model, openaiMessageId)
@@ -145,6 +148,7 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 	nextToolIndex := 0
 	sawToolUse := false
 	sentFinal := false
+	var outputText strings.Builder // 下发的文本和工具参数，用于估算输出tokens
 
 	// 添加完整性跟踪
 	totalBytesRead := 0
@@ -172,11 +176,13 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 					if dataMap, ok := event.Data.(map[string]any); ok {
 						switch dataMap["type"] {
 						case "content_block_delta":
+							observeFirstToken(c)
 							if delta, ok := dataMap["delta"]; ok {
 								if deltaMap, ok := delta.(map[string]any); ok {
 									switch deltaMap["type"] {
 									case "text_delta":
 										if text, ok := deltaMap["text"]; ok {
+											outputText.WriteString(text.(string))
 											// 发送文本内容的增量
 											contentEvent := map[string]any{
 												"id":      messageId,
@@ -225,6 +231,7 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 													}
 												}
 												if partial != "" {
+													outputText.WriteString(partial)
 													toolDelta := map[string]any{
 														"id":      messageId,
 														"object":  "chat.completion.chunk",
@@ -281,6 +288,7 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 												nextToolIndex++
 											}
 											toolUseIdByBlockIndex[toolBlockIndex] = toolUseId
+											trackBlockStart(c, toolBlockIndex, toolUseId, toolName)
 											sawToolUse = true
 											toolIdx := toolIndexByToolUseId[toolUseId]
 											// 发送OpenAI工具调用开始增量
@@ -338,7 +346,8 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 								}
 							}
 						case "content_block_stop":
-							// 忽略，最终结束由message_delta驱动
+							// 最终结束由message_delta驱动，这里只更新在途请求中进行中的工具调用
+							trackBlockStop(c, extractIndex(dataMap))
 						}
 					}
 				}
@@ -348,6 +357,12 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 
 		// 错误处理
 		if err != nil {
+			if requestCancelled(c) {
+				// 管理员取消：发送错误后直接结束，不再发送结束原因和[DONE]
+				noteCancelled(c)
+				sender.SendError(c, errRequestCancelled.Error(), errRequestCancelled)
+				return
+			}
 			if err == io.EOF {
 				// 正常结束
 				hasMoreData = false
@@ -403,6 +418,12 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 		c.Writer.Flush()
 	}
 
+	stopReason := "end_turn"
+	if sawToolUse {
+		stopReason = "tool_use"
+	}
+	recordUsage(c, estimateInputTokens(anthropicReq), utils.NewTokenEstimator().EstimateTextTokens(outputText.String()), stopReason)
+
 	// 发送结束标记
 	fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
 	c.Writer.Flush()
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/types"
+	"kiro2api/utils"
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientRateLimiter 按客户端密钥的限流器（限流配置随密钥身份下发，修改后即时生效）
+var clientRateLimiter = newRateLimiter()
+
+// rateBucket 令牌桶：容量为每个周期的限额，在 config.RateLimitWindow 内匀速补满
+// 额度允许为负（输出token在请求结束后才扣除），透支期间新请求被拒绝直到补回
+type rateBucket struct {
+	limit   float64
+	tokens  float64
+	updated time.Time
+}
+
+// refill 按流逝时间补充额度，限额变化时按新限额截断
+func (b *rateBucket) refill(limit int, now time.Time) {
+	capacity := float64(limit)
+	if b.updated.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += capacity * float64(elapsed) / float64(config.RateLimitWindow)
+	}
+	b.tokens = math.Min(b.tokens, capacity)
+	b.limit = capacity
+	b.updated = now
+}
+
+// waitFor 返回额度达到need所需的等待时间
+func (b *rateBucket) waitFor(need float64) time.Duration {
+	if b.tokens >= need || b.limit <= 0 {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.limit * float64(config.RateLimitWindow))
+}
+
+// state 返回当前限额、剩余额度和补满时间
+func (b *rateBucket) state(now time.Time) *rateLimitState {
+	resetIn := b.waitFor(b.limit)
+	return &rateLimitState{
+		Limit:     int(b.limit),
+		Remaining: int(math.Max(0, math.Floor(b.tokens))),
+		Reset:     now.Add(resetIn),
+		ResetIn:   resetIn,
+	}
+}
+
+// rateLimitState 单个限流维度的状态
+type rateLimitState struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time     // 额度补满的时间
+	ResetIn   time.Duration // 距额度补满的时长
+}
+
+// rateLimitStatus 一次限流检查的结果，未限制的维度为nil
+type rateLimitStatus struct {
+	Requests     *rateLimitState
+	InputTokens  *rateLimitState
+	OutputTokens *rateLimitState
+	RetryAfter   time.Duration // 被拒绝时需要等待的时间
+}
+
+// Tokens 返回输入/输出token中剩余额度更少的维度（对应 *-tokens-* 响应头）
+func (s rateLimitStatus) Tokens() *rateLimitState {
+	switch {
+	case s.InputTokens == nil:
+		return s.OutputTokens
+	case s.OutputTokens == nil:
+		return s.InputTokens
+	case s.OutputTokens.Remaining < s.InputTokens.Remaining:
+		return s.OutputTokens
+	default:
+		return s.InputTokens
+	}
+}
+
+// keyBuckets 单个客户端密钥的令牌桶
+type keyBuckets struct {
+	requests rateBucket
+	input    rateBucket
+	output   rateBucket
+}
+
+// rateLimiter 按客户端密钥限制每分钟请求数、输入token数和输出token数
+type rateLimiter struct {
+	mutex sync.Mutex
+	keys  map[string]*keyBuckets
+	now   func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		keys: make(map[string]*keyBuckets),
+		now:  time.Now,
+	}
+}
+
+// bucketsUnlocked 返回密钥的令牌桶（调用方需持有锁）
+func (rl *rateLimiter) bucketsUnlocked(keyID string) *keyBuckets {
+	buckets, exists := rl.keys[keyID]
+	if !exists {
+		buckets = &keyBuckets{}
+		rl.keys[keyID] = buckets
+	}
+	return buckets
+}
+
+// Allow 检查并扣除一次请求和估算的输入token
+// 任一维度额度不足时不扣除，返回 false 和需要等待的时间
+func (rl *rateLimiter) Allow(keyID string, limit webconfig.RateLimitConfig, inputTokens int) (rateLimitStatus, bool) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	buckets := rl.bucketsUnlocked(keyID)
+
+	var wait time.Duration
+	check := func(bucket *rateBucket, limit int, need int) {
+		if limit <= 0 {
+			return
+		}
+		bucket.refill(limit, now)
+		// 单次需求超过限额时，只要求额度已补满，避免请求永远无法通过
+		if d := bucket.waitFor(math.Min(float64(need), float64(limit))); d > wait {
+			wait = d
+		}
+	}
+	check(&buckets.requests, limit.RequestsPerMinute, 1)
+	check(&buckets.input, limit.InputTokensPerMinute, inputTokens)
+	check(&buckets.output, limit.OutputTokensPerMinute, 1)
+
+	allowed := wait == 0
+	if allowed {
+		if limit.RequestsPerMinute > 0 {
+			buckets.requests.tokens--
+		}
+		if limit.InputTokensPerMinute > 0 {
+			buckets.input.tokens -= float64(inputTokens)
+		}
+	}
+
+	status := rateLimitStatus{RetryAfter: wait}
+	if limit.RequestsPerMinute > 0 {
+		status.Requests = buckets.requests.state(now)
+	}
+	if limit.InputTokensPerMinute > 0 {
+		status.InputTokens = buckets.input.state(now)
+	}
+	if limit.OutputTokensPerMinute > 0 {
+		status.OutputTokens = buckets.output.state(now)
+	}
+	return status, allowed
+}
+
+// RecordOutput 请求结束后扣除实际输出token
+func (rl *rateLimiter) RecordOutput(keyID string, limit int, outputTokens int) {
+	if limit <= 0 || outputTokens <= 0 {
+		return
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	bucket := &rl.bucketsUnlocked(keyID).output
+	bucket.refill(limit, rl.now())
+	bucket.tokens -= float64(outputTokens)
+}
+
+// setRateLimitHeaders 写入限流响应头
+// 所有端点写入 anthropic-ratelimit-*，OpenAI端点额外写入 x-ratelimit-*
+func setRateLimitHeaders(c *gin.Context, status rateLimitStatus, openAI bool) {
+	setAnthropic := func(name string, state *rateLimitState) {
+		if state == nil {
+			return
+		}
+		prefix := "anthropic-ratelimit-" + name
+		c.Header(prefix+"-limit", strconv.Itoa(state.Limit))
+		c.Header(prefix+"-remaining", strconv.Itoa(state.Remaining))
+		c.Header(prefix+"-reset", state.Reset.UTC().Format(time.RFC3339))
+	}
+	setAnthropic("requests", status.Requests)
+	setAnthropic("tokens", status.Tokens())
+	setAnthropic("input-tokens", status.InputTokens)
+	setAnthropic("output-tokens", status.OutputTokens)
+
+	if !openAI {
+		return
+	}
+	setOpenAI := func(name string, state *rateLimitState) {
+		if state == nil {
+			return
+		}
+		c.Header("x-ratelimit-limit-"+name, strconv.Itoa(state.Limit))
+		c.Header("x-ratelimit-remaining-"+name, strconv.Itoa(state.Remaining))
+		c.Header("x-ratelimit-reset-"+name, formatResetDuration(state.ResetIn))
+	}
+	setOpenAI("requests", status.Requests)
+	setOpenAI("tokens", status.Tokens())
+}
+
+// formatResetDuration 按OpenAI的格式输出重置时间，如 "1s"、"6m0s"
+func formatResetDuration(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// retryAfterSeconds 向上取整的等待秒数，至少为1
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// estimateRequestInputTokens 估算请求的输入token数（Anthropic与OpenAI请求体均可解析）
+func estimateRequestInputTokens(body []byte) int {
+	var req types.AnthropicRequest
+	if err := utils.SafeUnmarshal(body, &req); err != nil {
+		return len(body) / 4
+	}
+	return estimateInputTokens(req)
+}
+
+// checkRateLimit 检查客户端密钥的限流，超限时返回429并写入retry-after
+func checkRateLimit(c *gin.Context, apiKey *webconfig.APIKeyIdentity, body []byte, openAI bool) bool {
+	limit := apiKey.RateLimit
+	if limit == (webconfig.RateLimitConfig{}) {
+		return true
+	}
+
+	inputTokens := 0
+	if limit.InputTokensPerMinute > 0 {
+		inputTokens = estimateRequestInputTokens(body)
+	}
+
+	status, allowed := clientRateLimiter.Allow(apiKey.ID, limit, inputTokens)
+	setRateLimitHeaders(c, status, openAI)
+	if allowed {
+		return true
+	}
+
+	retryAfter := retryAfterSeconds(status.RetryAfter)
+	logger.Warn("客户端请求超出限流",
+		addReqFields(c,
+			logger.Int("input_tokens", inputTokens),
+			logger.Int("retry_after", retryAfter),
+		)...)
+	c.Header("retry-after", strconv.Itoa(retryAfter))
+	respondErrorWithCode(c, http.StatusTooManyRequests, "rate_limit_error",
+		"请求超出API密钥 %s 的限流，请在 %d 秒后重试", apiKey.Name, retryAfter)
+	return false
+}
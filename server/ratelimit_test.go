@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kiro2api/webconfig"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRateLimiter 创建使用可控时钟的限流器
+func newTestRateLimiter() (*rateLimiter, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter()
+	rl.now = func() time.Time { return now }
+	return rl, &now
+}
+
+func TestRateLimiter_Requests(t *testing.T) {
+	rl, now := newTestRateLimiter()
+	limit := webconfig.RateLimitConfig{RequestsPerMinute: 2}
+
+	status, ok := rl.Allow("k1", limit, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 2, status.Requests.Limit)
+	assert.Equal(t, 1, status.Requests.Remaining)
+
+	_, ok = rl.Allow("k1", limit, 0)
+	assert.True(t, ok)
+
+	status, ok = rl.Allow("k1", limit, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, status.RetryAfter)
+	assert.Nil(t, status.InputTokens)
+
+	// 其他密钥不受影响
+	_, ok = rl.Allow("k2", limit, 0)
+	assert.True(t, ok)
+
+	*now = now.Add(30 * time.Second)
+	_, ok = rl.Allow("k1", limit, 0)
+	assert.True(t, ok)
+}
+
+func TestRateLimiter_Tokens(t *testing.T) {
+	rl, now := newTestRateLimiter()
+	limit := webconfig.RateLimitConfig{InputTokensPerMinute: 1000, OutputTokensPerMinute: 600}
+
+	// 超过限额的单次请求在额度补满时允许通过
+	status, ok := rl.Allow("k1", limit, 1500)
+	assert.True(t, ok)
+	assert.Equal(t, 0, status.InputTokens.Remaining)
+
+	status, ok = rl.Allow("k1", limit, 10)
+	assert.False(t, ok)
+	assert.Equal(t, 30600*time.Millisecond, status.RetryAfter)
+
+	// 输出token在请求结束后扣除，透支期间拒绝新请求
+	*now = now.Add(time.Minute)
+	rl.RecordOutput("k1", limit.OutputTokensPerMinute, 900)
+	status, ok = rl.Allow("k1", limit, 10)
+	assert.False(t, ok)
+	assert.Equal(t, 30100*time.Millisecond, status.RetryAfter)
+	assert.Same(t, status.OutputTokens, status.Tokens())
+
+	*now = now.Add(31 * time.Second)
+	_, ok = rl.Allow("k1", limit, 10)
+	assert.True(t, ok)
+}
+
+func TestCheckRateLimit_Headers(t *testing.T) {
+	original := clientRateLimiter
+	clientRateLimiter = newRateLimiter()
+	defer func() { clientRateLimiter = original }()
+
+	apiKey := &webconfig.APIKeyIdentity{
+		ID:        "k1",
+		Name:      "team-a",
+		RateLimit: webconfig.RateLimitConfig{RequestsPerMinute: 1, InputTokensPerMinute: 10000},
+	}
+	body := []byte(`{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"hello"}]}`)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	assert.True(t, checkRateLimit(c, apiKey, body, true))
+	assert.Equal(t, "1", w.Header().Get("anthropic-ratelimit-requests-limit"))
+	assert.Equal(t, "0", w.Header().Get("anthropic-ratelimit-requests-remaining"))
+	assert.NotEmpty(t, w.Header().Get("anthropic-ratelimit-requests-reset"))
+	assert.Equal(t, "10000", w.Header().Get("anthropic-ratelimit-input-tokens-limit"))
+	assert.Equal(t, "10000", w.Header().Get("anthropic-ratelimit-tokens-limit"))
+	assert.Equal(t, "1", w.Header().Get("x-ratelimit-limit-requests"))
+	assert.Equal(t, "1m0s", w.Header().Get("x-ratelimit-reset-requests"))
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	assert.False(t, checkRateLimit(c, apiKey, body, false))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("retry-after"))
+	assert.Contains(t, w.Body.String(), "rate_limit_error")
+	assert.Empty(t, w.Header().Get("x-ratelimit-limit-requests"))
+}
+
+func TestRequestContext_RateLimitRecordsOutput(t *testing.T) {
+	original := clientRateLimiter
+	clientRateLimiter = newRateLimiter()
+	defer func() { clientRateLimiter = original }()
+
+	apiKey := &webconfig.APIKeyIdentity{
+		ID:        "k1",
+		Name:      "team-a",
+		RateLimit: webconfig.RateLimitConfig{OutputTokensPerMinute: 100},
+	}
+	newRequest := func() (*httptest.ResponseRecorder, *RequestContext, *MockAuthService) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"model":"m"}`))
+		c.Set(apiKeyContextKey, apiKey)
+		mockAuth := &MockAuthService{}
+		return w, &RequestContext{GinContext: c, AuthService: mockAuth, RequestType: "Anthropic"}, mockAuth
+	}
+
+	_, reqCtx, _ := newRequest()
+	_, _, err := reqCtx.GetTokenAndBody()
+	assert.NoError(t, err)
+	recordUsage(reqCtx.GinContext, 10, 500, "end_turn")
+	reqCtx.ReleaseToken()
+
+	// 输出token透支后，下一个请求在获取token前被拒绝
+	w, reqCtx, mockAuth := newRequest()
+	_, _, err = reqCtx.GetTokenAndBody()
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, 0, mockAuth.released)
+	assert.Empty(t, mockAuth.affinityKey)
+}
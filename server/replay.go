@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// replayMessageID 重放时使用的固定消息ID，保证输出可与golden比较
+const replayMessageID = "msg_replay"
+
+// replayMaxDiffCells 逐行比较的最大计算量，超出时把差异区间整体输出
+const replayMaxDiffCells = 4_000_000
+
+// ReplayCapture 将捕获的上游原始响应送入当前的解析器和SSE管道，返回下发给客户端的SSE文本
+// 无论原始请求是否流式、来自哪个端点，都按 Anthropic 流式格式输出
+func ReplayCapture(capture *Capture) (string, error) {
+	if capture.Status != http.StatusOK {
+		return "", fmt.Errorf("捕获的上游响应状态码为 %d，没有可重放的事件流", capture.Status)
+	}
+	var anthropicReq types.AnthropicRequest
+	if err := json.Unmarshal(capture.ClientRequest, &anthropicReq); err != nil {
+		return "", fmt.Errorf("解析捕获的客户端请求失败: %w", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	endpoint := capture.Endpoint
+	if !strings.HasPrefix(endpoint, "/") {
+		endpoint = "/v1/messages"
+	}
+	c.Request = httptest.NewRequest(http.MethodPost, endpoint, nil)
+	if capture.RequestID != "" {
+		c.Set("request_id", capture.RequestID)
+	}
+	c.Set("message_id", replayMessageID)
+
+	forwardEventStream(c, anthropicReq, &types.TokenWithUsage{}, &AnthropicStreamSender{}, createAnthropicStreamEvents,
+		replayMessageID, estimateInputTokens(anthropicReq), bytes.NewReader(capture.ResponseBytes))
+	return w.Body.String(), nil
+}
+
+// DiffLines 逐行比较 want 与 got，一致时返回空串
+// 差异以 "-"（仅在 want 中）和 "+"（仅在 got 中）前缀输出，并附带行号
+func DiffLines(want, got string) string {
+	if want == got {
+		return ""
+	}
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// 去掉公共前缀和后缀，只比较中间部分
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a = a[prefix : len(a)-suffix]
+	b = b[prefix : len(b)-suffix]
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "@@ 第 %d 行起 @@\n", prefix+1)
+	if len(a)*len(b) > replayMaxDiffCells {
+		for _, line := range a {
+			out.WriteString("-" + line + "\n")
+		}
+		for _, line := range b {
+			out.WriteString("+" + line + "\n")
+		}
+		return out.String()
+	}
+
+	// 最长公共子序列
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString(" " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventStreamFrame 按 AWS EventStream 格式编码一条事件消息
+func eventStreamFrame(eventType, payload string) []byte {
+	var headers bytes.Buffer
+	for _, h := range [][2]string{
+		{":message-type", "event"},
+		{":event-type", eventType},
+		{":content-type", "application/json"},
+	} {
+		headers.WriteByte(byte(len(h[0])))
+		headers.WriteString(h[0])
+		headers.WriteByte(7) // string
+		_ = binary.Write(&headers, binary.BigEndian, uint16(len(h[1])))
+		headers.WriteString(h[1])
+	}
+
+	total := 12 + headers.Len() + len(payload) + 4
+	var frame bytes.Buffer
+	_ = binary.Write(&frame, binary.BigEndian, uint32(total))
+	_ = binary.Write(&frame, binary.BigEndian, uint32(headers.Len()))
+	_ = binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	frame.Write(headers.Bytes())
+	frame.WriteString(payload)
+	_ = binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	return frame.Bytes()
+}
+
+func TestCapturer_WritesRedactedCapture(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	cp := &capturer{dir: dir}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Set("request_id", "req_1/2")
+
+	upstream := append(eventStreamFrame("assistantResponseEvent", `{"content":"Hi"}`),
+		eventStreamFrame("assistantResponseEvent", `{"content":" there"}`)...)
+	req, _ := http.NewRequest(http.MethodPost, "https://example.invalid", strings.NewReader(`{"profileArn":"arn:aws:secret","conversationState":{"conversationId":"c1"},"n":12345678901234567890}`))
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(upstream))}
+	anthropicReq := types.AnthropicRequest{Model: "claude-sonnet-4", MaxTokens: 100, Stream: true, Metadata: map[string]any{"user_id": "alice@example.com"}}
+
+	// 未标记且未设置采样比例时不捕获
+	cp.wrap(c, anthropicReq, req, resp, true)
+	_, wrapped := resp.Body.(*captureBody)
+	assert.False(t, wrapped)
+
+	c.Request.Header.Set(CaptureHeader, "true")
+	cp.wrap(c, anthropicReq, req, resp, true)
+	_, _ = io.ReadAll(resp.Body)
+	assert.NoError(t, resp.Body.Close())
+	assert.NoError(t, resp.Body.Close()) // 重复关闭只写一次
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0], "_req_1_2.json"))
+
+	raw, _ := os.ReadFile(files[0])
+	assert.NotContains(t, string(raw), "arn:aws:secret")
+	assert.NotContains(t, string(raw), "alice@example.com")
+	assert.Contains(t, string(raw), "12345678901234567890")
+
+	capture, err := LoadCapture(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, CaptureReasonFlagged, capture.Reason)
+	assert.Equal(t, "/v1/messages", capture.Endpoint)
+	assert.Equal(t, upstream, capture.ResponseBytes)
+	assert.False(t, capture.Truncated)
+
+	output, err := ReplayCapture(capture)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "event: message_start")
+	assert.Contains(t, output, `"text":"Hi"`)
+	assert.Contains(t, output, `"text":" there"`)
+	assert.Contains(t, output, "event: message_stop")
+	assert.Contains(t, output, replayMessageID)
+
+	// 重放结果是确定的
+	again, _ := ReplayCapture(capture)
+	assert.Equal(t, output, again)
+}
+
+func TestReplayCapture_RejectsErrorResponse(t *testing.T) {
+	_, err := ReplayCapture(&Capture{Version: captureFileVersion, Status: http.StatusForbidden})
+	assert.Error(t, err)
+}
+
+// TestReplayGoldenCaptures 重放 testdata/captures 下的捕获文件并与同名 .golden 文件比较
+// 更新golden：go run . replay -golden server/testdata/captures/X.golden -update server/testdata/captures/X.json
+func TestReplayGoldenCaptures(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "captures", "*.json"))
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			capture, err := LoadCapture(file)
+			assert.NoError(t, err)
+			output, err := ReplayCapture(capture)
+			assert.NoError(t, err)
+
+			want, err := os.ReadFile(strings.TrimSuffix(file, ".json") + ".golden")
+			assert.NoError(t, err)
+			assert.Empty(t, DiffLines(string(want), output))
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	assert.Empty(t, DiffLines("a\nb\n", "a\nb\n"))
+	assert.Equal(t, "@@ 第 2 行起 @@\n-b\n+x\n c\n+y\n", DiffLines("a\nb\nc\nd", "a\nx\nc\ny\nd"))
+}
@@ -12,6 +12,7 @@ import (
 	"kiro2api/config"
 	"kiro2api/converter"
 	"kiro2api/logger"
+	"kiro2api/metrics"
 	"kiro2api/types"
 	"kiro2api/utils"
 	"kiro2api/webconfig"
@@ -33,14 +34,29 @@ func StartServer(port string, authToken string, authService *auth.AuthService) {
 	r := gin.New()
 
 	// 添加中间件
-	r.Use(gin.Logger())
+	// 结构化访问日志，每个请求一条
+	r.Use(AccessLogMiddleware())
 	r.Use(gin.Recovery())
 	// 注入请求ID，便于日志追踪
 	r.Use(RequestIDMiddleware())
+	// 为请求创建追踪span，沿用客户端的 traceparent
+	r.Use(TracingMiddleware())
+	// 请求计数、延迟和token指标
+	r.Use(MetricsMiddleware())
 	r.Use(corsMiddleware())
+
+	// 存活和就绪探针，无需认证
+	poolState := newPoolStateSource(authService)
+	registerHealthRoutes(r, poolState)
+
 	// 只对 /v1 开头的端点进行认证
 	r.Use(PathBasedAuthMiddleware(authToken, []string{"/v1", "/api/tokens"}))
 
+	// Prometheus 指标，使用独立的令牌认证
+	r.GET("/metrics", handleMetrics(func() string {
+		return os.Getenv(metrics.EnvMetricsToken)
+	}))
+
 	// 静态资源服务 - 前后端完全分离
 	r.Static("/static", "./static")
 	r.GET("/", func(c *gin.Context) {
@@ -50,6 +66,9 @@ func StartServer(port string, authToken string, authService *auth.AuthService) {
 	// API端点 - 纯数据服务
 	// 注意：不在这里添加 /api/tokens，避免与Web配置路由冲突
 
+	// GET /v1/status 端点，只读取token池缓存状态
+	r.GET("/v1/status", handleStatus(poolState))
+
 	// GET /v1/models 端点
 	r.GET("/v1/models", func(c *gin.Context) {
 		// 构建模型列表
@@ -93,6 +112,7 @@ func StartServer(port string, authToken string, authService *auth.AuthService) {
 		if err != nil {
 			return // 错误已在GetTokenAndBody中处理
 		}
+		defer reqCtx.ReleaseToken() // 请求（包括流式响应）结束后释放并发槽位
 
 		// 先解析为通用map以便处理工具格式
 		var rawReq map[string]any
@@ -222,6 +242,7 @@ func StartServer(port string, authToken string, authService *auth.AuthService) {
 		if err != nil {
 			return // 错误已在GetTokenAndBody中处理
 		}
+		defer reqCtx.ReleaseToken() // 请求（包括流式响应）结束后释放并发槽位
 
 		var openaiReq types.OpenAIRequest
 		if err := utils.SafeUnmarshal(body, &openaiReq); err != nil {
@@ -269,6 +290,7 @@ func StartServer(port string, authToken string, authService *auth.AuthService) {
 	logger.Info("  POST /v1/messages               - Anthropic API代理")
 	logger.Info("  POST /v1/messages/count_tokens  - Token计数接口")
 	logger.Info("  POST /v1/chat/completions       - OpenAI API代理")
+	logger.Info("  GET  /metrics                   - Prometheus指标（独立令牌认证）")
 	logger.Info("按Ctrl+C停止服务器")
 
 	// 获取服务器超时配置
@@ -333,21 +355,42 @@ func StartServerWithConfig(port string, authToken string, authService *auth.Auth
 	r := gin.New()
 
 	// 添加中间件
-	r.Use(gin.Logger())
+	// 结构化访问日志，每个请求一条
+	r.Use(AccessLogMiddleware())
 	r.Use(gin.Recovery())
 	// 注入请求ID，便于日志追踪
 	r.Use(RequestIDMiddleware())
+	// 为请求创建追踪span，沿用客户端的 traceparent
+	r.Use(TracingMiddleware())
+	// 请求计数、延迟和token指标
+	r.Use(MetricsMiddleware())
 	r.Use(corsMiddleware())
 
+	// 存活和就绪探针，无需认证
+	poolState := newPoolStateSource(authService)
+	registerHealthRoutes(r, poolState)
+
 	// 设置Web配置管理的路由
 	setupWebConfigRoutes(r, configManager)
 
 	// 只对 /v1 开头的端点进行认证（不包括 /api 路径，因为 /api 路径由 webconfig 自己管理认证）
-	r.Use(PathBasedAuthMiddleware(authToken, []string{"/v1"}))
+	// 客户端Token与 /api/keys 管理的多密钥均可使用，修改后即时生效
+	r.Use(APIKeyAuthMiddleware(configManager, []string{"/v1"}))
+
+	// Prometheus 指标，使用独立的令牌认证（Web配置优先，修改后即时生效）
+	r.GET("/metrics", handleMetrics(func() string {
+		if token := configManager.GetConfig().ServiceConfig.MetricsToken; token != "" {
+			return token
+		}
+		return os.Getenv(metrics.EnvMetricsToken)
+	}))
 
 	// API端点 - 纯数据服务
 	// 注意：不在这里添加 /api/tokens，避免与Web配置路由冲突
 
+	// GET /v1/status 端点，只读取token池缓存状态
+	r.GET("/v1/status", handleStatus(poolState))
+
 	// GET /v1/models 端点
 	r.GET("/v1/models", func(c *gin.Context) {
 		// 构建模型列表
@@ -391,6 +434,7 @@ func StartServerWithConfig(port string, authToken string, authService *auth.Auth
 		if err != nil {
 			return // 错误已在GetTokenAndBody中处理
 		}
+		defer reqCtx.ReleaseToken() // 请求（包括流式响应）结束后释放并发槽位
 
 		// 先解析为通用map以便处理工具格式
 		var rawReq map[string]any
@@ -501,6 +545,7 @@ func StartServerWithConfig(port string, authToken string, authService *auth.Auth
 		if err != nil {
 			return // 错误已在GetTokenAndBody中处理
 		}
+		defer reqCtx.ReleaseToken() // 请求（包括流式响应）结束后释放并发槽位
 
 		var openaiReq types.OpenAIRequest
 		if err := utils.SafeUnmarshal(body, &openaiReq); err != nil {
@@ -533,10 +578,23 @@ func StartServerWithConfig(port string, authToken string, authService *auth.Auth
 	logger.Info("可用端点:")
 	logger.Info("  GET  /                          - Web配置管理页面")
 	logger.Info("  GET  /api/tokens                - Token池状态API")
+	logger.Info("  GET  /api/tokens/concurrency    - Token并发与排队统计")
+	logger.Info("  GET  /api/events              - 实时事件流（SSE）")
+	logger.Info("  GET  /api/requests/active     - 在途请求")
+	logger.Info("  POST /api/requests/cancel     - 取消在途请求")
+	logger.Info("  POST /api/alerts/test         - 发送测试告警")
+	logger.Info("  GET  /api/keys                - 客户端API密钥管理")
+	logger.Info("  GET  /api/usage               - 用量报表（支持CSV导出）")
+	logger.Info("  GET  /api/budgets             - 客户端密钥额度预算与消耗")
+	logger.Info("  GET  /api/admin-tokens        - 管理API令牌（Authorization: Bearer）")
+	logger.Info("  POST /api/sessions/logout-all - 注销全部登录会话")
+	logger.Info("  GET  /api/users               - 控制台用户与角色")
+	logger.Info("  GET  /api/audit               - 配置与Token变更审计日志")
 	logger.Info("  GET  /v1/models                 - 模型列表")
 	logger.Info("  POST /v1/messages               - Anthropic API代理")
 	logger.Info("  POST /v1/messages/count_tokens  - Token计数接口")
 	logger.Info("  POST /v1/chat/completions       - OpenAI API代理")
+	logger.Info("  GET  /metrics                   - Prometheus指标（独立令牌认证）")
 	logger.Info("按Ctrl+C停止服务器")
 
 	// 使用Web配置的超时设置
@@ -583,6 +641,19 @@ func setupWebConfigRoutes(r *gin.Engine, configManager *webconfig.Manager) {
 	r.Any("/api/tokens/refresh-single", gin.WrapH(mux))
 	r.Any("/api/tokens/current", gin.WrapH(mux))
 	r.Any("/api/tokens/switch", gin.WrapH(mux))
+	r.Any("/api/tokens/concurrency", gin.WrapH(mux))
+	r.Any("/api/events", gin.WrapH(mux))
+	r.Any("/api/requests/active", gin.WrapH(mux))
+	r.Any("/api/requests/cancel", gin.WrapH(mux))
+	r.Any("/api/alerts/test", gin.WrapH(mux))
+	r.Any("/api/keys", gin.WrapH(mux))
+	r.Any("/api/keys/regenerate", gin.WrapH(mux))
+	r.Any("/api/usage", gin.WrapH(mux))
+	r.Any("/api/budgets", gin.WrapH(mux))
+	r.Any("/api/admin-tokens", gin.WrapH(mux))
+	r.Any("/api/sessions/logout-all", gin.WrapH(mux))
+	r.Any("/api/users", gin.WrapH(mux))
+	r.Any("/api/audit", gin.WrapH(mux))
 	r.Any("/api/backup", gin.WrapH(mux))
 	r.Any("/api/restore", gin.WrapH(mux))
 	r.Any("/static/*path", gin.WrapH(mux))
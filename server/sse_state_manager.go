@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"kiro2api/logger"
+	"kiro2api/metrics"
 
 	"github.com/gin-gonic/gin"
 )
@@ -48,6 +49,7 @@ func (ssm *SSEStateManager) Reset() {
 func (ssm *SSEStateManager) SendEvent(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	eventType, ok := eventData["type"].(string)
 	if !ok {
+		metrics.IncSSEViolation("invalid_event_type")
 		return errors.New("无效的事件类型")
 	}
 
@@ -82,6 +84,7 @@ func (ssm *SSEStateManager) SendEvent(c *gin.Context, sender StreamEventSender,
 func (ssm *SSEStateManager) handleMessageStart(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if ssm.messageStarted {
 		errMsg := "违规：message_start只能出现一次"
+		metrics.IncSSEViolation("duplicate_message_start")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -97,6 +100,7 @@ func (ssm *SSEStateManager) handleMessageStart(c *gin.Context, sender StreamEven
 func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
 		errMsg := "违规：content_block_start必须在message_start之后"
+		metrics.IncSSEViolation("block_start_before_message_start")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -105,6 +109,7 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 
 	if ssm.messageEnded {
 		errMsg := "违规：message已结束，不能发送content_block_start"
+		metrics.IncSSEViolation("block_start_after_message_stop")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -125,6 +130,7 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 	// 检查是否重复启动同一块
 	if block, exists := ssm.activeBlocks[index]; exists && block.Started && !block.Stopped {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block已经started但未stopped", index)
+		metrics.IncSSEViolation("duplicate_block_start")
 		logger.Error(errMsg, logger.Int("block_index", index))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -177,12 +183,13 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 	}
 
 	// 创建或更新块状态
-	toolUseID := ""
+	toolUseID, toolName := "", ""
 	if blockType == "tool_use" {
 		if contentBlock, ok := eventData["content_block"].(map[string]any); ok {
 			if id, ok := contentBlock["id"].(string); ok {
 				toolUseID = id
 			}
+			toolName, _ = contentBlock["name"].(string)
 		}
 	}
 
@@ -203,6 +210,8 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 		logger.String("type", blockType),
 		logger.String("tool_use_id", toolUseID))
 
+	// 记入在途请求，供 /api/requests/active 查看当前内容块和进行中的工具调用
+	trackBlockStart(c, index, toolUseID, toolName)
 	return sender.SendEvent(c, eventData)
 }
 
@@ -214,6 +223,7 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 			index = int(indexFloat)
 		} else {
 			errMsg := "content_block_delta缺少有效索引"
+			metrics.IncSSEViolation("delta_missing_index")
 			logger.Error(errMsg)
 			if ssm.strictMode {
 				return errors.New(errMsg)
@@ -268,6 +278,7 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 
 	if block != nil && block.Stopped {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block已停止，不能发送delta", index)
+		metrics.IncSSEViolation("delta_after_block_stop")
 		logger.Error(errMsg, logger.Int("block_index", index), logger.Any("eventData", eventData))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -286,6 +297,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 			index = int(indexFloat)
 		} else {
 			errMsg := "content_block_stop缺少有效索引"
+			metrics.IncSSEViolation("stop_missing_index")
 			logger.Error(errMsg)
 			if ssm.strictMode {
 				return errors.New(errMsg)
@@ -298,6 +310,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 	block, exists := ssm.activeBlocks[index]
 	if !exists || !block.Started {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block未启动就发送stop", index)
+		metrics.IncSSEViolation("stop_before_block_start")
 		logger.Error(errMsg, logger.Int("block_index", index))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -307,6 +320,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 
 	if block.Stopped {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block重复停止", index)
+		metrics.IncSSEViolation("duplicate_block_stop")
 		logger.Error(errMsg, logger.Int("block_index", index))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -316,6 +330,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 
 	// 标记为已停止
 	block.Stopped = true
+	trackBlockStop(c, index)
 
 	// logger.Debug("内容块已停止",
 	// 	logger.Int("index", index),
@@ -328,6 +343,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
 		errMsg := "违规：message_delta必须在message_start之后"
+		metrics.IncSSEViolation("message_delta_before_message_start")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -338,6 +354,7 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 	// 根据Claude规范，message_delta在一次消息中只能出现一次
 	if ssm.messageDeltaSent {
 		errMsg := "违规：message_delta只能出现一次"
+		metrics.IncSSEViolation("duplicate_message_delta")
 		logger.Error(errMsg,
 			logger.Bool("message_started", ssm.messageStarted),
 			logger.Bool("message_delta_sent", ssm.messageDeltaSent),
@@ -370,6 +387,7 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 				}
 				sender.SendEvent(c, stopEvent)
 				ssm.activeBlocks[index].Stopped = true
+				trackBlockStop(c, index)
 				logger.Debug("自动关闭未关闭的content_block（message_delta前）", logger.Int("index", index))
 			}
 		}
@@ -386,6 +404,7 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 func (ssm *SSEStateManager) handleMessageStop(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
 		errMsg := "违规：message_stop必须在message_start之后"
+		metrics.IncSSEViolation("message_stop_before_message_start")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -394,6 +413,7 @@ func (ssm *SSEStateManager) handleMessageStop(c *gin.Context, sender StreamEvent
 
 	if ssm.messageEnded {
 		errMsg := "违规：message_stop只能出现一次"
+		metrics.IncSSEViolation("duplicate_message_stop")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
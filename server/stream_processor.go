@@ -9,6 +9,7 @@ import (
 	"kiro2api/config"
 	"kiro2api/logger"
 	"kiro2api/parser"
+	"kiro2api/tracing"
 	"kiro2api/types"
 	"kiro2api/utils"
 
@@ -250,6 +251,8 @@ func (ctx *StreamProcessorContext) sendFinalEvents() error {
 		logger.String("stop_reason_description", GetStopReasonDescription(stopReason)),
 		logger.Int("output_tokens", outputTokens))
 
+	recordUsage(ctx.c, ctx.inputTokens, outputTokens, stopReason)
+
 	// 创建并发送结束事件
 	finalEvents := createAnthropicFinalEvents(outputTokens, ctx.inputTokens, stopReason)
 	for _, event := range finalEvents {
@@ -297,6 +300,25 @@ func NewEventStreamProcessor(ctx *StreamProcessorContext) *EventStreamProcessor
 
 // ProcessEventStream 处理事件流的主循环
 func (esp *EventStreamProcessor) ProcessEventStream(reader io.Reader) error {
+	span, endSpan := startSpan(esp.ctx.c, "ProcessEventStream")
+	defer endSpan()
+
+	err := esp.processEventStream(reader)
+	span.SetAttributes(
+		tracing.Attribute{Key: "stream.read_bytes", Value: esp.ctx.totalReadBytes},
+		tracing.Attribute{Key: "stream.events", Value: esp.ctx.totalProcessedEvents},
+		tracing.Attribute{Key: "stream.output_chars", Value: esp.ctx.totalOutputChars},
+	)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		// 解析错误不中断转发，但仍标记span失败以便排查
+		span.RecordError(esp.ctx.lastParseErr)
+	}
+	return err
+}
+
+func (esp *EventStreamProcessor) processEventStream(reader io.Reader) error {
 	buf := make([]byte, 1024)
 
 	for {
@@ -335,6 +357,9 @@ func (esp *EventStreamProcessor) ProcessEventStream(reader io.Reader) error {
 		}
 
 		if err != nil {
+			if requestCancelled(esp.ctx.c) {
+				return errRequestCancelled
+			}
 			if err == io.EOF {
 				logger.Debug("响应流结束",
 					addReqFields(esp.ctx.c,
@@ -374,6 +399,7 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 	case "content_block_delta":
 		// 直传：不做聚合
 		// 但需要统计输出字符数（在后面统一处理）
+		observeFirstToken(esp.ctx.c)
 
 	case "content_block_stop":
 		esp.ctx.processToolUseStop(dataMap)
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"kiro2api/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TracingMiddleware 为每个请求创建服务端span
+// 客户端携带 W3C traceparent 时沿用其追踪标识，span上下文写入请求上下文供后续步骤创建子span
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if sc, ok := tracing.ParseTraceparent(c.GetHeader(tracing.TraceparentHeader)); ok {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+metricsEndpoint(c),
+			tracing.WithKind(tracing.SpanKindServer),
+			tracing.WithAttributes(
+				tracing.Attribute{Key: "http.request.method", Value: c.Request.Method},
+				tracing.Attribute{Key: "url.path", Value: c.Request.URL.Path},
+				tracing.Attribute{Key: "http.route", Value: c.FullPath()},
+				tracing.Attribute{Key: "request_id", Value: GetRequestID(c)},
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+		endSSESend(c)
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.response.status_code", status)
+		if model := c.GetString(requestModelContextKey); model != "" {
+			span.SetAttribute("gen_ai.request.model", model)
+		}
+		if key := metricsKeyLabel(c); key != "" {
+			span.SetAttribute("api_key", key)
+		}
+		if status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("HTTP %d", status))
+		}
+	}
+}
+
+// startSpan 以请求上下文中的当前span为父创建子span，并把请求上下文切换到新span
+// 之后在该请求上创建的span都成为它的子span；返回的 end 结束span并恢复原来的请求上下文
+func startSpan(c *gin.Context, name string, opts ...tracing.Option) (*tracing.Span, func()) {
+	if c.Request == nil {
+		_, span := tracing.Start(context.Background(), name, opts...)
+		return span, span.End
+	}
+	parent := c.Request.Context()
+	ctx, span := tracing.Start(parent, name, opts...)
+	if span == nil {
+		// 追踪未启用或未采样，不切换请求上下文
+		return nil, func() {}
+	}
+	c.Request = c.Request.WithContext(ctx)
+	return span, func() {
+		span.End()
+		c.Request = c.Request.WithContext(parent)
+	}
+}
+
+// sseSendContextKey gin上下文中SSE发送阶段的键
+const sseSendContextKey = "sse_send"
+
+// sseSend 请求的SSE发送阶段：整个阶段共用一个span，结束时写入事件计数，不为每个事件创建span
+type sseSend struct {
+	span   *tracing.Span
+	events int
+	bytes  int
+	byType map[string]int
+}
+
+// recordSSESend 记录一次SSE事件发送，第一个事件开始发送阶段的span
+// eventType 为空时（OpenAI格式）只计入总数
+func recordSSESend(c *gin.Context, eventType string, payloadSize int, err error) {
+	var phase *sseSend
+	if v, ok := c.Get(sseSendContextKey); ok {
+		phase = v.(*sseSend)
+	} else {
+		ctx := context.Background()
+		if c.Request != nil {
+			ctx = c.Request.Context()
+		}
+		_, span := tracing.Start(ctx, "SSE send")
+		phase = &sseSend{span: span}
+		c.Set(sseSendContextKey, phase)
+	}
+	if phase.span == nil {
+		return
+	}
+
+	if err != nil {
+		phase.span.RecordError(err)
+		return
+	}
+	phase.events++
+	phase.bytes += payloadSize
+	if eventType != "" {
+		if phase.byType == nil {
+			phase.byType = make(map[string]int)
+		}
+		phase.byType[eventType]++
+	}
+}
+
+// endSSESend 结束SSE发送阶段的span，附带事件总数、负载字节数和各类型事件数
+func endSSESend(c *gin.Context) {
+	v, ok := c.Get(sseSendContextKey)
+	if !ok {
+		return
+	}
+	phase := v.(*sseSend)
+	if phase.span == nil {
+		return
+	}
+	phase.span.SetAttributes(
+		tracing.Attribute{Key: "sse.events", Value: phase.events},
+		tracing.Attribute{Key: "sse.payload_size", Value: phase.bytes},
+	)
+	for eventType, count := range phase.byType {
+		phase.span.SetAttribute("sse.events."+eventType, count)
+	}
+	phase.span.End()
+}
+
+// traceFields 返回请求当前span的追踪标识，未创建span时返回空串
+func traceFields(c *gin.Context) (traceID, spanID string) {
+	if c.Request == nil {
+		return "", ""
+	}
+	span := tracing.SpanFromContext(c.Request.Context())
+	if span == nil {
+		return "", ""
+	}
+	sc := span.SpanContext()
+	return sc.TraceID.String(), sc.SpanID.String()
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/logger"
+	"kiro2api/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestCollector 启动接收OTLP JSON的收集器并配置导出器，返回导出器和收到的span名称到属性的映射
+func startTestCollector(t *testing.T) (*tracing.Exporter, chan map[string]map[string]any) {
+	received := make(chan map[string]map[string]any, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name       string `json:"name"`
+						Attributes []struct {
+							Key   string         `json:"key"`
+							Value map[string]any `json:"value"`
+						} `json:"attributes"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		spans := make(map[string]map[string]any)
+		for _, rs := range payload.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					attributes := make(map[string]any)
+					for _, attribute := range span.Attributes {
+						for _, v := range attribute.Value {
+							attributes[attribute.Key] = v
+						}
+					}
+					spans[span.Name] = attributes
+				}
+			}
+		}
+		received <- spans
+	}))
+	t.Cleanup(collector.Close)
+
+	e := tracing.NewExporter(collector.URL+"/v1/traces", nil, "kiro2api-test")
+	tracing.SetExporter(e)
+	t.Cleanup(func() {
+		tracing.SetExporter(nil)
+		e.Shutdown()
+	})
+	return e, received
+}
+
+func TestTracingMiddleware_PropagatesTraceparent(t *testing.T) {
+	startTestCollector(t)
+	var traceID, spanID string
+	var fields []logger.Field
+	r := gin.New()
+	r.Use(RequestIDMiddleware(), TracingMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		span, end := startSpan(c, "child")
+		defer end()
+		traceID, spanID = traceFields(c)
+		assert.Equal(t, span.SpanContext().SpanID.String(), spanID)
+		fields = addReqFields(c)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.NotEqual(t, "00f067aa0ba902b7", spanID)
+	assert.Contains(t, fields, logger.String("trace_id", traceID))
+	assert.Contains(t, fields, logger.String("span_id", spanID))
+}
+
+func TestTracingMiddleware_StartsNewTrace(t *testing.T) {
+	var traceID string
+	r := gin.New()
+	r.Use(TracingMiddleware())
+	r.GET("/v1/models", func(c *gin.Context) {
+		traceID, _ = traceFields(c)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set(tracing.TraceparentHeader, "not-a-traceparent")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Len(t, traceID, 32)
+	assert.NotEqual(t, "00000000000000000000000000000000", traceID)
+}
+
+func TestTracingMiddleware_OneSpanForSSESendPhase(t *testing.T) {
+	e, received := startTestCollector(t)
+	r := gin.New()
+	r.Use(TracingMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		sender := &AnthropicStreamSender{}
+		_ = sender.SendEvent(c, map[string]any{"type": "message_start"})
+		_ = sender.SendEvent(c, map[string]any{"type": "content_block_delta"})
+		_ = sender.SendEvent(c, map[string]any{"type": "content_block_delta"})
+		_ = sender.SendEvent(c, map[string]any{"type": "message_stop"})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	e.Flush()
+
+	spans := <-received
+	assert.Len(t, spans, 2, "服务端span和一个SSE发送阶段span")
+	send := spans["SSE send"]
+	assert.Equal(t, "4", send["sse.events"])
+	assert.Equal(t, "2", send["sse.events.content_block_delta"])
+	assert.Equal(t, "1", send["sse.events.message_stop"])
+}
+
+func TestTracingMiddleware_NoChildSpansWithoutExporter(t *testing.T) {
+	tracing.SetExporter(nil)
+	r := gin.New()
+	r.Use(TracingMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		span, end := startSpan(c, "child")
+		defer end()
+		assert.Nil(t, span)
+		_ = (&AnthropicStreamSender{}).SendEvent(c, map[string]any{"type": "message_start"})
+
+		// 日志仍关联到服务端span
+		traceID, spanID := traceFields(c)
+		assert.Len(t, traceID, 32)
+		assert.Len(t, spanID, 16)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageContextKey gin上下文中请求用量的键
+const usageContextKey = "request_usage"
+
+// requestUsage 一次请求最终下发给客户端的用量
+type requestUsage struct {
+	InputTokens  int
+	OutputTokens int
+	StopReason   string
+}
+
+// recordUsage 在发送最终响应时记录请求用量，供限流等在请求结束后统计
+func recordUsage(c *gin.Context, inputTokens, outputTokens int, stopReason string) {
+	c.Set(usageContextKey, &requestUsage{
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		StopReason:   stopReason,
+	})
+}
+
+// getUsage 读取请求用量，未记录时返回nil
+func getUsage(c *gin.Context) *requestUsage {
+	if v, ok := c.Get(usageContextKey); ok {
+		if usage, ok2 := v.(*requestUsage); ok2 {
+			return usage
+		}
+	}
+	return nil
+}
+
+// estimateInputTokens 估算已转换为Anthropic格式的请求的输入tokens
+func estimateInputTokens(req types.AnthropicRequest) int {
+	return utils.NewTokenEstimator().EstimateTokens(&types.CountTokensRequest{
+		Model:    req.Model,
+		System:   req.System,
+		Messages: req.Messages,
+		Tools:    req.Tools,
+	})
+}
@@ -0,0 +1,349 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// 标准 OpenTelemetry 环境变量
+const (
+	EnvOTLPEndpoint       = "OTEL_EXPORTER_OTLP_ENDPOINT"        // OTLP基础地址，追加 /v1/traces
+	EnvOTLPTracesEndpoint = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT" // 追踪数据的完整地址，优先于基础地址
+	EnvOTLPHeaders        = "OTEL_EXPORTER_OTLP_HEADERS"         // 附加请求头，格式 k1=v1,k2=v2
+	EnvServiceName        = "OTEL_SERVICE_NAME"                  // 服务名，默认 kiro2api
+	EnvSamplerArg         = "OTEL_TRACES_SAMPLER_ARG"            // 新追踪的采样比例（0-1），默认全部采样
+)
+
+const (
+	defaultServiceName = "kiro2api"
+	scopeName          = "kiro2api/tracing"
+	otlpTracesPath     = "/v1/traces"
+)
+
+// Exporter 批量将span以 OTLP/HTTP JSON 格式发送到收集器
+// 队列满时丢弃新的span，导出失败不会重试，不影响请求处理
+type Exporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+
+	queue   chan *Span
+	flushCh chan chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewExporter 创建导出器并启动后台发送协程
+func NewExporter(endpoint string, headers map[string]string, serviceName string) *Exporter {
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	e := &Exporter{
+		endpoint:    endpoint,
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: config.TraceExportTimeout},
+		queue:       make(chan *Span, config.TraceQueueSize),
+		flushCh:     make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Endpoint 返回导出地址
+func (e *Exporter) Endpoint() string {
+	return e.endpoint
+}
+
+func (e *Exporter) enqueue(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		// 队列已满：丢弃，避免阻塞请求
+	}
+}
+
+func (e *Exporter) run() {
+	ticker := time.NewTicker(config.TraceExportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, config.TraceExportBatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "导出追踪数据失败: %v\n", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= config.TraceExportBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-e.flushCh:
+			e.drain(&batch)
+			send()
+			close(ack)
+		case <-e.done:
+			e.drain(&batch)
+			send()
+			return
+		}
+	}
+}
+
+// drain 取出队列中已结束的span
+func (e *Exporter) drain(batch *[]*Span) {
+	for {
+		select {
+		case span := <-e.queue:
+			*batch = append(*batch, span)
+		default:
+			return
+		}
+	}
+}
+
+// Flush 立即发送已结束的span
+func (e *Exporter) Flush() {
+	ack := make(chan struct{})
+	select {
+	case e.flushCh <- ack:
+		<-ack
+	case <-e.done:
+	}
+}
+
+// Shutdown 发送剩余的span并停止后台协程
+func (e *Exporter) Shutdown() {
+	e.once.Do(func() { close(e.done) })
+}
+
+func (e *Exporter) export(spans []*Span) error {
+	body, err := json.Marshal(e.buildRequest(spans))
+	if err != nil {
+		return fmt.Errorf("序列化追踪数据失败: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.TraceExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("收集器返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// OTLP JSON 结构（opentelemetry-proto 的 JSON 映射，ID 使用十六进制，64位整数使用字符串）
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 0未设置 1成功 2失败
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func (e *Exporter) buildRequest(spans []*Span) otlpRequest {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		converted = append(converted, convertSpan(span))
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{keyValue(Attribute{Key: "service.name", Value: e.serviceName})}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: converted}},
+	}}}
+}
+
+func convertSpan(span *Span) otlpSpan {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	out := otlpSpan{
+		TraceID:           span.sc.TraceID.String(),
+		SpanID:            span.sc.SpanID.String(),
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+	}
+	if span.parent.IsValid() {
+		out.ParentSpanID = span.parent.String()
+	}
+	for _, attribute := range span.attributes {
+		out.Attributes = append(out.Attributes, keyValue(attribute))
+	}
+	if span.errMessage != "" {
+		out.Status = otlpStatus{Code: 2, Message: span.errMessage}
+	}
+	return out
+}
+
+func keyValue(attribute Attribute) otlpKeyValue {
+	var value otlpValue
+	switch v := attribute.Value.(type) {
+	case string:
+		value.StringValue = &v
+	case bool:
+		value.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		value.IntValue = &s
+	case float64:
+		value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		value.StringValue = &s
+	}
+	return otlpKeyValue{Key: attribute.Key, Value: value}
+}
+
+var (
+	exporter      *Exporter
+	exporterMutex sync.RWMutex
+	sampleRatio   = 1.0
+)
+
+// SetExporter 设置全局导出器，nil 表示关闭导出（新追踪不再采样）
+func SetExporter(e *Exporter) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	exporter = e
+}
+
+func currentExporter() *Exporter {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	return exporter
+}
+
+// SetSampleRatio 设置新追踪的采样比例，客户端传入的追踪沿用其采样标记
+func SetSampleRatio(ratio float64) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	sampleRatio = math.Max(0, math.Min(1, ratio))
+}
+
+// sampleRoot 决定新追踪是否采样：未配置导出器时不采样，否则按追踪标识的低8字节与比例比较
+func sampleRoot(id TraceID) bool {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	if exporter == nil || sampleRatio <= 0 {
+		return false
+	}
+	if sampleRatio >= 1 {
+		return true
+	}
+	return binary.BigEndian.Uint64(id[8:])>>11 < uint64(sampleRatio*(1<<53))
+}
+
+// InitFromEnv 按 OpenTelemetry 标准环境变量配置导出，未设置导出地址时返回nil（不导出）
+func InitFromEnv() (*Exporter, error) {
+	endpoint := strings.TrimSpace(os.Getenv(EnvOTLPTracesEndpoint))
+	if endpoint == "" {
+		if base := strings.TrimSpace(os.Getenv(EnvOTLPEndpoint)); base != "" {
+			endpoint = strings.TrimRight(base, "/") + otlpTracesPath
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	if arg := strings.TrimSpace(os.Getenv(EnvSamplerArg)); arg != "" {
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s 不是有效的比例: %s", EnvSamplerArg, arg)
+		}
+		SetSampleRatio(ratio)
+	}
+
+	e := NewExporter(endpoint, parseHeaders(os.Getenv(EnvOTLPHeaders)), os.Getenv(EnvServiceName))
+	SetExporter(e)
+	return e, nil
+}
+
+// parseHeaders 解析 k1=v1,k2=v2 格式的请求头
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return headers
+}
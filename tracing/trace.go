@@ -0,0 +1,248 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader W3C Trace Context 请求头
+const TraceparentHeader = "traceparent"
+
+// TraceID 16字节的追踪标识
+type TraceID [16]byte
+
+// SpanID 8字节的span标识
+type SpanID [8]byte
+
+// String 返回小写十六进制表示
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid 全零的追踪标识无效
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// String 返回小写十六进制表示
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid 全零的span标识无效
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext 跨进程传播的span标识
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid 追踪标识和span标识都有效
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent 格式化为 W3C traceparent 请求头的值
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent 解析 W3C traceparent 请求头，格式不合法时返回false
+// 只接受版本 00 的格式；未知的更高版本按 00 的前缀解析（规范要求向前兼容）
+func ParseTraceparent(value string) (SpanContext, bool) {
+	value = strings.TrimSpace(value)
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	if parts[0] != strings.ToLower(parts[0]) || parts[1] != strings.ToLower(parts[1]) || parts[2] != strings.ToLower(parts[2]) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	return sc, true
+}
+
+// SpanKind span的类型，取值与 OTLP 一致
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1 // 进程内部操作
+	SpanKindServer   SpanKind = 2 // 处理客户端请求
+	SpanKindClient   SpanKind = 3 // 调用上游服务
+)
+
+// Attribute span属性，Value 为 string、bool、int、int64 或 float64
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span 一段计时的操作
+// 未采样的span只用于传播标识和日志关联，不会导出
+type Span struct {
+	name   string
+	kind   SpanKind
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mutex      sync.Mutex
+	end        time.Time
+	attributes []Attribute
+	errMessage string
+	ended      bool
+}
+
+// SpanContext 返回span的传播标识
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes 设置span属性
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// SetAttribute 设置单个span属性
+func (s *Span) SetAttribute(key string, value any) {
+	s.SetAttributes(Attribute{Key: key, Value: value})
+}
+
+// RecordError 将span标记为失败，err 为nil时忽略
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil || !s.sc.Sampled {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errMessage = err.Error()
+}
+
+// End 结束span并交给导出器，重复调用只生效一次
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	if s.sc.Sampled {
+		if exporter := currentExporter(); exporter != nil {
+			exporter.enqueue(s)
+		}
+	}
+}
+
+// spanKey 上下文中当前span的键
+type spanKey struct{}
+
+// remoteKey 上下文中从请求头解析出的远端span的键
+type remoteKey struct{}
+
+// ContextWithRemoteSpanContext 将客户端传入的span标识写入上下文，之后创建的span作为其子span
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext 返回上下文中的当前span，不存在时返回nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Option 创建span的选项
+type Option func(*Span)
+
+// WithKind 指定span类型，默认为 SpanKindInternal
+func WithKind(kind SpanKind) Option {
+	return func(s *Span) { s.kind = kind }
+}
+
+// WithAttributes 在创建时设置属性
+func WithAttributes(attributes ...Attribute) Option {
+	return func(s *Span) { s.attributes = append(s.attributes, attributes...) }
+}
+
+// Start 创建子span并返回包含该span的上下文
+// 父span依次取上下文中的当前span和客户端传入的远端span，都没有时开启新的追踪
+// 未配置导出器或所属追踪未采样时，子span不会被导出，直接返回nil span和原上下文（nil span的方法都是空操作）；
+// 日志仍关联到上下文中的父span
+func Start(ctx context.Context, name string, opts ...Option) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent != nil && (!parent.sc.Sampled || currentExporter() == nil) {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: SpanKindInternal, start: time.Now()}
+	if parent != nil {
+		span.sc.TraceID = parent.sc.TraceID
+		span.sc.Sampled = parent.sc.Sampled
+		span.parent = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		span.sc.TraceID = remote.TraceID
+		span.sc.Sampled = remote.Sampled
+		span.parent = remote.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+		span.sc.Sampled = sampleRoot(span.sc.TraceID)
+	}
+	span.sc.SpanID = newSpanID()
+
+	for _, opt := range opts {
+		opt(span)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	sc, ok = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.True(t, ok)
+	assert.False(t, sc.Sampled)
+
+	// 更高版本允许附加字段
+	_, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.True(t, ok)
+
+	for _, invalid := range []string{
+		"",
+		"garbage",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", // 全零追踪标识
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", // 全零span标识
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", // 大写
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		_, ok := ParseTraceparent(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestStart_ParentChild(t *testing.T) {
+	e := NewExporter("http://127.0.0.1:0/v1/traces", nil, "kiro2api-test")
+	SetExporter(e)
+	defer SetExporter(nil)
+	defer e.Shutdown()
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+
+	ctx, server := Start(ctx, "server")
+	_, child := Start(ctx, "child")
+
+	assert.Equal(t, remote.TraceID, server.SpanContext().TraceID)
+	assert.Equal(t, remote.SpanID, server.parent)
+	assert.True(t, server.SpanContext().Sampled)
+	assert.NotEqual(t, remote.SpanID, server.SpanContext().SpanID)
+
+	assert.Equal(t, remote.TraceID, child.SpanContext().TraceID)
+	assert.Equal(t, server.SpanContext().SpanID, child.parent)
+	assert.Same(t, server, SpanFromContext(ctx))
+}
+
+func TestStart_RootNotSampledWithoutExporter(t *testing.T) {
+	SetExporter(nil)
+	_, span := Start(context.Background(), "root")
+
+	assert.True(t, span.SpanContext().IsValid())
+	assert.False(t, span.SpanContext().Sampled)
+	assert.False(t, span.parent.IsValid())
+}
+
+func TestStart_ChildIsNoopWhenNotExported(t *testing.T) {
+	SetExporter(nil)
+	ctx, root := Start(context.Background(), "root")
+	childCtx, child := Start(ctx, "child", WithAttributes(Attribute{Key: "count", Value: 1}))
+
+	// 没有导出器时子span为空操作，上下文仍指向父span，日志关联不受影响
+	assert.Nil(t, child)
+	assert.Same(t, root, SpanFromContext(childCtx))
+	child.SetAttribute("count", 2)
+	child.RecordError(errors.New("boom"))
+	child.End()
+	assert.False(t, child.SpanContext().IsValid())
+
+	// 有导出器但追踪未采样时同样不创建子span
+	e := NewExporter("http://127.0.0.1:0/v1/traces", nil, "kiro2api-test")
+	SetExporter(e)
+	defer SetExporter(nil)
+	defer e.Shutdown()
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, server := Start(ContextWithRemoteSpanContext(context.Background(), remote), "server")
+	assert.False(t, server.SpanContext().Sampled)
+	_, child = Start(ctx, "child")
+	assert.Nil(t, child)
+
+	assert.Zero(t, testing.AllocsPerRun(100, func() { Start(ctx, "child") }))
+}
+
+func TestExporter_SendsOTLPJSON(t *testing.T) {
+	received := make(chan map[string]any, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		received <- payload
+	}))
+	defer collector.Close()
+
+	t.Setenv(EnvOTLPEndpoint, collector.URL+"/")
+	t.Setenv(EnvOTLPHeaders, "Authorization=secret, bad")
+	t.Setenv(EnvServiceName, "kiro2api-test")
+	e, err := InitFromEnv()
+	assert.NoError(t, err)
+	defer SetExporter(nil)
+	defer e.Shutdown()
+	assert.Equal(t, collector.URL+"/v1/traces", e.Endpoint())
+
+	ctx, parent := Start(context.Background(), "GET /v1/models", WithKind(SpanKindServer))
+	_, child := Start(ctx, "child")
+	child.SetAttributes(Attribute{Key: "count", Value: 3}, Attribute{Key: "stream", Value: true})
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End() // 重复结束只导出一次
+	parent.End()
+	e.Flush()
+
+	payload := <-received
+	resourceSpans := payload["resourceSpans"].([]any)[0].(map[string]any)
+	resource := resourceSpans["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	assert.Equal(t, "service.name", resource["key"])
+	assert.Equal(t, "kiro2api-test", resource["value"].(map[string]any)["stringValue"])
+
+	spans := resourceSpans["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	assert.Len(t, spans, 2)
+
+	exported := spans[0].(map[string]any)
+	assert.Equal(t, "child", exported["name"])
+	assert.Equal(t, child.SpanContext().TraceID.String(), exported["traceId"])
+	assert.Equal(t, parent.SpanContext().SpanID.String(), exported["parentSpanId"])
+	assert.Equal(t, float64(SpanKindInternal), exported["kind"])
+	assert.IsType(t, "", exported["startTimeUnixNano"])
+	assert.Equal(t, map[string]any{"code": float64(2), "message": "boom"}, exported["status"])
+	assert.Equal(t, []any{
+		map[string]any{"key": "count", "value": map[string]any{"intValue": "3"}},
+		map[string]any{"key": "stream", "value": map[string]any{"boolValue": true}},
+	}, exported["attributes"])
+
+	root := spans[1].(map[string]any)
+	assert.Equal(t, float64(SpanKindServer), root["kind"])
+	assert.NotContains(t, root, "parentSpanId")
+}
+
+func TestInitFromEnv(t *testing.T) {
+	t.Setenv(EnvOTLPEndpoint, "")
+	t.Setenv(EnvOTLPTracesEndpoint, "")
+	e, err := InitFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, e)
+
+	t.Setenv(EnvOTLPTracesEndpoint, "http://collector:4318/custom")
+	t.Setenv(EnvSamplerArg, "abc")
+	_, err = InitFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv(EnvSamplerArg, "0")
+	e, err = InitFromEnv()
+	assert.NoError(t, err)
+	defer SetSampleRatio(1)
+	defer SetExporter(nil)
+	defer e.Shutdown()
+	assert.Equal(t, "http://collector:4318/custom", e.Endpoint())
+
+	// 比例为0时新追踪不采样
+	_, span := Start(context.Background(), "root")
+	assert.False(t, span.SpanContext().Sampled)
+}
@@ -0,0 +1,23 @@
+package types
+
+// TokenConcurrencyStats token池并发与排队统计
+type TokenConcurrencyStats struct {
+	QueueDepth     int                 `json:"queueDepth"`     // 当前排队请求数
+	QueueCapacity  int                 `json:"queueCapacity"`  // 最大排队请求数
+	QueueTimeoutMs int64               `json:"queueTimeoutMs"` // 排队超时时间(毫秒)
+	TotalQueued    int64               `json:"totalQueued"`    // 累计排队请求数
+	TotalTimeouts  int64               `json:"totalTimeouts"`  // 累计排队超时数
+	TotalRejected  int64               `json:"totalRejected"`  // 队列已满被拒绝的请求数
+	AvgWaitMs      float64             `json:"avgWaitMs"`      // 平均排队等待时间(毫秒)
+	MaxWaitMs      int64               `json:"maxWaitMs"`      // 最长排队等待时间(毫秒)
+	LastWaitMs     int64               `json:"lastWaitMs"`     // 最近一次排队等待时间(毫秒)
+	AffinityCount  int                 `json:"affinityCount"`  // 当前有效的会话亲和绑定数
+	Tokens         []TokenInFlightStat `json:"tokens"`         // 各token在途请求数
+}
+
+// TokenInFlightStat 单个token的在途请求统计
+type TokenInFlightStat struct {
+	Index       int `json:"index"`       // token索引（与当前token索引一致）
+	InFlight    int `json:"inFlight"`    // 在途请求数
+	MaxInFlight int `json:"maxInFlight"` // 最大并发数，0表示不限制
+}
@@ -14,6 +14,15 @@ type Token struct {
 	// API响应字段
 	ExpiresIn  int    `json:"expiresIn,omitempty"`  // 多少秒后失效，来自RefreshResponse
 	ProfileArn string `json:"profileArn,omitempty"` // 来自RefreshResponse
+
+	// 上游配置（区域、端点、客户端指纹），由刷新时的认证配置解析得到
+	Upstream UpstreamProfile `json:"-"`
+
+	// ID 分配该token的配置标识（Web配置中的Token ID，环境变量配置为缓存键），用于用量统计
+	ID string `json:"-"`
+
+	// Index 该token在token池中的位置（与配置顺序一致），用于访问日志
+	Index int `json:"-"`
 }
 
 // FromRefreshResponse 从RefreshResponse创建Token
@@ -30,6 +39,19 @@ func (t *Token) IsExpired() bool {
 	return time.Now().After(t.ExpiresAt)
 }
 
+// ResolvedUpstream 返回该token应使用的上游配置
+// 未携带上游配置时回退到内置默认值；未配置profileArn时使用刷新响应中的profileArn
+func (t *Token) ResolvedUpstream() UpstreamProfile {
+	profile := t.Upstream
+	if profile.IsZero() {
+		profile = profile.Resolve()
+	}
+	if profile.ProfileArn == "" {
+		profile.ProfileArn = t.ProfileArn
+	}
+	return profile
+}
+
 // 兼容性别名 - 逐步迁移时使用
 type TokenInfo = Token // TokenInfo现在是Token的别名
 // RefreshResponse 统一的token刷新响应结构，支持Social和IdC两种认证方式
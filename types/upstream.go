@@ -0,0 +1,128 @@
+package types
+
+import (
+	"fmt"
+
+	"kiro2api/config"
+)
+
+// UpstreamEndpoints 上游端点配置，未设置的端点按区域生成默认值
+type UpstreamEndpoints struct {
+	CodeWhisperer string `json:"codeWhisperer,omitempty"` // generateAssistantResponse 端点
+	UsageLimits   string `json:"usageLimits,omitempty"`   // getUsageLimits 端点
+	SocialRefresh string `json:"socialRefresh,omitempty"` // Social认证刷新端点
+	IdcRefresh    string `json:"idcRefresh,omitempty"`    // IdC认证刷新端点
+}
+
+// ClientFingerprint 客户端指纹，用于生成模拟KiroIDE的请求头
+type ClientFingerprint struct {
+	KiroVersion string `json:"kiroVersion,omitempty"` // KiroIDE版本，如 0.2.13
+	MachineID   string `json:"machineId,omitempty"`   // KiroIDE机器标识哈希
+	OS          string `json:"os,omitempty"`          // 操作系统标识，如 darwin#25.0.0，为空时按API使用默认值
+	NodeVersion string `json:"nodeVersion,omitempty"` // Node.js版本，如 20.16.0
+}
+
+// UpstreamProfile 单个token解析后的完整上游配置
+// 合并顺序：内置默认值 < 全局上游配置 < token级配置
+type UpstreamProfile struct {
+	Region      string            `json:"region,omitempty"`
+	SSORegion   string            `json:"ssoRegion,omitempty"` // IAM Identity Center（SSO OIDC）所在区域，为空时与Region相同
+	ProfileArn  string            `json:"profileArn,omitempty"`
+	Endpoints   UpstreamEndpoints `json:"endpoints"`
+	Fingerprint ClientFingerprint `json:"fingerprint"`
+}
+
+// DefaultUpstreamProfile 返回内置默认的上游配置
+// 注意：端点不在此处填充，由Resolve按最终区域生成
+func DefaultUpstreamProfile() UpstreamProfile {
+	return UpstreamProfile{
+		Region: config.DefaultRegion,
+		Fingerprint: ClientFingerprint{
+			KiroVersion: config.DefaultKiroVersion,
+			MachineID:   config.DefaultMachineID,
+			NodeVersion: config.DefaultNodeVersion,
+		},
+	}
+}
+
+// Merge 使用override中的非空字段覆盖当前配置，返回新配置
+func (p UpstreamProfile) Merge(override UpstreamProfile) UpstreamProfile {
+	merged := p
+	merged.Region = pickString(override.Region, p.Region)
+	merged.SSORegion = pickString(override.SSORegion, p.SSORegion)
+	merged.ProfileArn = pickString(override.ProfileArn, p.ProfileArn)
+
+	merged.Endpoints.CodeWhisperer = pickString(override.Endpoints.CodeWhisperer, p.Endpoints.CodeWhisperer)
+	merged.Endpoints.UsageLimits = pickString(override.Endpoints.UsageLimits, p.Endpoints.UsageLimits)
+	merged.Endpoints.SocialRefresh = pickString(override.Endpoints.SocialRefresh, p.Endpoints.SocialRefresh)
+	merged.Endpoints.IdcRefresh = pickString(override.Endpoints.IdcRefresh, p.Endpoints.IdcRefresh)
+
+	merged.Fingerprint.KiroVersion = pickString(override.Fingerprint.KiroVersion, p.Fingerprint.KiroVersion)
+	merged.Fingerprint.MachineID = pickString(override.Fingerprint.MachineID, p.Fingerprint.MachineID)
+	merged.Fingerprint.OS = pickString(override.Fingerprint.OS, p.Fingerprint.OS)
+	merged.Fingerprint.NodeVersion = pickString(override.Fingerprint.NodeVersion, p.Fingerprint.NodeVersion)
+	return merged
+}
+
+// Resolve 补全缺省值：区域为空时使用默认区域，端点为空时按区域生成
+// IdC刷新端点按SSO区域生成，Identity Center实例与CodeWhisperer可能不在同一区域
+// Social刷新端点不随区域变化，未自定义时使用 us-east-1 的默认地址
+func (p UpstreamProfile) Resolve() UpstreamProfile {
+	resolved := DefaultUpstreamProfile().Merge(p)
+	region := resolved.Region
+
+	if resolved.Endpoints.CodeWhisperer == "" {
+		resolved.Endpoints.CodeWhisperer = fmt.Sprintf(config.CodeWhispererURLFormat, region)
+	}
+	if resolved.Endpoints.UsageLimits == "" {
+		resolved.Endpoints.UsageLimits = fmt.Sprintf(config.UsageLimitsURLFormat, region)
+	}
+	if resolved.Endpoints.SocialRefresh == "" {
+		resolved.Endpoints.SocialRefresh = config.RefreshTokenURL
+	}
+	if resolved.Endpoints.IdcRefresh == "" {
+		resolved.Endpoints.IdcRefresh = fmt.Sprintf(config.IdcRefreshTokenURLFormat, pickString(resolved.SSORegion, region))
+	}
+	return resolved
+}
+
+// IsZero 判断配置是否未解析（用于兼容未携带上游配置的TokenInfo）
+func (p UpstreamProfile) IsZero() bool {
+	return p.Region == "" && p.Endpoints.CodeWhisperer == ""
+}
+
+// KiroTag 返回 KiroIDE-<版本>-<机器标识> 形式的客户端标签
+func (f ClientFingerprint) KiroTag() string {
+	return fmt.Sprintf("KiroIDE-%s-%s", f.KiroVersion, f.MachineID)
+}
+
+// AmzUserAgent 生成 x-amz-user-agent 请求头
+func (f ClientFingerprint) AmzUserAgent(sdkVersion string) string {
+	return fmt.Sprintf("aws-sdk-js/%s %s", sdkVersion, f.KiroTag())
+}
+
+// UserAgent 生成 user-agent 请求头，api 为 codewhispererstreaming 或 codewhispererruntime
+func (f ClientFingerprint) UserAgent(sdkVersion, api string) string {
+	clientOS := f.OS
+	if clientOS == "" {
+		clientOS = defaultClientOS(api)
+	}
+	return fmt.Sprintf("aws-sdk-js/%s ua/2.1 os/%s lang/js md/nodejs#%s api/%s#%s m/E %s",
+		sdkVersion, clientOS, f.NodeVersion, api, sdkVersion, f.KiroTag())
+}
+
+// defaultClientOS 返回未配置操作系统标识时各API使用的默认值
+func defaultClientOS(api string) string {
+	if api == "codewhispererruntime" {
+		return config.DefaultRuntimeClientOS
+	}
+	return config.DefaultStreamingClientOS
+}
+
+// pickString 返回第一个非空字符串
+func pickString(primary, fallback string) string {
+	if primary != "" {
+		return primary
+	}
+	return fallback
+}
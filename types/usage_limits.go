@@ -2,6 +2,7 @@ package types
 
 import (
 	"kiro2api/config"
+	"math"
 	"strings"
 	"time"
 )
@@ -18,6 +19,61 @@ type UsageLimits struct {
 	UsageBreakdown       any              `json:"usageBreakdown"`
 }
 
+// ResetTime 计算额度重置时间，无法确定时返回零值
+// 优先使用CREDIT资源的nextDateReset，其次顶层nextDateReset，最后按daysUntilReset推算（UTC零点）
+func (u *UsageLimits) ResetTime(now time.Time) time.Time {
+	for _, breakdown := range u.UsageBreakdownList {
+		if breakdown.ResourceType == "CREDIT" && breakdown.NextDateReset > 0 {
+			return epochToTime(breakdown.NextDateReset)
+		}
+	}
+
+	if u.NextDateReset > 0 {
+		return epochToTime(u.NextDateReset)
+	}
+
+	if u.DaysUntilReset > 0 {
+		year, month, day := now.UTC().Date()
+		return time.Date(year, month, day+u.DaysUntilReset, 0, 0, 0, 0, time.UTC)
+	}
+
+	return time.Time{}
+}
+
+// ResetCreditLimit 重置后恢复的CREDIT额度（基础额度，不含免费试用）
+func (u *UsageLimits) ResetCreditLimit() float64 {
+	for _, breakdown := range u.UsageBreakdownList {
+		if breakdown.ResourceType == "CREDIT" {
+			return breakdown.UsageLimitWithPrecision
+		}
+	}
+	return 0
+}
+
+// CreditsUsed 当前周期已使用的CREDIT额度（基础额度加生效中的免费试用额度）
+// 与 CalculateAvailableCount 的口径一致，前后两次观测的差值即为期间消耗的额度
+func (u *UsageLimits) CreditsUsed() float64 {
+	for _, breakdown := range u.UsageBreakdownList {
+		if breakdown.ResourceType == "CREDIT" {
+			used := breakdown.CurrentUsageWithPrecision
+			if breakdown.FreeTrialInfo != nil && breakdown.FreeTrialInfo.FreeTrialStatus == "ACTIVE" {
+				used += breakdown.FreeTrialInfo.CurrentUsageWithPrecision
+			}
+			return used
+		}
+	}
+	return 0
+}
+
+// epochToTime 将上游返回的时间戳转换为时间，兼容秒和毫秒两种精度
+func epochToTime(value float64) time.Time {
+	if value > 1e12 {
+		return time.UnixMilli(int64(value))
+	}
+	sec, frac := math.Modf(value)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
 // UsageBreakdown 使用详细信息
 type UsageBreakdown struct {
 	NextDateReset                float64        `json:"nextDateReset"`
@@ -0,0 +1,276 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/webconfig"
+)
+
+// BudgetFileName 预算消耗文件名（保存在用量账本目录中）
+const BudgetFileName = "budgets.json"
+
+// periodSpend 单个周期的额度消耗
+type periodSpend struct {
+	Period    string  `json:"period"`    // 周期标识：日为 YYYY-MM-DD，月为 YYYY-MM
+	Credits   float64 `json:"credits"`   // 已用额度（含估算部分）
+	Estimated float64 `json:"estimated"` // 尚未被Token额度变化确认的估算部分
+}
+
+// keySpend 客户端密钥在当前日、月周期内的消耗
+type keySpend struct {
+	Daily   periodSpend `json:"daily"`
+	Monthly periodSpend `json:"monthly"`
+}
+
+// pendingCharge 等待Token额度变化确认的估算消耗
+type pendingCharge struct {
+	keyID   string
+	day     string
+	month   string
+	credits float64
+}
+
+// BudgetTracker 按客户端密钥统计额度消耗
+// 请求结束时先按token数估算消耗并记到服务该请求的上游Token名下；
+// 观测到该Token的已用额度增加后，按各请求估算值的比例把实际增量分摊给这些密钥，替换估算值
+type BudgetTracker struct {
+	path    string
+	mutex   sync.Mutex
+	spend   map[string]*keySpend       // 密钥ID -> 消耗
+	pending map[string][]pendingCharge // 上游Token ID -> 待确认的估算消耗
+	flush   *time.Timer                // 已安排的写盘
+	now     func() time.Time
+}
+
+// OpenBudgetTracker 打开预算消耗文件，文件不存在时从零开始统计
+func OpenBudgetTracker(path string) (*BudgetTracker, error) {
+	return openBudgetTracker(path, time.Now)
+}
+
+func openBudgetTracker(path string, now func() time.Time) (*BudgetTracker, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("创建预算目录失败: %w", err)
+	}
+
+	b := &BudgetTracker{
+		path:    path,
+		spend:   make(map[string]*keySpend),
+		pending: make(map[string][]pendingCharge),
+		now:     now,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取预算消耗失败: %w", err)
+	}
+	if err := json.Unmarshal(data, &b.spend); err != nil {
+		return nil, fmt.Errorf("解析预算消耗失败: %w", err)
+	}
+	return b, nil
+}
+
+// periodIDs 返回时间所在的日、月周期标识（本地时区）
+func periodIDs(t time.Time) (day, month string) {
+	t = t.In(time.Local)
+	return t.Format(webconfig.UsageDateFormat), t.Format("2006-01")
+}
+
+// periodReset 返回周期的重置时间
+func periodReset(period string, t time.Time) time.Time {
+	t = t.In(time.Local)
+	if period == webconfig.BudgetPeriodMonthly {
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.Local)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.Local)
+}
+
+// EstimateCredits 按输入+输出token数估算一次请求消耗的额度
+func EstimateCredits(inputTokens, outputTokens int) float64 {
+	return math.Max(float64(inputTokens+outputTokens)/config.BudgetTokensPerCredit, config.BudgetMinCreditsPerRequest)
+}
+
+// currentUnlocked 返回密钥在当前周期的消耗，周期切换时清零
+func (b *BudgetTracker) currentUnlocked(keyID string) *keySpend {
+	day, month := periodIDs(b.now())
+	spend, exists := b.spend[keyID]
+	if !exists {
+		spend = &keySpend{}
+		b.spend[keyID] = spend
+	}
+	if spend.Daily.Period != day {
+		spend.Daily = periodSpend{Period: day}
+	}
+	if spend.Monthly.Period != month {
+		spend.Monthly = periodSpend{Period: month}
+	}
+	return spend
+}
+
+// Charge 记录一次请求的估算消耗，tokenID 非空时等待该Token的额度变化确认
+func (b *BudgetTracker) Charge(keyID, tokenID string, credits float64) {
+	if keyID == "" || credits <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	spend := b.currentUnlocked(keyID)
+	spend.Daily.Credits += credits
+	spend.Daily.Estimated += credits
+	spend.Monthly.Credits += credits
+	spend.Monthly.Estimated += credits
+
+	if tokenID != "" {
+		b.pending[tokenID] = append(b.pending[tokenID], pendingCharge{
+			keyID:   keyID,
+			day:     spend.Daily.Period,
+			month:   spend.Monthly.Period,
+			credits: credits,
+		})
+	}
+	b.scheduleFlushUnlocked()
+}
+
+// ObserveCredits 处理观测到的上游Token已用额度变化
+// delta > 0 时按估算比例把实际增量分摊给待确认的请求；delta < 0（额度已重置）时放弃确认，保留估算值
+func (b *BudgetTracker) ObserveCredits(tokenID string, delta float64) {
+	if delta == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	charges := b.pending[tokenID]
+	delete(b.pending, tokenID)
+	if delta < 0 || len(charges) == 0 {
+		return
+	}
+
+	var estimated float64
+	for _, charge := range charges {
+		estimated += charge.credits
+	}
+
+	for _, charge := range charges {
+		actual := delta * charge.credits / estimated
+		spend, exists := b.spend[charge.keyID]
+		if !exists {
+			continue
+		}
+		// 已进入新周期的消耗不再调整
+		if spend.Daily.Period == charge.day {
+			spend.Daily.Credits += actual - charge.credits
+			spend.Daily.Estimated = math.Max(spend.Daily.Estimated-charge.credits, 0)
+		}
+		if spend.Monthly.Period == charge.month {
+			spend.Monthly.Credits += actual - charge.credits
+			spend.Monthly.Estimated = math.Max(spend.Monthly.Estimated-charge.credits, 0)
+		}
+	}
+	b.scheduleFlushUnlocked()
+}
+
+// Status 返回密钥在各周期的预算使用情况
+func (b *BudgetTracker) Status(keyID string, budget webconfig.BudgetConfig) []webconfig.BudgetPeriodStatus {
+	b.mutex.Lock()
+	now := b.now()
+	var spend keySpend
+	if _, exists := b.spend[keyID]; exists {
+		spend = *b.currentUnlocked(keyID)
+	}
+	b.mutex.Unlock()
+
+	statuses := make([]webconfig.BudgetPeriodStatus, 0, len(webconfig.BudgetPeriods))
+	for _, period := range webconfig.BudgetPeriods {
+		current := spend.Daily
+		if period == webconfig.BudgetPeriodMonthly {
+			current = spend.Monthly
+		}
+
+		status := webconfig.BudgetPeriodStatus{
+			Period:    period,
+			Limit:     budget.Limit(period),
+			Spent:     current.Credits,
+			Estimated: current.Estimated,
+			ResetAt:   periodReset(period, now),
+		}
+		if status.Limit > 0 {
+			status.Remaining = math.Max(status.Limit-status.Spent, 0)
+			status.Exhausted = status.Spent >= status.Limit
+			status.Warning = status.Spent >= status.Limit*budget.SoftLimitRatio()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// scheduleFlushUnlocked 安排延迟写盘，合并短时间内的多次更新
+func (b *BudgetTracker) scheduleFlushUnlocked() {
+	if b.flush == nil {
+		b.flush = time.AfterFunc(config.BudgetFlushInterval, func() {
+			if err := b.Flush(); err != nil {
+				logger.Warn("保存预算消耗失败", logger.Err(err))
+			}
+		})
+	}
+}
+
+// Flush 将当前消耗原子写入磁盘
+func (b *BudgetTracker) Flush() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.flush != nil {
+		b.flush.Stop()
+		b.flush = nil
+	}
+	data, err := json.MarshalIndent(b.spend, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempPath := b.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, ledgerFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, b.path)
+}
+
+var (
+	defaultBudgets      *BudgetTracker
+	defaultBudgetsMutex sync.RWMutex
+)
+
+// SetDefaultBudgets 设置全局预算统计，供请求处理和Token刷新使用
+func SetDefaultBudgets(b *BudgetTracker) {
+	defaultBudgetsMutex.Lock()
+	defer defaultBudgetsMutex.Unlock()
+	defaultBudgets = b
+}
+
+// DefaultBudgets 返回全局预算统计，未初始化时返回nil
+func DefaultBudgets() *BudgetTracker {
+	defaultBudgetsMutex.RLock()
+	defer defaultBudgetsMutex.RUnlock()
+	return defaultBudgets
+}
+
+// ObserveCredits 向全局预算统计报告上游Token已用额度的变化
+func ObserveCredits(tokenID string, delta float64) {
+	if b := DefaultBudgets(); b != nil {
+		b.ObserveCredits(tokenID, delta)
+	}
+}
@@ -0,0 +1,113 @@
+package usage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro2api/webconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestBudgets(t *testing.T, path string, now *time.Time) *BudgetTracker {
+	b, err := openBudgetTracker(path, func() time.Time { return *now })
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = b.Flush() })
+	return b
+}
+
+// periodStatus 返回指定周期的状态
+func periodStatus(statuses []webconfig.BudgetPeriodStatus, period string) webconfig.BudgetPeriodStatus {
+	for _, status := range statuses {
+		if status.Period == period {
+			return status
+		}
+	}
+	return webconfig.BudgetPeriodStatus{}
+}
+
+func TestEstimateCredits(t *testing.T) {
+	assert.InDelta(t, 1.5, EstimateCredits(10000, 5000), 1e-9)
+	assert.InDelta(t, 0.01, EstimateCredits(1, 1), 1e-9)
+}
+
+func TestBudgetTracker_ObserveCredits(t *testing.T) {
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	b := openTestBudgets(t, filepath.Join(t.TempDir(), BudgetFileName), &now)
+	budget := webconfig.BudgetConfig{DailyCredits: 10}
+
+	b.Charge("k1", "token_1", 1)
+	b.Charge("k2", "token_1", 3)
+	b.Charge("k1", "token_2", 2)
+
+	daily := periodStatus(b.Status("k1", budget), webconfig.BudgetPeriodDaily)
+	assert.InDelta(t, 3, daily.Spent, 1e-9)
+	assert.InDelta(t, 3, daily.Estimated, 1e-9)
+
+	// 按估算比例分摊token_1的实际额度增量
+	b.ObserveCredits("token_1", 2)
+	daily = periodStatus(b.Status("k1", budget), webconfig.BudgetPeriodDaily)
+	assert.InDelta(t, 2.5, daily.Spent, 1e-9)
+	assert.InDelta(t, 2, daily.Estimated, 1e-9)
+	daily = periodStatus(b.Status("k2", budget), webconfig.BudgetPeriodDaily)
+	assert.InDelta(t, 1.5, daily.Spent, 1e-9)
+	assert.InDelta(t, 0, daily.Estimated, 1e-9)
+
+	// 已确认的消耗不会被再次分摊
+	b.ObserveCredits("token_1", 5)
+	assert.InDelta(t, 1.5, periodStatus(b.Status("k2", budget), webconfig.BudgetPeriodDaily).Spent, 1e-9)
+
+	// 额度重置（已用额度减少）时保留估算值
+	b.ObserveCredits("token_2", -100)
+	b.ObserveCredits("token_2", 10)
+	assert.InDelta(t, 2.5, periodStatus(b.Status("k1", budget), webconfig.BudgetPeriodDaily).Spent, 1e-9)
+}
+
+func TestBudgetTracker_Status(t *testing.T) {
+	now := time.Date(2025, 3, 31, 10, 0, 0, 0, time.Local)
+	b := openTestBudgets(t, filepath.Join(t.TempDir(), BudgetFileName), &now)
+	budget := webconfig.BudgetConfig{DailyCredits: 10, MonthlyCredits: 100, SoftLimitPercent: 50}
+
+	b.Charge("k1", "", 6)
+	statuses := b.Status("k1", budget)
+	daily := periodStatus(statuses, webconfig.BudgetPeriodDaily)
+	assert.True(t, daily.Warning)
+	assert.False(t, daily.Exhausted)
+	assert.InDelta(t, 4, daily.Remaining, 1e-9)
+	assert.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.Local), daily.ResetAt)
+	monthly := periodStatus(statuses, webconfig.BudgetPeriodMonthly)
+	assert.False(t, monthly.Warning)
+	assert.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.Local), monthly.ResetAt)
+
+	b.Charge("k1", "", 4)
+	assert.True(t, periodStatus(b.Status("k1", budget), webconfig.BudgetPeriodDaily).Exhausted)
+
+	// 不限制的周期只统计消耗
+	unlimited := periodStatus(b.Status("k1", webconfig.BudgetConfig{}), webconfig.BudgetPeriodDaily)
+	assert.InDelta(t, 10, unlimited.Spent, 1e-9)
+	assert.False(t, unlimited.Exhausted)
+	assert.Zero(t, unlimited.Remaining)
+
+	// 进入新的一天和新的月份后消耗清零
+	now = now.Add(24 * time.Hour)
+	statuses = b.Status("k1", budget)
+	assert.Zero(t, periodStatus(statuses, webconfig.BudgetPeriodDaily).Spent)
+	assert.Zero(t, periodStatus(statuses, webconfig.BudgetPeriodMonthly).Spent)
+}
+
+func TestBudgetTracker_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), BudgetFileName)
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	b := openTestBudgets(t, path, &now)
+	b.Charge("k1", "token_1", 2)
+	assert.NoError(t, b.Flush())
+
+	reopened := openTestBudgets(t, path, &now)
+	monthly := periodStatus(reopened.Status("k1", webconfig.BudgetConfig{}), webconfig.BudgetPeriodMonthly)
+	assert.InDelta(t, 2, monthly.Spent, 1e-9)
+
+	// 待确认的估算不持久化，重启后保留为估算值
+	reopened.ObserveCredits("token_1", 5)
+	assert.InDelta(t, 2, periodStatus(reopened.Status("k1", webconfig.BudgetConfig{}), webconfig.BudgetPeriodMonthly).Spent, 1e-9)
+}
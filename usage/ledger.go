@@ -0,0 +1,437 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/webconfig"
+)
+
+// EnvLedgerDir 用量账本目录的环境变量
+const EnvLedgerDir = "USAGE_LEDGER_DIR"
+
+// DefaultLedgerDir 默认用量账本目录（与Web配置文件放在一起）
+var DefaultLedgerDir = filepath.Join("webconfig", "data", "usage")
+
+const (
+	ledgerFilePrefix = "ledger-"
+	ledgerFileSuffix = ".jsonl"
+	rollupFilePrefix = "rollup-"
+	rollupFileSuffix = ".json"
+	ledgerFileMode   = 0600
+)
+
+// Entry 一次完成请求的用量记录
+type Entry struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"requestId,omitempty"`
+	KeyID        string    `json:"keyId"`
+	KeyName      string    `json:"keyName"`
+	Model        string    `json:"model"`
+	TokenID      string    `json:"tokenId"`
+	InputTokens  int       `json:"inputTokens"`
+	OutputTokens int       `json:"outputTokens"`
+	LatencyMs    int64     `json:"latencyMs"`
+	StopReason   string    `json:"stopReason,omitempty"`
+	Status       int       `json:"status"`
+}
+
+// rollupKey 每日汇总的最细粒度：日期 + 密钥 + 模型 + Token
+type rollupKey struct {
+	day, keyID, model, tokenID string
+}
+
+// dayRollup 单日汇总
+type dayRollup map[rollupKey]*webconfig.UsageRow
+
+// add 将一条记录计入汇总
+func (d dayRollup) add(day string, e Entry) {
+	key := rollupKey{day: day, keyID: e.KeyID, model: e.Model, tokenID: e.TokenID}
+	row, exists := d[key]
+	if !exists {
+		row = &webconfig.UsageRow{Day: day, KeyID: e.KeyID, Model: e.Model, TokenID: e.TokenID}
+		d[key] = row
+	}
+	row.KeyName = e.KeyName // 密钥改名后以最新名称为准
+	row.Requests++
+	if e.Status >= 400 {
+		row.Errors++
+	}
+	row.InputTokens += int64(e.InputTokens)
+	row.OutputTokens += int64(e.OutputTokens)
+	row.TotalLatencyMs += e.LatencyMs
+}
+
+// rows 返回按维度排序的汇总行
+func (d dayRollup) rows() []webconfig.UsageRow {
+	rows := make([]webconfig.UsageRow, 0, len(d))
+	for _, row := range d {
+		rows = append(rows, *row)
+	}
+	sortRows(rows)
+	return rows
+}
+
+// Ledger 追加写入的本地用量账本
+// 每条记录追加到当日的 ledger-YYYY-MM-DD.jsonl；日期切换后把前一天汇总为 rollup-YYYY-MM-DD.json，
+// 报表查询只读取每日汇总，当日汇总保存在内存中
+type Ledger struct {
+	dir   string
+	mutex sync.Mutex
+	file  *os.File  // 当日账本文件
+	day   string    // 当日日期
+	today dayRollup // 当日汇总
+	now   func() time.Time
+}
+
+// Open 打开用量账本目录，恢复当日汇总并补齐之前缺失的每日汇总
+func Open(dir string) (*Ledger, error) {
+	return open(dir, time.Now)
+}
+
+func open(dir string, now func() time.Time) (*Ledger, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("创建用量账本目录失败: %w", err)
+	}
+
+	l := &Ledger{dir: dir, now: now}
+	l.day = dayOf(l.now())
+
+	today, err := l.readLedger(l.day)
+	if err != nil {
+		return nil, err
+	}
+	l.today = today
+
+	if err := l.rollupPastDays(); err != nil {
+		logger.Warn("补齐用量每日汇总失败", logger.Err(err))
+	}
+
+	return l, nil
+}
+
+// dayOf 返回时间所在的日期（本地时区）
+func dayOf(t time.Time) string {
+	return t.In(time.Local).Format(webconfig.UsageDateFormat)
+}
+
+func (l *Ledger) ledgerPath(day string) string {
+	return filepath.Join(l.dir, ledgerFilePrefix+day+ledgerFileSuffix)
+}
+
+func (l *Ledger) rollupPath(day string) string {
+	return filepath.Join(l.dir, rollupFilePrefix+day+rollupFileSuffix)
+}
+
+// Record 追加一条用量记录并更新当日汇总
+func (l *Ledger) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("序列化用量记录失败: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	day := dayOf(e.Time)
+	if day > l.day {
+		l.rolloverUnlocked(day)
+	}
+	if day < l.day {
+		// 跨日边界完成的请求按开始日期记账：追加到对应日期的账本
+		return l.appendPastUnlocked(day, line)
+	}
+
+	if l.file == nil {
+		if err := l.openFileUnlocked(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("写入用量记录失败: %w", err)
+	}
+	l.today.add(day, e)
+	return nil
+}
+
+// openFileUnlocked 以追加模式打开当日账本文件
+func (l *Ledger) openFileUnlocked() error {
+	file, err := os.OpenFile(l.ledgerPath(l.day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, ledgerFileMode)
+	if err != nil {
+		return fmt.Errorf("打开用量账本失败: %w", err)
+	}
+	l.file = file
+	return nil
+}
+
+// appendPastUnlocked 追加非当日的记录，并删除该日已有的汇总（查询时重新汇总）
+func (l *Ledger) appendPastUnlocked(day string, line []byte) error {
+	file, err := os.OpenFile(l.ledgerPath(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, ledgerFileMode)
+	if err != nil {
+		return fmt.Errorf("打开用量账本失败: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("写入用量记录失败: %w", err)
+	}
+	_ = os.Remove(l.rollupPath(day))
+	return nil
+}
+
+// rolloverUnlocked 切换到新的一天：关闭旧账本并写入前一天的汇总
+func (l *Ledger) rolloverUnlocked(day string) {
+	if l.file != nil {
+		_ = l.file.Close()
+		l.file = nil
+	}
+	if err := l.writeRollup(l.day, l.today); err != nil {
+		logger.Warn("写入用量每日汇总失败", logger.String("day", l.day), logger.Err(err))
+	}
+	l.day = day
+	l.today = make(dayRollup)
+}
+
+// Close 关闭当日账本文件
+func (l *Ledger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// readLedger 读取某一天的账本并汇总，文件不存在时返回空汇总
+func (l *Ledger) readLedger(day string) (dayRollup, error) {
+	rollup := make(dayRollup)
+
+	file, err := os.Open(l.ledgerPath(day))
+	if os.IsNotExist(err) {
+		return rollup, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取用量账本失败: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// 进程崩溃可能留下不完整的最后一行，跳过即可
+			logger.Warn("跳过无法解析的用量记录", logger.String("day", day), logger.Err(err))
+			continue
+		}
+		rollup.add(day, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取用量账本失败: %w", err)
+	}
+	return rollup, nil
+}
+
+// writeRollup 原子写入每日汇总
+func (l *Ledger) writeRollup(day string, rollup dayRollup) error {
+	data, err := json.MarshalIndent(rollup.rows(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := l.rollupPath(day)
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, ledgerFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// readRollup 读取某一天的汇总：优先读取汇总文件，缺失时从账本重新汇总
+func (l *Ledger) readRollup(day string) ([]webconfig.UsageRow, error) {
+	data, err := os.ReadFile(l.rollupPath(day))
+	if err == nil {
+		var rows []webconfig.UsageRow
+		if err := json.Unmarshal(data, &rows); err == nil {
+			return rows, nil
+		}
+	}
+
+	rollup, err := l.readLedger(day)
+	if err != nil {
+		return nil, err
+	}
+	if len(rollup) > 0 {
+		if err := l.writeRollup(day, rollup); err != nil {
+			logger.Warn("写入用量每日汇总失败", logger.String("day", day), logger.Err(err))
+		}
+	}
+	return rollup.rows(), nil
+}
+
+// rollupPastDays 为之前缺少汇总文件的账本生成汇总（服务在跨日期间停止时）
+func (l *Ledger) rollupPastDays() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, ledgerFilePrefix) || !strings.HasSuffix(name, ledgerFileSuffix) {
+			continue
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(name, ledgerFilePrefix), ledgerFileSuffix)
+		if day >= l.day {
+			continue
+		}
+		if _, err := os.Stat(l.rollupPath(day)); err == nil {
+			continue
+		}
+		if _, err := l.readRollup(day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query 按日期范围、过滤条件和分组维度汇总用量
+func (l *Ledger) Query(q webconfig.UsageQuery) ([]webconfig.UsageRow, error) {
+	groupBy := make(map[string]bool, len(q.GroupBy))
+	for _, dim := range q.GroupBy {
+		groupBy[dim] = true
+	}
+
+	grouped := make(map[rollupKey]*webconfig.UsageRow)
+	for date := q.From; !date.After(q.To); date = date.AddDate(0, 0, 1) {
+		day := date.Format(webconfig.UsageDateFormat)
+
+		rows, err := l.dayRows(day)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			if (q.KeyID != "" && row.KeyID != q.KeyID) ||
+				(q.Model != "" && row.Model != q.Model) ||
+				(q.TokenID != "" && row.TokenID != q.TokenID) {
+				continue
+			}
+			mergeRow(grouped, row, groupBy)
+		}
+	}
+
+	result := make([]webconfig.UsageRow, 0, len(grouped))
+	for _, row := range grouped {
+		if row.Requests > 0 {
+			row.AvgLatencyMs = row.TotalLatencyMs / row.Requests
+		}
+		result = append(result, *row)
+	}
+	sortRows(result)
+	return result, nil
+}
+
+// dayRows 返回某一天的汇总行，当日使用内存中的汇总
+func (l *Ledger) dayRows(day string) ([]webconfig.UsageRow, error) {
+	l.mutex.Lock()
+	current := l.day
+	var rows []webconfig.UsageRow
+	if day == current {
+		rows = l.today.rows()
+	}
+	l.mutex.Unlock()
+
+	if day >= current {
+		return rows, nil
+	}
+	return l.readRollup(day)
+}
+
+// mergeRow 按分组维度合并汇总行，未参与分组的维度置空
+func mergeRow(grouped map[rollupKey]*webconfig.UsageRow, row webconfig.UsageRow, groupBy map[string]bool) {
+	var key rollupKey
+	if groupBy[webconfig.UsageGroupDay] {
+		key.day = row.Day
+	}
+	if groupBy[webconfig.UsageGroupKey] {
+		key.keyID = row.KeyID
+	}
+	if groupBy[webconfig.UsageGroupModel] {
+		key.model = row.Model
+	}
+	if groupBy[webconfig.UsageGroupToken] {
+		key.tokenID = row.TokenID
+	}
+
+	target, exists := grouped[key]
+	if !exists {
+		target = &webconfig.UsageRow{Day: key.day, KeyID: key.keyID, Model: key.model, TokenID: key.tokenID}
+		grouped[key] = target
+	}
+	if key.keyID != "" {
+		target.KeyName = row.KeyName
+	}
+	target.Requests += row.Requests
+	target.Errors += row.Errors
+	target.InputTokens += row.InputTokens
+	target.OutputTokens += row.OutputTokens
+	target.TotalLatencyMs += row.TotalLatencyMs
+}
+
+// sortRows 按日期、密钥、模型、Token排序
+func sortRows(rows []webconfig.UsageRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.KeyID != b.KeyID {
+			return a.KeyID < b.KeyID
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.TokenID < b.TokenID
+	})
+}
+
+var (
+	defaultMutex  sync.RWMutex
+	defaultLedger *Ledger
+)
+
+// SetDefault 设置全局用量账本，未设置时 Record 不记录
+func SetDefault(l *Ledger) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultLedger = l
+}
+
+// Record 向全局用量账本追加一条记录（写入失败只记录日志，不影响请求）
+func Record(e Entry) {
+	defaultMutex.RLock()
+	l := defaultLedger
+	defaultMutex.RUnlock()
+
+	if l == nil {
+		return
+	}
+	if err := l.Record(e); err != nil {
+		logger.Warn("记录用量失败", logger.Err(err))
+	}
+}
@@ -0,0 +1,128 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro2api/webconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestLedger 打开使用可控时钟的账本
+func openTestLedger(t *testing.T, dir string, now *time.Time) *Ledger {
+	l, err := open(dir, func() time.Time { return *now })
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+func dayQuery(from, to string, groupBy ...string) webconfig.UsageQuery {
+	fromDay, _ := time.ParseInLocation(webconfig.UsageDateFormat, from, time.Local)
+	toDay, _ := time.ParseInLocation(webconfig.UsageDateFormat, to, time.Local)
+	return webconfig.UsageQuery{From: fromDay, To: toDay, GroupBy: groupBy}
+}
+
+func TestLedger_RecordAndQuery(t *testing.T) {
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	l := openTestLedger(t, t.TempDir(), &now)
+
+	assert.NoError(t, l.Record(Entry{Time: now, KeyID: "k1", KeyName: "team-a", Model: "m1", TokenID: "t1", InputTokens: 10, OutputTokens: 20, LatencyMs: 100, Status: 200}))
+	assert.NoError(t, l.Record(Entry{Time: now, KeyID: "k1", KeyName: "team-a", Model: "m2", TokenID: "t1", InputTokens: 5, LatencyMs: 300, Status: 500}))
+	assert.NoError(t, l.Record(Entry{Time: now, KeyID: "k2", KeyName: "team-b", Model: "m1", TokenID: "t2", InputTokens: 1, OutputTokens: 2, LatencyMs: 50, Status: 200}))
+
+	// 不分组时汇总为一行
+	rows, err := l.Query(dayQuery("2025-03-01", "2025-03-01"))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, int64(3), rows[0].Requests)
+	assert.Equal(t, int64(1), rows[0].Errors)
+	assert.Equal(t, int64(16), rows[0].InputTokens)
+	assert.Equal(t, int64(22), rows[0].OutputTokens)
+	assert.Equal(t, int64(150), rows[0].AvgLatencyMs)
+	assert.Empty(t, rows[0].KeyID)
+
+	rows, err = l.Query(dayQuery("2025-03-01", "2025-03-01", webconfig.UsageGroupKey))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "k1", rows[0].KeyID)
+	assert.Equal(t, "team-a", rows[0].KeyName)
+	assert.Equal(t, int64(2), rows[0].Requests)
+	assert.Empty(t, rows[0].Model)
+
+	// 过滤条件
+	query := dayQuery("2025-03-01", "2025-03-01", webconfig.UsageGroupKey, webconfig.UsageGroupToken)
+	query.Model = "m1"
+	rows, err = l.Query(query)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "t1", rows[0].TokenID)
+	assert.Equal(t, "t2", rows[1].TokenID)
+
+	// 其他日期没有记录
+	rows, err = l.Query(dayQuery("2025-02-01", "2025-02-28"))
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func TestLedger_Rollover(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 3, 1, 23, 59, 0, 0, time.Local)
+	l := openTestLedger(t, dir, &now)
+
+	start := now
+	assert.NoError(t, l.Record(Entry{Time: now, KeyID: "k1", InputTokens: 10, Status: 200}))
+
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, l.Record(Entry{Time: now, KeyID: "k1", InputTokens: 20, Status: 200}))
+	assert.FileExists(t, filepath.Join(dir, "rollup-2025-03-01.json"))
+
+	// 跨日完成的请求按开始日期记账，并使该日汇总失效
+	assert.NoError(t, l.Record(Entry{Time: start, KeyID: "k1", InputTokens: 5, Status: 200}))
+	assert.NoFileExists(t, filepath.Join(dir, "rollup-2025-03-01.json"))
+
+	rows, err := l.Query(dayQuery("2025-03-01", "2025-03-02", webconfig.UsageGroupDay))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "2025-03-01", rows[0].Day)
+	assert.Equal(t, int64(15), rows[0].InputTokens)
+	assert.Equal(t, int64(2), rows[0].Requests)
+	assert.Equal(t, "2025-03-02", rows[1].Day)
+	assert.Equal(t, int64(20), rows[1].InputTokens)
+
+	// 查询时重建汇总
+	assert.FileExists(t, filepath.Join(dir, "rollup-2025-03-01.json"))
+}
+
+func TestLedger_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	l := openTestLedger(t, dir, &now)
+	assert.NoError(t, l.Record(Entry{Time: now, KeyID: "k1", OutputTokens: 7, Status: 200}))
+	assert.NoError(t, l.Close())
+
+	// 进程崩溃留下的不完整记录被跳过
+	file, err := os.OpenFile(filepath.Join(dir, "ledger-2025-03-01.jsonl"), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, _ = file.WriteString(`{"time":"2025-03-01T10:00:01`)
+	_ = file.Close()
+
+	// 同一天重新打开时恢复当日汇总
+	l = openTestLedger(t, dir, &now)
+	rows, err := l.Query(dayQuery("2025-03-01", "2025-03-01"))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, int64(7), rows[0].OutputTokens)
+	assert.NoError(t, l.Close())
+
+	// 跨日后重新打开时补齐前一天的汇总
+	now = now.AddDate(0, 0, 1)
+	l = openTestLedger(t, dir, &now)
+	assert.FileExists(t, filepath.Join(dir, "rollup-2025-03-01.json"))
+	rows, err = l.Query(dayQuery("2025-03-01", "2025-03-02"))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, int64(1), rows[0].Requests)
+}
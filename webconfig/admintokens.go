@@ -0,0 +1,246 @@
+package webconfig
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 管理API令牌的权限范围
+const (
+	AdminScopeRead   = "read"   // 只读：GET 查询 /api/*（不含敏感配置，Token列表中的凭证已脱敏）
+	AdminScopeTokens = "tokens" // Token管理：只读权限加 /api/tokens* 的全部操作
+	AdminScopeAdmin  = "admin"  // 完全管理：所有 /api/* 操作
+)
+
+const (
+	adminTokenSecretPrefix = "kiro-admin-"
+	adminTokenDisplayLen   = len(adminTokenSecretPrefix) + 6
+)
+
+// adminOnlyPaths 只允许完全管理权限访问的路径（包含敏感配置或可修改认证信息）
+var adminOnlyPaths = []string{"/api/config", "/api/admin-tokens", "/api/users", "/api/audit", "/api/backup", "/api/restore"}
+
+var (
+	// ErrAdminTokenInvalid 管理令牌不存在或不匹配
+	ErrAdminTokenInvalid = errors.New("管理令牌无效")
+	// ErrAdminTokenExpired 管理令牌已过期
+	ErrAdminTokenExpired = errors.New("管理令牌已过期")
+)
+
+// AdminToken 管理API令牌，只保存哈希，明文仅在创建时返回一次
+type AdminToken struct {
+	ID        string     `json:"id"`                  // 唯一标识
+	Name      string     `json:"name"`                // 名称（如脚本或系统名称）
+	Prefix    string     `json:"prefix"`              // 令牌前缀，用于识别
+	Hash      string     `json:"hash,omitempty"`      // 令牌的SHA-256哈希
+	Scope     string     `json:"scope"`               // 权限范围，见 AdminScope*
+	CreatedAt time.Time  `json:"createdAt"`           // 创建时间
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // 过期时间，为空表示永不过期
+}
+
+// Redacted 返回不含哈希的副本，用于API响应
+func (t AdminToken) Redacted() AdminToken {
+	t.Hash = ""
+	return t
+}
+
+// Allows 检查令牌的权限范围是否允许访问指定的方法和路径
+func (t AdminToken) Allows(method, path string) bool {
+	switch t.Scope {
+	case AdminScopeAdmin:
+		return true
+	case AdminScopeTokens:
+		if path == "/api/tokens" || strings.HasPrefix(path, "/api/tokens/") {
+			return true
+		}
+		fallthrough
+	case AdminScopeRead:
+		return (method == http.MethodGet || method == http.MethodHead) &&
+			strings.HasPrefix(path, "/api/") && !containsString(adminOnlyPaths, path)
+	}
+	return false
+}
+
+// actor 审计日志中的操作者
+func (t AdminToken) actor() string {
+	return "token:" + t.Name
+}
+
+func (t AdminToken) validate(index int) error {
+	if t.ID == "" {
+		return NewConfigError("管理令牌 #%d: ID不能为空", index+1)
+	}
+	if t.Name == "" {
+		return NewConfigError("管理令牌 #%d: 名称不能为空", index+1)
+	}
+	if t.Hash == "" {
+		return NewConfigError("管理令牌 %s: 缺少令牌哈希", t.Name)
+	}
+	if !validAdminScope(t.Scope) {
+		return NewConfigError("管理令牌 %s: 未知的权限范围: %s", t.Name, t.Scope)
+	}
+	return nil
+}
+
+func validAdminScope(scope string) bool {
+	return scope == AdminScopeRead || scope == AdminScopeTokens || scope == AdminScopeAdmin
+}
+
+// AuthenticateAdminToken 验证管理API令牌
+func (m *Manager) AuthenticateAdminToken(secret string) (*AdminToken, error) {
+	if !strings.HasPrefix(secret, adminTokenSecretPrefix) {
+		return nil, ErrAdminTokenInvalid
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	hash := HashAPIKey(secret)
+	for _, token := range m.config.AdminTokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(token.Hash)) != 1 {
+			continue
+		}
+		if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+			return nil, ErrAdminTokenExpired
+		}
+		return &token, nil
+	}
+	return nil, ErrAdminTokenInvalid
+}
+
+// bearerToken 提取 Authorization: Bearer 请求头中的令牌
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// authenticateBearer 使用管理令牌认证请求，失败时写入错误响应并返回false
+// 被拒绝的请求写入审计日志，令牌发起的修改由各处理函数以具体操作类型记录
+func (m *Manager) authenticateBearer(w http.ResponseWriter, r *http.Request, secret string) (*http.Request, bool) {
+	token, err := m.AuthenticateAdminToken(secret)
+	if err != nil {
+		m.audit(r, AuditActionAdminTokenDenied, r.Method+" "+r.URL.Path, err.Error())
+		m.writeJSONError(w, err.Error(), http.StatusUnauthorized)
+		return r, false
+	}
+
+	r = withAdminActor(r, token.actor())
+	if !token.Allows(r.Method, r.URL.Path) {
+		m.audit(r, AuditActionAdminTokenDenied, r.Method+" "+r.URL.Path, "权限范围不足: "+token.Scope)
+		m.writeJSONError(w, fmt.Sprintf("管理令牌的权限范围(%s)不允许 %s %s", token.Scope, r.Method, r.URL.Path), http.StatusForbidden)
+		return r, false
+	}
+	return r, true
+}
+
+// adminTokenRequest 创建管理令牌的请求
+type adminTokenRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// handleAdminTokens 处理管理API令牌的查询、创建和吊销
+// 令牌明文只在创建时返回一次，之后只保存哈希
+func (m *Manager) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		config := m.GetConfig()
+		tokens := make([]AdminToken, 0, len(config.AdminTokens))
+		for _, token := range config.AdminTokens {
+			tokens = append(tokens, token.Redacted())
+		}
+		m.writeJSONResponse(w, tokens)
+
+	case "POST":
+		var req adminTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			m.writeJSONError(w, "JSON解析失败", http.StatusBadRequest)
+			return
+		}
+
+		if req.Name == "" {
+			m.writeJSONError(w, "管理令牌名称不能为空", http.StatusBadRequest)
+			return
+		}
+		if !validAdminScope(req.Scope) {
+			m.writeJSONError(w, "未知的权限范围: "+req.Scope, http.StatusBadRequest)
+			return
+		}
+
+		secret, err := generateSecret(adminTokenSecretPrefix)
+		if err != nil {
+			m.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		token := AdminToken{
+			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			Name:      req.Name,
+			Prefix:    secret[:adminTokenDisplayLen],
+			Hash:      HashAPIKey(secret),
+			Scope:     req.Scope,
+			CreatedAt: time.Now(),
+			ExpiresAt: req.ExpiresAt,
+		}
+
+		config := m.GetConfig()
+		config.AdminTokens = append(config.AdminTokens, token)
+		if err := m.updateConfigFrom(r, AuditActionAdminTokenCreate, token.Name, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("创建管理令牌失败: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "管理令牌创建成功，请妥善保存，令牌只显示一次",
+			"token":   token.Redacted(),
+			"secret":  secret,
+		})
+
+	case "DELETE":
+		tokenID := r.URL.Query().Get("id")
+		if tokenID == "" {
+			m.writeJSONError(w, "管理令牌ID不能为空", http.StatusBadRequest)
+			return
+		}
+
+		config := m.GetConfig()
+		remaining := make([]AdminToken, 0, len(config.AdminTokens))
+		var revoked *AdminToken
+		for i, token := range config.AdminTokens {
+			if token.ID == tokenID {
+				revoked = &config.AdminTokens[i]
+				continue
+			}
+			remaining = append(remaining, token)
+		}
+
+		if revoked == nil {
+			m.writeJSONError(w, "管理令牌不存在", http.StatusNotFound)
+			return
+		}
+
+		config.AdminTokens = remaining
+		if err := m.updateConfigFrom(r, AuditActionAdminTokenRevoke, revoked.Name, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("吊销管理令牌失败: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "管理令牌已吊销",
+		})
+
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,113 @@
+package webconfig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRefreshToken = "aorAAAAAGjrefresh-secret-value"
+	testClientSecret = "eyJraWQiOiJjbGllbnQtc2VjcmV0"
+)
+
+// newTestManager 创建使用临时目录的配置管理器，不读取工作目录下的配置
+func newTestManager(t *testing.T, config *WebConfig) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	if config == nil {
+		config = GetDefaultConfig()
+	}
+	return &Manager{
+		storage:            &Storage{configPath: filepath.Join(dir, configFileName)},
+		config:             config,
+		sessions:           make(map[string]*Session),
+		tokenCache:         make(map[string]*TokenWithUsageInfo),
+		cacheTime:          time.Now(),
+		minRefreshInterval: time.Hour,
+		loginGuard:         newLoginGuard(),
+		auditLog:           NewAuditLog(filepath.Join(dir, auditDirName)),
+	}
+}
+
+// testConfigWithToken 返回包含一个IdC上游Token的配置
+func testConfigWithToken() *WebConfig {
+	config := GetDefaultConfig()
+	config.ServiceConfig.ClientToken = "client-token-value"
+	config.AuthTokens = []AuthToken{{
+		ID:           "tok-1",
+		Auth:         "IdC",
+		RefreshToken: testRefreshToken,
+		ClientID:     "client-1",
+		ClientSecret: testClientSecret,
+		Enabled:      true,
+	}}
+	return config
+}
+
+// addAdminToken 向配置添加管理令牌，返回令牌明文
+func addAdminToken(config *WebConfig, scope string) string {
+	secret := "kiro-admin-test-" + scope
+	config.AdminTokens = append(config.AdminTokens, AdminToken{
+		ID:     "admin-" + scope,
+		Name:   "test-" + scope,
+		Prefix: secret[:adminTokenDisplayLen],
+		Hash:   HashAPIKey(secret),
+		Scope:  scope,
+	})
+	return secret
+}
+
+func TestAdminToken_Allows(t *testing.T) {
+	tests := []struct {
+		scope  string
+		method string
+		path   string
+		want   bool
+	}{
+		{AdminScopeRead, http.MethodGet, "/api/tokens", true},
+		{AdminScopeRead, http.MethodPost, "/api/tokens", false},
+		{AdminScopeRead, http.MethodGet, "/api/config", false},
+		{AdminScopeRead, http.MethodGet, "/api/admin-tokens", false},
+		{AdminScopeTokens, http.MethodPost, "/api/tokens/refresh", true},
+		{AdminScopeTokens, http.MethodDelete, "/api/tokens", true},
+		{AdminScopeTokens, http.MethodPut, "/api/config", false},
+		{AdminScopeAdmin, http.MethodPut, "/api/config", true},
+		{"unknown", http.MethodGet, "/api/tokens", false},
+	}
+	for _, tt := range tests {
+		token := AdminToken{Scope: tt.scope}
+		assert.Equal(t, tt.want, token.Allows(tt.method, tt.path), "%s %s %s", tt.scope, tt.method, tt.path)
+	}
+}
+
+func TestAdminToken_ReadScopeSeesRedactedCredentials(t *testing.T) {
+	config := testConfigWithToken()
+	secret := addAdminToken(config, AdminScopeRead)
+	m := newTestManager(t, config)
+	mux := http.NewServeMux()
+	m.SetupRoutes(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tokens", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.NotContains(t, body, testRefreshToken)
+	assert.NotContains(t, body, testClientSecret)
+	assert.True(t, strings.Contains(body, maskSecret(testRefreshToken)))
+
+	// 只读令牌不能添加Token
+	req = httptest.NewRequest(http.MethodPost, "/api/tokens", strings.NewReader(`{"auth":"Social","refreshToken":"x"}`))
+	req.Header.Set("Authorization", "Bearer "+secret)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,201 @@
+package webconfig
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// API端点类型（用于限制密钥可访问的接口）
+const (
+	APIEndpointAnthropic = "anthropic" // /v1/messages, /v1/messages/count_tokens
+	APIEndpointOpenAI    = "openai"    // /v1/chat/completions
+)
+
+// DefaultAPIKeyID 使用 ServiceConfig.ClientToken 认证时的密钥标识
+const DefaultAPIKeyID = "default"
+
+const (
+	apiKeySecretPrefix = "sk-kiro-"
+	apiKeySecretBytes  = 24
+	apiKeyDisplayLen   = len(apiKeySecretPrefix) + 6 // 列表中显示的密钥前缀长度
+)
+
+var (
+	// ErrAPIKeyInvalid API密钥不存在或不匹配
+	ErrAPIKeyInvalid = errors.New("API密钥无效")
+	// ErrAPIKeyDisabled API密钥已禁用
+	ErrAPIKeyDisabled = errors.New("API密钥已禁用")
+	// ErrAPIKeyExpired API密钥已过期
+	ErrAPIKeyExpired = errors.New("API密钥已过期")
+)
+
+// APIKey 客户端API密钥，只保存密钥的哈希，明文仅在创建或重新生成时返回一次
+type APIKey struct {
+	ID               string           `json:"id"`                         // 唯一标识
+	Name             string           `json:"name"`                       // 名称（如团队或用户）
+	Prefix           string           `json:"prefix"`                     // 密钥前缀，用于识别
+	Hash             string           `json:"hash,omitempty"`             // 密钥的SHA-256哈希
+	CreatedAt        time.Time        `json:"createdAt"`                  // 创建时间
+	ExpiresAt        *time.Time       `json:"expiresAt,omitempty"`        // 过期时间，为空表示永不过期
+	Enabled          bool             `json:"enabled"`                    // 是否启用
+	AllowedModels    []string         `json:"allowedModels,omitempty"`    // 允许的模型，为空表示全部
+	AllowedEndpoints []string         `json:"allowedEndpoints,omitempty"` // 允许的端点类型，为空表示全部
+	TokenGroup       string           `json:"tokenGroup,omitempty"`       // 绑定的Token分组，为空表示使用全部Token
+	RateLimit        *RateLimitConfig `json:"rateLimit,omitempty"`        // 密钥限流，为空表示使用默认限流
+	Budget           *BudgetConfig    `json:"budget,omitempty"`           // 额度预算，为空表示不限制
+}
+
+// APIKeyIdentity 已认证的API密钥身份，注入到请求上下文供日志和计费使用
+type APIKeyIdentity struct {
+	ID               string          `json:"id"`
+	Name             string          `json:"name"`
+	AllowedModels    []string        `json:"allowedModels,omitempty"`
+	AllowedEndpoints []string        `json:"allowedEndpoints,omitempty"`
+	TokenGroup       string          `json:"tokenGroup,omitempty"`
+	RateLimit        RateLimitConfig `json:"rateLimit"` // 生效的限流配置
+	Budget           BudgetConfig    `json:"budget"`    // 额度预算
+}
+
+// AllowsModel 检查密钥是否允许使用指定模型
+func (k *APIKeyIdentity) AllowsModel(model string) bool {
+	return len(k.AllowedModels) == 0 || containsString(k.AllowedModels, model)
+}
+
+// AllowsEndpoint 检查密钥是否允许访问指定端点类型
+func (k *APIKeyIdentity) AllowsEndpoint(endpoint string) bool {
+	return len(k.AllowedEndpoints) == 0 || containsString(k.AllowedEndpoints, endpoint)
+}
+
+// identity 转换为请求上下文中的身份信息，未单独设置限流时使用默认限流
+func (k *APIKey) identity(defaultLimit RateLimitConfig) *APIKeyIdentity {
+	rateLimit := defaultLimit
+	if k.RateLimit != nil {
+		rateLimit = *k.RateLimit
+	}
+	var budget BudgetConfig
+	if k.Budget != nil {
+		budget = *k.Budget
+	}
+	return &APIKeyIdentity{
+		ID:               k.ID,
+		Name:             k.Name,
+		AllowedModels:    append([]string(nil), k.AllowedModels...),
+		AllowedEndpoints: append([]string(nil), k.AllowedEndpoints...),
+		TokenGroup:       k.TokenGroup,
+		RateLimit:        rateLimit,
+		Budget:           budget,
+	}
+}
+
+// Redacted 返回不含哈希的副本，用于API响应
+func (k APIKey) Redacted() APIKey {
+	k.Hash = ""
+	return k
+}
+
+// GenerateAPIKeySecret 生成新的API密钥明文
+func GenerateAPIKeySecret() (string, error) {
+	return generateSecret(apiKeySecretPrefix)
+}
+
+// generateSecret 生成带前缀的高熵随机密钥
+func generateSecret(prefix string) (string, error) {
+	buf := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成密钥失败: %w", err)
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+// HashAPIKey 计算API密钥的哈希（密钥为高熵随机值，SHA-256即可）
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKeySecret 生成密钥明文并填充密钥的哈希和前缀
+func (k *APIKey) newAPIKeySecret() (string, error) {
+	secret, err := GenerateAPIKeySecret()
+	if err != nil {
+		return "", err
+	}
+	k.Hash = HashAPIKey(secret)
+	k.Prefix = secret[:apiKeyDisplayLen]
+	return secret, nil
+}
+
+// AuthenticateAPIKey 验证客户端提供的API密钥并返回其身份
+// ServiceConfig.ClientToken 作为默认密钥继续有效，不受模型和端点限制
+func (m *Manager) AuthenticateAPIKey(secret string) (*APIKeyIdentity, error) {
+	if secret == "" {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	clientToken := m.config.ServiceConfig.ClientToken
+	if clientToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(clientToken)) == 1 {
+		return &APIKeyIdentity{ID: DefaultAPIKeyID, Name: DefaultAPIKeyID, RateLimit: m.config.RateLimitConfig}, nil
+	}
+
+	hash := HashAPIKey(secret)
+	for i := range m.config.APIKeys {
+		key := &m.config.APIKeys[i]
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(key.Hash)) != 1 {
+			continue
+		}
+		if !key.Enabled {
+			return nil, ErrAPIKeyDisabled
+		}
+		if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+			return nil, ErrAPIKeyExpired
+		}
+		return key.identity(m.config.RateLimitConfig), nil
+	}
+
+	return nil, ErrAPIKeyInvalid
+}
+
+// validate 验证API密钥配置
+func (k APIKey) validate(index int) error {
+	if k.ID == "" {
+		return NewConfigError("API密钥 #%d: ID不能为空", index+1)
+	}
+	if k.Name == "" {
+		return NewConfigError("API密钥 #%d: 名称不能为空", index+1)
+	}
+	if k.Hash == "" {
+		return NewConfigError("API密钥 %s: 缺少密钥哈希", k.Name)
+	}
+	if k.RateLimit != nil {
+		if err := k.RateLimit.validate("API密钥 " + k.Name); err != nil {
+			return err
+		}
+	}
+	if k.Budget != nil {
+		if err := k.Budget.validate("API密钥 " + k.Name); err != nil {
+			return err
+		}
+	}
+	for _, endpoint := range k.AllowedEndpoints {
+		if endpoint != APIEndpointAnthropic && endpoint != APIEndpointOpenAI {
+			return NewConfigError("API密钥 %s: 未知的端点类型: %s", k.Name, endpoint)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,448 @@
+package webconfig
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+const (
+	auditDirName       = "audit"     // 审计日志目录（位于配置目录中）
+	auditFilePrefix    = "audit-"    // 按日切分的审计日志文件前缀，如 audit-2024-01-02.jsonl
+	auditFileSuffix    = ".jsonl"    // 审计日志文件后缀
+	legacyAuditLogName = "audit.log" // 旧版本的单文件审计日志
+)
+
+// 审计操作类型
+const (
+	AuditActionAdminTokenCreate = "admin_token.create"   // 创建管理令牌
+	AuditActionAdminTokenRevoke = "admin_token.revoke"   // 吊销管理令牌
+	AuditActionAdminTokenDenied = "admin_token.denied"   // 管理令牌认证失败或权限不足
+	AuditActionLoginSuccess     = "login.success"        // 登录成功
+	AuditActionLoginFailure     = "login.failure"        // 登录密码错误
+	AuditActionLoginLocked      = "login.locked"         // 登录因失败次数过多被锁定
+	AuditActionSessionLogoutAll = "session.logout_all"   // 注销全部会话
+	AuditActionCSRFRejected     = "csrf.rejected"        // 缺少或错误的CSRF令牌
+	AuditActionRoleDenied       = "role.denied"          // 控制台用户的角色权限不足
+	AuditActionUserCreate       = "user.create"          // 创建控制台用户
+	AuditActionUserUpdate       = "user.update"          // 修改控制台用户
+	AuditActionUserDelete       = "user.delete"          // 删除控制台用户
+	AuditActionConfigInit       = "config.init"          // 首次初始化配置
+	AuditActionConfigUpdate     = "config.update"        // 修改配置
+	AuditActionConfigRestore    = "config.restore"       // 从备份恢复配置
+	AuditActionConfigBackup     = "config.backup"        // 创建配置备份
+	AuditActionTokenCreate      = "token.create"         // 添加Token
+	AuditActionTokenUpdate      = "token.update"         // 修改Token（含启用/禁用）
+	AuditActionTokenDelete      = "token.delete"         // 删除Token
+	AuditActionTokenSwitch      = "token.switch"         // 手动切换当前Token
+	AuditActionTokenRefresh     = "token.refresh"        // 手动刷新Token信息
+	AuditActionTokenRefreshFail = "token.refresh_failed" // 后台刷新Token失败
+	AuditActionKeyCreate        = "key.create"           // 创建API密钥
+	AuditActionKeyUpdate        = "key.update"           // 修改API密钥
+	AuditActionKeyDelete        = "key.delete"           // 删除API密钥
+	AuditActionKeyRegenerate    = "key.regenerate"       // 重新生成API密钥
+	AuditActionRequestCancel    = "request.cancel"       // 取消在途请求
+)
+
+// AuditEntry 一条审计记录
+type AuditEntry struct {
+	Time       time.Time      `json:"time"`
+	Actor      string         `json:"actor"`            // 操作者，如 user:<用户名>、token:<名称> 或 system
+	Action     string         `json:"action"`           // 操作类型，见 AuditAction*
+	Target     string         `json:"target,omitempty"` // 操作对象
+	Detail     string         `json:"detail,omitempty"` // 补充说明
+	RemoteAddr string         `json:"remoteAddr,omitempty"`
+	Changes    []ConfigChange `json:"changes,omitempty"` // 配置变更前后的差异（敏感字段已脱敏）
+}
+
+// AuditQuery 审计日志查询条件，字符串条件为空表示不过滤
+type AuditQuery struct {
+	From   time.Time // 起始日期（含，本地时区）
+	To     time.Time // 结束日期（含，本地时区）
+	Actor  string    // 操作者，精确匹配
+	Action string    // 操作类型，精确匹配；以 . 结尾时按前缀匹配，如 token.
+	Target string    // 操作对象，包含匹配
+	IP     string    // 来源IP，精确匹配
+	Limit  int       // 最多返回的记录数
+}
+
+// matches 检查记录是否满足查询条件（日期范围由文件选择保证）
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	if q.Actor != "" && entry.Actor != q.Actor {
+		return false
+	}
+	if q.Action != "" {
+		if strings.HasSuffix(q.Action, ".") {
+			if !strings.HasPrefix(entry.Action, q.Action) {
+				return false
+			}
+		} else if entry.Action != q.Action {
+			return false
+		}
+	}
+	if q.Target != "" && !strings.Contains(entry.Target, q.Target) {
+		return false
+	}
+	if q.IP != "" && remoteHost(entry.RemoteAddr) != q.IP {
+		return false
+	}
+	return true
+}
+
+// AuditLog 按日切分、只追加写入的审计日志
+// 每天一个 JSONL 文件，超过保留天数的文件整体删除，已写入的记录不会被修改
+type AuditLog struct {
+	dir           string
+	retentionDays int // 保留天数，0表示使用默认值
+	mutex         sync.Mutex
+	prunedDay     string // 最近一次清理过期文件的日期
+	now           func() time.Time
+}
+
+// NewAuditLog 创建审计日志
+func NewAuditLog(dir string) *AuditLog {
+	return &AuditLog{dir: dir, now: time.Now}
+}
+
+// SetRetentionDays 设置保留天数（0表示使用默认值），缩短后在下次写入时清理
+func (a *AuditLog) SetRetentionDays(days int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.retentionDays != days {
+		a.retentionDays = days
+		a.prunedDay = ""
+	}
+}
+
+// auditFilePath 返回指定日期的审计日志文件路径
+func (a *AuditLog) auditFilePath(day string) string {
+	return filepath.Join(a.dir, auditFilePrefix+day+auditFileSuffix)
+}
+
+// Append 追加一条审计记录
+func (a *AuditLog) Append(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = a.now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("序列化审计记录失败: %w", err)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return fmt.Errorf("创建审计日志目录失败: %w", err)
+	}
+
+	day := entry.Time.In(time.Local).Format(UsageDateFormat)
+	if a.prunedDay != day {
+		a.pruneUnlocked(entry.Time)
+		a.prunedDay = day
+	}
+
+	file, err := os.OpenFile(a.auditFilePath(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, configFileMode)
+	if err != nil {
+		return fmt.Errorf("打开审计日志失败: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("写入审计日志失败: %w", err)
+	}
+	return nil
+}
+
+// retentionUnlocked 返回生效的保留天数
+func (a *AuditLog) retentionUnlocked() int {
+	if a.retentionDays > 0 {
+		return a.retentionDays
+	}
+	return config.AuditRetentionDays
+}
+
+// Retention 返回生效的保留天数
+func (a *AuditLog) Retention() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.retentionUnlocked()
+}
+
+// pruneUnlocked 删除超过保留天数的审计日志文件
+func (a *AuditLog) pruneUnlocked(now time.Time) {
+	days, err := a.listDays()
+	if err != nil {
+		fmt.Printf("清理审计日志失败: %v\n", err)
+		return
+	}
+
+	cutoff := now.In(time.Local).AddDate(0, 0, -a.retentionUnlocked()+1).Format(UsageDateFormat)
+	for _, day := range days {
+		if day >= cutoff {
+			continue
+		}
+		if err := os.Remove(a.auditFilePath(day)); err != nil {
+			fmt.Printf("删除过期审计日志失败: %v\n", err)
+		}
+	}
+}
+
+// listDays 返回已有审计日志文件的日期，按时间升序
+func (a *AuditLog) listDays() ([]string, error) {
+	entries, err := os.ReadDir(a.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, auditFilePrefix) || !strings.HasSuffix(name, auditFileSuffix) {
+			continue
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(name, auditFilePrefix), auditFileSuffix)
+		if _, err := time.Parse(UsageDateFormat, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// Query 按条件查询审计记录，按时间倒序返回最近的 Limit 条
+func (a *AuditLog) Query(query AuditQuery) ([]AuditEntry, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	days, err := a.listDays()
+	if err != nil {
+		return nil, fmt.Errorf("读取审计日志目录失败: %w", err)
+	}
+
+	from, to := query.From.Format(UsageDateFormat), query.To.Format(UsageDateFormat)
+	results := make([]AuditEntry, 0)
+	for i := len(days) - 1; i >= 0 && len(results) < query.Limit; i-- {
+		if days[i] < from || days[i] > to {
+			continue
+		}
+		entries, err := readAuditFile(a.auditFilePath(days[i]))
+		if err != nil {
+			return nil, err
+		}
+		for j := len(entries) - 1; j >= 0 && len(results) < query.Limit; j-- {
+			if query.matches(entries[j]) {
+				results = append(results, entries[j])
+			}
+		}
+	}
+	return results, nil
+}
+
+// readAuditFile 读取一个审计日志文件，跳过无法解析的行（如写入中断留下的半行）
+func readAuditFile(path string) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开审计日志失败: %w", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取审计日志失败: %w", err)
+	}
+	return entries, nil
+}
+
+// migrateLegacyAuditLog 将旧版本的单文件审计日志按记录日期拆分到按日切分的目录，完成后删除旧文件
+func migrateLegacyAuditLog(dataDir, auditDir string) {
+	legacyPath := filepath.Join(dataDir, legacyAuditLogName)
+	if _, err := os.Stat(legacyPath); err != nil {
+		return
+	}
+
+	entries, err := readAuditFile(legacyPath)
+	if err != nil {
+		fmt.Printf("迁移审计日志失败: %v\n", err)
+		return
+	}
+
+	// 预先标记为已清理，迁移时不按保留天数删除旧记录
+	auditLog := NewAuditLog(auditDir)
+	for _, entry := range entries {
+		auditLog.prunedDay = entry.Time.In(time.Local).Format(UsageDateFormat)
+		if err := auditLog.Append(entry); err != nil {
+			fmt.Printf("迁移审计日志失败: %v\n", err)
+			return
+		}
+	}
+	if err := os.Remove(legacyPath); err != nil {
+		fmt.Printf("删除旧审计日志失败: %v\n", err)
+	}
+}
+
+var (
+	defaultAuditLog      *AuditLog
+	defaultAuditLogMutex sync.RWMutex
+)
+
+// AuditLog 返回管理器的审计日志
+func (m *Manager) AuditLog() *AuditLog {
+	return m.auditLog
+}
+
+// SetDefaultAuditLog 设置全局审计日志，供后台任务（如Token刷新）记录系统操作
+func SetDefaultAuditLog(a *AuditLog) {
+	defaultAuditLogMutex.Lock()
+	defer defaultAuditLogMutex.Unlock()
+	defaultAuditLog = a
+}
+
+// RecordSystemAudit 以 system 身份记录一条审计日志，未设置全局审计日志时忽略
+func RecordSystemAudit(action, target, detail string) {
+	defaultAuditLogMutex.RLock()
+	a := defaultAuditLog
+	defaultAuditLogMutex.RUnlock()
+	if a == nil {
+		return
+	}
+	if err := a.Append(AuditEntry{Actor: auditActorSystem, Action: action, Target: target, Detail: detail}); err != nil {
+		fmt.Printf("写入审计日志失败: %v\n", err)
+	}
+}
+
+// 审计日志中的特殊操作者
+const (
+	auditActorAnonymous = "anonymous" // 未认证的请求
+	auditActorSystem    = "system"    // 后台任务或启动流程
+)
+
+// adminActorKey 请求上下文中操作者的键
+type adminActorKey struct{}
+
+// withAdminActor 将已认证的操作者写入请求上下文
+func withAdminActor(r *http.Request, actor string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminActorKey{}, actor))
+}
+
+// adminActor 返回请求的操作者
+func adminActor(r *http.Request) string {
+	if actor, ok := r.Context().Value(adminActorKey{}).(string); ok {
+		return actor
+	}
+	return auditActorAnonymous
+}
+
+// remoteHost 返回 RemoteAddr 中的IP部分
+func remoteHost(remoteAddr string) string {
+	if i := strings.LastIndex(remoteAddr, ":"); i > 0 {
+		return strings.Trim(remoteAddr[:i], "[]")
+	}
+	return remoteAddr
+}
+
+// audit 记录一条审计日志，写入失败不影响请求
+func (m *Manager) audit(r *http.Request, action, target, detail string) {
+	m.recordAudit(r, AuditEntry{Action: action, Target: target, Detail: detail})
+}
+
+// recordAudit 补全操作者和来源后写入审计日志，r 为nil时记为 system
+func (m *Manager) recordAudit(r *http.Request, entry AuditEntry) {
+	if m.auditLog == nil {
+		return
+	}
+	entry.Actor = auditActorSystem
+	if r != nil {
+		entry.Actor = adminActor(r)
+		entry.RemoteAddr = r.RemoteAddr
+	}
+	if err := m.auditLog.Append(entry); err != nil {
+		fmt.Printf("写入审计日志失败: %v\n", err)
+	}
+}
+
+// handleAudit 查询审计日志
+// 支持 from/to（YYYY-MM-DD，默认最近7天）、actor、action（以 . 结尾按前缀匹配）、target、ip、limit
+func (m *Manager) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	values := r.URL.Query()
+	query := AuditQuery{
+		Actor:  values.Get("actor"),
+		Action: values.Get("action"),
+		Target: values.Get("target"),
+		IP:     values.Get("ip"),
+		Limit:  config.AuditQueryDefaultLimit,
+	}
+
+	today, _ := time.ParseInLocation(UsageDateFormat, time.Now().Format(UsageDateFormat), time.Local)
+	query.To = today
+	if to := values.Get("to"); to != "" {
+		parsed, err := time.ParseInLocation(UsageDateFormat, to, time.Local)
+		if err != nil {
+			m.writeJSONError(w, "无效的结束日期: "+to, http.StatusBadRequest)
+			return
+		}
+		query.To = parsed
+	}
+	query.From = query.To.AddDate(0, 0, -6)
+	if from := values.Get("from"); from != "" {
+		parsed, err := time.ParseInLocation(UsageDateFormat, from, time.Local)
+		if err != nil {
+			m.writeJSONError(w, "无效的起始日期: "+from, http.StatusBadRequest)
+			return
+		}
+		query.From = parsed
+	}
+	if query.From.After(query.To) {
+		m.writeJSONError(w, "起始日期不能晚于结束日期", http.StatusBadRequest)
+		return
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			m.writeJSONError(w, "无效的limit: "+limit, http.StatusBadRequest)
+			return
+		}
+		query.Limit = min(parsed, config.AuditQueryMaxLimit)
+	}
+
+	entries, err := m.auditLog.Query(query)
+	if err != nil {
+		m.writeJSONError(w, "查询审计日志失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.writeJSONResponse(w, map[string]interface{}{
+		"from":          query.From.Format(UsageDateFormat),
+		"to":            query.To.Format(UsageDateFormat),
+		"retentionDays": m.auditLog.Retention(),
+		"entries":       entries,
+	})
+}
@@ -0,0 +1,120 @@
+package webconfig
+
+import (
+	"net/http"
+	"time"
+
+	"kiro2api/config"
+)
+
+// 预算周期
+const (
+	BudgetPeriodDaily   = "daily"   // 自然日（本地时区）
+	BudgetPeriodMonthly = "monthly" // 自然月（本地时区）
+)
+
+// BudgetPeriods 按检查顺序排列的预算周期
+var BudgetPeriods = []string{BudgetPeriodDaily, BudgetPeriodMonthly}
+
+// BudgetConfig 按客户端密钥的额度预算（CREDIT），0表示不限制
+type BudgetConfig struct {
+	DailyCredits     float64 `json:"dailyCredits"`               // 每日预算
+	MonthlyCredits   float64 `json:"monthlyCredits"`             // 每月预算
+	SoftLimitPercent int     `json:"softLimitPercent,omitempty"` // 预警比例(%)，0表示使用默认值
+}
+
+// Limit 返回指定周期的预算，0表示不限制
+func (b BudgetConfig) Limit(period string) float64 {
+	switch period {
+	case BudgetPeriodDaily:
+		return b.DailyCredits
+	case BudgetPeriodMonthly:
+		return b.MonthlyCredits
+	}
+	return 0
+}
+
+// SoftLimitRatio 返回预警比例
+func (b BudgetConfig) SoftLimitRatio() float64 {
+	if b.SoftLimitPercent > 0 {
+		return float64(b.SoftLimitPercent) / 100
+	}
+	return float64(config.BudgetSoftLimitPercent) / 100
+}
+
+func (b BudgetConfig) validate(scope string) error {
+	if b.DailyCredits < 0 || b.MonthlyCredits < 0 {
+		return NewConfigError("%s: 预算不能为负数", scope)
+	}
+	if b.SoftLimitPercent < 0 || b.SoftLimitPercent > 100 {
+		return NewConfigError("%s: 预算预警比例必须在 0-100 范围内", scope)
+	}
+	return nil
+}
+
+// BudgetPeriodStatus 单个周期的预算使用情况
+type BudgetPeriodStatus struct {
+	Period    string    `json:"period"`    // 周期类型，见 BudgetPeriod*
+	Limit     float64   `json:"limit"`     // 预算，0表示不限制
+	Spent     float64   `json:"spent"`     // 已用额度（含估算部分）
+	Estimated float64   `json:"estimated"` // 已用额度中尚未被Token额度变化确认的估算部分
+	Remaining float64   `json:"remaining"` // 剩余额度，不限制时为0
+	ResetAt   time.Time `json:"resetAt"`   // 周期重置时间
+	Warning   bool      `json:"warning"`   // 已达到预警比例
+	Exhausted bool      `json:"exhausted"` // 预算已用尽
+}
+
+// KeyBudgetStatus 客户端密钥的预算使用情况
+type KeyBudgetStatus struct {
+	KeyID   string               `json:"keyId"`
+	KeyName string               `json:"keyName"`
+	Budget  BudgetConfig         `json:"budget"`
+	Periods []BudgetPeriodStatus `json:"periods"`
+}
+
+// handleBudgets 查询各客户端密钥的预算与当前周期消耗，支持 ?id= 查询单个密钥
+func (m *Manager) handleBudgets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if m.budgetStatus == nil {
+		m.writeJSONError(w, "预算统计未初始化", http.StatusServiceUnavailable)
+		return
+	}
+
+	config := m.GetConfig()
+	keyID := r.URL.Query().Get("id")
+
+	statuses := make([]KeyBudgetStatus, 0, len(config.APIKeys)+1)
+	add := func(id, name string, budget BudgetConfig) {
+		if keyID != "" && keyID != id {
+			return
+		}
+		statuses = append(statuses, KeyBudgetStatus{
+			KeyID:   id,
+			KeyName: name,
+			Budget:  budget,
+			Periods: m.budgetStatus(id, budget),
+		})
+	}
+
+	if config.ServiceConfig.ClientToken != "" {
+		add(DefaultAPIKeyID, DefaultAPIKeyID, BudgetConfig{})
+	}
+	for _, key := range config.APIKeys {
+		budget := BudgetConfig{}
+		if key.Budget != nil {
+			budget = *key.Budget
+		}
+		add(key.ID, key.Name, budget)
+	}
+
+	if keyID != "" && len(statuses) == 0 {
+		m.writeJSONError(w, "API密钥不存在", http.StatusNotFound)
+		return
+	}
+
+	m.writeJSONResponse(w, statuses)
+}
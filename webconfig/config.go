@@ -2,17 +2,27 @@ package webconfig
 
 import (
 	"crypto/subtle"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"kiro2api/types"
 )
 
+// TokenUsage Token实时使用信息
+type TokenUsage struct {
+	UserEmail      string
+	UserId         string
+	RemainingUsage float64
+	UsageLimit     float64    // 重置后恢复的额度
+	ResetAt        *time.Time // 额度重置时间，未知时为nil
+	LastUsed       *time.Time
+}
+
 // TokenUsageProvider Token使用信息提供者接口
-type TokenUsageProvider func(token AuthToken) (userEmail string, userId string, remainingUsage float64, lastUsed *time.Time, err error)
+type TokenUsageProvider func(token AuthToken) (*TokenUsage, error)
 
 // Manager 配置管理器
 type Manager struct {
@@ -31,14 +41,22 @@ type Manager struct {
 	minRefreshInterval time.Duration // 最小刷新间隔
 	getCurrentTokenIndex func() int // 获取当前token索引的回调
 	switchToToken func(int) error // 切换token的回调
+	getConcurrencyStats func() types.TokenConcurrencyStats // 获取并发与排队统计的回调
+	testAlert func(webhook string) []AlertTestResult // 测试告警发送的回调
+	queryUsage func(query UsageQuery) ([]UsageRow, error) // 用量账本查询的回调
+	budgetStatus func(keyID string, budget BudgetConfig) []BudgetPeriodStatus // 预算消耗查询的回调
+	listActiveRequests func() []ActiveRequest // 在途请求列表的回调
+	cancelRequest func(id string) error // 取消在途请求的回调
+	auditLog *AuditLog // 管理操作审计日志
+	loginGuard *loginGuard // 登录失败锁定
 }
 
-// Session 会话信息
-type Session struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"createdAt"`
-	ExpiresAt time.Time `json:"expiresAt"`
-	Active    bool      `json:"active"`
+// AlertTestResult 测试告警的发送结果
+type AlertTestResult struct {
+	Webhook    string `json:"webhook"`
+	Success    bool   `json:"success"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
 }
 
 const (
@@ -53,7 +71,11 @@ func NewManager() *Manager {
 		sessions: make(map[string]*Session),
 		tokenCache: make(map[string]*TokenWithUsageInfo),
 		minRefreshInterval: 5 * time.Minute, // 最小刷新间隔5分钟
+		loginGuard: newLoginGuard(),
 	}
+	auditDir := filepath.Join(m.storage.dataDir(), auditDirName)
+	migrateLegacyAuditLog(m.storage.dataDir(), auditDir)
+	m.auditLog = NewAuditLog(auditDir)
 
 	// 加载配置
 	config, err := m.storage.LoadConfig()
@@ -61,8 +83,15 @@ func NewManager() *Manager {
 		panic(fmt.Sprintf("加载配置失败: %v", err))
 	}
 	m.config = config
+	m.auditLog.SetRetentionDays(config.AuditConfig.RetentionDays)
+
+	// 旧版本配置中的明文登录密码迁移为哈希
+	if err := m.migrateLoginPassword(); err != nil {
+		fmt.Printf("迁移登录密码失败: %v\n", err)
+	}
 
-	// 启动会话清理协程
+	// 恢复重启前的会话，并启动会话清理协程
+	m.loadSessions()
 	go m.cleanupExpiredSessions()
 
 	return m
@@ -75,27 +104,38 @@ func (m *Manager) GetConfig() *WebConfig {
 	return m.config.Clone()
 }
 
-// UpdateConfig 更新配置
+// UpdateConfig 更新配置，审计日志中记为 system 操作
 func (m *Manager) UpdateConfig(newConfig *WebConfig) error {
+	return m.updateConfigFrom(nil, AuditActionConfigUpdate, "", newConfig)
+}
+
+// updateConfigFrom 更新配置，并把操作者、来源和脱敏后的前后差异写入审计日志
+// r 为nil时（启动流程、后台任务）记为 system 操作
+func (m *Manager) updateConfigFrom(r *http.Request, action, target string, newConfig *WebConfig) error {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
 
 	// 验证配置
 	if err := newConfig.Validate(); err != nil {
+		m.mutex.Unlock()
 		return err
 	}
 
 	// 保存到文件
 	if err := m.storage.SaveConfig(newConfig); err != nil {
+		m.mutex.Unlock()
 		return fmt.Errorf("保存配置失败: %w", err)
 	}
 
 	// 更新内存中的配置
+	changes := diffConfig(m.config, newConfig)
 	m.config = newConfig.Clone()
+	m.auditLog.SetRetentionDays(m.config.AuditConfig.RetentionDays)
 
 	// 调用配置更新回调
 	m.notifyConfigChange()
+	m.mutex.Unlock()
 
+	m.recordAudit(r, AuditEntry{Action: action, Target: target, Changes: changes})
 	return nil
 }
 
@@ -137,78 +177,64 @@ func (m *Manager) InitializeFirstRun(loginPassword, clientToken string) error {
 		return NewConfigError("配置已存在，不是首次运行")
 	}
 
+	passwordHash, err := HashPassword(loginPassword)
+	if err != nil {
+		return err
+	}
+
 	config := GetDefaultConfig()
-	config.LoginPassword = loginPassword
+	config.LoginPassword = passwordHash
 	config.ServiceConfig.ClientToken = clientToken
 
-	return m.UpdateConfig(config)
+	return m.updateConfigFrom(nil, AuditActionConfigInit, "", config)
 }
 
 // VerifyLoginPassword 验证登录密码
+// 保存的仍是明文（如恢复了旧版本的备份）时按明文比较，验证通过后迁移为哈希
 func (m *Manager) VerifyLoginPassword(password string) bool {
 	config := m.GetConfig()
-	return subtle.ConstantTimeCompare([]byte(config.LoginPassword), []byte(password)) == 1
-}
-
-// CreateSession 创建会话
-func (m *Manager) CreateSession() *Session {
-	session := &Session{
-		ID:        generateSessionID(),
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(sessionDuration),
-		Active:    true,
+	if config.LoginPassword == "" {
+		return false
 	}
 
-	m.sessionMutex.Lock()
-	m.sessions[session.ID] = session
-	m.sessionMutex.Unlock()
-
-	return session
-}
-
-// ValidateSession 验证会话
-func (m *Manager) ValidateSession(sessionID string) bool {
-	m.sessionMutex.RLock()
-	defer m.sessionMutex.RUnlock()
-
-	session, exists := m.sessions[sessionID]
-	if !exists || !session.Active {
-		return false
+	if IsPasswordHashed(config.LoginPassword) {
+		ok, err := verifyPasswordHash(config.LoginPassword, password)
+		if err != nil {
+			fmt.Printf("校验登录密码失败: %v\n", err)
+		}
+		return ok
 	}
 
-	// 检查是否过期
-	if time.Now().After(session.ExpiresAt) {
+	if subtle.ConstantTimeCompare([]byte(config.LoginPassword), []byte(password)) != 1 {
 		return false
 	}
-
+	if err := m.migrateLoginPassword(); err != nil {
+		fmt.Printf("迁移登录密码失败: %v\n", err)
+	}
 	return true
 }
 
-// InvalidateSession 使会话失效
-func (m *Manager) InvalidateSession(sessionID string) {
-	m.sessionMutex.Lock()
-	defer m.sessionMutex.Unlock()
+// migrateLoginPassword 将明文登录密码替换为哈希并保存，不触发配置更新回调
+func (m *Manager) migrateLoginPassword() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	if session, exists := m.sessions[sessionID]; exists {
-		session.Active = false
+	if m.config.LoginPassword == "" || IsPasswordHashed(m.config.LoginPassword) {
+		return nil
 	}
-}
 
-// cleanupExpiredSessions 清理过期会话
-func (m *Manager) cleanupExpiredSessions() {
-	ticker := time.NewTicker(cleanupInterval)
-	defer ticker.Stop()
+	passwordHash, err := HashPassword(m.config.LoginPassword)
+	if err != nil {
+		return err
+	}
 
-	for range ticker.C {
-		m.sessionMutex.Lock()
-		now := time.Now()
-		for id, session := range m.sessions {
-			if now.After(session.ExpiresAt) {
-				delete(m.sessions, id)
-			}
-		}
-		m.sessionMutex.Unlock()
+	config := m.config.Clone()
+	config.LoginPassword = passwordHash
+	if err := m.storage.SaveConfig(config); err != nil {
+		return fmt.Errorf("保存配置失败: %w", err)
 	}
+	m.config = config
+	return nil
 }
 
 // GetAuthTokenString 获取认证Token字符串（兼容原有代码）
@@ -277,9 +303,23 @@ func (m *Manager) GetEnabledTokens() []AuthToken {
 // TokenWithUsageInfo Token带使用信息
 type TokenWithUsageInfo struct {
 	AuthToken
-	UserEmail      string  `json:"userEmail"`
-	RemainingUsage float64 `json:"remainingUsage"`
-	UserId         string  `json:"userId"`
+	UserEmail      string     `json:"userEmail"`
+	RemainingUsage float64    `json:"remainingUsage"`
+	UserId         string     `json:"userId"`
+	UsageLimit     float64    `json:"usageLimit"`        // 重置后恢复的额度
+	ResetAt        *time.Time `json:"resetAt,omitempty"` // 额度重置时间
+}
+
+// apply 使用实时使用信息更新Token信息
+func (t *TokenWithUsageInfo) apply(usage *TokenUsage) {
+	t.UserEmail = usage.UserEmail
+	t.UserId = usage.UserId
+	t.RemainingUsage = usage.RemainingUsage
+	t.UsageLimit = usage.UsageLimit
+	t.ResetAt = usage.ResetAt
+	if usage.LastUsed != nil {
+		t.LastUsed = usage.LastUsed
+	}
 }
 
 // SetTokenUsageProvider 设置Token使用信息提供者
@@ -299,6 +339,32 @@ func (m *Manager) SetSwitchTokenProvider(provider func(int) error) {
 	m.switchToToken = provider
 }
 
+// SetConcurrencyStatsProvider 设置获取并发与排队统计的回调
+func (m *Manager) SetConcurrencyStatsProvider(provider func() types.TokenConcurrencyStats) {
+	m.getConcurrencyStats = provider
+}
+
+// SetAlertTestProvider 设置测试告警发送的回调
+func (m *Manager) SetAlertTestProvider(provider func(webhook string) []AlertTestResult) {
+	m.testAlert = provider
+}
+
+// SetUsageProvider 设置用量账本查询的回调
+func (m *Manager) SetUsageProvider(provider func(query UsageQuery) ([]UsageRow, error)) {
+	m.queryUsage = provider
+}
+
+// SetBudgetProvider 设置预算消耗查询的回调
+func (m *Manager) SetBudgetProvider(provider func(keyID string, budget BudgetConfig) []BudgetPeriodStatus) {
+	m.budgetStatus = provider
+}
+
+// SetActiveRequestsProvider 设置在途请求列表和取消请求的回调
+func (m *Manager) SetActiveRequestsProvider(list func() []ActiveRequest, cancel func(id string) error) {
+	m.listActiveRequests = list
+	m.cancelRequest = cancel
+}
+
 // GetTokensWithUsageInfo 获取带有实时使用信息的Token列表（使用缓存）
 func (m *Manager) GetTokensWithUsageInfo() []TokenWithUsageInfo {
 	config := m.GetConfig()
@@ -386,21 +452,14 @@ func (m *Manager) RefreshTokenCache() {
 		
 		// 获取实时使用信息，失败时自动重试2次
 		var err error
-		var userEmail, userId string
-		var remainingUsage float64
-		var lastUsed *time.Time
+		var usage *TokenUsage
 		
 		maxRetries := 2
 		for attempt := 0; attempt <= maxRetries; attempt++ {
-			userEmail, userId, remainingUsage, lastUsed, err = provider(token)
+			usage, err = provider(token)
 			if err == nil {
 				// 成功获取信息
-				tokenInfo.UserEmail = userEmail
-				tokenInfo.UserId = userId
-				tokenInfo.RemainingUsage = remainingUsage
-				if lastUsed != nil {
-					tokenInfo.LastUsed = lastUsed
-				}
+				tokenInfo.apply(usage)
 				break
 			}
 			
@@ -441,6 +500,11 @@ func (m *Manager) ListBackups() ([]string, error) {
 
 // RestoreFromBackup 从备份恢复
 func (m *Manager) RestoreFromBackup(backupFile string) error {
+	return m.restoreFromBackup(nil, backupFile)
+}
+
+// restoreFromBackup 从备份恢复，恢复前后的差异记入审计日志
+func (m *Manager) restoreFromBackup(r *http.Request, backupFile string) error {
 	// 加载备份配置
 	backupPath := filepath.Join(filepath.Dir(m.storage.GetConfigPath()), backupFile)
 	config, err := m.storage.LoadConfigFromPath(backupPath)
@@ -448,7 +512,7 @@ func (m *Manager) RestoreFromBackup(backupFile string) error {
 		return err
 	}
 
-	return m.UpdateConfig(config)
+	return m.updateConfigFrom(r, AuditActionConfigRestore, backupFile, config)
 }
 
 // 全局配置管理器实例
@@ -463,37 +527,12 @@ func GetGlobalManager() *Manager {
 	return globalManager
 }
 
-// 临时方法，需要在storage.go中添加
-func (s *Storage) LoadConfigFromPath(configPath string) (*WebConfig, error) {
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("读取配置文件失败: %w", err)
-	}
-
-	var config WebConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("解析配置文件失败: %w", err)
-	}
-
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("配置验证失败: %w", err)
-	}
-
-	return &config, nil
-}
-
-// 生成会话ID
-func generateSessionID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
-}
-
 // AuthMiddleware 认证中间件
 func (m *Manager) AuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// 检查会话
-			sessionCookie, err := r.Cookie("session_id")
-			if err != nil || !m.ValidateSession(sessionCookie.Value) {
+			if _, ok := m.requestSession(r); !ok {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
@@ -0,0 +1,190 @@
+package webconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// redactedValue 敏感字段在审计差异中的占位值
+const redactedValue = "[REDACTED]"
+
+// sensitiveConfigKeys 审计差异中需要脱敏的字段（JSON字段名）
+var sensitiveConfigKeys = map[string]bool{
+	"loginPassword": true,
+	"clientToken":   true,
+	"metricsToken":  true,
+	"refreshToken":  true,
+	"clientSecret":  true,
+	"secret":        true,
+	"hash":          true,
+	"passwordHash":  true,
+}
+
+// ignoredConfigPaths 每次保存都会变化、不记录差异的字段
+var ignoredConfigPaths = map[string]bool{
+	"updatedAt": true,
+}
+
+// ConfigChange 配置中一个字段的变更
+// 数组中带 id 的元素按 id 定位，如 authTokens[id=123].enabled
+type ConfigChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// diffConfig 计算两份配置的差异，敏感字段只记录是否变化，不记录内容
+func diffConfig(before, after *WebConfig) []ConfigChange {
+	var changes []ConfigChange
+	diffValue("", toGeneric(before), toGeneric(after), false, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// toGeneric 将配置转换为 JSON 通用结构，便于逐字段比较
+func toGeneric(config *WebConfig) interface{} {
+	if config == nil {
+		return nil
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil
+	}
+	return generic
+}
+
+// diffValue 递归比较两个值，sensitive 表示该值位于敏感字段下
+func diffValue(path string, before, after interface{}, sensitive bool, changes *[]ConfigChange) {
+	if ignoredConfigPaths[path] || reflect.DeepEqual(before, after) {
+		return
+	}
+
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		keys := make(map[string]bool, len(beforeMap)+len(afterMap))
+		for key := range beforeMap {
+			keys[key] = true
+		}
+		for key := range afterMap {
+			keys[key] = true
+		}
+		for key := range keys {
+			diffValue(joinConfigPath(path, key), beforeMap[key], afterMap[key], sensitive || sensitiveConfigKeys[key], changes)
+		}
+		return
+	}
+
+	beforeList, beforeIsList := before.([]interface{})
+	afterList, afterIsList := after.([]interface{})
+	if beforeIsList && afterIsList {
+		diffList(path, beforeList, afterList, sensitive, changes)
+		return
+	}
+
+	*changes = append(*changes, ConfigChange{
+		Path:   path,
+		Before: redactValue(before, sensitive),
+		After:  redactValue(after, sensitive),
+	})
+}
+
+// diffList 比较两个数组：元素都带 id 时按 id 配对，否则按下标配对
+func diffList(path string, before, after []interface{}, sensitive bool, changes *[]ConfigChange) {
+	beforeByID, beforeOK := indexByID(before)
+	afterByID, afterOK := indexByID(after)
+	if !beforeOK || !afterOK {
+		for i := 0; i < len(before) || i < len(after); i++ {
+			var b, a interface{}
+			if i < len(before) {
+				b = before[i]
+			}
+			if i < len(after) {
+				a = after[i]
+			}
+			diffValue(fmt.Sprintf("%s[%d]", path, i), b, a, sensitive, changes)
+		}
+		return
+	}
+
+	ids := make(map[string]bool, len(beforeByID)+len(afterByID))
+	for id := range beforeByID {
+		ids[id] = true
+	}
+	for id := range afterByID {
+		ids[id] = true
+	}
+	for id := range ids {
+		elementPath := fmt.Sprintf("%s[id=%s]", path, id)
+		b, inBefore := beforeByID[id]
+		a, inAfter := afterByID[id]
+		if inBefore && inAfter {
+			diffValue(elementPath, b, a, sensitive, changes)
+			continue
+		}
+		// 新增或删除的元素整体记录，敏感字段同样脱敏
+		*changes = append(*changes, ConfigChange{
+			Path:   elementPath,
+			Before: redactTree(b, sensitive),
+			After:  redactTree(a, sensitive),
+		})
+	}
+}
+
+// indexByID 按元素的 id 字段建立索引，存在不带 id 的元素时返回false
+func indexByID(list []interface{}) (map[string]interface{}, bool) {
+	byID := make(map[string]interface{}, len(list))
+	for _, element := range list {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id, ok := object["id"].(string)
+		if !ok || id == "" {
+			return nil, false
+		}
+		byID[id] = element
+	}
+	return byID, true
+}
+
+// redactTree 返回脱敏后的副本，敏感字段的非空值替换为占位值
+func redactTree(value interface{}, sensitive bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			redacted[key] = redactTree(child, sensitive || sensitiveConfigKeys[key])
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, child := range v {
+			redacted[i] = redactTree(child, sensitive)
+		}
+		return redacted
+	default:
+		return redactValue(v, sensitive)
+	}
+}
+
+// redactValue 脱敏单个值，空值保持原样以便看出字段是被设置还是被清空
+func redactValue(value interface{}, sensitive bool) interface{} {
+	if !sensitive || value == nil || value == "" {
+		return value
+	}
+	return redactedValue
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,250 @@
+package webconfig
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 主密钥相关环境变量
+const (
+	EnvConfigKey        = "KIRO_CONFIG_KEY"          // 主密钥（32字节，base64或hex编码）
+	EnvConfigKeyFile    = "KIRO_CONFIG_KEY_FILE"     // 主密钥文件路径
+	EnvNewConfigKey     = "KIRO_CONFIG_NEW_KEY"      // 轮换时使用的新主密钥
+	EnvNewConfigKeyFile = "KIRO_CONFIG_NEW_KEY_FILE" // 轮换时使用的新主密钥文件路径
+)
+
+const (
+	encryptedValuePrefix = "enc:v1:" // 加密字段值前缀
+	encryptionVersion    = 1
+	masterKeySize        = 32 // AES-256
+	dataKeyAAD           = "kiro2api-config-dek"
+)
+
+// EncryptionInfo 信封加密元数据，随配置文件一起保存
+// 每次保存生成新的数据密钥(DEK)加密敏感字段，DEK再由主密钥(KEK)加密
+type EncryptionInfo struct {
+	Version    int    `json:"version"`    // 加密格式版本
+	KeyID      string `json:"keyId"`      // 主密钥指纹，用于识别密钥不匹配
+	WrappedKey string `json:"wrappedKey"` // 主密钥加密后的数据密钥(base64)
+}
+
+// persistedConfig 配置文件的落盘格式
+type persistedConfig struct {
+	*WebConfig
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+}
+
+// configCipher 使用主密钥对配置中的敏感字段进行信封加密
+type configCipher struct {
+	masterKey []byte
+	keyID     string
+}
+
+// newConfigCipher 创建配置加密器，key为nil时返回nil（不加密）
+func newConfigCipher(key []byte) *configCipher {
+	if key == nil {
+		return nil
+	}
+	sum := sha256.Sum256(key)
+	return &configCipher{
+		masterKey: key,
+		keyID:     hex.EncodeToString(sum[:8]),
+	}
+}
+
+// LoadMasterKey 从环境变量或密钥文件读取主密钥，均未设置时返回nil
+func LoadMasterKey(keyEnv, fileEnv string) ([]byte, error) {
+	if value := strings.TrimSpace(os.Getenv(keyEnv)); value != "" {
+		return parseMasterKey(value)
+	}
+
+	if path := strings.TrimSpace(os.Getenv(fileEnv)); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取主密钥文件失败: %w", err)
+		}
+		return parseMasterKey(strings.TrimSpace(string(data)))
+	}
+
+	return nil, nil
+}
+
+// parseMasterKey 解析base64或hex编码的32字节主密钥
+func parseMasterKey(value string) ([]byte, error) {
+	if len(value) == hex.EncodedLen(masterKeySize) {
+		if key, err := hex.DecodeString(value); err == nil {
+			return key, nil
+		}
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := encoding.DecodeString(value); err == nil && len(key) == masterKeySize {
+			return key, nil
+		}
+	}
+
+	return nil, NewConfigError("主密钥必须是32字节的base64或hex编码（可使用 openssl rand -base64 32 生成）")
+}
+
+// secretFields 返回配置中所有需要加密的字段
+func secretFields(c *WebConfig) []*string {
+	fields := []*string{&c.LoginPassword, &c.ServiceConfig.ClientToken, &c.ServiceConfig.MetricsToken}
+	for i := range c.AuthTokens {
+		fields = append(fields, &c.AuthTokens[i].RefreshToken, &c.AuthTokens[i].ClientSecret)
+	}
+	for i := range c.AlertConfig.Webhooks {
+		fields = append(fields, &c.AlertConfig.Webhooks[i].Secret)
+	}
+	return fields
+}
+
+// hasEncryptedFields 检查配置中是否存在加密字段值
+func hasEncryptedFields(c *WebConfig) bool {
+	for _, field := range secretFields(c) {
+		if strings.HasPrefix(*field, encryptedValuePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPlaintextSecrets 检查配置中是否存在未加密的敏感字段值（如新增加密的字段）
+func hasPlaintextSecrets(c *WebConfig) bool {
+	for _, field := range secretFields(c) {
+		if *field != "" && !strings.HasPrefix(*field, encryptedValuePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// seal 加密配置的敏感字段，返回落盘格式（不修改传入的配置）
+func (cc *configCipher) seal(config *WebConfig) (*persistedConfig, error) {
+	sealed := config.Clone()
+	if cc == nil {
+		return &persistedConfig{WebConfig: sealed}, nil
+	}
+
+	dataKey := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("生成数据密钥失败: %w", err)
+	}
+
+	wrappedKey, err := gcmSeal(cc.masterKey, dataKey, []byte(dataKeyAAD))
+	if err != nil {
+		return nil, fmt.Errorf("加密数据密钥失败: %w", err)
+	}
+
+	for _, field := range secretFields(sealed) {
+		if *field == "" {
+			continue
+		}
+		ciphertext, err := gcmSeal(dataKey, []byte(*field), nil)
+		if err != nil {
+			return nil, fmt.Errorf("加密敏感字段失败: %w", err)
+		}
+		*field = encryptedValuePrefix + base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	return &persistedConfig{
+		WebConfig: sealed,
+		Encryption: &EncryptionInfo{
+			Version:    encryptionVersion,
+			KeyID:      cc.keyID,
+			WrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		},
+	}, nil
+}
+
+// open 解密落盘格式中的敏感字段（原地修改）
+func (cc *configCipher) open(persisted *persistedConfig) error {
+	if persisted.Encryption == nil {
+		if hasEncryptedFields(persisted.WebConfig) {
+			return NewConfigError("配置文件包含加密字段但缺少加密元数据")
+		}
+		return nil // 明文配置，兼容旧版本
+	}
+
+	if cc == nil {
+		return NewConfigError("配置文件已加密，请设置 %s 或 %s", EnvConfigKey, EnvConfigKeyFile)
+	}
+
+	info := persisted.Encryption
+	if info.Version != encryptionVersion {
+		return NewConfigError("不支持的配置加密版本: %d", info.Version)
+	}
+	if info.KeyID != cc.keyID {
+		return NewConfigError("主密钥不匹配（配置文件密钥指纹: %s，当前密钥指纹: %s）", info.KeyID, cc.keyID)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(info.WrappedKey)
+	if err != nil {
+		return fmt.Errorf("解析数据密钥失败: %w", err)
+	}
+	dataKey, err := gcmOpen(cc.masterKey, wrappedKey, []byte(dataKeyAAD))
+	if err != nil {
+		return fmt.Errorf("解密数据密钥失败: %w", err)
+	}
+
+	for _, field := range secretFields(persisted.WebConfig) {
+		if !strings.HasPrefix(*field, encryptedValuePrefix) {
+			continue
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(*field, encryptedValuePrefix))
+		if err != nil {
+			return fmt.Errorf("解析加密字段失败: %w", err)
+		}
+		plaintext, err := gcmOpen(dataKey, ciphertext, nil)
+		if err != nil {
+			return fmt.Errorf("解密敏感字段失败: %w", err)
+		}
+		*field = string(plaintext)
+	}
+
+	persisted.Encryption = nil
+	return nil
+}
+
+// gcmSeal 使用AES-GCM加密，输出格式为 nonce || ciphertext
+func gcmSeal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// gcmOpen 解密 gcmSeal 的输出
+func gcmOpen(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("密文长度不足")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,224 @@
+package webconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testMasterKey 返回指定字节填充的32字节主密钥
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, masterKeySize)
+}
+
+func TestConfigCipher_SealsAllSecretFields(t *testing.T) {
+	config := testConfigWithToken()
+	config.ServiceConfig.MetricsToken = "metrics-token-value"
+
+	persisted, err := newConfigCipher(testMasterKey(1)).seal(config)
+	assert.NoError(t, err)
+	for _, field := range secretFields(persisted.WebConfig) {
+		if *field != "" {
+			assert.Contains(t, *field, encryptedValuePrefix)
+		}
+	}
+	assert.Contains(t, persisted.ServiceConfig.MetricsToken, encryptedValuePrefix)
+	// seal 不修改传入的配置
+	assert.Equal(t, "metrics-token-value", config.ServiceConfig.MetricsToken)
+}
+
+func TestStorage_LoadConfigSealsPlaintextSecrets(t *testing.T) {
+	m := newTestManager(t, nil)
+	storage := m.storage
+	storage.cipher = newConfigCipher(testMasterKey(1))
+
+	// 模拟旧版本写入的配置：已加密，但指标令牌仍为明文
+	config := GetDefaultConfig()
+	config.ServiceConfig.ClientToken = "client-token-value"
+	persisted, err := storage.cipher.seal(config)
+	assert.NoError(t, err)
+	persisted.ServiceConfig.MetricsToken = "metrics-token-value"
+	data, _ := json.Marshal(persisted)
+	assert.NoError(t, os.WriteFile(storage.configPath, data, configFileMode))
+
+	loaded, err := storage.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "metrics-token-value", loaded.ServiceConfig.MetricsToken)
+
+	raw, _ := os.ReadFile(storage.configPath)
+	assert.NotContains(t, string(raw), "metrics-token-value")
+}
+
+func TestParseMasterKey(t *testing.T) {
+	key := testMasterKey(7)
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"hex", hex.EncodeToString(key), false},
+		{"base64", base64.StdEncoding.EncodeToString(key), false},
+		{"raw url base64", base64.RawURLEncoding.EncodeToString(key), false},
+		{"too short", base64.StdEncoding.EncodeToString(key[:16]), true},
+		{"not encoded", "not-a-key", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseMasterKey(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, key, parsed)
+		})
+	}
+}
+
+func TestConfigCipher_RoundTrip(t *testing.T) {
+	cc := newConfigCipher(testMasterKey(1))
+	config := testConfigWithToken()
+
+	persisted, err := cc.seal(config)
+	assert.NoError(t, err)
+	assert.NotEqual(t, testRefreshToken, persisted.AuthTokens[0].RefreshToken)
+
+	// 每次保存使用新的数据密钥，相同明文的密文不同
+	again, _ := cc.seal(config)
+	assert.NotEqual(t, persisted.AuthTokens[0].RefreshToken, again.AuthTokens[0].RefreshToken)
+
+	assert.NoError(t, cc.open(persisted))
+	assert.Nil(t, persisted.Encryption)
+	assert.Equal(t, testRefreshToken, persisted.AuthTokens[0].RefreshToken)
+	assert.Equal(t, testClientSecret, persisted.AuthTokens[0].ClientSecret)
+	assert.Equal(t, "client-token-value", persisted.ServiceConfig.ClientToken)
+}
+
+func TestConfigCipher_RejectsWrongKey(t *testing.T) {
+	persisted, err := newConfigCipher(testMasterKey(1)).seal(testConfigWithToken())
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		cipher  *configCipher
+		mutate  func(p *persistedConfig)
+		wantMsg string
+	}{
+		{"missing key", nil, nil, EnvConfigKey},
+		{"different key", newConfigCipher(testMasterKey(2)), nil, "主密钥不匹配"},
+		{"wrong key with forged key id", newConfigCipher(testMasterKey(2)), func(p *persistedConfig) {
+			p.Encryption.KeyID = newConfigCipher(testMasterKey(2)).keyID
+		}, "解密数据密钥失败"},
+		{"tampered field", newConfigCipher(testMasterKey(1)), func(p *persistedConfig) {
+			p.AuthTokens[0].RefreshToken = encryptedValuePrefix + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0}, 40))
+		}, "解密敏感字段失败"},
+		{"encrypted field without metadata", newConfigCipher(testMasterKey(1)), func(p *persistedConfig) {
+			p.Encryption = nil
+		}, "缺少加密元数据"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 每个用例使用独立副本
+			info := *persisted.Encryption
+			copied := &persistedConfig{WebConfig: persisted.WebConfig.Clone(), Encryption: &info}
+			if tt.mutate != nil {
+				tt.mutate(copied)
+			}
+			err := tt.cipher.open(copied)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+// writeSealedConfig 用指定主密钥加密写入配置文件
+func writeSealedConfig(t *testing.T, path string, key []byte, config *WebConfig) {
+	t.Helper()
+	storage := &Storage{configPath: path, cipher: newConfigCipher(key)}
+	if err := storage.writeConfigFile(path, config, storage.cipher); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_RotateKey(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, configFileName)
+	backupPath := filepath.Join(dir, "config_backup_20250101_000000.json")
+	config := testConfigWithToken()
+	writeSealedConfig(t, configPath, testMasterKey(1), config)
+	writeSealedConfig(t, backupPath, testMasterKey(1), config)
+
+	storage := &Storage{configPath: configPath, cipher: newConfigCipher(testMasterKey(1))}
+	count, err := storage.RotateKey(testMasterKey(2))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// 配置和备份都只能用新密钥解密
+	rotated := &Storage{configPath: configPath, cipher: newConfigCipher(testMasterKey(2))}
+	old := &Storage{configPath: configPath, cipher: newConfigCipher(testMasterKey(1))}
+	for _, path := range []string{configPath, backupPath} {
+		loaded, _, err := rotated.readConfigFile(path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, testRefreshToken, loaded.AuthTokens[0].RefreshToken)
+		}
+		_, _, err = old.readConfigFile(path)
+		assert.Error(t, err)
+	}
+
+	_, err = rotated.RotateKey(nil)
+	assert.Error(t, err)
+}
+
+func TestStorage_RotateKeyKeepsBackupsThatFailValidation(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, configFileName)
+	backupPath := filepath.Join(dir, "auto_backup_20240101_000000.json")
+	writeSealedConfig(t, configPath, testMasterKey(1), testConfigWithToken())
+	// 旧版本写入的备份不满足当前的验证规则
+	legacy := testConfigWithToken()
+	legacy.AuthTokens[0].Region = "legacy region"
+	writeSealedConfig(t, backupPath, testMasterKey(1), legacy)
+
+	storage := &Storage{configPath: configPath, cipher: newConfigCipher(testMasterKey(1))}
+	count, err := storage.RotateKey(testMasterKey(2))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	rotated := &Storage{configPath: configPath, cipher: newConfigCipher(testMasterKey(2))}
+	loaded, _, err := rotated.decodeConfigFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "legacy region", loaded.AuthTokens[0].Region)
+	assert.Equal(t, testRefreshToken, loaded.AuthTokens[0].RefreshToken)
+}
+
+func TestStorage_RotateKeyWritesNothingWhenAnyFileFails(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, configFileName)
+	config := testConfigWithToken()
+	writeSealedConfig(t, configPath, testMasterKey(1), config)
+	// 用其他密钥加密的备份在第一阶段解密失败
+	writeSealedConfig(t, filepath.Join(dir, "config_backup_20250101_000000.json"), testMasterKey(3), config)
+	before, _ := os.ReadFile(configPath)
+
+	storage := &Storage{configPath: configPath, cipher: newConfigCipher(testMasterKey(1))}
+	count, err := storage.RotateKey(testMasterKey(2))
+	assert.Error(t, err)
+	assert.Equal(t, 0, count)
+	assert.True(t, strings.Contains(err.Error(), "config_backup_20250101_000000.json"))
+
+	after, _ := os.ReadFile(configPath)
+	assert.Equal(t, before, after)
+	assert.Equal(t, newConfigCipher(testMasterKey(1)).keyID, storage.cipher.keyID)
+}
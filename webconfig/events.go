@@ -0,0 +1,156 @@
+package webconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/events"
+	"kiro2api/logger"
+)
+
+// eventFilter 事件流的过滤条件
+type eventFilter struct {
+	prefixes []string     // 事件类型前缀，为空表示全部（日志除外）
+	logLevel logger.Level // 推送的最低日志级别
+	logs     bool         // 是否推送日志
+}
+
+// parseEventFilter 解析查询参数：types 为逗号分隔的事件类型前缀（如 token.,request.），logs 为推送的最低日志级别
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	var filter eventFilter
+	for _, prefix := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			filter.prefixes = append(filter.prefixes, prefix)
+		}
+	}
+	if level := r.URL.Query().Get("logs"); level != "" {
+		parsed, err := logger.ParseLevel(level)
+		if err != nil {
+			return filter, fmt.Errorf("无效的日志级别: %s", level)
+		}
+		filter.logLevel = parsed
+		filter.logs = true
+	}
+	return filter, nil
+}
+
+// match 检查事件是否满足过滤条件
+func (f eventFilter) match(ev events.Event) bool {
+	if ev.Type == events.TypeLog {
+		if !f.logs {
+			return false
+		}
+		name, _ := ev.Data["level"].(string)
+		level, err := logger.ParseLevel(name)
+		return err == nil && level >= f.logLevel
+	}
+	if len(f.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(ev.Type, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleEvents 实时事件流（SSE）：推送token选择、刷新、耗尽，请求开始、结束和失败，以及日志
+// 连接时先补发最近的事件；断线重连时浏览器携带 Last-Event-ID，只补发错过的事件
+func (m *Manager) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		m.writeJSONError(w, "当前连接不支持事件流", http.StatusInternalServerError)
+		return
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		m.writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.logs && !m.canStreamLogs(r) {
+		m.writeJSONError(w, "查看日志需要操作员及以上角色", http.StatusForbidden)
+		return
+	}
+
+	var afterID uint64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		afterID, _ = strconv.ParseUint(lastID, 10, 64)
+	}
+	sub, backlog := events.Subscribe(afterID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // 禁止nginx缓冲
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	for _, ev := range backlog {
+		if filter.match(ev) {
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(config.EventKeepaliveInterval)
+	defer keepalive.Stop()
+	var reportedDrops int64
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if filter.match(ev) {
+				err = writeEvent(w, ev)
+			}
+		case <-keepalive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		// 客户端读取过慢时告知丢弃的事件数，控制台可据此提示
+		if dropped := sub.Dropped(); err == nil && dropped > reportedDrops {
+			_, err = fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped-reportedDrops)
+			reportedDrops = dropped
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// canStreamLogs 检查请求者能否订阅日志：日志可能包含请求细节，会话用户需要操作员及以上角色
+// 管理令牌已在认证时按权限范围授权
+func (m *Manager) canStreamLogs(r *http.Request) bool {
+	session := contextSession(r)
+	if session == nil {
+		return true
+	}
+	role, ok := m.userRole(session.Username)
+	return ok && roleAllows(role, RoleOperator)
+}
+
+// writeEvent 按SSE格式写入事件，事件ID用于断线重连
+func writeEvent(w http.ResponseWriter, ev events.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return nil // 无法序列化的事件跳过，不断开连接
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.ID, data)
+	return err
+}
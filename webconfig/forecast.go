@@ -0,0 +1,54 @@
+package webconfig
+
+import "time"
+
+// maxForecastDays 额度预测的最大天数
+const maxForecastDays = 365
+
+// CreditForecast Token池额度预测
+type CreditForecast struct {
+	Days            int        `json:"days"`                  // 预测天数
+	Until           time.Time  `json:"until"`                 // 预测截止时间
+	AvailableNow    float64    `json:"availableNow"`          // 当前剩余额度
+	RestoredCredits float64    `json:"restoredCredits"`       // 预测期内重置恢复的额度
+	TotalAvailable  float64    `json:"totalAvailable"`        // 预测期内可用额度合计
+	NextResetAt     *time.Time `json:"nextResetAt,omitempty"` // 最近一次额度重置时间
+}
+
+// ForecastCredits 预测未来days天内Token池可用的额度
+// 可用额度 = 当前剩余额度 + 预测期内每次重置（按月）恢复的整额额度，仅统计启用的Token
+func ForecastCredits(tokens []TokenWithUsageInfo, days int, now time.Time) CreditForecast {
+	forecast := CreditForecast{
+		Days:  days,
+		Until: now.AddDate(0, 0, days),
+	}
+
+	for _, token := range tokens {
+		if !token.Enabled {
+			continue
+		}
+		forecast.AvailableNow += token.RemainingUsage
+
+		if token.ResetAt == nil || token.UsageLimit <= 0 {
+			continue
+		}
+
+		// 重置时间已过（缓存数据过旧）时顺延到下一个周期
+		resetAt := *token.ResetAt
+		for !resetAt.After(now) {
+			resetAt = resetAt.AddDate(0, 1, 0)
+		}
+
+		if forecast.NextResetAt == nil || resetAt.Before(*forecast.NextResetAt) {
+			next := resetAt
+			forecast.NextResetAt = &next
+		}
+
+		for ; !resetAt.After(forecast.Until); resetAt = resetAt.AddDate(0, 1, 0) {
+			forecast.RestoredCredits += token.UsageLimit
+		}
+	}
+
+	forecast.TotalAvailable = forecast.AvailableNow + forecast.RestoredCredits
+	return forecast
+}
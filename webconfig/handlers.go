@@ -6,8 +6,11 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"kiro2api/types"
 )
 
 // SetupRoutes 设置路由
@@ -18,21 +21,54 @@ func (m *Manager) SetupRoutes(r *http.ServeMux) {
 	r.HandleFunc("/api/init", m.handleInit)
 
 	// 需要认证的路由
-	r.HandleFunc("/logout", m.withAuth(m.handleLogout))
-	r.HandleFunc("/config", m.withAuth(m.handleConfig))
-	r.HandleFunc("/api/config", m.withAuth(m.handleAPIConfig))
-	r.HandleFunc("/api/tokens", m.withAuth(m.handleAPITokens))
-	r.HandleFunc("/api/tokens/refresh", m.withAuth(m.handleRefreshTokens))
-	r.HandleFunc("/api/tokens/refresh-single", m.withAuth(m.handleRefreshSingleToken))
-	r.HandleFunc("/api/tokens/current", m.withAuth(m.handleGetCurrentToken))
-	r.HandleFunc("/api/tokens/switch", m.withAuth(m.handleSwitchToken))
-	r.HandleFunc("/api/backup", m.withAuth(m.handleBackup))
-	r.HandleFunc("/api/restore", m.withAuth(m.handleRestore))
+	for _, route := range m.protectedRoutes() {
+		r.HandleFunc(route.path, m.withMethodRoles(route.readRole, route.writeRole, route.methodRoles, route.handler))
+	}
 
 	// 静态文件服务
 	r.HandleFunc("/static/", m.handleStatic)
 }
 
+// protectedRoute 需要认证的路由及其所需的最低角色
+type protectedRoute struct {
+	path        string
+	readRole    string            // 查询请求（GET/HEAD）所需的角色
+	writeRole   string            // 修改请求所需的角色
+	methodRoles map[string]string // 按请求方法覆盖修改请求所需的角色
+	handler     http.HandlerFunc
+}
+
+// protectedRoutes 返回需要认证的路由，分别指定查询和修改请求所需的最低角色
+func (m *Manager) protectedRoutes() []protectedRoute {
+	return []protectedRoute{
+		{path: "/logout", readRole: RoleViewer, writeRole: RoleViewer, handler: m.handleLogout},
+		{path: "/api/sessions/logout-all", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleLogoutAll},
+		{path: "/config", readRole: RoleViewer, writeRole: RoleAdmin, handler: m.handleConfig},
+		{path: "/api/config", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleAPIConfig},
+		// 运维可以启用/禁用Token，添加和删除上游凭证需要管理员
+		{path: "/api/tokens", readRole: RoleViewer, writeRole: RoleOperator,
+			methodRoles: map[string]string{"POST": RoleAdmin, "DELETE": RoleAdmin}, handler: m.handleAPITokens},
+		{path: "/api/tokens/refresh", readRole: RoleViewer, writeRole: RoleOperator, handler: m.handleRefreshTokens},
+		{path: "/api/tokens/refresh-single", readRole: RoleViewer, writeRole: RoleOperator, handler: m.handleRefreshSingleToken},
+		{path: "/api/tokens/current", readRole: RoleViewer, writeRole: RoleOperator, handler: m.handleGetCurrentToken},
+		{path: "/api/tokens/switch", readRole: RoleViewer, writeRole: RoleOperator, handler: m.handleSwitchToken},
+		{path: "/api/tokens/concurrency", readRole: RoleViewer, writeRole: RoleOperator, handler: m.handleConcurrencyStats},
+		{path: "/api/events", readRole: RoleViewer, writeRole: RoleViewer, handler: m.handleEvents},
+		{path: "/api/requests/active", readRole: RoleViewer, writeRole: RoleViewer, handler: m.handleActiveRequests},
+		{path: "/api/requests/cancel", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleCancelRequest},
+		{path: "/api/alerts/test", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleTestAlert},
+		{path: "/api/keys", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleAPIKeys},
+		{path: "/api/keys/regenerate", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleRegenerateAPIKey},
+		{path: "/api/usage", readRole: RoleViewer, writeRole: RoleAdmin, handler: m.handleUsage},
+		{path: "/api/budgets", readRole: RoleViewer, writeRole: RoleAdmin, handler: m.handleBudgets},
+		{path: "/api/admin-tokens", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleAdminTokens},
+		{path: "/api/users", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleUsers},
+		{path: "/api/audit", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleAudit},
+		{path: "/api/backup", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleBackup},
+		{path: "/api/restore", readRole: RoleAdmin, writeRole: RoleAdmin, handler: m.handleRestore},
+	}
+}
+
 // handleRoot 处理根路径
 func (m *Manager) handleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
@@ -46,8 +82,7 @@ func (m *Manager) handleRoot(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 检查会话
-	sessionCookie, err := r.Cookie("session_id")
-	if err != nil || !m.ValidateSession(sessionCookie.Value) {
+	if _, ok := m.requestSession(r); !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
@@ -59,7 +94,7 @@ func (m *Manager) handleRoot(w http.ResponseWriter, r *http.Request) {
 func (m *Manager) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		isInit := r.URL.Query().Get("init") == "true"
-		m.renderLoginPage(w, isInit)
+		m.renderLoginPage(w, http.StatusOK, isInit)
 		return
 	}
 
@@ -68,12 +103,20 @@ func (m *Manager) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 同一IP连续登录失败过多时拒绝尝试
+	clientIP := loginClientIP(r)
+	if lockout := m.loginGuard.lockedFor(clientIP, time.Now()); lockout > 0 {
+		m.renderLoginLocked(w, lockout)
+		return
+	}
+
 	// 处理登录
 	if err := r.ParseForm(); err != nil {
 		m.renderError(w, "解析表单失败", http.StatusBadRequest)
 		return
 	}
 
+	username := strings.TrimSpace(r.FormValue("username"))
 	password := r.FormValue("password")
 	if password == "" {
 		m.renderError(w, "密码不能为空", http.StatusBadRequest)
@@ -94,23 +137,31 @@ func (m *Manager) handleLogin(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		// 验证密码
-		if !m.VerifyLoginPassword(password) {
-			m.renderLoginPage(w, false, "密码错误")
+		// 验证用户名和密码，用户名为空时为内置管理员
+		if _, err := m.AuthenticateUser(username, password); err != nil {
+			if lockout := m.loginGuard.recordFailure(clientIP, time.Now()); lockout > 0 {
+				m.audit(r, AuditActionLoginLocked, username, "lockout="+lockout.String())
+				m.renderLoginLocked(w, lockout)
+				return
+			}
+			m.audit(r, AuditActionLoginFailure, username, err.Error())
+			m.renderLoginPage(w, http.StatusUnauthorized, false, err.Error())
 			return
 		}
+		m.loginGuard.recordSuccess(clientIP)
+	}
+	if username == "" || isInit {
+		username = BuiltinAdminUsername
 	}
 
 	// 创建会话
-	session := m.CreateSession()
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    session.ID,
-		Path:     "/",
-		Expires:  session.ExpiresAt,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	})
+	session, err := m.CreateSession(username, clientIP)
+	if err != nil {
+		m.renderError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, session)
+	m.audit(withAdminActor(r, userActor(username)), AuditActionLoginSuccess, username, "")
 
 	http.Redirect(w, r, "/config", http.StatusSeeOther)
 }
@@ -147,38 +198,26 @@ func (m *Manager) handleInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 创建会话
-	session := m.CreateSession()
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    session.ID,
-		Path:     "/",
-		Expires:  session.ExpiresAt,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	})
+	// 创建会话（会话ID只通过HttpOnly Cookie下发）
+	session, err := m.CreateSession(BuiltinAdminUsername, loginClientIP(r))
+	if err != nil {
+		m.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, session)
 
 	m.writeJSONResponse(w, map[string]interface{}{
 		"success": true,
 		"message": "初始化成功",
-		"session": session.ID,
 	})
 }
 
 // handleLogout 处理登出
 func (m *Manager) handleLogout(w http.ResponseWriter, r *http.Request) {
-	sessionCookie, err := r.Cookie("session_id")
-	if err == nil {
-		m.InvalidateSession(sessionCookie.Value)
+	if session := contextSession(r); session != nil {
+		m.InvalidateSession(session.ID)
 	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Path:     "/",
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-	})
+	clearSessionCookie(w, r)
 
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
@@ -186,7 +225,7 @@ func (m *Manager) handleLogout(w http.ResponseWriter, r *http.Request) {
 // handleConfig 处理配置页面
 func (m *Manager) handleConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		m.renderConfigPage(w)
+		m.renderConfigPage(w, r)
 		return
 	}
 
@@ -198,8 +237,17 @@ func (m *Manager) handleAPIConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		config := m.GetConfig()
-		// 不返回密码
+		// 不返回密码、API密钥、管理令牌和用户密码的哈希
 		config.LoginPassword = ""
+		for i, key := range config.APIKeys {
+			config.APIKeys[i] = key.Redacted()
+		}
+		for i, token := range config.AdminTokens {
+			config.AdminTokens[i] = token.Redacted()
+		}
+		for i, user := range config.Users {
+			config.Users[i] = user.Redacted()
+		}
 		m.writeJSONResponse(w, config)
 
 	case "PUT":
@@ -209,11 +257,14 @@ func (m *Manager) handleAPIConfig(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 保持原有的登录密码
+		// 保持原有的登录密码、API密钥、管理令牌和用户（分别通过 /api/keys、/api/admin-tokens 和 /api/users 管理）
 		oldConfig := m.GetConfig()
 		newConfig.LoginPassword = oldConfig.LoginPassword
+		newConfig.APIKeys = oldConfig.APIKeys
+		newConfig.AdminTokens = oldConfig.AdminTokens
+		newConfig.Users = oldConfig.Users
 
-		if err := m.UpdateConfig(&newConfig); err != nil {
+		if err := m.updateConfigFrom(r, AuditActionConfigUpdate, "", &newConfig); err != nil {
 			m.writeJSONError(w, fmt.Sprintf("更新配置失败: %v", err), http.StatusBadRequest)
 			return
 		}
@@ -237,6 +288,7 @@ func (m *Manager) handleRefreshTokens(w http.ResponseWriter, r *http.Request) {
 	
 	// 强制刷新Token缓存
 	go m.ForceRefreshTokenCache()
+	m.audit(r, AuditActionTokenRefresh, "all", "")
 	
 	m.writeJSONResponse(w, map[string]interface{}{
 		"success": true,
@@ -256,6 +308,7 @@ func (m *Manager) handleRefreshSingleToken(w http.ResponseWriter, r *http.Reques
 		m.writeJSONError(w, "Token ID不能为空", http.StatusBadRequest)
 		return
 	}
+	m.audit(r, AuditActionTokenRefresh, tokenID, "")
 	
 	// 异步刷新单个Token
 	go func() {
@@ -282,21 +335,14 @@ func (m *Manager) handleRefreshSingleToken(w http.ResponseWriter, r *http.Reques
 				} else {
 					// 获取实时使用信息，失败时自动重试2次
 					var err error
-					var userEmail, userId string
-					var remainingUsage float64
-					var lastUsed *time.Time
+					var usage *TokenUsage
 					
 					maxRetries := 2
 					for attempt := 0; attempt <= maxRetries; attempt++ {
-						userEmail, userId, remainingUsage, lastUsed, err = provider(token)
+						usage, err = provider(token)
 						if err == nil {
 							// 成功获取信息
-							tokenInfo.UserEmail = userEmail
-							tokenInfo.UserId = userId
-							tokenInfo.RemainingUsage = remainingUsage
-							if lastUsed != nil {
-								tokenInfo.LastUsed = lastUsed
-							}
+							tokenInfo.apply(usage)
 							break
 						}
 						
@@ -330,9 +376,29 @@ func (m *Manager) handleRefreshSingleToken(w http.ResponseWriter, r *http.Reques
 func (m *Manager) handleAPITokens(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		// 获取带有实时使用信息的Token列表（使用缓存）
+		// 获取带有实时使用信息的Token列表（使用缓存），不返回刷新Token和客户端密钥
 		tokens := m.GetTokensWithUsageInfo()
-		m.writeJSONResponse(w, tokens)
+		for i := range tokens {
+			tokens[i].AuthToken = tokens[i].AuthToken.Redacted()
+		}
+
+		// 指定forecastDays时附带Token池额度预测
+		forecastParam := r.URL.Query().Get("forecastDays")
+		if forecastParam == "" {
+			m.writeJSONResponse(w, tokens)
+			return
+		}
+
+		days, err := strconv.Atoi(forecastParam)
+		if err != nil || days < 1 || days > maxForecastDays {
+			m.writeJSONError(w, fmt.Sprintf("forecastDays必须在 1-%d 范围内", maxForecastDays), http.StatusBadRequest)
+			return
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"tokens":   tokens,
+			"forecast": ForecastCredits(tokens, days, time.Now()),
+		})
 
 	case "POST":
 		var token AuthToken
@@ -358,7 +424,7 @@ func (m *Manager) handleAPITokens(w http.ResponseWriter, r *http.Request) {
 		config := m.GetConfig()
 		config.AuthTokens = append(config.AuthTokens, token)
 
-		if err := m.UpdateConfig(config); err != nil {
+		if err := m.updateConfigFrom(r, AuditActionTokenCreate, token.ID, config); err != nil {
 			m.writeJSONError(w, fmt.Sprintf("添加Token失败: %v", err), http.StatusInternalServerError)
 			return
 		}
@@ -366,7 +432,7 @@ func (m *Manager) handleAPITokens(w http.ResponseWriter, r *http.Request) {
 		m.writeJSONResponse(w, map[string]interface{}{
 			"success": true,
 			"message": "Token添加成功",
-			"token":   token,
+			"token":   token.Redacted(),
 		})
 
 	case "PUT":
@@ -401,7 +467,7 @@ func (m *Manager) handleAPITokens(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := m.UpdateConfig(config); err != nil {
+		if err := m.updateConfigFrom(r, AuditActionTokenUpdate, tokenID, config); err != nil {
 			m.writeJSONError(w, fmt.Sprintf("更新Token失败: %v", err), http.StatusInternalServerError)
 			return
 		}
@@ -436,7 +502,7 @@ func (m *Manager) handleAPITokens(w http.ResponseWriter, r *http.Request) {
 		}
 
 		config.AuthTokens = updatedTokens
-		if err := m.UpdateConfig(config); err != nil {
+		if err := m.updateConfigFrom(r, AuditActionTokenDelete, tokenID, config); err != nil {
 			m.writeJSONError(w, fmt.Sprintf("删除Token失败: %v", err), http.StatusInternalServerError)
 			return
 		}
@@ -471,6 +537,7 @@ func (m *Manager) handleBackup(w http.ResponseWriter, r *http.Request) {
 			m.writeJSONError(w, fmt.Sprintf("备份失败: %v", err), http.StatusInternalServerError)
 			return
 		}
+		m.audit(r, AuditActionConfigBackup, "", "")
 
 		m.writeJSONResponse(w, map[string]interface{}{
 			"success": true,
@@ -503,7 +570,7 @@ func (m *Manager) handleRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := m.RestoreFromBackup(req.BackupFile); err != nil {
+	if err := m.restoreFromBackup(r, req.BackupFile); err != nil {
 		m.writeJSONError(w, fmt.Sprintf("恢复失败: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -521,17 +588,68 @@ func (m *Manager) handleStatic(w http.ResponseWriter, r *http.Request) {
 }
 
 // withAuth 认证中间件
-func (m *Manager) withAuth(handler http.HandlerFunc) http.HandlerFunc {
+// 登录会话按用户角色授权：查询请求（GET/HEAD）需要 readRole，其他请求需要 writeRole
+func (m *Manager) withAuth(readRole, writeRole string, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sessionCookie, err := r.Cookie("session_id")
-		if err != nil || !m.ValidateSession(sessionCookie.Value) {
+		// 脚本使用管理令牌（Authorization: Bearer）访问，按令牌的权限范围授权
+		if secret, ok := bearerToken(r); ok {
+			authorized, ok := m.authenticateBearer(w, r, secret)
+			if ok {
+				handler(w, authorized)
+			}
+			return
+		}
+
+		session, ok := m.requestSession(r)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		// 角色每次从配置读取，用户被删除或禁用后会话立即失效
+		role, ok := m.userRole(session.Username)
+		if !ok {
+			m.InvalidateUserSessions(session.Username)
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+		r = withSession(withAdminActor(r, userActor(session.Username)), session)
+
+		// 会话认证的修改请求必须携带页面下发的CSRF令牌
+		if isStateChanging(r.Method) && !validCSRF(r, session) {
+			m.audit(r, AuditActionCSRFRejected, r.Method+" "+r.URL.Path, "")
+			m.writeJSONError(w, "CSRF令牌无效，请刷新页面后重试", http.StatusForbidden)
+			return
+		}
+
+		required := readRole
+		if isStateChanging(r.Method) {
+			required = writeRole
+		}
+		if !roleAllows(role, required) {
+			m.audit(r, AuditActionRoleDenied, r.Method+" "+r.URL.Path, "role="+role)
+			m.writeJSONError(w, fmt.Sprintf("用户角色(%s)不允许 %s %s", role, r.Method, r.URL.Path), http.StatusForbidden)
+			return
+		}
 		handler(w, r)
 	}
 }
 
+// withMethodRoles 与 withAuth 相同，但可以按请求方法指定修改请求需要的角色，未列出的方法需要 writeRole
+func (m *Manager) withMethodRoles(readRole, writeRole string, methodRoles map[string]string, handler http.HandlerFunc) http.HandlerFunc {
+	fallback := m.withAuth(readRole, writeRole, handler)
+	byMethod := make(map[string]http.HandlerFunc, len(methodRoles))
+	for method, role := range methodRoles {
+		byMethod[method] = m.withAuth(readRole, role, handler)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := byMethod[r.Method]; ok {
+			h(w, r)
+			return
+		}
+		fallback(w, r)
+	}
+}
+
 // writeJSONResponse 写入JSON响应
 func (m *Manager) writeJSONResponse(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -574,8 +692,19 @@ func (m *Manager) renderError(w http.ResponseWriter, message string, code int) {
 	tmpl.Execute(w, map[string]string{"Message": message})
 }
 
+// renderLoginLocked 渲染登录锁定提示，返回429并通过 Retry-After 告知剩余锁定时长
+func (m *Manager) renderLoginLocked(w http.ResponseWriter, lockout time.Duration) {
+	seconds := int(lockout.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	m.renderLoginPage(w, http.StatusTooManyRequests, false,
+		fmt.Sprintf("登录失败次数过多，请在 %s 后重试", (time.Duration(seconds) * time.Second).String()))
+}
+
 // renderLoginPage 渲染登录页面
-func (m *Manager) renderLoginPage(w http.ResponseWriter, isInit bool, errorMsg ...string) {
+func (m *Manager) renderLoginPage(w http.ResponseWriter, code int, isInit bool, errorMsg ...string) {
 	tmpl := template.Must(template.ParseFiles(filepath.Join("webconfig", "static", "login.html")))
 
 	data := map[string]interface{}{
@@ -587,6 +716,7 @@ func (m *Manager) renderLoginPage(w http.ResponseWriter, isInit bool, errorMsg .
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
 	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, "渲染页面失败", http.StatusInternalServerError)
 	}
@@ -633,6 +763,11 @@ func (m *Manager) handleSwitchToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	previousIndex := -1
+	if m.getCurrentTokenIndex != nil {
+		previousIndex = m.getCurrentTokenIndex()
+	}
+
 	// 调用切换函数（通过回调）
 	if m.switchToToken != nil {
 		if err := m.switchToToken(req.Index); err != nil {
@@ -643,6 +778,7 @@ func (m *Manager) handleSwitchToken(w http.ResponseWriter, r *http.Request) {
 		m.writeJSONError(w, "切换功能未初始化", http.StatusServiceUnavailable)
 		return
 	}
+	m.audit(r, AuditActionTokenSwitch, config.AuthTokens[req.Index].ID, fmt.Sprintf("index=%d->%d", previousIndex, req.Index))
 	
 	m.writeJSONResponse(w, map[string]interface{}{
 		"success": true,
@@ -651,12 +787,281 @@ func (m *Manager) handleSwitchToken(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// renderConfigPage 渲染配置页面
-func (m *Manager) renderConfigPage(w http.ResponseWriter) {
+// handleConcurrencyStats 获取token并发与排队统计
+func (m *Manager) handleConcurrencyStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 未配置Token时AuthService不存在，返回空统计
+	stats := types.TokenConcurrencyStats{Tokens: []types.TokenInFlightStat{}}
+	if m.getConcurrencyStats != nil {
+		stats = m.getConcurrencyStats()
+	}
+
+	m.writeJSONResponse(w, stats)
+}
+
+// handleTestAlert 向Webhook发送测试告警（忽略去重和订阅过滤）
+func (m *Manager) handleTestAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 可选：指定Webhook名称，为空时发送到所有启用的Webhook
+	var req struct {
+		Webhook string `json:"webhook"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			m.writeJSONError(w, "JSON解析失败", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if m.testAlert == nil {
+		m.writeJSONError(w, "告警功能未初始化", http.StatusServiceUnavailable)
+		return
+	}
+
+	results := m.testAlert(req.Webhook)
+	if len(results) == 0 {
+		m.writeJSONError(w, "没有可用的Webhook", http.StatusBadRequest)
+		return
+	}
+
+	success := true
+	for _, result := range results {
+		success = success && result.Success
+	}
+
+	m.writeJSONResponse(w, map[string]interface{}{
+		"success": success,
+		"results": results,
+	})
+}
+
+// apiKeyRequest 创建或更新API密钥的请求
+type apiKeyRequest struct {
+	Name             string           `json:"name"`
+	ExpiresAt        *time.Time       `json:"expiresAt"`
+	Enabled          *bool            `json:"enabled"`
+	AllowedModels    []string         `json:"allowedModels"`
+	AllowedEndpoints []string         `json:"allowedEndpoints"`
+	TokenGroup       string           `json:"tokenGroup"`
+	RateLimit        *RateLimitConfig `json:"rateLimit"`
+	Budget           *BudgetConfig    `json:"budget"`
+}
+
+// apply 将请求中的字段应用到API密钥
+func (req apiKeyRequest) apply(key *APIKey) {
+	key.Name = req.Name
+	key.ExpiresAt = req.ExpiresAt
+	if req.Enabled != nil {
+		key.Enabled = *req.Enabled
+	}
+	key.AllowedModels = req.AllowedModels
+	key.AllowedEndpoints = req.AllowedEndpoints
+	key.TokenGroup = req.TokenGroup
+	key.RateLimit = req.RateLimit
+	key.Budget = req.Budget
+}
+
+// handleAPIKeys 处理客户端API密钥的增删改查
+// 密钥明文只在创建时返回一次，之后只保存哈希
+func (m *Manager) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		config := m.GetConfig()
+		keys := make([]APIKey, 0, len(config.APIKeys))
+		for _, key := range config.APIKeys {
+			keys = append(keys, key.Redacted())
+		}
+		m.writeJSONResponse(w, keys)
+
+	case "POST":
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			m.writeJSONError(w, "JSON解析失败", http.StatusBadRequest)
+			return
+		}
+
+		if req.Name == "" {
+			m.writeJSONError(w, "API密钥名称不能为空", http.StatusBadRequest)
+			return
+		}
+
+		key := APIKey{
+			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			CreatedAt: time.Now(),
+			Enabled:   true,
+		}
+		req.apply(&key)
+
+		secret, err := key.newAPIKeySecret()
+		if err != nil {
+			m.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		config := m.GetConfig()
+		config.APIKeys = append(config.APIKeys, key)
+
+		if err := m.updateConfigFrom(r, AuditActionKeyCreate, key.Name, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("创建API密钥失败: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "API密钥创建成功，请妥善保存，密钥只显示一次",
+			"key":     key.Redacted(),
+			"secret":  secret,
+		})
+
+	case "PUT":
+		keyID := r.URL.Query().Get("id")
+		if keyID == "" {
+			m.writeJSONError(w, "API密钥ID不能为空", http.StatusBadRequest)
+			return
+		}
+
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			m.writeJSONError(w, "JSON解析失败", http.StatusBadRequest)
+			return
+		}
+
+		config := m.GetConfig()
+		var updated *APIKey
+		for i := range config.APIKeys {
+			if config.APIKeys[i].ID == keyID {
+				updated = &config.APIKeys[i]
+				break
+			}
+		}
+
+		if updated == nil {
+			m.writeJSONError(w, "API密钥不存在", http.StatusNotFound)
+			return
+		}
+		req.apply(updated)
+
+		if err := m.updateConfigFrom(r, AuditActionKeyUpdate, updated.Name, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("更新API密钥失败: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "API密钥更新成功",
+			"key":     updated.Redacted(),
+		})
+
+	case "DELETE":
+		keyID := r.URL.Query().Get("id")
+		if keyID == "" {
+			m.writeJSONError(w, "API密钥ID不能为空", http.StatusBadRequest)
+			return
+		}
+
+		config := m.GetConfig()
+		updatedKeys := make([]APIKey, 0, len(config.APIKeys))
+		found := false
+
+		for _, key := range config.APIKeys {
+			if key.ID == keyID {
+				found = true
+				continue
+			}
+			updatedKeys = append(updatedKeys, key)
+		}
+
+		if !found {
+			m.writeJSONError(w, "API密钥不存在", http.StatusNotFound)
+			return
+		}
+
+		config.APIKeys = updatedKeys
+		if err := m.updateConfigFrom(r, AuditActionKeyDelete, keyID, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("删除API密钥失败: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "API密钥删除成功",
+		})
+
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRegenerateAPIKey 重新生成API密钥，旧密钥立即失效，新密钥只显示一次
+func (m *Manager) handleRegenerateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyID := r.URL.Query().Get("id")
+	if keyID == "" {
+		m.writeJSONError(w, "API密钥ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	config := m.GetConfig()
+	var target *APIKey
+	for i := range config.APIKeys {
+		if config.APIKeys[i].ID == keyID {
+			target = &config.APIKeys[i]
+			break
+		}
+	}
+
+	if target == nil {
+		m.writeJSONError(w, "API密钥不存在", http.StatusNotFound)
+		return
+	}
+
+	secret, err := target.newAPIKeySecret()
+	if err != nil {
+		m.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := m.updateConfigFrom(r, AuditActionKeyRegenerate, target.Name, config); err != nil {
+		m.writeJSONError(w, fmt.Sprintf("重新生成API密钥失败: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	m.writeJSONResponse(w, map[string]interface{}{
+		"success": true,
+		"message": "API密钥已重新生成，请妥善保存，密钥只显示一次",
+		"key":     target.Redacted(),
+		"secret":  secret,
+	})
+}
+
+// renderConfigPage 渲染配置页面，页面中嵌入会话的CSRF令牌供前端修改请求使用，
+// 并嵌入当前用户和角色，前端据此隐藏无权访问的功能
+func (m *Manager) renderConfigPage(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseFiles(filepath.Join("webconfig", "static", "index.html")))
 
+	data := map[string]interface{}{"CSRFToken": "", "Username": "", "Role": RoleAdmin}
+	if session := contextSession(r); session != nil {
+		data["CSRFToken"] = session.CSRFToken
+		data["Username"] = session.Username
+		if role, ok := m.userRole(session.Username); ok {
+			data["Role"] = role
+		}
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := tmpl.Execute(w, nil); err != nil {
+	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, "渲染页面失败", http.StatusInternalServerError)
 	}
 }
\ No newline at end of file
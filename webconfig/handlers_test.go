@@ -0,0 +1,140 @@
+package webconfig
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtectedRoutes_RoleMatrix(t *testing.T) {
+	// 每个路由各角色能否查询、修改（POST/PUT/DELETE）
+	type access struct{ get, post, put, del bool }
+	var (
+		everyone  = access{true, true, true, true}
+		adminOnly = access{false, false, false, false}
+		readAll   = access{get: true}
+	)
+	matrix := map[string]map[string]access{
+		"/logout":                    {RoleViewer: everyone, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/sessions/logout-all":   {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/config":                    {RoleViewer: readAll, RoleOperator: readAll, RoleAdmin: everyone},
+		"/api/config":                {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/tokens":                {RoleViewer: readAll, RoleOperator: {get: true, put: true}, RoleAdmin: everyone},
+		"/api/tokens/refresh":        {RoleViewer: readAll, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/tokens/refresh-single": {RoleViewer: readAll, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/tokens/current":        {RoleViewer: readAll, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/tokens/switch":         {RoleViewer: readAll, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/tokens/concurrency":    {RoleViewer: readAll, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/events":                {RoleViewer: everyone, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/requests/active":       {RoleViewer: everyone, RoleOperator: everyone, RoleAdmin: everyone},
+		"/api/requests/cancel":       {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/alerts/test":           {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/keys":                  {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/keys/regenerate":       {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/usage":                 {RoleViewer: readAll, RoleOperator: readAll, RoleAdmin: everyone},
+		"/api/budgets":               {RoleViewer: readAll, RoleOperator: readAll, RoleAdmin: everyone},
+		"/api/admin-tokens":          {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/users":                 {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/audit":                 {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/backup":                {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+		"/api/restore":               {RoleViewer: adminOnly, RoleOperator: adminOnly, RoleAdmin: everyone},
+	}
+
+	config := testConfigWithToken()
+	config.Users = []ConsoleUser{
+		{ID: "user-viewer", Username: "viewer", Role: RoleViewer},
+		{ID: "user-operator", Username: "operator", Role: RoleOperator},
+	}
+	m := newTestManager(t, config)
+	sessions := make(map[string]*Session)
+	for role, username := range map[string]string{RoleViewer: "viewer", RoleOperator: "operator", RoleAdmin: BuiltinAdminUsername} {
+		session, err := m.CreateSession(username, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[role] = session
+	}
+
+	// 用桩处理器替换真实处理器，只验证路由声明的角色要求
+	stub := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	routes := m.protectedRoutes()
+	assert.Len(t, routes, len(matrix), "新增路由需要补充到角色矩阵")
+	for _, route := range routes {
+		expected, ok := matrix[route.path]
+		if !ok {
+			t.Errorf("路由 %s 不在角色矩阵中", route.path)
+			continue
+		}
+		handler := m.withMethodRoles(route.readRole, route.writeRole, route.methodRoles, stub)
+		for role, session := range sessions {
+			want := expected[role]
+			for method, allowed := range map[string]bool{
+				http.MethodGet:    want.get,
+				http.MethodPost:   want.post,
+				http.MethodPut:    want.put,
+				http.MethodDelete: want.del,
+			} {
+				req := sessionRequest(method, route.path, "", session)
+				req.Header.Set(csrfHeader, session.CSRFToken)
+				w := httptest.NewRecorder()
+				handler(w, req)
+
+				wantCode := http.StatusForbidden
+				if allowed {
+					wantCode = http.StatusNoContent
+				}
+				assert.Equal(t, wantCode, w.Code, "%s %s %s", role, method, route.path)
+			}
+		}
+	}
+}
+
+func TestHandleEvents_LogsRequireOperator(t *testing.T) {
+	config := testConfigWithToken()
+	config.Users = []ConsoleUser{
+		{ID: "user-viewer", Username: "viewer", Role: RoleViewer},
+		{ID: "user-operator", Username: "operator", Role: RoleOperator},
+	}
+	m := newTestManager(t, config)
+	mux := http.NewServeMux()
+	m.SetupRoutes(mux)
+	events.Publish(events.TypeLog, map[string]any{"level": "error", "message": "log-event-marker"})
+	events.Publish(events.TypePoolEmpty, map[string]any{"total": 1})
+
+	tests := []struct {
+		username string
+		query    string
+		wantCode int
+		wantLogs bool
+	}{
+		{username: "viewer", query: "?logs=debug", wantCode: http.StatusForbidden},
+		{username: "viewer", query: "", wantCode: http.StatusOK},
+		{username: "operator", query: "?logs=debug", wantCode: http.StatusOK, wantLogs: true},
+		{username: BuiltinAdminUsername, query: "?logs=error", wantCode: http.StatusOK, wantLogs: true},
+	}
+	for _, tt := range tests {
+		session, err := m.CreateSession(tt.username, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 上下文提前取消，处理器补发最近的事件后立即返回
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := sessionRequest(http.MethodGet, "/api/events"+tt.query, "", session).WithContext(ctx)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantCode, w.Code, "%s %s", tt.username, tt.query)
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		assert.Contains(t, w.Body.String(), `"type":"pool.empty"`, tt.username)
+		assert.Equal(t, tt.wantLogs, strings.Contains(w.Body.String(), "log-event-marker"), "%s %s", tt.username, tt.query)
+	}
+}
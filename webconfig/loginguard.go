@@ -0,0 +1,96 @@
+package webconfig
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// loginAttempts 单个客户端地址的登录失败记录
+type loginAttempts struct {
+	failures    int       // 连续失败次数
+	lastFailure time.Time // 最近一次失败时间
+	lockedUntil time.Time // 锁定截止时间
+}
+
+// loginGuard 按客户端IP限制登录失败次数
+// 连续失败达到阈值后锁定，之后每次失败锁定时长翻倍，直至上限；登录成功后清零
+type loginGuard struct {
+	mutex    sync.Mutex
+	attempts map[string]*loginAttempts
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{attempts: make(map[string]*loginAttempts)}
+}
+
+// loginClientIP 返回用于限制登录的客户端地址
+// 只使用连接的远端地址，不信任可被伪造的 X-Forwarded-For
+func loginClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lockedFor 返回客户端剩余的锁定时长，未锁定时返回0
+func (g *loginGuard) lockedFor(ip string, now time.Time) time.Duration {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if attempts, exists := g.attempts[ip]; exists && now.Before(attempts.lockedUntil) {
+		return attempts.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// recordFailure 记录一次登录失败，返回因此产生的锁定时长（未触发锁定时为0）
+func (g *loginGuard) recordFailure(ip string, now time.Time) time.Duration {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	attempts, exists := g.attempts[ip]
+	if !exists {
+		attempts = &loginAttempts{}
+		g.attempts[ip] = attempts
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+
+	if attempts.failures < config.LoginLockoutThreshold {
+		return 0
+	}
+
+	lockout := config.LoginLockoutBase
+	for i := config.LoginLockoutThreshold; i < attempts.failures && lockout < config.LoginLockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > config.LoginLockoutMax {
+		lockout = config.LoginLockoutMax
+	}
+	attempts.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// recordSuccess 登录成功，清除失败记录
+func (g *loginGuard) recordSuccess(ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.attempts, ip)
+}
+
+// cleanup 清除已解锁且长时间没有再失败的记录
+func (g *loginGuard) cleanup(now time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for ip, attempts := range g.attempts {
+		if now.After(attempts.lockedUntil) && now.Sub(attempts.lastFailure) > config.LoginLockoutMax {
+			delete(g.attempts, ip)
+		}
+	}
+}
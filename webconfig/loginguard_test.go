@@ -0,0 +1,68 @@
+package webconfig
+
+import (
+	"testing"
+	"time"
+
+	"kiro2api/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginGuard_LockoutDoublesUpToMax(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{config.LoginLockoutThreshold - 1, 0},
+		{config.LoginLockoutThreshold, config.LoginLockoutBase},
+		{config.LoginLockoutThreshold + 1, 2 * config.LoginLockoutBase},
+		{config.LoginLockoutThreshold + 2, 4 * config.LoginLockoutBase},
+		{config.LoginLockoutThreshold + 20, config.LoginLockoutMax},
+	}
+	for _, tt := range tests {
+		g := newLoginGuard()
+		now := time.Now()
+		var lockout time.Duration
+		for i := 0; i < tt.failures; i++ {
+			lockout = g.recordFailure("10.0.0.1", now)
+		}
+		assert.Equal(t, tt.want, lockout, "failures=%d", tt.failures)
+		assert.Equal(t, tt.want, g.lockedFor("10.0.0.1", now), "failures=%d", tt.failures)
+	}
+}
+
+func TestLoginGuard_LockExpiresAndSuccessResets(t *testing.T) {
+	g := newLoginGuard()
+	now := time.Now()
+	for i := 0; i < config.LoginLockoutThreshold; i++ {
+		g.recordFailure("10.0.0.1", now)
+	}
+
+	assert.Equal(t, config.LoginLockoutBase, g.lockedFor("10.0.0.1", now))
+	assert.Equal(t, time.Duration(0), g.lockedFor("10.0.0.2", now), "其他IP不受影响")
+	assert.Equal(t, time.Second, g.lockedFor("10.0.0.1", now.Add(config.LoginLockoutBase-time.Second)))
+	assert.Equal(t, time.Duration(0), g.lockedFor("10.0.0.1", now.Add(config.LoginLockoutBase)))
+
+	// 锁定结束后再次失败，锁定时长继续翻倍
+	later := now.Add(config.LoginLockoutBase)
+	assert.Equal(t, 2*config.LoginLockoutBase, g.recordFailure("10.0.0.1", later))
+
+	// 登录成功后清零
+	g.recordSuccess("10.0.0.1")
+	assert.Equal(t, time.Duration(0), g.lockedFor("10.0.0.1", later))
+	assert.Equal(t, time.Duration(0), g.recordFailure("10.0.0.1", later))
+}
+
+func TestLoginGuard_Cleanup(t *testing.T) {
+	g := newLoginGuard()
+	now := time.Now()
+	g.recordFailure("10.0.0.1", now)
+
+	g.cleanup(now.Add(time.Minute))
+	assert.Len(t, g.attempts, 1, "最近的失败记录保留")
+
+	g.cleanup(now.Add(config.LoginLockoutMax + time.Minute))
+	assert.Empty(t, g.attempts)
+}
@@ -0,0 +1,74 @@
+package webconfig
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id 参数（OWASP 推荐的内存型配置）
+const (
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Time    = 3
+	argon2Threads = 2
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// passwordHashPrefix argon2id 哈希的 PHC 格式前缀
+const passwordHashPrefix = "$argon2id$"
+
+// HashPassword 使用 argon2id 计算登录密码的哈希，结果为 PHC 格式：
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("生成密码盐失败: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		passwordHashPrefix, argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// IsPasswordHashed 判断保存的登录密码是否已是哈希（旧版本配置保存的是明文）
+func IsPasswordHashed(stored string) bool {
+	return strings.HasPrefix(stored, passwordHashPrefix)
+}
+
+// verifyPasswordHash 校验密码是否与 argon2id 哈希匹配，参数从哈希中读取
+func verifyPasswordHash(encoded, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("密码哈希格式无效")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("不支持的 argon2 版本: %s", parts[2])
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, fmt.Errorf("密码哈希参数无效: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("密码盐解码失败: %w", err)
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("密码哈希解码失败: %w", err)
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(actual, expected) == 1, nil
+}
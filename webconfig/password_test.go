@@ -0,0 +1,62 @@
+package webconfig
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyPasswordHash(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, IsPasswordHashed(hash))
+
+	tests := []struct {
+		name     string
+		encoded  string
+		password string
+		want     bool
+		wantErr  bool
+	}{
+		{"correct password", hash, "correct horse", true, false},
+		{"wrong password", hash, "wrong horse", false, false},
+		{"malformed hash", "$argon2id$v=19$broken", "correct horse", false, true},
+		{"unsupported version", strings.Replace(hash, "v=19", "v=16", 1), "correct horse", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := verifyPasswordHash(tt.encoded, tt.password)
+			assert.Equal(t, tt.want, ok)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+
+	// 相同密码每次使用不同的盐
+	again, _ := HashPassword("correct horse")
+	assert.NotEqual(t, hash, again)
+}
+
+func TestVerifyLoginPassword_MigratesPlaintext(t *testing.T) {
+	config := GetDefaultConfig()
+	config.LoginPassword = "legacy-password"
+	config.ServiceConfig.ClientToken = "client-token-value"
+	m := newTestManager(t, config)
+
+	assert.False(t, m.VerifyLoginPassword("wrong-password"))
+	assert.Equal(t, "legacy-password", m.GetConfig().LoginPassword, "密码错误时不迁移")
+
+	assert.True(t, m.VerifyLoginPassword("legacy-password"))
+	migrated := m.GetConfig().LoginPassword
+	assert.True(t, IsPasswordHashed(migrated))
+
+	saved, err := os.ReadFile(m.storage.configPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(saved), "legacy-password")
+	assert.Contains(t, string(saved), migrated)
+
+	// 迁移后使用哈希校验
+	assert.True(t, m.VerifyLoginPassword("legacy-password"))
+	assert.False(t, m.VerifyLoginPassword("wrong-password"))
+}
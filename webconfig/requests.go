@@ -0,0 +1,95 @@
+package webconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrActiveRequestNotFound 要取消的请求不存在或已结束
+var ErrActiveRequestNotFound = errors.New("请求不存在或已结束")
+
+// ActiveRequest 一个在途请求的状态
+type ActiveRequest struct {
+	ID            string           `json:"id"`
+	KeyID         string           `json:"keyId,omitempty"`
+	KeyName       string           `json:"keyName,omitempty"`
+	Endpoint      string           `json:"endpoint"`
+	Model         string           `json:"model"`
+	Stream        bool             `json:"stream"`
+	TokenID       string           `json:"tokenId"` // 上游token，排队等待token时为空
+	TokenIndex    int              `json:"tokenIndex"`
+	StartedAt     time.Time        `json:"startedAt"`
+	DurationMs    int64            `json:"durationMs"`
+	BytesStreamed int64            `json:"bytesStreamed"`        // 已写给客户端的字节数
+	BlockIndex    *int             `json:"blockIndex,omitempty"` // 当前内容块索引，尚未输出内容时为空
+	ToolCalls     []ActiveToolCall `json:"toolCalls"`            // 进行中的工具调用
+	Cancelled     bool             `json:"cancelled"`            // 已被取消、正在结束
+}
+
+// ActiveToolCall 在途请求中进行中的工具调用
+type ActiveToolCall struct {
+	BlockIndex int    `json:"blockIndex"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+}
+
+// handleActiveRequests 查看在途请求：客户端密钥、模型、上游token、已输出字节数、当前内容块和进行中的工具调用
+func (m *Manager) handleActiveRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests := []ActiveRequest{}
+	if m.listActiveRequests != nil {
+		requests = m.listActiveRequests()
+	}
+
+	m.writeJSONResponse(w, map[string]interface{}{
+		"requests": requests,
+		"count":    len(requests),
+	})
+}
+
+// handleCancelRequest 取消在途请求：中断上游响应读取，并向客户端发送错误
+func (m *Manager) handleCancelRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		m.writeJSONError(w, "JSON解析失败", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		m.writeJSONError(w, "请求ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	if m.cancelRequest == nil {
+		m.writeJSONError(w, "取消功能未初始化", http.StatusServiceUnavailable)
+		return
+	}
+	if err := m.cancelRequest(req.ID); err != nil {
+		if errors.Is(err, ErrActiveRequestNotFound) {
+			m.writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		m.writeJSONError(w, fmt.Sprintf("取消请求失败: %v", err), http.StatusInternalServerError)
+		return
+	}
+	m.audit(r, AuditActionRequestCancel, req.ID, "")
+
+	m.writeJSONResponse(w, map[string]interface{}{
+		"success": true,
+		"message": "请求已取消",
+		"id":      req.ID,
+	})
+}
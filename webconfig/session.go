@@ -0,0 +1,308 @@
+package webconfig
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	sessionCookieName = "session_id"
+	sessionsFileName  = "sessions.json" // 会话持久化文件（保存在配置目录中）
+	sessionIDBytes    = 32
+	csrfTokenBytes    = 32
+
+	// csrfHeader 会话发起修改请求时必须携带的CSRF令牌请求头
+	csrfHeader = "X-CSRF-Token"
+)
+
+// Session 会话信息
+// 会话ID只存在于Cookie中，内存和磁盘上以其SHA-256哈希为键，泄露会话文件不会泄露可用的会话
+type Session struct {
+	ID         string    `json:"-"`
+	Username   string    `json:"username"` // 登录的控制台用户，角色在每次请求时从配置读取
+	CSRFToken  string    `json:"csrfToken"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"` // 登录时的客户端地址
+}
+
+// hashSessionID 计算会话ID的哈希，作为会话表的键
+func hashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex 生成指定字节数的随机十六进制字符串
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// sessionsPath 返回会话持久化文件路径
+func (m *Manager) sessionsPath() string {
+	return filepath.Join(m.storage.dataDir(), sessionsFileName)
+}
+
+// loadSessions 从磁盘恢复未过期的会话，使重启后无需重新登录
+func (m *Manager) loadSessions() {
+	data, err := os.ReadFile(m.sessionsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("读取会话文件失败: %v\n", err)
+		}
+		return
+	}
+
+	sessions := make(map[string]*Session)
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		fmt.Printf("解析会话文件失败，已忽略: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	for key, session := range sessions {
+		if now.Before(session.ExpiresAt) {
+			// 引入控制台用户之前的会话都由内置管理员创建
+			if session.Username == "" {
+				session.Username = BuiltinAdminUsername
+			}
+			m.sessions[key] = session
+		}
+	}
+}
+
+// saveSessionsUnlocked 原子写入会话文件，调用方需持有 sessionMutex
+func (m *Manager) saveSessionsUnlocked() {
+	data, err := json.MarshalIndent(m.sessions, "", "  ")
+	if err != nil {
+		fmt.Printf("序列化会话失败: %v\n", err)
+		return
+	}
+
+	path := m.sessionsPath()
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, configFileMode); err != nil {
+		fmt.Printf("保存会话失败: %v\n", err)
+		return
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		fmt.Printf("保存会话失败: %v\n", err)
+	}
+}
+
+// CreateSession 为控制台用户创建会话
+func (m *Manager) CreateSession(username, remoteAddr string) (*Session, error) {
+	sessionID, err := randomHex(sessionIDBytes)
+	if err != nil {
+		return nil, fmt.Errorf("生成会话ID失败: %w", err)
+	}
+	csrfToken, err := randomHex(csrfTokenBytes)
+	if err != nil {
+		return nil, fmt.Errorf("生成CSRF令牌失败: %w", err)
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:         sessionID,
+		Username:   username,
+		CSRFToken:  csrfToken,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(sessionDuration),
+		RemoteAddr: remoteAddr,
+	}
+
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	m.sessions[hashSessionID(sessionID)] = session
+	m.saveSessionsUnlocked()
+
+	return session, nil
+}
+
+// lookupSession 返回有效的会话
+func (m *Manager) lookupSession(sessionID string) (*Session, bool) {
+	if sessionID == "" {
+		return nil, false
+	}
+
+	m.sessionMutex.RLock()
+	defer m.sessionMutex.RUnlock()
+
+	session, exists := m.sessions[hashSessionID(sessionID)]
+	if !exists || time.Now().After(session.ExpiresAt) {
+		return nil, false
+	}
+	return session, true
+}
+
+// ValidateSession 验证会话
+func (m *Manager) ValidateSession(sessionID string) bool {
+	_, ok := m.lookupSession(sessionID)
+	return ok
+}
+
+// InvalidateSession 使会话失效
+func (m *Manager) InvalidateSession(sessionID string) {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+
+	key := hashSessionID(sessionID)
+	if _, exists := m.sessions[key]; exists {
+		delete(m.sessions, key)
+		m.saveSessionsUnlocked()
+	}
+}
+
+// InvalidateUserSessions 使指定用户的所有会话失效（用户被删除、禁用或修改密码时）
+func (m *Manager) InvalidateUserSessions(username string) {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+
+	removed := false
+	for key, session := range m.sessions {
+		if session.Username == username {
+			delete(m.sessions, key)
+			removed = true
+		}
+	}
+	if removed {
+		m.saveSessionsUnlocked()
+	}
+}
+
+// InvalidateAllSessions 使所有会话失效，返回失效的会话数
+func (m *Manager) InvalidateAllSessions() int {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+
+	count := len(m.sessions)
+	m.sessions = make(map[string]*Session)
+	m.saveSessionsUnlocked()
+	return count
+}
+
+// cleanupExpiredSessions 清理过期会话和过期的登录失败记录
+func (m *Manager) cleanupExpiredSessions() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.sessionMutex.Lock()
+		now := time.Now()
+		removed := false
+		for key, session := range m.sessions {
+			if now.After(session.ExpiresAt) {
+				delete(m.sessions, key)
+				removed = true
+			}
+		}
+		if removed {
+			m.saveSessionsUnlocked()
+		}
+		m.sessionMutex.Unlock()
+
+		m.loginGuard.cleanup(now)
+	}
+}
+
+// requestSession 返回请求Cookie中的有效会话
+func (m *Manager) requestSession(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, false
+	}
+	return m.lookupSession(cookie.Value)
+}
+
+// isSecureRequest 判断请求是否通过HTTPS到达（直连TLS或经反向代理）
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// setSessionCookie 写入会话Cookie：HttpOnly、SameSite=Strict，HTTPS下附加Secure
+func setSessionCookie(w http.ResponseWriter, r *http.Request, session *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookie 清除会话Cookie
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// sessionKey 请求上下文中会话的键
+type sessionKey struct{}
+
+// withSession 将已认证的会话写入请求上下文
+func withSession(r *http.Request, session *Session) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{}, session))
+}
+
+// contextSession 返回请求上下文中的会话，管理令牌认证的请求没有会话
+func contextSession(r *http.Request) *Session {
+	session, _ := r.Context().Value(sessionKey{}).(*Session)
+	return session
+}
+
+// isStateChanging 判断请求方法是否会修改状态，需要CSRF校验
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// validCSRF 检查请求头中的CSRF令牌是否与会话一致
+func validCSRF(r *http.Request, session *Session) bool {
+	token := r.Header.Get(csrfHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// handleLogoutAll 使所有登录会话失效（包括当前会话），用于怀疑会话泄露时
+func (m *Manager) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count := m.InvalidateAllSessions()
+	m.audit(r, AuditActionSessionLogoutAll, "", fmt.Sprintf("sessions=%d", count))
+	clearSessionCookie(w, r)
+
+	m.writeJSONResponse(w, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("已注销全部 %d 个会话", count),
+		"count":   count,
+	})
+}
@@ -0,0 +1,89 @@
+package webconfig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sessionRequest 创建携带会话Cookie的请求
+func sessionRequest(method, path, body string, session *Session) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.ID})
+	return req
+}
+
+func TestWithAuth_RejectsMissingOrWrongCSRF(t *testing.T) {
+	m := newTestManager(t, testConfigWithToken())
+	mux := http.NewServeMux()
+	m.SetupRoutes(mux)
+	session, err := m.CreateSession(BuiltinAdminUsername, "127.0.0.1")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		csrf   string
+		want   int
+	}{
+		{"query without token", http.MethodGet, "", http.StatusOK},
+		{"change without token", http.MethodPut, "", http.StatusForbidden},
+		{"change with wrong token", http.MethodPut, "not-the-token", http.StatusForbidden},
+		{"change with session token", http.MethodPut, session.CSRFToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := sessionRequest(tt.method, "/api/tokens?id=tok-1", `{"enabled":false}`, session)
+			if tt.csrf != "" {
+				req.Header.Set(csrfHeader, tt.csrf)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+
+	// 被拒绝的请求不修改配置
+	assert.False(t, m.GetConfig().AuthTokens[0].Enabled)
+	now := time.Now()
+	entries, err := m.auditLog.Query(AuditQuery{From: now, To: now, Action: AuditActionCSRFRejected, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestSessions_PersistAcrossRestart(t *testing.T) {
+	m := newTestManager(t, nil)
+	session, err := m.CreateSession(BuiltinAdminUsername, "127.0.0.1")
+	assert.NoError(t, err)
+	expired, err := m.CreateSession(BuiltinAdminUsername, "127.0.0.1")
+	assert.NoError(t, err)
+	m.sessionMutex.Lock()
+	m.sessions[hashSessionID(expired.ID)].ExpiresAt = time.Now().Add(-time.Minute)
+	m.saveSessionsUnlocked()
+	m.sessionMutex.Unlock()
+
+	// 使用同一目录重新创建管理器，模拟重启
+	restarted := newTestManager(t, nil)
+	restarted.storage = m.storage
+	restarted.loadSessions()
+
+	restored, ok := restarted.lookupSession(session.ID)
+	assert.True(t, ok)
+	assert.Equal(t, session.CSRFToken, restored.CSRFToken)
+	_, ok = restarted.lookupSession(expired.ID)
+	assert.False(t, ok)
+
+	// 会话文件只保存会话ID的哈希
+	saved, err := os.ReadFile(m.sessionsPath())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(saved), session.ID)
+
+	restarted.InvalidateSession(session.ID)
+	_, ok = restarted.lookupSession(session.ID)
+	assert.False(t, ok)
+}
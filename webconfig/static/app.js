@@ -1,23 +1,62 @@
 // 全局变量
 let currentConfig = {};
+let apiKeys = [];
+let keyBudgets = {}; // 密钥ID -> 预算使用情况
+const FORECAST_DAYS = 7; // 额度预测天数
+const MAX_LIVE_EVENTS = 200; // 实时活动保留的事件数
+let liveSource = null; // 实时事件流连接
+let liveEvents = []; // 最近的实时事件，最新的在前
+let livePaused = false;
+const ACTIVE_REQUESTS_REFRESH_MS = 2000; // 在途请求的刷新间隔
+let activeRequestsTimer = null;
+
+// 会话的CSRF令牌，所有修改请求都需要通过 X-CSRF-Token 请求头携带
+const csrfToken = document.querySelector('meta[name="csrf-token"]')?.content || '';
+
+// 当前控制台用户的角色，决定页面上可见的功能（服务端按路由再次校验）
+const consoleRole = document.querySelector('meta[name="console-role"]')?.content || 'viewer';
+const ROLE_LEVELS = { viewer: 1, operator: 2, admin: 3 };
+
+// hasRole 判断当前用户是否达到指定角色
+function hasRole(role) {
+    return (ROLE_LEVELS[consoleRole] || 0) >= ROLE_LEVELS[role];
+}
+
+// apiFetch 调用管理API，自动附加CSRF令牌
+function apiFetch(url, options = {}) {
+    const headers = new Headers(options.headers || {});
+    headers.set('X-CSRF-Token', csrfToken);
+    return fetch(url, { ...options, headers });
+}
 
 // DOM元素
 const globalMessage = document.getElementById('globalMessage');
 const sections = {
     service: document.getElementById('service-section'),
     tokens: document.getElementById('tokens-section'),
+    keys: document.getElementById('keys-section'),
+    live: document.getElementById('live-section'),
+    usage: document.getElementById('usage-section'),
+    admintokens: document.getElementById('admintokens-section'),
+    users: document.getElementById('users-section'),
+    audit: document.getElementById('audit-section'),
     logs: document.getElementById('logs-section'),
     timeouts: document.getElementById('timeouts-section'),
+    alerts: document.getElementById('alerts-section'),
     backup: document.getElementById('backup-section')
 };
 
 // 页面加载完成后初始化
 document.addEventListener('DOMContentLoaded', function() {
+    applyRoleVisibility();
     initializeNavigation();
     initializeForms();
-    loadConfig();
     loadTokens();
-    loadBackups();
+    if (hasRole('admin')) {
+        loadConfig();
+        loadAPIKeys();
+        loadBackups();
+    }
     
     // 初始化时隐藏全局操作按钮（因为默认显示Token管理）
     const globalActions = document.getElementById('globalActions');
@@ -26,6 +65,15 @@ document.addEventListener('DOMContentLoaded', function() {
     }
 });
 
+// 隐藏当前角色无权使用的导航和操作
+function applyRoleVisibility() {
+    document.querySelectorAll('[data-role]').forEach(element => {
+        if (!hasRole(element.dataset.role)) {
+            element.classList.add('hidden');
+        }
+    });
+}
+
 // 初始化导航
 function initializeNavigation() {
     const navLinks = document.querySelectorAll('.nav-link[data-section]');
@@ -53,11 +101,29 @@ function showSection(sectionName) {
         sections[sectionName].classList.remove('hidden');
     }
 
+    if (sectionName === 'live') {
+        connectLiveEvents();
+    } else {
+        disconnectLiveEvents();
+    }
+    if (sectionName === 'usage') {
+        loadUsage();
+    }
+    if (sectionName === 'admintokens') {
+        loadAdminTokens();
+    }
+    if (sectionName === 'users') {
+        loadUsers();
+    }
+    if (sectionName === 'audit') {
+        loadAudit();
+    }
+
     // 控制全局操作按钮的显示
     const globalActions = document.getElementById('globalActions');
     if (globalActions) {
-        // Token管理页面隐藏全局操作按钮
-        if (sectionName === 'tokens') {
+        // Token管理、实时活动、API密钥、用量报表、管理令牌、用户和审计日志页面隐藏全局操作按钮
+        if (['tokens', 'live', 'keys', 'usage', 'admintokens', 'users', 'audit'].includes(sectionName)) {
             globalActions.classList.add('hidden');
         } else {
             globalActions.classList.remove('hidden');
@@ -76,9 +142,42 @@ function initializeForms() {
     document.getElementById('authType').addEventListener('change', toggleIdcFields);
     document.getElementById('refreshTokensBtn').addEventListener('click', refreshTokenInfo);
 
+    // API密钥管理
+    document.getElementById('addKeyForm').addEventListener('submit', addAPIKey);
+
+    // 实时活动：修改过滤条件后重新连接
+    document.getElementById('liveForm').addEventListener('change', connectLiveEvents);
+    document.getElementById('livePauseBtn').addEventListener('click', toggleLivePause);
+    document.getElementById('liveClearBtn').addEventListener('click', function() {
+        liveEvents = [];
+        renderLiveTable();
+    });
+
+    // 用量报表
+    document.getElementById('usageForm').addEventListener('submit', function(e) {
+        e.preventDefault();
+        loadUsage();
+    });
+    document.getElementById('exportUsageBtn').addEventListener('click', exportUsage);
+
+    // 管理令牌
+    document.getElementById('addAdminTokenForm').addEventListener('submit', addAdminToken);
+
+    // 控制台用户
+    document.getElementById('addUserForm').addEventListener('submit', addUser);
+
+    // 审计日志
+    document.getElementById('auditForm').addEventListener('submit', function(e) {
+        e.preventDefault();
+        loadAudit();
+    });
+
     // 备份管理
     document.getElementById('createBackupBtn').addEventListener('click', createBackup);
     document.getElementById('refreshBackupsBtn').addEventListener('click', loadBackups);
+
+    // 告警配置
+    document.getElementById('testAlertBtn').addEventListener('click', testAlert);
 }
 
 // 切换IdC字段显示
@@ -116,7 +215,7 @@ function showMessage(message, type = 'info') {
 // 加载配置
 async function loadConfig() {
     try {
-        const response = await fetch('/api/config');
+        const response = await apiFetch('/api/config');
         if (!response.ok) {
             throw new Error('加载配置失败');
         }
@@ -128,6 +227,7 @@ async function loadConfig() {
         document.getElementById('port').value = config.serviceConfig.port;
         document.getElementById('ginMode').value = config.serviceConfig.ginMode;
         document.getElementById('clientToken').value = config.serviceConfig.clientToken;
+        document.getElementById('metricsToken').value = config.serviceConfig.metricsToken || '';
 
         // 填充日志配置表单
         document.getElementById('logLevel').value = config.logConfig.level;
@@ -136,6 +236,14 @@ async function loadConfig() {
         document.getElementById('logConsole').checked = config.logConfig.console;
         document.getElementById('logCaller').checked = config.logConfig.enableCaller;
         document.getElementById('callerSkip').value = config.logConfig.callerSkip;
+        document.getElementById('accessLogFile').value = config.logConfig.accessFile || '';
+        document.getElementById('accessLogFormat').value = config.logConfig.accessFormat || 'json';
+        document.getElementById('logMaxSize').value = config.logConfig.maxSizeMB || 0;
+        document.getElementById('logMaxAge').value = config.logConfig.maxAgeHours || 0;
+        document.getElementById('logMaxBackups').value = config.logConfig.maxBackups || 0;
+        document.getElementById('logCompress').checked = config.logConfig.compress;
+        document.getElementById('logRedaction').value = config.logConfig.redaction || 'metadata';
+        document.getElementById('auditRetentionDays').value = (config.auditConfig || {}).retentionDays || 0;
 
         // 填充超时配置表单
         document.getElementById('requestTimeout').value = config.timeoutConfig.requestMinutes;
@@ -144,6 +252,31 @@ async function loadConfig() {
         document.getElementById('serverReadTimeout').value = config.timeoutConfig.serverReadMinutes;
         document.getElementById('serverWriteTimeout').value = config.timeoutConfig.serverWriteMinutes;
 
+        // 填充并发配置表单
+        const concurrency = config.concurrencyConfig || {};
+        document.getElementById('maxInFlightPerToken').value = concurrency.maxInFlightPerToken || 0;
+        document.getElementById('queueSize').value = concurrency.queueSize || 0;
+        document.getElementById('queueTimeout').value = concurrency.queueTimeoutSeconds || 0;
+
+        // 填充会话亲和配置表单
+        const affinity = config.affinityConfig || {};
+        document.getElementById('affinityEnabled').checked = !!affinity.enabled;
+        document.getElementById('affinityTtl').value = affinity.ttlMinutes || 0;
+
+        // 填充默认限流配置表单
+        const rateLimit = config.rateLimitConfig || {};
+        document.getElementById('rateLimitRequests').value = rateLimit.requestsPerMinute || 0;
+        document.getElementById('rateLimitInputTokens').value = rateLimit.inputTokensPerMinute || 0;
+        document.getElementById('rateLimitOutputTokens').value = rateLimit.outputTokensPerMinute || 0;
+
+        // 填充告警配置表单
+        const alertConfig = config.alertConfig || {};
+        document.getElementById('alertEnabled').checked = !!alertConfig.enabled;
+        document.getElementById('lowCreditThreshold').value = alertConfig.lowCreditThreshold || 0;
+        document.getElementById('alertDedupe').value = alertConfig.dedupeMinutes || 0;
+        document.getElementById('alertRetries').value = alertConfig.maxRetries || 0;
+        document.getElementById('alertWebhooks').value = JSON.stringify(alertConfig.webhooks || [], null, 2);
+
         showMessage('配置加载成功', 'success');
     } catch (error) {
         showMessage('加载配置失败: ' + error.message, 'error');
@@ -153,13 +286,21 @@ async function loadConfig() {
 // 保存配置
 async function saveConfig() {
     try {
+        let webhooks;
+        try {
+            webhooks = JSON.parse(document.getElementById('alertWebhooks').value || '[]');
+        } catch (e) {
+            throw new Error('Webhook列表不是有效的JSON');
+        }
+
         // 收集表单数据
         const updatedConfig = {
             ...currentConfig,
             serviceConfig: {
                 port: parseInt(document.getElementById('port').value),
                 ginMode: document.getElementById('ginMode').value,
-                clientToken: document.getElementById('clientToken').value
+                clientToken: document.getElementById('clientToken').value,
+                metricsToken: document.getElementById('metricsToken').value.trim()
             },
             logConfig: {
                 level: document.getElementById('logLevel').value,
@@ -167,7 +308,17 @@ async function saveConfig() {
                 file: document.getElementById('logFile').value,
                 console: document.getElementById('logConsole').checked,
                 enableCaller: document.getElementById('logCaller').checked,
-                callerSkip: parseInt(document.getElementById('callerSkip').value)
+                callerSkip: parseInt(document.getElementById('callerSkip').value),
+                accessFile: document.getElementById('accessLogFile').value.trim(),
+                accessFormat: document.getElementById('accessLogFormat').value,
+                maxSizeMB: parseInt(document.getElementById('logMaxSize').value) || 0,
+                maxAgeHours: parseInt(document.getElementById('logMaxAge').value) || 0,
+                maxBackups: parseInt(document.getElementById('logMaxBackups').value) || 0,
+                compress: document.getElementById('logCompress').checked,
+                redaction: document.getElementById('logRedaction').value
+            },
+            auditConfig: {
+                retentionDays: parseInt(document.getElementById('auditRetentionDays').value) || 0
             },
             timeoutConfig: {
                 requestMinutes: parseInt(document.getElementById('requestTimeout').value),
@@ -175,10 +326,31 @@ async function saveConfig() {
                 streamMinutes: parseInt(document.getElementById('streamTimeout').value),
                 serverReadMinutes: parseInt(document.getElementById('serverReadTimeout').value),
                 serverWriteMinutes: parseInt(document.getElementById('serverWriteTimeout').value)
+            },
+            concurrencyConfig: {
+                maxInFlightPerToken: parseInt(document.getElementById('maxInFlightPerToken').value) || 0,
+                queueSize: parseInt(document.getElementById('queueSize').value) || 0,
+                queueTimeoutSeconds: parseInt(document.getElementById('queueTimeout').value) || 0
+            },
+            affinityConfig: {
+                enabled: document.getElementById('affinityEnabled').checked,
+                ttlMinutes: parseInt(document.getElementById('affinityTtl').value) || 0
+            },
+            rateLimitConfig: {
+                requestsPerMinute: parseInt(document.getElementById('rateLimitRequests').value) || 0,
+                inputTokensPerMinute: parseInt(document.getElementById('rateLimitInputTokens').value) || 0,
+                outputTokensPerMinute: parseInt(document.getElementById('rateLimitOutputTokens').value) || 0
+            },
+            alertConfig: {
+                enabled: document.getElementById('alertEnabled').checked,
+                lowCreditThreshold: parseFloat(document.getElementById('lowCreditThreshold').value) || 0,
+                dedupeMinutes: parseInt(document.getElementById('alertDedupe').value) || 0,
+                maxRetries: parseInt(document.getElementById('alertRetries').value) || 0,
+                webhooks: webhooks
             }
         };
 
-        const response = await fetch('/api/config', {
+        const response = await apiFetch('/api/config', {
             method: 'PUT',
             headers: {
                 'Content-Type': 'application/json',
@@ -201,17 +373,18 @@ async function saveConfig() {
 // 加载Token列表
 async function loadTokens() {
     try {
-        const response = await fetch('/api/tokens');
+        const response = await apiFetch(`/api/tokens?forecastDays=${FORECAST_DAYS}`);
         if (!response.ok) {
             throw new Error('加载Token失败');
         }
 
-        const tokens = await response.json();
+        const data = await response.json();
+        const tokens = data.tokens;
         
         // 获取当前正在使用的token索引
         let currentIndex = -1;
         try {
-            const currentResponse = await fetch('/api/tokens/current');
+            const currentResponse = await apiFetch('/api/tokens/current');
             if (currentResponse.ok) {
                 const currentData = await currentResponse.json();
                 currentIndex = currentData.currentIndex;
@@ -220,8 +393,19 @@ async function loadTokens() {
             console.warn('获取当前token索引失败:', e);
         }
         
+        // 获取并发与排队统计
+        let concurrency = null;
+        try {
+            const concurrencyResponse = await apiFetch('/api/tokens/concurrency');
+            if (concurrencyResponse.ok) {
+                concurrency = await concurrencyResponse.json();
+            }
+        } catch (e) {
+            console.warn('获取并发统计失败:', e);
+        }
+        
         renderTokenList(tokens || [], currentIndex);
-        updateStatistics(tokens || []);
+        updateStatistics(tokens || [], concurrency, data.forecast);
     } catch (error) {
         showMessage('加载Token失败: ' + error.message, 'error');
         renderTokenList([], -1); // 确保错误情况下也能显示空列表
@@ -230,8 +414,10 @@ async function loadTokens() {
 }
 
 // 更新统计数据
-function updateStatistics(tokens) {
+function updateStatistics(tokens, concurrency = null, forecast = null) {
     const stats = calculateStatistics(tokens);
+    const queueDepth = concurrency ? concurrency.queueDepth : 0;
+    const avgWait = concurrency ? Math.round(concurrency.avgWaitMs) : 0;
     
     // 更新统计卡片
     const statsContainer = document.getElementById('tokenStats');
@@ -273,6 +459,20 @@ function updateStatistics(tokens) {
                 <div class="stat-value">${stats.errorTokens}</div>
             </div>
         </div>
+        <div class="stat-card ${queueDepth > 0 ? 'stat-warning' : ''}">
+            <div class="stat-icon">🚦</div>
+            <div class="stat-content">
+                <div class="stat-label">排队中 / 平均等待</div>
+                <div class="stat-value">${queueDepth} / ${avgWait}ms</div>
+            </div>
+        </div>
+        <div class="stat-card" title="${forecast && forecast.nextResetAt ? '最近重置: ' + new Date(forecast.nextResetAt).toLocaleString('zh-CN') : ''}">
+            <div class="stat-icon">📅</div>
+            <div class="stat-content">
+                <div class="stat-label">未来${FORECAST_DAYS}天可用额度</div>
+                <div class="stat-value">${forecast ? forecast.totalAvailable.toFixed(1) : '-'}</div>
+            </div>
+        </div>
     `;
 }
 
@@ -337,10 +537,20 @@ function renderTokenList(tokens, currentIndex = -1) {
                         <span>${maskToken(token.clientSecret)}</span>
                     </div>
                 ` : ''}
+                ${token.group ? `
+                    <div class="token-detail">
+                        <label>分组:</label>
+                        <span>${token.group}</span>
+                    </div>
+                ` : ''}
                 <div class="token-detail">
                     <label>剩余次数:</label>
                     <span>${remainingDisplay}</span>
                 </div>
+                <div class="token-detail">
+                    <label>额度重置:</label>
+                    <span>${token.resetAt ? new Date(token.resetAt).toLocaleString('zh-CN') : '未知'}</span>
+                </div>
                 <div class="token-detail">
                     <label>最后使用:</label>
                     <span>${token.lastUsed ? new Date(token.lastUsed).toLocaleString('zh-CN') : '从未使用'}</span>
@@ -350,7 +560,7 @@ function renderTokenList(tokens, currentIndex = -1) {
                     <span style="color: ${token.errorCount > 0 ? '#e74c3c' : '#95a5a6'};">${token.errorCount || 0}</span>
                 </div>
             </div>
-            <div class="token-actions">
+            <div class="token-actions ${hasRole('operator') ? '' : 'hidden'}">
                 <button class="btn btn-info btn-small" onclick="refreshSingleToken('${token.id}', this)" title="刷新此Token信息">🔄</button>
                 ${token.enabled && !isCurrentToken ?
                     `<button class="btn btn-primary btn-small" onclick="switchToToken(${index})" title="切换到此Token">切换使用</button>` :
@@ -360,7 +570,7 @@ function renderTokenList(tokens, currentIndex = -1) {
                     `<button class="btn btn-success btn-small" onclick="toggleToken('${token.id}', true)">启用</button>` :
                     `<button class="btn btn-secondary btn-small" onclick="toggleToken('${token.id}', false)">禁用</button>`
                 }
-                <button class="btn btn-danger btn-small" onclick="deleteToken('${token.id}')">删除</button>
+                ${hasRole('admin') ? `<button class="btn btn-danger btn-small" onclick="deleteToken('${token.id}')">删除</button>` : ''}
             </div>
         </div>
     `;
@@ -429,7 +639,9 @@ async function addToken(e) {
     const tokenData = {
         auth: formData.get('auth'),
         refreshToken: formData.get('refreshToken'),
-        description: formData.get('description') || ''
+        description: formData.get('description') || '',
+        maxConcurrency: parseInt(formData.get('maxConcurrency')) || 0,
+        group: (formData.get('group') || '').trim()
     };
 
     if (tokenData.auth === 'IdC') {
@@ -438,7 +650,7 @@ async function addToken(e) {
     }
 
     try {
-        const response = await fetch('/api/tokens', {
+        const response = await apiFetch('/api/tokens', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -463,7 +675,7 @@ async function addToken(e) {
 // 切换Token状态
 async function toggleToken(tokenId, enabled) {
     try {
-        const response = await fetch(`/api/tokens?id=${tokenId}`, {
+        const response = await apiFetch(`/api/tokens?id=${tokenId}`, {
             method: 'PUT',
             headers: {
                 'Content-Type': 'application/json',
@@ -494,7 +706,7 @@ async function deleteToken(tokenId) {
     }
 
     try {
-        const response = await fetch(`/api/tokens?id=${tokenId}`, {
+        const response = await apiFetch(`/api/tokens?id=${tokenId}`, {
             method: 'DELETE'
         });
 
@@ -518,7 +730,7 @@ async function refreshSingleToken(tokenId, btnElement) {
         btnElement.disabled = true;
         btnElement.textContent = '🔄';
         
-        const response = await fetch(`/api/tokens/refresh-single?id=${tokenId}`, {
+        const response = await apiFetch(`/api/tokens/refresh-single?id=${tokenId}`, {
             method: 'POST'
         });
         
@@ -550,7 +762,7 @@ async function refreshTokenInfo() {
         btn.disabled = true;
         btn.textContent = '🔄 刷新中...';
         
-        const response = await fetch('/api/tokens/refresh', {
+        const response = await apiFetch('/api/tokens/refresh', {
             method: 'POST'
         });
         
@@ -575,10 +787,914 @@ async function refreshTokenInfo() {
     }
 }
 
+// 发送测试告警（使用已保存的告警配置）
+async function testAlert() {
+    try {
+        const response = await apiFetch('/api/alerts/test', {
+            method: 'POST'
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage('测试告警发送成功', 'success');
+        } else if (result.results) {
+            const failed = result.results.filter(r => !r.success).map(r => `${r.webhook}: ${r.error}`);
+            showMessage('测试告警发送失败: ' + failed.join('; '), 'error');
+        } else {
+            showMessage('测试告警发送失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('测试告警发送失败: ' + error.message, 'error');
+    }
+}
+
+// 加载API密钥列表
+async function loadAPIKeys() {
+    try {
+        const response = await apiFetch('/api/keys');
+        if (!response.ok) {
+            throw new Error('加载API密钥失败');
+        }
+
+        apiKeys = await response.json() || [];
+        keyBudgets = await loadKeyBudgets();
+        renderAPIKeyList(apiKeys);
+    } catch (error) {
+        showMessage('加载API密钥失败: ' + error.message, 'error');
+        renderAPIKeyList([]);
+    }
+}
+
+// 加载各密钥的预算使用情况（预算统计不可用时返回空）
+async function loadKeyBudgets() {
+    try {
+        const response = await apiFetch('/api/budgets');
+        if (!response.ok) {
+            return {};
+        }
+        const statuses = await response.json() || [];
+        return Object.fromEntries(statuses.map(status => [status.keyId, status]));
+    } catch (error) {
+        return {};
+    }
+}
+
+// 渲染API密钥列表
+function renderAPIKeyList(keys) {
+    const keyList = document.getElementById('keyList');
+
+    if (!keys || keys.length === 0) {
+        keyList.innerHTML = '<p style="text-align: center; color: #666; padding: 20px;">暂无API密钥（服务配置中的客户端Token仍然有效）</p>';
+        return;
+    }
+
+    const listOrAll = values => (values && values.length > 0) ? values.join(', ') : '全部';
+
+    keyList.innerHTML = keys.map(key => {
+        const expired = key.expiresAt && new Date(key.expiresAt) < new Date();
+        return `
+        <div class="token-item ${!key.enabled || expired ? 'disabled' : ''}">
+            <div class="token-header">
+                <div class="token-title">${key.name}</div>
+                <div class="token-status ${key.enabled && !expired ? 'enabled' : 'disabled'}">
+                    ${expired ? '已过期' : (key.enabled ? '启用' : '禁用')}
+                </div>
+            </div>
+            <div class="token-details">
+                <div class="token-detail">
+                    <label>密钥:</label>
+                    <span>${key.prefix}…</span>
+                </div>
+                <div class="token-detail">
+                    <label>允许的模型:</label>
+                    <span>${listOrAll(key.allowedModels)}</span>
+                </div>
+                <div class="token-detail">
+                    <label>允许的端点:</label>
+                    <span>${listOrAll(key.allowedEndpoints)}</span>
+                </div>
+                <div class="token-detail">
+                    <label>Token分组:</label>
+                    <span>${key.tokenGroup || '全部'}</span>
+                </div>
+                <div class="token-detail">
+                    <label>限流:</label>
+                    <span>${formatRateLimit(key.rateLimit)}</span>
+                </div>
+                <div class="token-detail">
+                    <label>额度预算:</label>
+                    <span>${formatBudget(keyBudgets[key.id])}</span>
+                </div>
+                <div class="token-detail">
+                    <label>创建时间:</label>
+                    <span>${new Date(key.createdAt).toLocaleString('zh-CN')}</span>
+                </div>
+                <div class="token-detail">
+                    <label>过期时间:</label>
+                    <span>${key.expiresAt ? new Date(key.expiresAt).toLocaleString('zh-CN') : '永不过期'}</span>
+                </div>
+            </div>
+            <div class="token-actions">
+                ${!key.enabled ?
+                    `<button class="btn btn-success btn-small" onclick="toggleAPIKey('${key.id}', true)">启用</button>` :
+                    `<button class="btn btn-secondary btn-small" onclick="toggleAPIKey('${key.id}', false)">禁用</button>`
+                }
+                <button class="btn btn-info btn-small" onclick="regenerateAPIKey('${key.id}')">重新生成</button>
+                <button class="btn btn-danger btn-small" onclick="deleteAPIKey('${key.id}')">删除</button>
+            </div>
+        </div>
+    `;
+    }).join('');
+}
+
+// 格式化密钥的预算与当前周期消耗
+function formatBudget(status) {
+    if (!status) return '-';
+    const names = { daily: '今日', monthly: '本月' };
+    return status.periods.map(period => {
+        const limit = period.limit > 0 ? period.limit.toFixed(2) : '不限';
+        const flag = period.exhausted ? ' ⛔' : (period.warning ? ' ⚠️' : '');
+        return `${names[period.period] || period.period} ${period.spent.toFixed(2)} / ${limit}${flag}`;
+    }).join(', ');
+}
+
+// 解析创建表单中的密钥预算，未填写时返回null（不限制）
+function parseKeyBudget(formData) {
+    const dailyCredits = parseFloat(formData.get('dailyCredits')) || 0;
+    const monthlyCredits = parseFloat(formData.get('monthlyCredits')) || 0;
+    if (!dailyCredits && !monthlyCredits) {
+        return null;
+    }
+    return {
+        dailyCredits: dailyCredits,
+        monthlyCredits: monthlyCredits,
+        softLimitPercent: parseInt(formData.get('softLimitPercent')) || 0
+    };
+}
+
+// 格式化密钥限流配置
+function formatRateLimit(rateLimit) {
+    if (!rateLimit) return '默认';
+    const parts = [];
+    if (rateLimit.requestsPerMinute) parts.push(`${rateLimit.requestsPerMinute} 请求/分`);
+    if (rateLimit.inputTokensPerMinute) parts.push(`${rateLimit.inputTokensPerMinute} 输入/分`);
+    if (rateLimit.outputTokensPerMinute) parts.push(`${rateLimit.outputTokensPerMinute} 输出/分`);
+    return parts.length > 0 ? parts.join(', ') : '不限制';
+}
+
+// 读取密钥限流表单，全部留空时使用默认限流
+function parseKeyRateLimit(formData) {
+    const fields = ['requestsPerMinute', 'inputTokensPerMinute', 'outputTokensPerMinute'];
+    if (fields.every(f => !formData.get(f))) {
+        return null;
+    }
+    const rateLimit = {};
+    fields.forEach(f => rateLimit[f] = parseInt(formData.get(f)) || 0);
+    return rateLimit;
+}
+
+// 解析逗号分隔的列表
+function parseList(value) {
+    return (value || '').split(',').map(v => v.trim()).filter(v => v);
+}
+
+// 显示新密钥明文（只显示一次）
+function showNewKeySecret(name, secret) {
+    const box = document.getElementById('newKeySecret');
+    box.textContent = `密钥 ${name}: ${secret}（仅显示一次，请立即保存）`;
+    box.className = 'message success';
+}
+
+// 创建API密钥
+async function addAPIKey(e) {
+    e.preventDefault();
+
+    const formData = new FormData(e.target);
+    const expiresAt = formData.get('expiresAt');
+    const keyData = {
+        name: formData.get('name'),
+        expiresAt: expiresAt ? new Date(expiresAt).toISOString() : null,
+        allowedModels: parseList(formData.get('allowedModels')),
+        allowedEndpoints: parseList(formData.get('allowedEndpoints')),
+        tokenGroup: (formData.get('tokenGroup') || '').trim(),
+        rateLimit: parseKeyRateLimit(formData),
+        budget: parseKeyBudget(formData)
+    };
+
+    try {
+        const response = await apiFetch('/api/keys', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify(keyData)
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showNewKeySecret(result.key.name, result.secret);
+            e.target.reset();
+            loadAPIKeys();
+        } else {
+            showMessage('API密钥创建失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('API密钥创建失败: ' + error.message, 'error');
+    }
+}
+
+// 启用或禁用API密钥（PUT为整体更新，其余字段保持不变）
+async function toggleAPIKey(keyId, enabled) {
+    const key = apiKeys.find(k => k.id === keyId);
+    if (!key) {
+        return;
+    }
+
+    try {
+        const response = await apiFetch(`/api/keys?id=${keyId}`, {
+            method: 'PUT',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({
+                name: key.name,
+                expiresAt: key.expiresAt || null,
+                enabled: enabled,
+                allowedModels: key.allowedModels || [],
+                allowedEndpoints: key.allowedEndpoints || [],
+                tokenGroup: key.tokenGroup || '',
+                rateLimit: key.rateLimit || null,
+                budget: key.budget || null
+            })
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage(`API密钥已${enabled ? '启用' : '禁用'}`, 'success');
+            loadAPIKeys();
+        } else {
+            showMessage('API密钥更新失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('API密钥更新失败: ' + error.message, 'error');
+    }
+}
+
+// 重新生成API密钥（旧密钥立即失效）
+async function regenerateAPIKey(keyId) {
+    if (!confirm('重新生成后旧密钥将立即失效，确定继续吗？')) {
+        return;
+    }
+
+    try {
+        const response = await apiFetch(`/api/keys/regenerate?id=${keyId}`, {
+            method: 'POST'
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showNewKeySecret(result.key.name, result.secret);
+            loadAPIKeys();
+        } else {
+            showMessage('API密钥重新生成失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('API密钥重新生成失败: ' + error.message, 'error');
+    }
+}
+
+// 删除API密钥
+async function deleteAPIKey(keyId) {
+    if (!confirm('确定要删除这个API密钥吗？')) {
+        return;
+    }
+
+    try {
+        const response = await apiFetch(`/api/keys?id=${keyId}`, {
+            method: 'DELETE'
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage('API密钥删除成功', 'success');
+            loadAPIKeys();
+        } else {
+            showMessage('API密钥删除失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('API密钥删除失败: ' + error.message, 'error');
+    }
+}
+
+// 管理令牌权限范围的显示名称
+const ADMIN_SCOPE_NAMES = { read: '只读', tokens: 'Token管理', admin: '完全管理' };
+
+// 加载管理令牌列表
+async function loadAdminTokens() {
+    try {
+        const response = await apiFetch('/api/admin-tokens');
+        if (!response.ok) {
+            throw new Error('加载管理令牌失败');
+        }
+        renderAdminTokenList(await response.json() || []);
+    } catch (error) {
+        showMessage('加载管理令牌失败: ' + error.message, 'error');
+        renderAdminTokenList([]);
+    }
+}
+
+// 渲染管理令牌列表
+function renderAdminTokenList(tokens) {
+    const list = document.getElementById('adminTokenList');
+
+    if (!tokens || tokens.length === 0) {
+        list.innerHTML = '<p style="text-align: center; color: #666; padding: 20px;">暂无管理令牌</p>';
+        return;
+    }
+
+    list.innerHTML = tokens.map(token => {
+        const expired = token.expiresAt && new Date(token.expiresAt) < new Date();
+        return `
+        <div class="token-item ${expired ? 'disabled' : ''}">
+            <div class="token-header">
+                <div class="token-title">${token.name}</div>
+                <div class="token-status ${expired ? 'disabled' : 'enabled'}">
+                    ${expired ? '已过期' : ADMIN_SCOPE_NAMES[token.scope] || token.scope}
+                </div>
+            </div>
+            <div class="token-details">
+                <div class="token-detail">
+                    <label>令牌:</label>
+                    <span>${token.prefix}…</span>
+                </div>
+                <div class="token-detail">
+                    <label>创建时间:</label>
+                    <span>${new Date(token.createdAt).toLocaleString('zh-CN')}</span>
+                </div>
+                <div class="token-detail">
+                    <label>过期时间:</label>
+                    <span>${token.expiresAt ? new Date(token.expiresAt).toLocaleString('zh-CN') : '永不过期'}</span>
+                </div>
+            </div>
+            <div class="token-actions">
+                <button class="btn btn-danger btn-small" onclick="revokeAdminToken('${token.id}')">吊销</button>
+            </div>
+        </div>
+    `;
+    }).join('');
+}
+
+// 创建管理令牌
+async function addAdminToken(e) {
+    e.preventDefault();
+
+    const formData = new FormData(e.target);
+    const expiresAt = formData.get('expiresAt');
+    const tokenData = {
+        name: formData.get('name'),
+        scope: formData.get('scope'),
+        expiresAt: expiresAt ? new Date(expiresAt).toISOString() : null
+    };
+
+    try {
+        const response = await apiFetch('/api/admin-tokens', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify(tokenData)
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            const box = document.getElementById('newAdminTokenSecret');
+            box.textContent = `令牌 ${result.token.name}: ${result.secret}（仅显示一次，请立即保存）`;
+            box.className = 'message success';
+            e.target.reset();
+            loadAdminTokens();
+        } else {
+            showMessage('管理令牌创建失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('管理令牌创建失败: ' + error.message, 'error');
+    }
+}
+
+// 吊销管理令牌
+async function revokeAdminToken(tokenId) {
+    if (!confirm('确定要吊销这个管理令牌吗？使用该令牌的脚本将立即失去访问权限。')) {
+        return;
+    }
+
+    try {
+        const response = await apiFetch(`/api/admin-tokens?id=${tokenId}`, {
+            method: 'DELETE'
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage('管理令牌已吊销', 'success');
+            loadAdminTokens();
+        } else {
+            showMessage('管理令牌吊销失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('管理令牌吊销失败: ' + error.message, 'error');
+    }
+}
+
+// 控制台用户角色的显示名称
+const ROLE_NAMES = { viewer: '只读', operator: '运维', admin: '管理员' };
+
+// 加载控制台用户列表
+async function loadUsers() {
+    try {
+        const response = await apiFetch('/api/users');
+        if (!response.ok) {
+            throw new Error('加载用户失败');
+        }
+        renderUserList(await response.json() || []);
+    } catch (error) {
+        showMessage('加载用户失败: ' + error.message, 'error');
+        renderUserList([]);
+    }
+}
+
+// 渲染控制台用户列表
+function renderUserList(users) {
+    const list = document.getElementById('userList');
+
+    if (!users || users.length === 0) {
+        list.innerHTML = '<p style="text-align: center; color: #666; padding: 20px;">暂无其他用户</p>';
+        return;
+    }
+
+    list.innerHTML = users.map(user => `
+        <div class="token-item ${user.disabled ? 'disabled' : ''}">
+            <div class="token-header">
+                <div class="token-title">${user.username}</div>
+                <div class="token-status ${user.disabled ? 'disabled' : 'enabled'}">
+                    ${user.disabled ? '已禁用' : ROLE_NAMES[user.role] || user.role}
+                </div>
+            </div>
+            <div class="token-details">
+                <div class="token-detail">
+                    <label>角色:</label>
+                    <select onchange="updateUser('${user.id}', { role: this.value, disabled: ${user.disabled} })">
+                        ${Object.keys(ROLE_NAMES).map(role =>
+                            `<option value="${role}" ${role === user.role ? 'selected' : ''}>${ROLE_NAMES[role]}</option>`
+                        ).join('')}
+                    </select>
+                </div>
+                <div class="token-detail">
+                    <label>创建时间:</label>
+                    <span>${new Date(user.createdAt).toLocaleString('zh-CN')}</span>
+                </div>
+            </div>
+            <div class="token-actions">
+                ${user.disabled ?
+                    `<button class="btn btn-success btn-small" onclick="updateUser('${user.id}', { role: '${user.role}', disabled: false })">启用</button>` :
+                    `<button class="btn btn-secondary btn-small" onclick="updateUser('${user.id}', { role: '${user.role}', disabled: true })">禁用</button>`
+                }
+                <button class="btn btn-info btn-small" onclick="resetUserPassword('${user.id}', '${user.role}', ${user.disabled})">重置密码</button>
+                <button class="btn btn-danger btn-small" onclick="deleteUser('${user.id}')">删除</button>
+            </div>
+        </div>
+    `).join('');
+}
+
+// 创建控制台用户
+async function addUser(e) {
+    e.preventDefault();
+
+    const formData = new FormData(e.target);
+    const userData = {
+        username: formData.get('username').trim(),
+        password: formData.get('password'),
+        role: formData.get('role')
+    };
+
+    try {
+        const response = await apiFetch('/api/users', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify(userData)
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage('用户创建成功', 'success');
+            e.target.reset();
+            loadUsers();
+        } else {
+            showMessage('用户创建失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('用户创建失败: ' + error.message, 'error');
+    }
+}
+
+// 更新控制台用户的角色、状态或密码
+async function updateUser(userId, changes) {
+    try {
+        const response = await apiFetch(`/api/users?id=${userId}`, {
+            method: 'PUT',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify(changes)
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage('用户已更新', 'success');
+        } else {
+            showMessage('用户更新失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('用户更新失败: ' + error.message, 'error');
+    }
+    loadUsers();
+}
+
+// 重置控制台用户的密码，该用户已有的会话将失效
+function resetUserPassword(userId, role, disabled) {
+    const password = prompt('请输入新密码：');
+    if (!password) {
+        return;
+    }
+    updateUser(userId, { role: role, disabled: disabled, password: password });
+}
+
+// 删除控制台用户
+async function deleteUser(userId) {
+    if (!confirm('确定要删除这个用户吗？该用户的登录会话将立即失效。')) {
+        return;
+    }
+
+    try {
+        const response = await apiFetch(`/api/users?id=${userId}`, {
+            method: 'DELETE'
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage('用户已删除', 'success');
+            loadUsers();
+        } else {
+            showMessage('用户删除失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('用户删除失败: ' + error.message, 'error');
+    }
+}
+
+// 构造用量报表查询参数
+function buildUsageQuery() {
+    const form = document.getElementById('usageForm');
+    const params = new URLSearchParams();
+    ['from', 'to', 'key', 'model'].forEach(name => {
+        const value = form.elements[name].value.trim();
+        if (value) {
+            params.set(name, value);
+        }
+    });
+
+    const groupBy = Array.from(form.querySelectorAll('input[name="groupBy"]:checked')).map(input => input.value);
+    if (groupBy.length > 0) {
+        params.set('groupBy', groupBy.join(','));
+    }
+    return params;
+}
+
+// 加载用量报表
+async function loadUsage() {
+    try {
+        const response = await apiFetch(`/api/usage?${buildUsageQuery()}`);
+        const result = await response.json();
+        if (!response.ok) {
+            throw new Error(result.error || '加载用量报表失败');
+        }
+        renderUsageTable(result.rows || [], result.groupBy || []);
+    } catch (error) {
+        showMessage('加载用量报表失败: ' + error.message, 'error');
+        renderUsageTable([], []);
+    }
+}
+
+// 渲染用量报表
+function renderUsageTable(rows, groupBy) {
+    const table = document.getElementById('usageTable');
+
+    if (!rows || rows.length === 0) {
+        table.innerHTML = '<p style="text-align: center; color: #666; padding: 20px;">所选范围内暂无用量记录</p>';
+        return;
+    }
+
+    const dimensions = [
+        { key: 'day', title: '日期', value: row => row.day },
+        { key: 'key', title: '密钥', value: row => row.keyName || row.keyId || '客户端Token' },
+        { key: 'model', title: '模型', value: row => row.model || '-' },
+        { key: 'token', title: 'Token', value: row => row.tokenId || '-' }
+    ].filter(dim => groupBy.includes(dim.key));
+
+    const header = dimensions.map(dim => `<th>${dim.title}</th>`).join('') +
+        '<th>请求数</th><th>错误数</th><th>输入Token</th><th>输出Token</th><th>平均延迟(ms)</th>';
+    const body = rows.map(row => `
+        <tr>
+            ${dimensions.map(dim => `<td>${dim.value(row)}</td>`).join('')}
+            <td>${row.requests}</td>
+            <td>${row.errors}</td>
+            <td>${row.inputTokens}</td>
+            <td>${row.outputTokens}</td>
+            <td>${row.avgLatencyMs}</td>
+        </tr>
+    `).join('');
+
+    table.innerHTML = `<table class="usage-table"><thead><tr>${header}</tr></thead><tbody>${body}</tbody></table>`;
+}
+
+// 转义HTML特殊字符，审计日志中的内容可能来自请求参数
+function escapeHtml(value) {
+    return String(value).replace(/[&<>"']/g, ch => ({
+        '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'
+    })[ch]);
+}
+
+// 格式化审计日志中的字段值
+function formatAuditValue(value) {
+    if (value === undefined || value === null) {
+        return '∅';
+    }
+    return escapeHtml(typeof value === 'string' ? value : JSON.stringify(value));
+}
+
+// 加载审计日志
+async function loadAudit() {
+    const form = document.getElementById('auditForm');
+    const params = new URLSearchParams();
+    ['from', 'to', 'actor', 'action', 'target', 'ip', 'limit'].forEach(name => {
+        const value = form.elements[name].value.trim();
+        if (value) {
+            params.set(name, value);
+        }
+    });
+
+    try {
+        const response = await apiFetch(`/api/audit?${params}`);
+        const result = await response.json();
+        if (!response.ok) {
+            throw new Error(result.error || '加载审计日志失败');
+        }
+        renderAuditTable(result.entries || []);
+    } catch (error) {
+        showMessage('加载审计日志失败: ' + error.message, 'error');
+        renderAuditTable([]);
+    }
+}
+
+// 渲染审计日志，配置变更显示为 字段: 变更前 → 变更后
+function renderAuditTable(entries) {
+    const table = document.getElementById('auditTable');
+
+    if (!entries || entries.length === 0) {
+        table.innerHTML = '<p style="text-align: center; color: #666; padding: 20px;">所选范围内暂无审计记录</p>';
+        return;
+    }
+
+    const body = entries.map(entry => `
+        <tr>
+            <td>${new Date(entry.time).toLocaleString('zh-CN')}</td>
+            <td>${escapeHtml(entry.actor || '')}</td>
+            <td>${escapeHtml(entry.remoteAddr || '')}</td>
+            <td>${escapeHtml(entry.action)}</td>
+            <td>${escapeHtml(entry.target || '')}</td>
+            <td>
+                ${entry.detail ? `<div>${escapeHtml(entry.detail)}</div>` : ''}
+                ${(entry.changes || []).map(change =>
+                    `<div><code>${escapeHtml(change.path)}</code>: ${formatAuditValue(change.before)} → ${formatAuditValue(change.after)}</div>`
+                ).join('')}
+            </td>
+        </tr>
+    `).join('');
+
+    table.innerHTML = `<table class="usage-table"><thead><tr><th>时间</th><th>操作者</th><th>来源IP</th><th>操作</th><th>对象</th><th>详情与变更</th></tr></thead><tbody>${body}</tbody></table>`;
+}
+
+// 连接实时事件流，按表单中的过滤条件订阅（断线后浏览器自动重连并补发错过的事件）
+function connectLiveEvents() {
+    disconnectLiveEvents();
+
+    const form = document.getElementById('liveForm');
+    const params = new URLSearchParams();
+    const types = Array.from(form.querySelectorAll('input[name="types"]:checked')).map(input => input.value);
+    params.set('types', types.length > 0 ? types.join(',') : 'none');
+    if (hasRole('operator') && form.elements.logs.value) {
+        params.set('logs', form.elements.logs.value);
+    }
+
+    liveEvents = [];
+    renderLiveTable();
+    setLiveStatus('连接中', false);
+    loadActiveRequests();
+    activeRequestsTimer = setInterval(loadActiveRequests, ACTIVE_REQUESTS_REFRESH_MS);
+
+    liveSource = new EventSource(`/api/events?${params}`);
+    liveSource.onopen = () => setLiveStatus('已连接', true);
+    liveSource.onerror = () => setLiveStatus('重新连接中', false);
+    liveSource.onmessage = message => {
+        const event = JSON.parse(message.data);
+        liveEvents.unshift(event);
+        liveEvents.length = Math.min(liveEvents.length, MAX_LIVE_EVENTS);
+        if (!livePaused) {
+            renderLiveTable();
+        }
+    };
+    liveSource.addEventListener('dropped', message => {
+        const { count } = JSON.parse(message.data);
+        showMessage(`浏览器处理过慢，丢弃了 ${count} 个实时事件`, 'error');
+    });
+}
+
+// 断开实时事件流
+function disconnectLiveEvents() {
+    if (activeRequestsTimer) {
+        clearInterval(activeRequestsTimer);
+        activeRequestsTimer = null;
+    }
+    if (liveSource) {
+        liveSource.close();
+        liveSource = null;
+        setLiveStatus('未连接', false);
+    }
+}
+
+// 暂停或恢复实时活动的刷新（暂停期间仍接收事件）
+function toggleLivePause() {
+    livePaused = !livePaused;
+    document.getElementById('livePauseBtn').textContent = livePaused ? '▶️ 继续' : '⏸️ 暂停';
+    if (!livePaused) {
+        renderLiveTable();
+    }
+}
+
+function setLiveStatus(text, connected) {
+    const status = document.getElementById('liveStatus');
+    status.textContent = text;
+    status.className = `token-status ${connected ? 'enabled' : 'disabled'}`;
+}
+
+// 生成实时事件的摘要
+function describeLiveEvent(event) {
+    const data = event.data || {};
+    const token = data.token_id !== undefined ? `Token #${data.index ?? data.token_index} (${data.token_id})` : '';
+    switch (event.type) {
+        case 'token.selected':
+            return `${token} 被选中，剩余 ${data.available}，在途 ${data.in_flight}${data.affinity ? '（会话亲和）' : ''}`;
+        case 'token.refreshed':
+            return `${token} 已刷新，剩余 ${data.available}`;
+        case 'token.refresh_failed':
+            return `${token} 刷新失败: ${data.error}`;
+        case 'token.exhausted':
+            return `${token} 移出轮换: ${data.reason}`;
+        case 'token.restored':
+            return `${token} 额度已重置，重新加入轮换，剩余 ${data.available}`;
+        case 'pool.empty':
+            return `所有Token都不可用（共 ${data.total} 个${data.group ? `，分组 ${data.group}` : ''}）`;
+        case 'request.started':
+            return `${data.model || '-'} ${data.stream ? '流式' : ''}请求开始，使用 ${token}`;
+        case 'request.finished':
+            return `${data.model || '-'} 请求结束，状态 ${data.status}，耗时 ${data.duration_ms}ms` +
+                (data.output_tokens !== undefined ? `，输入/输出 ${data.input_tokens}/${data.output_tokens} tokens` : '');
+        case 'request.error':
+            return `请求失败 (${data.stage}${data.status_code ? ` ${data.status_code}` : ''})${data.error ? `: ${data.error}` : ''}`;
+        case 'log':
+            return `[${data.level}] ${data.message}${data.fields ? ' ' + JSON.stringify(data.fields) : ''}`;
+        default:
+            return JSON.stringify(data);
+    }
+}
+
+// 实时事件的行样式：失败和警告高亮
+function liveEventClass(event) {
+    const level = event.data?.level;
+    if (['token.refresh_failed', 'pool.empty', 'request.error'].includes(event.type) || level === 'ERROR' || level === 'FATAL') {
+        return 'live-error';
+    }
+    if (event.type === 'token.exhausted' || level === 'WARN') {
+        return 'live-warn';
+    }
+    return '';
+}
+
+// 渲染实时事件，最新的在前
+function renderLiveTable() {
+    const table = document.getElementById('liveTable');
+
+    if (liveEvents.length === 0) {
+        table.innerHTML = '<p style="text-align: center; color: #666; padding: 20px;">等待事件...</p>';
+        return;
+    }
+
+    const body = liveEvents.map(event => `
+        <tr class="${liveEventClass(event)}">
+            <td>${new Date(event.time).toLocaleTimeString('zh-CN')}</td>
+            <td><code>${escapeHtml(event.type)}</code></td>
+            <td>${escapeHtml(event.data?.request_id || '')}</td>
+            <td>${escapeHtml(describeLiveEvent(event))}</td>
+        </tr>
+    `).join('');
+
+    table.innerHTML = `<table class="usage-table live-table"><thead><tr><th>时间</th><th>类型</th><th>请求ID</th><th>详情</th></tr></thead><tbody>${body}</tbody></table>`;
+}
+
+// 加载在途请求
+async function loadActiveRequests() {
+    try {
+        const response = await apiFetch('/api/requests/active');
+        if (!response.ok) {
+            throw new Error('加载在途请求失败');
+        }
+        const result = await response.json();
+        renderActiveRequests(result.requests || []);
+    } catch (error) {
+        renderActiveRequests([]);
+    }
+}
+
+// 渲染在途请求，管理员可以取消
+function renderActiveRequests(requests) {
+    const table = document.getElementById('activeRequestsTable');
+
+    if (requests.length === 0) {
+        table.innerHTML = '<p style="text-align: center; color: #666; padding: 20px;">暂无在途请求</p>';
+        return;
+    }
+
+    const canCancel = hasRole('admin');
+    const body = requests.map(req => {
+        const tools = (req.toolCalls || []).map(tool => `${tool.name} (#${tool.blockIndex})`).join(', ');
+        const action = req.cancelled
+            ? '<span class="token-status disabled">取消中</span>'
+            : `<button class="btn btn-danger btn-small" data-request-id="${escapeHtml(req.id)}" onclick="cancelActiveRequest(this.dataset.requestId)">取消</button>`;
+        return `
+            <tr>
+                <td>${escapeHtml(req.id)}</td>
+                <td>${escapeHtml(req.keyName || '-')}</td>
+                <td>${escapeHtml(req.model || '-')}${req.stream ? ' (流式)' : ''}</td>
+                <td>${req.tokenId ? `#${req.tokenIndex} (${escapeHtml(req.tokenId)})` : '排队等待Token'}</td>
+                <td>${new Date(req.startedAt).toLocaleTimeString('zh-CN')}，${(req.durationMs / 1000).toFixed(1)}s</td>
+                <td>${req.bytesStreamed}</td>
+                <td>${req.blockIndex ?? '-'}</td>
+                <td>${escapeHtml(tools || '-')}</td>
+                ${canCancel ? `<td>${action}</td>` : ''}
+            </tr>
+        `;
+    }).join('');
+
+    table.innerHTML = `<table class="usage-table"><thead><tr><th>请求ID</th><th>API密钥</th><th>模型</th><th>Token</th><th>开始/耗时</th><th>已输出字节</th><th>当前内容块</th><th>进行中的工具调用</th>${canCancel ? '<th>操作</th>' : ''}</tr></thead><tbody>${body}</tbody></table>`;
+}
+
+// 取消在途请求：中断上游读取，客户端收到错误
+async function cancelActiveRequest(requestId) {
+    if (!confirm(`确定要取消请求 ${requestId} 吗？客户端将收到错误。`)) {
+        return;
+    }
+
+    try {
+        const response = await apiFetch('/api/requests/cancel', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ id: requestId })
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            showMessage('请求已取消', 'success');
+        } else {
+            showMessage('取消请求失败: ' + result.error, 'error');
+        }
+        loadActiveRequests();
+    } catch (error) {
+        showMessage('取消请求失败: ' + error.message, 'error');
+    }
+}
+
+// 导出用量报表CSV
+function exportUsage() {
+    const params = buildUsageQuery();
+    params.set('format', 'csv');
+    window.location.href = `/api/usage?${params}`;
+}
+
 // 创建备份
 async function createBackup() {
     try {
-        const response = await fetch('/api/backup', {
+        const response = await apiFetch('/api/backup', {
             method: 'POST'
         });
 
@@ -597,7 +1713,7 @@ async function createBackup() {
 // 加载备份列表
 async function loadBackups() {
     try {
-        const response = await fetch('/api/backup');
+        const response = await apiFetch('/api/backup');
         if (!response.ok) {
             throw new Error('加载备份列表失败');
         }
@@ -636,7 +1752,7 @@ async function switchToToken(index) {
     }
     
     try {
-        const response = await fetch('/api/tokens/switch', {
+        const response = await apiFetch('/api/tokens/switch', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -666,7 +1782,7 @@ async function restoreBackup(backupFile) {
     }
 
     try {
-        const response = await fetch('/api/restore', {
+        const response = await apiFetch('/api/restore', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -685,4 +1801,26 @@ async function restoreBackup(backupFile) {
     } catch (error) {
         showMessage('配置恢复失败: ' + error.message, 'error');
     }
-}
\ No newline at end of file
+}
+
+// 注销全部会话（包括当前会话）
+async function logoutAllSessions() {
+    if (!confirm('确定要注销所有已登录的会话吗？\n包括当前会话在内都需要重新登录。')) {
+        return;
+    }
+
+    try {
+        const response = await apiFetch('/api/sessions/logout-all', {
+            method: 'POST'
+        });
+
+        const result = await response.json();
+        if (result.success) {
+            window.location.href = '/login';
+        } else {
+            showMessage('注销会话失败: ' + result.error, 'error');
+        }
+    } catch (error) {
+        showMessage('注销会话失败: ' + error.message, 'error');
+    }
+}
@@ -5,17 +5,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
 	configFileName = "config.json"
+	configFileMode = 0600 // 配置和备份文件包含敏感信息，仅所有者可读写
 )
 
 // Storage 配置存储管理器
 type Storage struct {
 	configPath string
+	cipher     *configCipher // 敏感字段加密器，未配置主密钥时为nil
 	mutex      sync.RWMutex
 }
 
@@ -23,20 +26,33 @@ type Storage struct {
 func NewStorage() *Storage {
 	// 确保配置目录存在
 	configDir := filepath.Join("webconfig", "data")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	if err := os.MkdirAll(configDir, 0700); err != nil {
 		panic(fmt.Sprintf("无法创建配置目录: %v", err))
 	}
 
+	// 读取主密钥（未配置时敏感字段以明文保存）
+	masterKey, err := LoadMasterKey(EnvConfigKey, EnvConfigKeyFile)
+	if err != nil {
+		panic(fmt.Sprintf("加载配置主密钥失败: %v", err))
+	}
+
 	configPath := filepath.Join(configDir, configFileName)
 	return &Storage{
 		configPath: configPath,
+		cipher:     newConfigCipher(masterKey),
 	}
 }
 
+// dataDir 返回配置目录
+func (s *Storage) dataDir() string {
+	return filepath.Dir(s.configPath)
+}
+
 // LoadConfig 加载配置文件
+// 配置了主密钥而文件仍为明文时，自动加密保存
 func (s *Storage) LoadConfig() (*WebConfig, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	// 检查文件是否存在
 	if _, err := os.Stat(s.configPath); os.IsNotExist(err) {
@@ -44,52 +60,92 @@ func (s *Storage) LoadConfig() (*WebConfig, error) {
 		return GetDefaultConfig(), nil
 	}
 
-	// 读取文件
-	data, err := os.ReadFile(s.configPath)
+	config, sealed, err := s.readConfigFile(s.configPath)
 	if err != nil {
-		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+		return nil, err
 	}
 
-	// 解析JSON
-	var config WebConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("解析配置文件失败: %w", err)
+	if s.cipher != nil && !sealed {
+		if err := s.writeConfigFile(s.configPath, config, s.cipher); err != nil {
+			return nil, fmt.Errorf("加密配置文件失败: %w", err)
+		}
+	} else if err := os.Chmod(s.configPath, configFileMode); err != nil {
+		return nil, fmt.Errorf("设置配置文件权限失败: %w", err)
+	}
+
+	return config, nil
+}
+
+// LoadConfigFromPath 从指定路径加载配置（如备份文件）
+func (s *Storage) LoadConfigFromPath(configPath string) (*WebConfig, error) {
+	config, _, err := s.readConfigFile(configPath)
+	return config, err
+}
+
+// IsEncrypted 是否启用了敏感字段加密
+func (s *Storage) IsEncrypted() bool {
+	return s.cipher != nil
+}
+
+// readConfigFile 读取、解密并验证配置文件，sealed 表示文件中的敏感字段是否已加密
+func (s *Storage) readConfigFile(path string) (*WebConfig, bool, error) {
+	config, sealed, err := s.decodeConfigFile(path)
+	if err != nil {
+		return nil, false, err
 	}
 
 	// 验证配置
 	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("配置验证失败: %w", err)
+		return nil, false, fmt.Errorf("配置验证失败: %w", err)
 	}
 
-	return &config, nil
+	return config, sealed, nil
 }
 
-// SaveConfig 保存配置文件
-func (s *Storage) SaveConfig(config *WebConfig) error {
-	if err := config.Validate(); err != nil {
-		return fmt.Errorf("配置验证失败: %w", err)
+// decodeConfigFile 读取并解密配置文件，不做验证
+func (s *Storage) decodeConfigFile(path string) (*WebConfig, bool, error) {
+	// 读取文件
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("读取配置文件失败: %w", err)
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	// 解析JSON
+	persisted := persistedConfig{WebConfig: &WebConfig{}}
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return nil, false, fmt.Errorf("解析配置文件失败: %w", err)
+	}
 
-	// 更新时间戳
-	config.UpdatedAt = time.Now()
+	// 解密敏感字段；仍有明文敏感字段时视为未加密，加载后重新加密保存
+	sealed := persisted.Encryption != nil && !hasPlaintextSecrets(persisted.WebConfig)
+	if err := s.cipher.open(&persisted); err != nil {
+		return nil, false, err
+	}
+
+	return persisted.WebConfig, sealed, nil
+}
+
+// writeConfigFile 加密敏感字段后原子写入配置文件
+func (s *Storage) writeConfigFile(path string, config *WebConfig, cc *configCipher) error {
+	persisted, err := cc.seal(config)
+	if err != nil {
+		return err
+	}
 
 	// 序列化JSON
-	data, err := json.MarshalIndent(config, "", "  ")
+	data, err := json.MarshalIndent(persisted, "", "  ")
 	if err != nil {
 		return fmt.Errorf("序列化配置失败: %w", err)
 	}
 
 	// 创建临时文件
-	tempPath := s.configPath + ".tmp"
-	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, configFileMode); err != nil {
 		return fmt.Errorf("写入临时配置文件失败: %w", err)
 	}
 
 	// 原子性替换文件
-	if err := os.Rename(tempPath, s.configPath); err != nil {
+	if err := os.Rename(tempPath, path); err != nil {
 		// 清理临时文件
 		os.Remove(tempPath)
 		return fmt.Errorf("保存配置文件失败: %w", err)
@@ -98,13 +154,28 @@ func (s *Storage) SaveConfig(config *WebConfig) error {
 	return nil
 }
 
+// SaveConfig 保存配置文件
+func (s *Storage) SaveConfig(config *WebConfig) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("配置验证失败: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 更新时间戳
+	config.UpdatedAt = time.Now()
+
+	return s.writeConfigFile(s.configPath, config, s.cipher)
+}
+
 // IsFirstRun 检查是否是首次运行
 func (s *Storage) IsFirstRun() bool {
 	_, err := os.Stat(s.configPath)
 	return os.IsNotExist(err)
 }
 
-// BackupConfig 备份配置文件
+// BackupConfig 备份配置文件（配置了主密钥时备份同样加密）
 func (s *Storage) BackupConfig() error {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -117,13 +188,13 @@ func (s *Storage) BackupConfig() error {
 	timestamp := time.Now().Format("20060102_150405")
 	backupPath := filepath.Join(filepath.Dir(s.configPath), fmt.Sprintf("config_backup_%s.json", timestamp))
 
-	// 复制文件
-	data, err := os.ReadFile(s.configPath)
+	// 重新加密写入，确保旧的明文配置也不会以明文备份
+	config, _, err := s.readConfigFile(s.configPath)
 	if err != nil {
-		return fmt.Errorf("读取配置文件失败: %w", err)
+		return err
 	}
 
-	if err := os.WriteFile(backupPath, data, 0644); err != nil {
+	if err := s.writeConfigFile(backupPath, config, s.cipher); err != nil {
 		return fmt.Errorf("创建备份文件失败: %w", err)
 	}
 
@@ -145,8 +216,7 @@ func (s *Storage) ListBackups() ([]string, error) {
 		}
 
 		name := entry.Name()
-		if len(name) > len("config_backup_") && name[:len("config_backup_")] == "config_backup_" &&
-		   name[len(name)-5:] == ".json" {
+		if strings.HasPrefix(name, "config_backup_") && strings.HasSuffix(name, ".json") {
 			backups = append(backups, name)
 		}
 	}
@@ -163,22 +233,12 @@ func (s *Storage) RestoreFromBackup(backupFile string) error {
 		return NewConfigError("备份文件不存在: %s", backupFile)
 	}
 
-	// 读取备份文件
-	data, err := os.ReadFile(backupPath)
+	// 读取并验证备份配置
+	config, _, err := s.readConfigFile(backupPath)
 	if err != nil {
 		return fmt.Errorf("读取备份文件失败: %w", err)
 	}
 
-	// 验证备份配置
-	var config WebConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("解析备份文件失败: %w", err)
-	}
-
-	if err := config.Validate(); err != nil {
-		return fmt.Errorf("备份配置验证失败: %w", err)
-	}
-
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -188,7 +248,7 @@ func (s *Storage) RestoreFromBackup(backupFile string) error {
 	}
 
 	// 恢复配置
-	if err := os.WriteFile(s.configPath, data, 0644); err != nil {
+	if err := s.writeConfigFile(s.configPath, config, s.cipher); err != nil {
 		return fmt.Errorf("恢复配置失败: %w", err)
 	}
 
@@ -200,12 +260,65 @@ func (s *Storage) createAutoBackup() error {
 	timestamp := time.Now().Format("20060102_150405")
 	autoBackupPath := filepath.Join(filepath.Dir(s.configPath), fmt.Sprintf("auto_backup_%s.json", timestamp))
 
-	data, err := os.ReadFile(s.configPath)
+	config, _, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(autoBackupPath, data, 0644)
+	return s.writeConfigFile(autoBackupPath, config, s.cipher)
+}
+
+// isBackupFileName 检查文件名是否为备份文件（手动或自动备份）
+func isBackupFileName(name string) bool {
+	return (strings.HasPrefix(name, "config_backup_") || strings.HasPrefix(name, "auto_backup_")) &&
+		strings.HasSuffix(name, ".json")
+}
+
+// RotateKey 使用新主密钥重新加密配置文件及所有备份，返回处理的文件数
+// 所有文件先用当前密钥解密成功后才开始写入，避免轮换到一半失败
+func (s *Storage) RotateKey(newKey []byte) (int, error) {
+	if newKey == nil {
+		return 0, NewConfigError("未提供新主密钥，请设置 %s 或 %s", EnvNewConfigKey, EnvNewConfigKeyFile)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := filepath.Dir(s.configPath)
+	paths := make([]string, 0)
+	if _, err := os.Stat(s.configPath); err == nil {
+		paths = append(paths, s.configPath)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("读取配置目录失败: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isBackupFileName(entry.Name()) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	// 旧备份可能不满足当前版本的验证规则，只需解密成功即可重新加密
+	configs := make([]*WebConfig, len(paths))
+	for i, path := range paths {
+		config, _, err := s.decodeConfigFile(path)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		configs[i] = config
+	}
+
+	newCipher := newConfigCipher(newKey)
+	for i, path := range paths {
+		if err := s.writeConfigFile(path, configs[i], newCipher); err != nil {
+			return i, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+
+	s.cipher = newCipher
+	return len(paths), nil
 }
 
 // GetConfigPath 获取配置文件路径
@@ -1,14 +1,31 @@
 package webconfig
 
-import "time"
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
 
 // WebConfig 主配置结构
 type WebConfig struct {
 	LoginPassword  string        `json:"loginPassword"`
 	ServiceConfig  ServiceConfig `json:"serviceConfig"`
+	UpstreamConfig UpstreamConfig `json:"upstreamConfig"`
 	AuthTokens     []AuthToken   `json:"authTokens"`
 	LogConfig      LogConfig     `json:"logConfig"`
 	TimeoutConfig  TimeoutConfig `json:"timeoutConfig"`
+	ConcurrencyConfig ConcurrencyConfig `json:"concurrencyConfig"`
+	AffinityConfig AffinityConfig `json:"affinityConfig"`
+	AlertConfig AlertConfig `json:"alertConfig"`
+	RateLimitConfig RateLimitConfig `json:"rateLimitConfig"` // 默认限流，适用于未单独设置限流的密钥（含客户端Token）
+	APIKeys     []APIKey    `json:"apiKeys"` // 客户端API密钥（ServiceConfig.ClientToken 之外的多密钥）
+	AdminTokens []AdminToken `json:"adminTokens"` // 管理API令牌（用于脚本访问 /api/*）
+	Users       []ConsoleUser `json:"users"`      // 控制台用户（内置管理员 admin 之外的账号）
+	AuditConfig AuditConfig `json:"auditConfig"`   // 审计日志配置
 	CreatedAt      time.Time     `json:"createdAt"`
 	UpdatedAt      time.Time     `json:"updatedAt"`
 }
@@ -18,6 +35,7 @@ type ServiceConfig struct {
 	Port        int    `json:"port"`         // HTTP服务端口
 	GinMode     string `json:"ginMode"`      // Gin框架运行模式: debug, release, test
 	ClientToken string `json:"clientToken"`  // API客户端认证token
+	MetricsToken string `json:"metricsToken,omitempty"` // 访问 /metrics 的令牌，为空时使用 METRICS_TOKEN 环境变量
 }
 
 // AuthToken 认证Token配置
@@ -32,6 +50,76 @@ type AuthToken struct {
 	LastUsed      *time.Time `json:"lastUsed,omitempty"` // 最后使用时间
 	ErrorCount    int    `json:"errorCount"`     // 错误次数
 	Description   string `json:"description"`    // 描述信息
+
+	// 上游配置（为空时使用全局上游配置）
+	Region      string                   `json:"region,omitempty"`      // AWS区域，如 us-east-1
+	SSORegion   string                   `json:"ssoRegion,omitempty"`   // IdC认证的Identity Center区域，为空时使用全局配置或AWS区域
+	ProfileArn  string                   `json:"profileArn,omitempty"`  // CodeWhisperer profile ARN
+	Endpoints   *types.UpstreamEndpoints `json:"endpoints,omitempty"`   // 自定义端点
+	Fingerprint *types.ClientFingerprint `json:"fingerprint,omitempty"` // 客户端指纹请求头
+
+	// 并发配置
+	MaxConcurrency int `json:"maxConcurrency,omitempty"` // 单Token最大并发请求数，0表示使用全局配置
+
+	// 分组（API密钥可绑定到分组，只使用该分组的Token）
+	Group string `json:"group,omitempty"`
+}
+
+// Redacted 返回隐藏刷新Token和客户端密钥的副本（只保留首尾各4位用于辨认），用于Token列表等非配置接口
+func (t AuthToken) Redacted() AuthToken {
+	t.RefreshToken = maskSecret(t.RefreshToken)
+	t.ClientSecret = maskSecret(t.ClientSecret)
+	return t
+}
+
+// maskSecret 隐藏凭证内容，只保留首尾各4位
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return secret[:4] + "****" + secret[len(secret)-4:]
+}
+
+// UpstreamConfig 全局上游配置，作为各Token的默认值
+type UpstreamConfig struct {
+	Region      string                  `json:"region"`               // 默认AWS区域
+	SSORegion   string                  `json:"ssoRegion,omitempty"`  // IdC认证的Identity Center区域，为空时与AWS区域相同
+	ProfileArn  string                  `json:"profileArn,omitempty"` // 默认profile ARN
+	Endpoints   types.UpstreamEndpoints `json:"endpoints"`            // 自定义端点（为空按区域生成）
+	Fingerprint types.ClientFingerprint `json:"fingerprint"`          // 客户端指纹
+	Mode        string                  `json:"mode,omitempty"`       // 上游模式: aws（默认）或 mock（内置模拟器）
+	Mock        MockUpstreamConfig      `json:"mock"`                 // 模拟器配置，mode 为 mock 时生效
+}
+
+// 上游模式
+const (
+	UpstreamModeAWS  = "aws"  // 真实的CodeWhisperer服务
+	UpstreamModeMock = "mock" // 本地CodeWhisperer模拟器，不消耗额度、不需要网络
+)
+
+// MockUpstreamConfig 内置CodeWhisperer模拟器配置
+// 模拟器只监听127.0.0.1，同时模拟生成、token刷新和额度查询端点
+type MockUpstreamConfig struct {
+	Port        int     `json:"port,omitempty"`        // 监听端口，0使用默认端口
+	ScenarioDir string  `json:"scenarioDir,omitempty"` // 场景文件目录，为空时只使用内置的回显场景
+	CreditLimit float64 `json:"creditLimit,omitempty"` // 每个token的模拟额度，0使用默认值
+}
+
+// Addr 返回模拟器的监听地址
+func (c MockUpstreamConfig) Addr() string {
+	port := c.Port
+	if port == 0 {
+		port = config.DefaultMockUpstreamPort
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// URL 返回模拟器的基础地址
+func (c MockUpstreamConfig) URL() string {
+	return "http://" + c.Addr()
 }
 
 // LogConfig 日志配置
@@ -42,6 +130,18 @@ type LogConfig struct {
 	File          string `json:"file,omitempty"`  // 日志文件路径(可选)
 	EnableCaller  bool   `json:"enableCaller"`    // 是否启用调用栈信息
 	CallerSkip    int    `json:"callerSkip"`      // 调用栈深度跳过
+	AccessFile    string `json:"accessFile,omitempty"`   // 访问日志文件路径(可选)，为空时写入主日志
+	AccessFormat  string `json:"accessFormat,omitempty"` // 访问日志格式: json, text（默认json）
+	MaxSizeMB     int    `json:"maxSizeMB"`              // 日志文件超过该大小(MB)时轮转，0不按大小轮转
+	MaxAgeHours   int    `json:"maxAgeHours"`            // 日志文件使用超过该时长(小时)时轮转，0不按时间轮转
+	MaxBackups    int    `json:"maxBackups"`             // 保留的轮转文件数，0保留全部
+	Compress      bool   `json:"compress"`               // 轮转后的文件使用gzip压缩
+	Redaction     string `json:"redaction,omitempty"`    // 日志载荷脱敏模式: off, metadata, truncated, hashed（默认metadata）
+}
+
+// AuditConfig 审计日志配置
+type AuditConfig struct {
+	RetentionDays int `json:"retentionDays"` // 保留天数，0表示使用默认值（90天）
 }
 
 // TimeoutConfig 超时配置
@@ -53,6 +153,60 @@ type TimeoutConfig struct {
 	ServerWriteMinutes   int `json:"serverWriteMinutes"`   // 服务器写入超时(分钟)
 }
 
+// ConcurrencyConfig 并发与排队配置
+type ConcurrencyConfig struct {
+	MaxInFlightPerToken int `json:"maxInFlightPerToken"` // 每个Token默认最大并发请求数，0表示不限制
+	QueueSize           int `json:"queueSize"`           // 所有Token饱和时的最大排队请求数
+	QueueTimeoutSeconds int `json:"queueTimeoutSeconds"` // 排队等待超时时间(秒)
+}
+
+// AffinityConfig 会话亲和配置：同一会话的请求固定使用同一个Token
+type AffinityConfig struct {
+	Enabled    bool `json:"enabled"`    // 是否启用会话亲和
+	TTLMinutes int  `json:"ttlMinutes"` // 会话绑定有效期(分钟)，0表示使用默认值
+}
+
+// RateLimitConfig 按客户端密钥的限流配置（令牌桶，每分钟补满），0表示不限制
+type RateLimitConfig struct {
+	RequestsPerMinute     int `json:"requestsPerMinute"`     // 每分钟请求数
+	InputTokensPerMinute  int `json:"inputTokensPerMinute"`  // 每分钟输入token数（本地估算）
+	OutputTokensPerMinute int `json:"outputTokensPerMinute"` // 每分钟输出token数
+}
+
+// AlertConfig 告警配置
+type AlertConfig struct {
+	Enabled            bool           `json:"enabled"`            // 是否启用告警
+	LowCreditThreshold float64        `json:"lowCreditThreshold"` // 单个Token剩余额度低于该值时告警，0表示不检查
+	DedupeMinutes      int            `json:"dedupeMinutes"`      // 相同告警的去重窗口(分钟)
+	MaxRetries         int            `json:"maxRetries"`         // Webhook发送失败的最大重试次数
+	Webhooks           []AlertWebhook `json:"webhooks"`           // 告警接收端
+}
+
+// AlertWebhook 告警Webhook接收端
+type AlertWebhook struct {
+	Name    string   `json:"name"`             // 名称
+	URL     string   `json:"url"`              // 接收地址
+	Format  string   `json:"format"`           // 消息格式: generic, slack
+	Secret  string   `json:"secret,omitempty"` // HMAC-SHA256签名密钥（仅generic格式）
+	Events  []string `json:"events,omitempty"` // 订阅的告警类型，为空表示全部
+	Enabled bool     `json:"enabled"`          // 是否启用
+}
+
+// 告警类型
+const (
+	AlertEventLowCredit     = "low_credit"     // Token剩余额度过低
+	AlertEventRefreshFailed = "refresh_failed" // Token刷新失败
+	AlertEventTokenDisabled = "token_disabled" // Token被自动移出轮换
+	AlertEventPoolEmpty     = "pool_empty"     // Token池没有可用Token
+	AlertEventTest          = "test"           // 测试告警
+)
+
+// 告警Webhook消息格式
+const (
+	AlertFormatGeneric = "generic"
+	AlertFormatSlack   = "slack"
+)
+
 // GetDefaultConfig 获取默认配置
 func GetDefaultConfig() *WebConfig {
 	now := time.Now()
@@ -63,6 +217,10 @@ func GetDefaultConfig() *WebConfig {
 			GinMode:     "release",
 			ClientToken: "", // 需要用户设置
 		},
+		UpstreamConfig: UpstreamConfig{
+			Region:      types.DefaultUpstreamProfile().Region,
+			Fingerprint: types.DefaultUpstreamProfile().Fingerprint,
+		},
 		AuthTokens: []AuthToken{},
 		LogConfig: LogConfig{
 			Level:        "info",
@@ -71,6 +229,12 @@ func GetDefaultConfig() *WebConfig {
 			File:         "",
 			EnableCaller: false,
 			CallerSkip:   3,
+			AccessFormat: "json",
+			MaxSizeMB:    100,
+			MaxAgeHours:  0,
+			MaxBackups:   10,
+			Compress:     true,
+			Redaction:    "metadata",
 		},
 		TimeoutConfig: TimeoutConfig{
 			RequestMinutes:       15,
@@ -79,6 +243,26 @@ func GetDefaultConfig() *WebConfig {
 			ServerReadMinutes:    16,
 			ServerWriteMinutes:   16,
 		},
+		ConcurrencyConfig: ConcurrencyConfig{
+			MaxInFlightPerToken: 0,
+			QueueSize:           100,
+			QueueTimeoutSeconds: 30,
+		},
+		AffinityConfig: AffinityConfig{
+			Enabled:    true,
+			TTLMinutes: 30,
+		},
+		AlertConfig: AlertConfig{
+			Enabled:            false,
+			LowCreditThreshold: 10,
+			DedupeMinutes:      30,
+			MaxRetries:         3,
+			Webhooks:           []AlertWebhook{},
+		},
+		RateLimitConfig: RateLimitConfig{},
+		APIKeys:         []APIKey{},
+		AdminTokens:     []AdminToken{},
+		Users:           []ConsoleUser{},
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
@@ -99,6 +283,29 @@ func (c *WebConfig) Validate() error {
 		return NewConfigError("客户端认证token不能为空")
 	}
 
+	if c.ServiceConfig.MetricsToken != "" && c.ServiceConfig.MetricsToken == c.ServiceConfig.ClientToken {
+		return NewConfigError("指标令牌不能与客户端认证token相同")
+	}
+
+	// 验证上游配置
+	if c.UpstreamConfig.Region != "" && !regionPattern.MatchString(c.UpstreamConfig.Region) {
+		return NewConfigError("无效的AWS区域: %s", c.UpstreamConfig.Region)
+	}
+	if c.UpstreamConfig.SSORegion != "" && !regionPattern.MatchString(c.UpstreamConfig.SSORegion) {
+		return NewConfigError("无效的SSO区域: %s", c.UpstreamConfig.SSORegion)
+	}
+	switch c.UpstreamConfig.Mode {
+	case "", UpstreamModeAWS, UpstreamModeMock:
+	default:
+		return NewConfigError("上游模式必须是 %s 或 %s", UpstreamModeAWS, UpstreamModeMock)
+	}
+	if mock := c.UpstreamConfig.Mock; mock.Port < 0 || mock.Port > 65535 || mock.CreditLimit < 0 {
+		return NewConfigError("模拟器端口必须在0-65535之间，额度不能为负数")
+	}
+	if c.UpstreamConfig.Mode == UpstreamModeMock && c.UpstreamConfig.Mock.Addr() == fmt.Sprintf("127.0.0.1:%d", c.ServiceConfig.Port) {
+		return NewConfigError("模拟器端口不能与服务端口相同")
+	}
+
 	// 验证日志配置
 	validLogLevels := map[string]bool{
 		"debug": true, "info": true, "warn": true, "error": true, "fatal": true,
@@ -111,6 +318,20 @@ func (c *WebConfig) Validate() error {
 		return NewConfigError("日志格式必须是 text 或 json")
 	}
 
+	if c.LogConfig.AccessFormat != "" && c.LogConfig.AccessFormat != "text" && c.LogConfig.AccessFormat != "json" {
+		return NewConfigError("访问日志格式必须是 text 或 json")
+	}
+
+	if c.LogConfig.MaxSizeMB < 0 || c.LogConfig.MaxAgeHours < 0 || c.LogConfig.MaxBackups < 0 {
+		return NewConfigError("日志轮转的大小、时长和保留数量不能为负数")
+	}
+
+	switch c.LogConfig.Redaction {
+	case "", "off", "metadata", "truncated", "hashed":
+	default:
+		return NewConfigError("日志脱敏模式必须是 off、metadata、truncated 或 hashed")
+	}
+
 	// 验证超时配置
 	if c.TimeoutConfig.RequestMinutes <= 0 || c.TimeoutConfig.RequestMinutes > 120 {
 		return NewConfigError("请求超时时间必须在 1-120 分钟范围内")
@@ -124,6 +345,79 @@ func (c *WebConfig) Validate() error {
 		return NewConfigError("流式请求超时时间必须在 1-180 分钟范围内")
 	}
 
+	// 验证并发配置
+	if c.ConcurrencyConfig.MaxInFlightPerToken < 0 {
+		return NewConfigError("单Token最大并发数不能为负数")
+	}
+
+	if c.ConcurrencyConfig.QueueSize < 0 || c.ConcurrencyConfig.QueueSize > 10000 {
+		return NewConfigError("排队队列长度必须在 0-10000 范围内")
+	}
+
+	if c.ConcurrencyConfig.QueueTimeoutSeconds < 0 || c.ConcurrencyConfig.QueueTimeoutSeconds > 600 {
+		return NewConfigError("排队超时时间必须在 0-600 秒范围内")
+	}
+
+	if c.AffinityConfig.TTLMinutes < 0 || c.AffinityConfig.TTLMinutes > 1440 {
+		return NewConfigError("会话亲和有效期必须在 0-1440 分钟范围内")
+	}
+
+	// 验证告警配置
+	if err := c.AlertConfig.validate(); err != nil {
+		return err
+	}
+
+	// 验证限流配置
+	if err := c.RateLimitConfig.validate("默认限流"); err != nil {
+		return err
+	}
+
+	// 验证API密钥
+	keyIDs := make(map[string]bool, len(c.APIKeys))
+	for i, key := range c.APIKeys {
+		if err := key.validate(i); err != nil {
+			return err
+		}
+		if keyIDs[key.ID] {
+			return NewConfigError("API密钥ID重复: %s", key.ID)
+		}
+		keyIDs[key.ID] = true
+	}
+
+	// 验证管理API令牌
+	tokenIDs := make(map[string]bool, len(c.AdminTokens))
+	for i, token := range c.AdminTokens {
+		if err := token.validate(i); err != nil {
+			return err
+		}
+		if tokenIDs[token.ID] {
+			return NewConfigError("管理令牌ID重复: %s", token.ID)
+		}
+		tokenIDs[token.ID] = true
+	}
+
+	// 验证审计日志配置
+	if c.AuditConfig.RetentionDays < 0 {
+		return NewConfigError("审计日志保留天数不能为负数")
+	}
+
+	// 验证控制台用户
+	userIDs := make(map[string]bool, len(c.Users))
+	usernames := make(map[string]bool, len(c.Users))
+	for i, user := range c.Users {
+		if err := user.validate(i); err != nil {
+			return err
+		}
+		if userIDs[user.ID] {
+			return NewConfigError("控制台用户ID重复: %s", user.ID)
+		}
+		if usernames[user.Username] {
+			return NewConfigError("控制台用户名重复: %s", user.Username)
+		}
+		userIDs[user.ID] = true
+		usernames[user.Username] = true
+	}
+
 	// 验证Token配置
 	for i, token := range c.AuthTokens {
 		if token.Auth != "Social" && token.Auth != "IdC" {
@@ -137,6 +431,18 @@ func (c *WebConfig) Validate() error {
 		if token.Auth == "IdC" && (token.ClientID == "" || token.ClientSecret == "") {
 			return NewConfigError("Token #%d: IdC认证需要客户端ID和密钥", i+1)
 		}
+
+		if token.Region != "" && !regionPattern.MatchString(token.Region) {
+			return NewConfigError("Token #%d: 无效的AWS区域: %s", i+1, token.Region)
+		}
+
+		if token.SSORegion != "" && !regionPattern.MatchString(token.SSORegion) {
+			return NewConfigError("Token #%d: 无效的SSO区域: %s", i+1, token.SSORegion)
+		}
+
+		if token.MaxConcurrency < 0 {
+			return NewConfigError("Token #%d: 最大并发数不能为负数", i+1)
+		}
 	}
 
 	return nil
@@ -150,13 +456,170 @@ func (c *WebConfig) Clone() *WebConfig {
 	clone.AuthTokens = make([]AuthToken, len(c.AuthTokens))
 	copy(clone.AuthTokens, c.AuthTokens)
 
-	// 深拷贝时间指针
+	clone.AlertConfig.Webhooks = make([]AlertWebhook, len(c.AlertConfig.Webhooks))
+	for i, webhook := range c.AlertConfig.Webhooks {
+		webhook.Events = append([]string(nil), webhook.Events...)
+		clone.AlertConfig.Webhooks[i] = webhook
+	}
+
+	clone.APIKeys = make([]APIKey, len(c.APIKeys))
+	for i, key := range c.APIKeys {
+		if key.ExpiresAt != nil {
+			expiresAt := *key.ExpiresAt
+			key.ExpiresAt = &expiresAt
+		}
+		key.AllowedModels = append([]string(nil), key.AllowedModels...)
+		key.AllowedEndpoints = append([]string(nil), key.AllowedEndpoints...)
+		if key.RateLimit != nil {
+			rateLimit := *key.RateLimit
+			key.RateLimit = &rateLimit
+		}
+		if key.Budget != nil {
+			budget := *key.Budget
+			key.Budget = &budget
+		}
+		clone.APIKeys[i] = key
+	}
+
+	clone.AdminTokens = make([]AdminToken, len(c.AdminTokens))
+	for i, token := range c.AdminTokens {
+		if token.ExpiresAt != nil {
+			expiresAt := *token.ExpiresAt
+			token.ExpiresAt = &expiresAt
+		}
+		clone.AdminTokens[i] = token
+	}
+
+	clone.Users = make([]ConsoleUser, len(c.Users))
+	copy(clone.Users, c.Users)
+
+	// 深拷贝指针字段
 	for i, token := range clone.AuthTokens {
 		if token.LastUsed != nil {
 			lastUsed := *token.LastUsed
 			clone.AuthTokens[i].LastUsed = &lastUsed
 		}
+		if token.Endpoints != nil {
+			endpoints := *token.Endpoints
+			clone.AuthTokens[i].Endpoints = &endpoints
+		}
+		if token.Fingerprint != nil {
+			fingerprint := *token.Fingerprint
+			clone.AuthTokens[i].Fingerprint = &fingerprint
+		}
 	}
 
 	return &clone
-}
\ No newline at end of file
+}
+
+// regionPattern AWS区域名称格式，如 us-east-1、eu-central-1
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+// ResolveUpstream 解析Token最终使用的上游配置
+// 合并顺序：内置默认值 < 全局上游配置 < Token级配置
+func (c *WebConfig) ResolveUpstream(token AuthToken) types.UpstreamProfile {
+	profile := types.DefaultUpstreamProfile().Merge(types.UpstreamProfile{
+		Region:      c.UpstreamConfig.Region,
+		SSORegion:   c.UpstreamConfig.SSORegion,
+		ProfileArn:  c.UpstreamConfig.ProfileArn,
+		Endpoints:   c.UpstreamConfig.Endpoints,
+		Fingerprint: c.UpstreamConfig.Fingerprint,
+	})
+
+	override := types.UpstreamProfile{
+		Region:     token.Region,
+		SSORegion:  token.SSORegion,
+		ProfileArn: token.ProfileArn,
+	}
+	if token.Endpoints != nil {
+		override.Endpoints = *token.Endpoints
+	}
+	if token.Fingerprint != nil {
+		override.Fingerprint = *token.Fingerprint
+	}
+
+	// Token指定了区域但未自定义端点时，不继承全局端点，按新区域生成
+	// Social刷新端点与区域无关，保留全局配置
+	if token.Region != "" && token.Region != profile.Region && token.Endpoints == nil {
+		profile.Endpoints = types.UpstreamEndpoints{SocialRefresh: profile.Endpoints.SocialRefresh}
+	}
+	if token.SSORegion != "" && token.SSORegion != profile.SSORegion && token.Endpoints == nil {
+		profile.Endpoints.IdcRefresh = ""
+	}
+
+	resolved := profile.Merge(override).Resolve()
+
+	// 模拟模式下所有Token都使用本地模拟器
+	if c.UpstreamConfig.Mode == UpstreamModeMock {
+		base := c.UpstreamConfig.Mock.URL()
+		resolved.Endpoints = types.UpstreamEndpoints{
+			CodeWhisperer: base + config.MockCodeWhispererPath,
+			UsageLimits:   base + config.MockUsageLimitsPath,
+			SocialRefresh: base + config.MockSocialRefreshPath,
+			IdcRefresh:    base + config.MockIdcRefreshPath,
+		}
+	}
+	return resolved
+}
+
+// ResolveMaxConcurrency 解析Token最终使用的最大并发数，0表示不限制
+func (c *WebConfig) ResolveMaxConcurrency(token AuthToken) int {
+	if token.MaxConcurrency > 0 {
+		return token.MaxConcurrency
+	}
+	return c.ConcurrencyConfig.MaxInFlightPerToken
+}
+
+// validAlertEvents 可订阅的告警类型
+var validAlertEvents = map[string]bool{
+	AlertEventLowCredit:     true,
+	AlertEventRefreshFailed: true,
+	AlertEventTokenDisabled: true,
+	AlertEventPoolEmpty:     true,
+}
+
+// validate 验证告警配置
+// validate 验证限流配置
+func (c RateLimitConfig) validate(scope string) error {
+	if c.RequestsPerMinute < 0 || c.InputTokensPerMinute < 0 || c.OutputTokensPerMinute < 0 {
+		return NewConfigError("%s: 限流值不能为负数", scope)
+	}
+	return nil
+}
+
+func (c AlertConfig) validate() error {
+	if c.LowCreditThreshold < 0 {
+		return NewConfigError("低额度告警阈值不能为负数")
+	}
+
+	if c.DedupeMinutes < 0 || c.DedupeMinutes > 1440 {
+		return NewConfigError("告警去重窗口必须在 0-1440 分钟范围内")
+	}
+
+	if c.MaxRetries < 0 || c.MaxRetries > 10 {
+		return NewConfigError("告警重试次数必须在 0-10 范围内")
+	}
+
+	for i, webhook := range c.Webhooks {
+		if webhook.Name == "" {
+			return NewConfigError("Webhook #%d: 名称不能为空", i+1)
+		}
+
+		parsed, err := url.Parse(webhook.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return NewConfigError("Webhook %s: 无效的URL", webhook.Name)
+		}
+
+		if webhook.Format != AlertFormatGeneric && webhook.Format != AlertFormatSlack {
+			return NewConfigError("Webhook %s: 消息格式必须是 generic 或 slack", webhook.Name)
+		}
+
+		for _, event := range webhook.Events {
+			if !validAlertEvents[event] {
+				return NewConfigError("Webhook %s: 未知的告警类型: %s", webhook.Name, event)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package webconfig
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 用量报表的分组维度
+const (
+	UsageGroupKey   = "key"   // 客户端API密钥
+	UsageGroupModel = "model" // 模型
+	UsageGroupToken = "token" // 上游Token
+	UsageGroupDay   = "day"   // 日期
+)
+
+// UsageDateFormat 用量报表的日期格式
+const UsageDateFormat = "2006-01-02"
+
+// usageMaxDays 单次查询的最大天数
+const usageMaxDays = 366
+
+// UsageQuery 用量报表查询条件
+type UsageQuery struct {
+	From    time.Time // 起始日期（含）
+	To      time.Time // 结束日期（含）
+	KeyID   string    // 按客户端密钥过滤
+	Model   string    // 按模型过滤
+	TokenID string    // 按上游Token过滤
+	GroupBy []string  // 分组维度，见 UsageGroup*；为空表示汇总为一行
+}
+
+// UsageRow 用量报表的一行，未参与分组的维度为空
+type UsageRow struct {
+	Day            string `json:"day,omitempty"`
+	KeyID          string `json:"keyId,omitempty"`
+	KeyName        string `json:"keyName,omitempty"`
+	Model          string `json:"model,omitempty"`
+	TokenID        string `json:"tokenId,omitempty"`
+	Requests       int64  `json:"requests"`
+	Errors         int64  `json:"errors"` // 状态码 >= 400 的请求数
+	InputTokens    int64  `json:"inputTokens"`
+	OutputTokens   int64  `json:"outputTokens"`
+	TotalLatencyMs int64  `json:"totalLatencyMs"`
+	AvgLatencyMs   int64  `json:"avgLatencyMs"`
+}
+
+// parseUsageQuery 解析 /api/usage 的查询参数
+// from/to 为 YYYY-MM-DD（默认最近30天），groupBy 为逗号分隔的维度
+func parseUsageQuery(r *http.Request) (UsageQuery, error) {
+	values := r.URL.Query()
+	query := UsageQuery{
+		KeyID:   values.Get("key"),
+		Model:   values.Get("model"),
+		TokenID: values.Get("token"),
+	}
+
+	today, _ := time.ParseInLocation(UsageDateFormat, time.Now().Format(UsageDateFormat), time.Local)
+	query.To = today
+	if to := values.Get("to"); to != "" {
+		parsed, err := time.ParseInLocation(UsageDateFormat, to, time.Local)
+		if err != nil {
+			return query, NewConfigError("无效的结束日期: %s", to)
+		}
+		query.To = parsed
+	}
+
+	query.From = query.To.AddDate(0, 0, -29)
+	if from := values.Get("from"); from != "" {
+		parsed, err := time.ParseInLocation(UsageDateFormat, from, time.Local)
+		if err != nil {
+			return query, NewConfigError("无效的起始日期: %s", from)
+		}
+		query.From = parsed
+	}
+
+	if query.From.After(query.To) {
+		return query, NewConfigError("起始日期不能晚于结束日期")
+	}
+	if query.To.Sub(query.From) >= usageMaxDays*24*time.Hour {
+		return query, NewConfigError("查询范围不能超过 %d 天", usageMaxDays)
+	}
+
+	if groupBy := values.Get("groupBy"); groupBy != "" {
+		for _, dim := range strings.Split(groupBy, ",") {
+			dim = strings.TrimSpace(dim)
+			switch dim {
+			case UsageGroupKey, UsageGroupModel, UsageGroupToken, UsageGroupDay:
+				query.GroupBy = append(query.GroupBy, dim)
+			default:
+				return query, NewConfigError("未知的分组维度: %s", dim)
+			}
+		}
+	}
+
+	return query, nil
+}
+
+// handleUsage 查询用量报表，支持 format=csv 导出
+func (m *Manager) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if m.queryUsage == nil {
+		m.writeJSONError(w, "用量账本未初始化", http.StatusServiceUnavailable)
+		return
+	}
+
+	query, err := parseUsageQuery(r)
+	if err != nil {
+		m.writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := m.queryUsage(query)
+	if err != nil {
+		m.writeJSONError(w, "查询用量失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeUsageCSV(w, query, rows)
+		return
+	}
+
+	m.writeJSONResponse(w, map[string]interface{}{
+		"from":    query.From.Format(UsageDateFormat),
+		"to":      query.To.Format(UsageDateFormat),
+		"groupBy": query.GroupBy,
+		"rows":    rows,
+	})
+}
+
+// writeUsageCSV 以CSV格式导出用量报表
+func writeUsageCSV(w http.ResponseWriter, query UsageQuery, rows []UsageRow) {
+	filename := "usage-" + query.From.Format(UsageDateFormat) + "-" + query.To.Format(UsageDateFormat) + ".csv"
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"day", "key_id", "key_name", "model", "token_id", "requests", "errors",
+		"input_tokens", "output_tokens", "total_latency_ms", "avg_latency_ms"})
+	for _, row := range rows {
+		_ = writer.Write([]string{
+			row.Day, row.KeyID, row.KeyName, row.Model, row.TokenID,
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatInt(row.Errors, 10),
+			strconv.FormatInt(row.InputTokens, 10),
+			strconv.FormatInt(row.OutputTokens, 10),
+			strconv.FormatInt(row.TotalLatencyMs, 10),
+			strconv.FormatInt(row.AvgLatencyMs, 10),
+		})
+	}
+	writer.Flush()
+}
@@ -0,0 +1,297 @@
+package webconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// 控制台用户角色，权限依次递增
+const (
+	RoleViewer   = "viewer"   // 只读：查看Token状态、用量和预算
+	RoleOperator = "operator" // 运维：查看权限加刷新、切换、启停Token
+	RoleAdmin    = "admin"    // 管理员：全部操作，包括配置、备份、密钥和用户管理
+)
+
+// roleLevels 角色的权限等级
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// BuiltinAdminUsername 内置管理员的用户名，使用 LoginPassword 登录
+const BuiltinAdminUsername = "admin"
+
+// usernamePattern 用户名格式
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+var (
+	// ErrUserInvalid 用户名或密码错误
+	ErrUserInvalid = errors.New("用户名或密码错误")
+	// ErrUserDisabled 用户已禁用
+	ErrUserDisabled = errors.New("用户已禁用")
+)
+
+// ConsoleUser 管理控制台用户，密码以 argon2id 哈希保存
+type ConsoleUser struct {
+	ID           string    `json:"id"`                     // 唯一标识
+	Username     string    `json:"username"`               // 登录用户名
+	PasswordHash string    `json:"passwordHash,omitempty"` // 密码哈希
+	Role         string    `json:"role"`                   // 角色，见 Role*
+	Disabled     bool      `json:"disabled"`               // 是否禁用
+	CreatedAt    time.Time `json:"createdAt"`              // 创建时间
+}
+
+// Redacted 返回不含密码哈希的副本，用于API响应
+func (u ConsoleUser) Redacted() ConsoleUser {
+	u.PasswordHash = ""
+	return u
+}
+
+func (u ConsoleUser) validate(index int) error {
+	if u.ID == "" {
+		return NewConfigError("控制台用户 #%d: ID不能为空", index+1)
+	}
+	if !usernamePattern.MatchString(u.Username) {
+		return NewConfigError("控制台用户 #%d: 用户名只能包含字母、数字、.、_、-，长度1-32", index+1)
+	}
+	if u.Username == BuiltinAdminUsername {
+		return NewConfigError("控制台用户 #%d: 用户名 %s 为内置管理员保留", index+1, BuiltinAdminUsername)
+	}
+	if !IsPasswordHashed(u.PasswordHash) {
+		return NewConfigError("控制台用户 %s: 缺少密码哈希", u.Username)
+	}
+	if !validRole(u.Role) {
+		return NewConfigError("控制台用户 %s: 未知的角色: %s", u.Username, u.Role)
+	}
+	return nil
+}
+
+func validRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// roleAllows 判断角色是否达到要求的角色等级
+func roleAllows(role, required string) bool {
+	return roleLevels[role] >= roleLevels[required]
+}
+
+// userActor 审计日志中的控制台用户
+func userActor(username string) string {
+	return "user:" + username
+}
+
+// AuthenticateUser 验证控制台用户的用户名和密码，返回用户的角色
+// 用户名为空或为内置管理员时使用 LoginPassword 验证
+func (m *Manager) AuthenticateUser(username, password string) (string, error) {
+	if username == "" || username == BuiltinAdminUsername {
+		if !m.VerifyLoginPassword(password) {
+			return "", ErrUserInvalid
+		}
+		return RoleAdmin, nil
+	}
+
+	user, exists := m.findUser(username)
+	if !exists {
+		// 不存在的用户同样计算一次哈希，避免通过响应时间枚举用户名
+		verifyPasswordHash(dummyPasswordHash, password)
+		return "", ErrUserInvalid
+	}
+	ok, err := verifyPasswordHash(user.PasswordHash, password)
+	if err != nil || !ok {
+		return "", ErrUserInvalid
+	}
+	if user.Disabled {
+		return "", ErrUserDisabled
+	}
+	return user.Role, nil
+}
+
+// dummyPasswordHash 用于不存在的用户的占位哈希
+var dummyPasswordHash, _ = HashPassword("kiro2api-dummy-password")
+
+// findUser 按用户名查找控制台用户
+func (m *Manager) findUser(username string) (ConsoleUser, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, user := range m.config.Users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return ConsoleUser{}, false
+}
+
+// userRole 返回用户当前的角色，用户已删除或禁用时返回false
+// 每次请求都从配置读取，角色调整和禁用立即生效
+func (m *Manager) userRole(username string) (string, bool) {
+	if username == BuiltinAdminUsername {
+		return RoleAdmin, true
+	}
+	user, exists := m.findUser(username)
+	if !exists || user.Disabled {
+		return "", false
+	}
+	return user.Role, true
+}
+
+// userRequest 创建或更新控制台用户的请求
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"` // 更新时为空表示不修改密码
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+// handleUsers 处理控制台用户的查询、创建、更新和删除
+func (m *Manager) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		config := m.GetConfig()
+		users := make([]ConsoleUser, 0, len(config.Users))
+		for _, user := range config.Users {
+			users = append(users, user.Redacted())
+		}
+		m.writeJSONResponse(w, users)
+
+	case "POST":
+		var req userRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			m.writeJSONError(w, "JSON解析失败", http.StatusBadRequest)
+			return
+		}
+		if req.Password == "" {
+			m.writeJSONError(w, "密码不能为空", http.StatusBadRequest)
+			return
+		}
+
+		passwordHash, err := HashPassword(req.Password)
+		if err != nil {
+			m.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		user := ConsoleUser{
+			ID:           fmt.Sprintf("%d", time.Now().UnixNano()),
+			Username:     req.Username,
+			PasswordHash: passwordHash,
+			Role:         req.Role,
+			Disabled:     req.Disabled,
+			CreatedAt:    time.Now(),
+		}
+
+		config := m.GetConfig()
+		for _, existing := range config.Users {
+			if existing.Username == user.Username {
+				m.writeJSONError(w, "用户名已存在: "+user.Username, http.StatusBadRequest)
+				return
+			}
+		}
+		config.Users = append(config.Users, user)
+		if err := m.updateConfigFrom(r, AuditActionUserCreate, user.Username, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("创建用户失败: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "用户创建成功",
+			"user":    user.Redacted(),
+		})
+
+	case "PUT":
+		userID := r.URL.Query().Get("id")
+		if userID == "" {
+			m.writeJSONError(w, "用户ID不能为空", http.StatusBadRequest)
+			return
+		}
+
+		var req userRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			m.writeJSONError(w, "JSON解析失败", http.StatusBadRequest)
+			return
+		}
+
+		config := m.GetConfig()
+		var target *ConsoleUser
+		for i := range config.Users {
+			if config.Users[i].ID == userID {
+				target = &config.Users[i]
+				break
+			}
+		}
+		if target == nil {
+			m.writeJSONError(w, "用户不存在", http.StatusNotFound)
+			return
+		}
+
+		target.Role = req.Role
+		target.Disabled = req.Disabled
+		if req.Password != "" {
+			passwordHash, err := HashPassword(req.Password)
+			if err != nil {
+				m.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			target.PasswordHash = passwordHash
+		}
+
+		if err := m.updateConfigFrom(r, AuditActionUserUpdate, target.Username, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("更新用户失败: %v", err), http.StatusBadRequest)
+			return
+		}
+		// 修改密码或禁用后，该用户已有的会话全部失效
+		if req.Password != "" || target.Disabled {
+			m.InvalidateUserSessions(target.Username)
+		}
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "用户更新成功",
+			"user":    target.Redacted(),
+		})
+
+	case "DELETE":
+		userID := r.URL.Query().Get("id")
+		if userID == "" {
+			m.writeJSONError(w, "用户ID不能为空", http.StatusBadRequest)
+			return
+		}
+
+		config := m.GetConfig()
+		remaining := make([]ConsoleUser, 0, len(config.Users))
+		var deleted *ConsoleUser
+		for i, user := range config.Users {
+			if user.ID == userID {
+				deleted = &config.Users[i]
+				continue
+			}
+			remaining = append(remaining, user)
+		}
+		if deleted == nil {
+			m.writeJSONError(w, "用户不存在", http.StatusNotFound)
+			return
+		}
+
+		config.Users = remaining
+		if err := m.updateConfigFrom(r, AuditActionUserDelete, deleted.Username, config); err != nil {
+			m.writeJSONError(w, fmt.Sprintf("删除用户失败: %v", err), http.StatusInternalServerError)
+			return
+		}
+		m.InvalidateUserSessions(deleted.Username)
+
+		m.writeJSONResponse(w, map[string]interface{}{
+			"success": true,
+			"message": "用户已删除",
+		})
+
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}